@@ -1,35 +1,102 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
 	"net/http"
-	"net/http/httputil"
 	"net/url"
 	"os"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
 	"github.com/gorilla/mux"
 	"github.com/streadway/amqp"
+	"github.com/varrrro/pay-up/internal/config"
+	"github.com/varrrro/pay-up/internal/consumer"
 	"github.com/varrrro/pay-up/internal/gateway"
+	"github.com/varrrro/pay-up/internal/health"
+	"github.com/varrrro/pay-up/internal/logging"
+	"github.com/varrrro/pay-up/internal/metrics"
+	"github.com/varrrro/pay-up/internal/openapi"
 	"github.com/varrrro/pay-up/internal/publisher"
+	"github.com/varrrro/pay-up/internal/shutdown"
+	"github.com/varrrro/pay-up/internal/tracing"
 )
 
+// fields of the service's configuration.
+var fields = []config.Field{
+	config.Port,
+	config.ShutdownTimeout,
+	config.LogFormat,
+	config.LogLevel,
+	config.TraceExporter,
+	config.OTLPEndpoint,
+	config.RabbitConn,
+	config.AMQPSecret,
+	config.MaxSkew,
+	config.Exchange,
+	config.Key,
+	config.EventsKey,
+	config.ConsumerTag,
+	{Name: "proxy-url", Env: "PROXY_URL", Usage: "URL of the groups microservice", Required: true, Check: config.URL},
+	config.ServiceSecret,
+	{Name: "api-keys-file", Env: "API_KEYS_FILE", Usage: "File with the API keys of automated clients"},
+	{Name: "read-rate-limit", Env: "READ_RATE_LIMIT", Default: "600", Usage: "Reads allowed per client and minute", Check: config.PositiveInt},
+	{Name: "write-rate-limit", Env: "WRITE_RATE_LIMIT", Default: "120", Usage: "Writes allowed per client and minute", Check: config.PositiveInt},
+	{Name: "events-buffer", Env: "EVENTS_BUFFER", Default: "1000", Usage: "Balance events kept for clients resuming a stream", Check: config.PositiveInt},
+}
+
 func init() {
-	// Set log formatter
-	log.SetFormatter(&log.TextFormatter{
-		DisableColors: true,
-		FullTimestamp: true,
-	})
-
-	// Write logs to stdout
-	log.SetOutput(os.Stdout)
+	// Log with the default settings until the configuration is loaded
+	logging.Setup(config.LogFormat.Default, config.LogLevel.Default)
 }
 
 func main() {
-	rabbit := os.Getenv("RABBIT_CONN")
-	gmicro := os.Getenv("PROXY_URL")
-	exchange := os.Getenv("EXCHANGE")
-	key := os.Getenv("KEY")
+	cfg, err := config.Load("gateway", fields, os.Args[1:])
+	if err == flag.ErrHelp {
+		return
+	} else if err != nil {
+		log.WithError(err).Fatal("Can't load configuration")
+	}
+	if cfg.PrintRequested() {
+		cfg.Print(os.Stdout)
+		return
+	}
+	if err := cfg.Validate(); err != nil {
+		log.WithError(err).Fatal("Invalid configuration")
+	}
+	if err := logging.Setup(cfg.String(config.LogFormat.Name), cfg.String(config.LogLevel.Name)); err != nil {
+		log.WithError(err).Fatal("Invalid logging configuration")
+	}
+
+	rabbit := cfg.String(config.RabbitConn.Name)
+	amqpSecret := cfg.Bytes(config.AMQPSecret.Name)
+	gmicro := cfg.String("proxy-url")
+	exchange := cfg.String(config.Exchange.Name)
+	key := cfg.String(config.Key.Name)
+	eventsKey := cfg.String(config.EventsKey.Name)
+	ctag := cfg.String(config.ConsumerTag.Name)
+	secret := cfg.Bytes(config.ServiceSecret.Name)
+	keysFile := cfg.String("api-keys-file")
+	readLimit := cfg.Int("read-rate-limit")
+	writeLimit := cfg.Int("write-rate-limit")
+	eventsBuffer := cfg.Int("events-buffer")
+	port := cfg.Int(config.Port.Name)
+	timeout := cfg.Duration(config.ShutdownTimeout.Name)
+	exporter := cfg.String(config.TraceExporter.Name)
+	endpoint := cfg.String(config.OTLPEndpoint.Name)
+
+	// Export traces
+	log.WithFields(log.Fields{
+		"exporter": exporter,
+		"endpoint": endpoint,
+	}).Info("Setting up tracing")
+	stopTracing, err := tracing.Init("gateway", exporter, endpoint)
+	if err != nil {
+		log.WithField("exporter", exporter).WithError(err).Fatal("Can't set up tracing")
+	}
 
 	// Open AMQP connection
 	log.WithField("url", rabbit).Info("Connecting to AMQP server")
@@ -37,14 +104,13 @@ func main() {
 	if err != nil {
 		log.WithField("url", rabbit).WithError(err).Fatal("AMQP server connection failure")
 	}
-	defer conn.Close()
 
 	// Create AMQP publisher
 	log.WithFields(log.Fields{
 		"exchange": exchange,
 		"key":      key,
 	}).Info("Creating AQMP publisher")
-	pub, err := publisher.New(conn, exchange, key)
+	pub, err := publisher.New(conn, exchange, key, amqpSecret)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"exchange": exchange,
@@ -52,28 +118,127 @@ func main() {
 		}).WithError(err).Fatal("Can't create publisher")
 	}
 
+	// Subscribe to balance events, fanned out to clients by the hub
+	log.WithFields(log.Fields{
+		"exchange": exchange,
+		"key":      eventsKey,
+		"tag":      ctag,
+	}).Info("Creating AMQP subscriber")
+	consumer.MaxSkew = cfg.Duration(config.MaxSkew.Name)
+	c, err := consumer.NewSubscriber(conn, exchange, eventsKey, ctag, amqpSecret)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"exchange": exchange,
+			"key":      eventsKey,
+			"tag":      ctag,
+		}).WithError(err).Fatal("Can't create subscriber")
+	}
+	hub := gateway.NewHub(eventsBuffer)
+
+	// Create context that can be cancelled
+	ctx, cfunc := context.WithCancel(context.Background())
+	defer cfunc()
+
+	log.Info("Starting AMQP subscriber")
+	if err := c.Start(ctx, gateway.BalanceEventHandler(hub)); err != nil {
+		log.WithError(err).Fatal("Can't start subscriber")
+	}
+
 	// Create proxy
 	log.WithField("url", gmicro).Info("Creating reverse proxy")
 	url, err := url.Parse(gmicro)
 	if err != nil {
 		log.WithField("url", gmicro).WithError(err).Fatal("Can't create reverse proxy")
 	}
-	proxy := httputil.NewSingleHostReverseProxy(url)
+	proxy := gateway.NewProxy(url, secret)
+
+	// Create authorizer that checks roles against gmicro
+	auth := gateway.NewAuthorizer(url, secret)
+
+	// Load API keys for automated clients
+	keys := gateway.APIKeys{}
+	if keysFile != "" {
+		log.WithField("file", keysFile).Info("Loading API keys")
+		keys, err = gateway.LoadAPIKeys(keysFile)
+		if err != nil {
+			log.WithField("file", keysFile).WithError(err).Fatal("Can't load API keys")
+		}
+	}
 
 	// Create router
 	r := mux.NewRouter().StrictSlash(true)
-	r.Use(gateway.LoggingMiddleware)
+	limits := gateway.RateLimits{
+		Read:  gateway.PerMinute(readLimit),
+		Write: gateway.PerMinute(writeLimit),
+	}
+	spec := openapi.GatewaySpec()
+	r.Use(
+		tracing.Middleware,
+		gateway.LoggingMiddleware,
+		gateway.APIKeyMiddleware(keys),
+		gateway.RateLimitMiddleware(gateway.NewMemoryStore(), limits),
+		openapi.ValidationMiddleware(spec),
+	)
 	r.HandleFunc("/", gateway.StatusHandler).Methods("GET")
-	r.HandleFunc("/groups", gateway.ProxyHandler(proxy)).Methods("POST")
-	r.HandleFunc("/groups/{groupid}", gateway.ProxyHandler(proxy)).Methods("GET", "PUT", "DELETE")
+	r.HandleFunc("/openapi.json", openapi.Handler(spec)).Methods("GET")
+	r.HandleFunc("/groups", gateway.ProxyHandler(proxy)).Methods("GET", "POST")
+	r.HandleFunc("/groups/{groupid}", gateway.ProxyHandler(proxy)).Methods("GET", "PUT", "PATCH", "DELETE")
+	r.HandleFunc("/groups/{groupid}/archive", gateway.ProxyHandler(proxy)).Methods("POST")
+	r.HandleFunc("/groups/{groupid}/restore", gateway.ProxyHandler(proxy)).Methods("POST")
 	r.HandleFunc("/groups/{groupid}/members", gateway.ProxyHandler(proxy)).Methods("POST")
-	r.HandleFunc("/groups/{groupid}/members/{memberid}", gateway.ProxyHandler(proxy)).Methods("GET", "PUT", "DELETE")
-	r.HandleFunc("/groups/{groupid}/expenses", gateway.ExpensesHandler(pub)).Methods("POST", "DELETE")
-	r.HandleFunc("/groups/{groupid}/payments", gateway.PaymentsHandler(pub)).Methods("POST", "DELETE")
+	r.HandleFunc("/groups/{groupid}/members/{memberid}", gateway.ProxyHandler(proxy)).Methods("GET", "PUT", "PATCH", "DELETE")
+	r.HandleFunc("/groups/{groupid}/members/{memberid}/preferences", gateway.ProxyHandler(proxy)).Methods("GET", "PUT")
+	r.HandleFunc("/groups/{groupid}/invites", gateway.ProxyHandler(proxy)).Methods("POST")
+	r.HandleFunc("/groups/{groupid}/invites/{inviteid}", gateway.ProxyHandler(proxy)).Methods("DELETE")
+	r.HandleFunc("/invites/{token}", gateway.ProxyHandler(proxy)).Methods("POST")
+	r.HandleFunc("/groups/{groupid}/webhooks", gateway.ProxyHandler(proxy)).Methods("GET", "POST")
+	r.HandleFunc("/groups/{groupid}/webhooks/{webhookid}", gateway.ProxyHandler(proxy)).Methods("DELETE")
+	r.HandleFunc("/groups/{groupid}/webhooks/{webhookid}/deliveries", gateway.ProxyHandler(proxy)).Methods("GET")
+	r.HandleFunc("/groups/{groupid}/webhooks/{webhookid}/deliveries/{deliveryid}/redeliver", gateway.ProxyHandler(proxy)).Methods("POST")
+	r.HandleFunc("/groups/{groupid}/expenses", gateway.ExpensesHandler(pub, auth)).Methods("POST", "DELETE")
+	r.HandleFunc("/groups/{groupid}/payments", gateway.PaymentsHandler(pub, auth)).Methods("POST", "DELETE")
+	r.HandleFunc("/groups/{groupid}/events", gateway.EventsHandler(hub, auth)).Methods("GET")
+
+	// Check dependencies for readiness probes
+	checker := health.NewChecker(5 * time.Second)
+	checker.Add("amqp", health.AMQP(conn))
+	checker.Add("subscriber", health.Done(c.Done(), "Subscriber isn't running"))
+	checker.Add("gmicro", health.HTTP(&http.Client{Timeout: 5 * time.Second}, gmicro+"/readyz"))
+
+	// Serve probes and metrics without the API's middleware
+	root := http.NewServeMux()
+	root.HandleFunc("/livez", health.LiveHandler)
+	root.HandleFunc("/readyz", health.ReadyHandler(checker))
+	root.Handle("/metrics", metrics.Handler())
+	root.Handle("/", r)
 
 	// Start HTTP server
-	log.WithField("port", 8080).Info("Starting HTTP server")
-	if err := http.ListenAndServe(":8080", r); err != nil {
-		log.WithError(err).Fatal("Server fail")
+	srv := &http.Server{Addr: fmt.Sprintf(":%d", port), Handler: root}
+	srv.RegisterOnShutdown(hub.Close) // end event streams, which never go idle
+	go func() {
+		log.WithField("port", port).Info("Starting HTTP server")
+		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+			log.WithError(err).Fatal("Server fail")
+		}
+	}()
+
+	sig := shutdown.Wait() // blocking until we receive a signal
+	log.WithFields(log.Fields{
+		"signal":  sig,
+		"timeout": timeout,
+	}).Info("Shutting down")
+
+	failed := shutdown.Run(timeout,
+		shutdown.Step{Name: "http", Stop: srv.Shutdown},
+		shutdown.Step{Name: "subscriber", Stop: func(ctx context.Context) error {
+			cfunc()
+			return shutdown.Done(c.Done())(ctx)
+		}},
+		shutdown.Step{Name: "publisher", Stop: pub.Close},
+		shutdown.Step{Name: "amqp", Stop: shutdown.Close(conn.Close)},
+		shutdown.Step{Name: "tracing", Stop: stopTracing},
+	)
+	if failed > 0 {
+		os.Exit(1)
 	}
 }
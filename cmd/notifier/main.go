@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/postgres"
+	"github.com/streadway/amqp"
+	"github.com/varrrro/pay-up/internal/config"
+	"github.com/varrrro/pay-up/internal/consumer"
+	"github.com/varrrro/pay-up/internal/health"
+	"github.com/varrrro/pay-up/internal/logging"
+	"github.com/varrrro/pay-up/internal/metrics"
+	"github.com/varrrro/pay-up/internal/migrate"
+	"github.com/varrrro/pay-up/internal/notifier"
+	"github.com/varrrro/pay-up/internal/shutdown"
+	"github.com/varrrro/pay-up/internal/tracing"
+)
+
+// fields of the service's configuration.
+var fields = []config.Field{
+	config.Port,
+	config.ShutdownTimeout,
+	config.LogFormat,
+	config.LogLevel,
+	config.TraceExporter,
+	config.OTLPEndpoint,
+	config.RabbitConn,
+	config.AMQPSecret,
+	config.MaxSkew,
+	config.DBType,
+	config.DBConn,
+	config.Exchange,
+	config.Queue,
+	config.ConsumerTag,
+	config.EventsKey,
+	{Name: "smtp-addr", Env: "SMTP_ADDR", Usage: "Address of the SMTP server as host:port, or empty to log emails instead"},
+	{Name: "smtp-from", Env: "SMTP_FROM", Default: "payup@localhost", Usage: "Sender address of emails"},
+	{Name: "smtp-user", Env: "SMTP_USER", Usage: "User of the SMTP server, if it needs authentication"},
+	{Name: "smtp-password", Env: "SMTP_PASSWORD", Usage: "Password of the SMTP user", Secret: true},
+	{Name: "templates-dir", Env: "TEMPLATES_DIR", Usage: "Directory with templates overriding the default emails"},
+	{Name: "digest-interval", Env: "DIGEST_INTERVAL", Default: "1m", Usage: "Time between checks for weekly digests that are due", Check: config.Duration},
+}
+
+func init() {
+	// Log with the default settings until the configuration is loaded
+	logging.Setup(config.LogFormat.Default, config.LogLevel.Default)
+}
+
+func main() {
+	cfg, err := config.Load("notifier", fields, os.Args[1:])
+	if err == flag.ErrHelp {
+		return
+	} else if err != nil {
+		log.WithError(err).Fatal("Can't load configuration")
+	}
+	if cfg.PrintRequested() {
+		cfg.Print(os.Stdout)
+		return
+	}
+
+	// The migrate subcommand only needs the database
+	migrating := len(cfg.Args()) > 0 && cfg.Args()[0] == "migrate"
+	if migrating {
+		err = cfg.Validate(config.DBType.Name, config.DBConn.Name)
+	} else {
+		err = cfg.Validate()
+	}
+	if err != nil {
+		log.WithError(err).Fatal("Invalid configuration")
+	}
+	if err := logging.Setup(cfg.String(config.LogFormat.Name), cfg.String(config.LogLevel.Name)); err != nil {
+		log.WithError(err).Fatal("Invalid logging configuration")
+	}
+
+	rabbit := cfg.String(config.RabbitConn.Name)
+	amqpSecret := cfg.Bytes(config.AMQPSecret.Name)
+	dbtype := cfg.String(config.DBType.Name)
+	dbconn := cfg.String(config.DBConn.Name)
+	exchange := cfg.String(config.Exchange.Name)
+	queue := cfg.String(config.Queue.Name)
+	ctag := cfg.String(config.ConsumerTag.Name)
+	eventsKey := cfg.String(config.EventsKey.Name)
+	smtpAddr := cfg.String("smtp-addr")
+	smtpFrom := cfg.String("smtp-from")
+	templatesDir := cfg.String("templates-dir")
+	digestInterval := cfg.Duration("digest-interval")
+	port := cfg.Int(config.Port.Name)
+	timeout := cfg.Duration(config.ShutdownTimeout.Name)
+	exporter := cfg.String(config.TraceExporter.Name)
+	endpoint := cfg.String(config.OTLPEndpoint.Name)
+
+	// Open database connection
+	log.WithFields(log.Fields{
+		"db":  dbtype,
+		"url": dbconn,
+	}).Info("Connecting to database")
+	db, err := gorm.Open(dbtype, dbconn)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"url": dbconn,
+			"err": err,
+		}).Fatal("Database connection failure")
+	}
+
+	// Record query latency and trace queries
+	metrics.InstrumentDB(db)
+	tracing.InstrumentDB(db)
+
+	// Bring database schema up to date, or run the migrate subcommand
+	mig, err := migrate.New(db, notifier.MigrationsTable, notifier.Migrations)
+	if err != nil {
+		log.WithError(err).Fatal("Invalid migrations")
+	}
+	if migrating {
+		if err := migrate.Run(mig, cfg.Args()[1:]); err != nil {
+			log.WithError(err).Fatal("Migration failure")
+		}
+		db.Close()
+		return
+	}
+	done, err := mig.Up()
+	if err != nil {
+		log.WithError(err).Fatal("Migration failure")
+	}
+	log.WithField("applied", len(done)).Info("Database schema is up to date")
+
+	// Load email templates
+	templates, err := notifier.LoadTemplates(templatesDir)
+	if err != nil {
+		log.WithField("dir", templatesDir).WithError(err).Fatal("Can't load templates")
+	}
+
+	// Send emails through the SMTP server, or log them without one
+	var transport notifier.Transport = notifier.LogTransport{}
+	if smtpAddr != "" {
+		log.WithFields(log.Fields{
+			"addr": smtpAddr,
+			"from": smtpFrom,
+		}).Info("Sending emails through SMTP server")
+		transport, err = notifier.NewSMTPTransport(smtpAddr, smtpFrom, cfg.String("smtp-user"), cfg.String("smtp-password"))
+		if err != nil {
+			log.WithError(err).Fatal("Can't create SMTP transport")
+		}
+	} else {
+		log.Warn("No SMTP server configured, emails will be logged")
+	}
+
+	// Export traces
+	log.WithFields(log.Fields{
+		"exporter": exporter,
+		"endpoint": endpoint,
+	}).Info("Setting up tracing")
+	stopTracing, err := tracing.Init("notifier", exporter, endpoint)
+	if err != nil {
+		log.WithField("exporter", exporter).WithError(err).Fatal("Can't set up tracing")
+	}
+
+	// Open AMQP connection
+	log.WithField("url", rabbit).Info("Connecting to AMQP server")
+	conn, err := amqp.Dial(rabbit)
+	if err != nil {
+		log.WithField("url", rabbit).WithError(err).Fatal("AMQP server connection failure")
+	}
+
+	// Create data manager and mailer
+	nm := notifier.NewManager(db)
+	ml := notifier.NewMailer(transport, templates)
+
+	// Create AMQP consumer of domain events, whose queue keeps them while the
+	// notifier is down
+	log.WithFields(log.Fields{
+		"exchange": exchange,
+		"queue":    queue,
+		"key":      eventsKey,
+		"tag":      ctag,
+	}).Info("Creating AMQP consumer")
+	consumer.MaxSkew = cfg.Duration(config.MaxSkew.Name)
+	c, err := consumer.NewBound(conn, exchange, queue, eventsKey, ctag, amqpSecret)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"exchange": exchange,
+			"queue":    queue,
+			"key":      eventsKey,
+			"tag":      ctag,
+			"err":      err,
+		}).Fatal("Can't create consumer")
+	}
+
+	// Create context that can be cancelled
+	ctx, cfunc := context.WithCancel(context.Background())
+	defer cfunc()
+
+	log.Info("Starting AMQP consumer")
+	if err := c.Start(ctx, notifier.MessageHandler(nm, ml)); err != nil {
+		log.WithError(err).Fatal("Can't start consumer")
+	}
+
+	// Send weekly digests in the background
+	digester := notifier.NewDigester(nm, ml)
+	digester.Interval = digestInterval
+	log.Info("Starting digester")
+	digester.Start(ctx)
+
+	// Check dependencies for readiness probes
+	checker := health.NewChecker(5 * time.Second)
+	checker.Add("database", health.Database(db.DB()))
+	checker.Add("amqp", health.AMQP(conn))
+	checker.Add("consumer", health.Done(c.Done(), "Consumer isn't running"))
+	checker.Add("digests", health.Done(digester.Done(), "Digester isn't running"))
+
+	// Serve probes and metrics
+	root := http.NewServeMux()
+	root.HandleFunc("/livez", health.LiveHandler)
+	root.HandleFunc("/readyz", health.ReadyHandler(checker))
+	root.Handle("/metrics", metrics.Handler())
+
+	// Start HTTP server
+	srv := &http.Server{Addr: fmt.Sprintf(":%d", port), Handler: root}
+	go func() {
+		log.WithField("port", port).Info("Starting HTTP server")
+		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+			log.WithError(err).Fatal("Server fail")
+		}
+	}()
+
+	sig := shutdown.Wait() // blocking until we receive a signal
+	log.WithFields(log.Fields{
+		"signal":  sig,
+		"timeout": timeout,
+	}).Info("Shutting down")
+
+	failed := shutdown.Run(timeout,
+		shutdown.Step{Name: "http", Stop: srv.Shutdown},
+		shutdown.Step{Name: "consumer", Stop: func(ctx context.Context) error {
+			cfunc()
+			return shutdown.Done(c.Done())(ctx)
+		}},
+		shutdown.Step{Name: "digests", Stop: shutdown.Done(digester.Done())}, // shares the consumer's context
+		shutdown.Step{Name: "amqp", Stop: shutdown.Close(conn.Close)},
+		shutdown.Step{Name: "tracing", Stop: stopTracing},
+		shutdown.Step{Name: "database", Stop: shutdown.Close(db.Close)},
+	)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
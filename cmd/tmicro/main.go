@@ -2,51 +2,91 @@ package main
 
 import (
 	"context"
+	"flag"
+	"fmt"
+	"net/http"
 	"os"
-	"os/signal"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
 	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/postgres"
 	"github.com/streadway/amqp"
+	"github.com/varrrro/pay-up/internal/config"
 	"github.com/varrrro/pay-up/internal/consumer"
+	"github.com/varrrro/pay-up/internal/health"
+	"github.com/varrrro/pay-up/internal/logging"
+	"github.com/varrrro/pay-up/internal/metrics"
+	"github.com/varrrro/pay-up/internal/migrate"
 	"github.com/varrrro/pay-up/internal/publisher"
+	"github.com/varrrro/pay-up/internal/shutdown"
 	"github.com/varrrro/pay-up/internal/tmicro"
-	"github.com/varrrro/pay-up/internal/tmicro/expense"
-	"github.com/varrrro/pay-up/internal/tmicro/payment"
+	"github.com/varrrro/pay-up/internal/tracing"
 )
 
+// fields of the service's configuration.
+var fields = []config.Field{
+	config.Port,
+	config.ShutdownTimeout,
+	config.LogFormat,
+	config.LogLevel,
+	config.TraceExporter,
+	config.OTLPEndpoint,
+	config.RabbitConn,
+	config.AMQPSecret,
+	config.MaxSkew,
+	config.DBType,
+	config.DBConn,
+	config.Exchange,
+	config.Key,
+	config.Queue,
+	config.ConsumerTag,
+}
+
 func init() {
-	// Set log formatter
-	log.SetFormatter(&log.TextFormatter{
-		DisableColors: true,
-		FullTimestamp: true,
-	})
-
-	// Write logs to stdout
-	log.SetOutput(os.Stdout)
+	// Log with the default settings until the configuration is loaded
+	logging.Setup(config.LogFormat.Default, config.LogLevel.Default)
 }
 
 func main() {
-	rabbit := os.Getenv("RABBIT_CONN")
-	dbtype := os.Getenv("DB_TYPE")
-	dbconn := os.Getenv("DB_CONN")
-	exchange := os.Getenv("EXCHANGE")
-	key := os.Getenv("KEY")
-	queue := os.Getenv("QUEUE")
-	ctag := os.Getenv("CTAG")
+	cfg, err := config.Load("tmicro", fields, os.Args[1:])
+	if err == flag.ErrHelp {
+		return
+	} else if err != nil {
+		log.WithError(err).Fatal("Can't load configuration")
+	}
+	if cfg.PrintRequested() {
+		cfg.Print(os.Stdout)
+		return
+	}
 
-	// Open AMQP connection
-	log.WithField("url", rabbit).Info("Connecting to AMQP server")
-	conn, err := amqp.Dial(rabbit)
+	// The migrate subcommand only needs the database
+	migrating := len(cfg.Args()) > 0 && cfg.Args()[0] == "migrate"
+	if migrating {
+		err = cfg.Validate(config.DBType.Name, config.DBConn.Name)
+	} else {
+		err = cfg.Validate()
+	}
 	if err != nil {
-		log.WithFields(log.Fields{
-			"url": rabbit,
-			"err": err,
-		}).Fatal("AMQP server connection failure")
+		log.WithError(err).Fatal("Invalid configuration")
+	}
+	if err := logging.Setup(cfg.String(config.LogFormat.Name), cfg.String(config.LogLevel.Name)); err != nil {
+		log.WithError(err).Fatal("Invalid logging configuration")
 	}
-	defer conn.Close()
+
+	rabbit := cfg.String(config.RabbitConn.Name)
+	amqpSecret := cfg.Bytes(config.AMQPSecret.Name)
+	dbtype := cfg.String(config.DBType.Name)
+	dbconn := cfg.String(config.DBConn.Name)
+	exchange := cfg.String(config.Exchange.Name)
+	key := cfg.String(config.Key.Name)
+	queue := cfg.String(config.Queue.Name)
+	ctag := cfg.String(config.ConsumerTag.Name)
+	port := cfg.Int(config.Port.Name)
+	timeout := cfg.Duration(config.ShutdownTimeout.Name)
+	exporter := cfg.String(config.TraceExporter.Name)
+	endpoint := cfg.String(config.OTLPEndpoint.Name)
 
 	// Open database connection
 	log.WithFields(log.Fields{
@@ -60,10 +100,48 @@ func main() {
 			"err": err,
 		}).Fatal("Database connection failure")
 	}
-	defer db.Close()
 
-	// Check if database schema is correct
-	checkSchema(db)
+	// Record query latency and trace queries
+	metrics.InstrumentDB(db)
+	tracing.InstrumentDB(db)
+
+	// Bring database schema up to date, or run the migrate subcommand
+	mig, err := migrate.New(db, tmicro.MigrationsTable, tmicro.Migrations)
+	if err != nil {
+		log.WithError(err).Fatal("Invalid migrations")
+	}
+	if migrating {
+		if err := migrate.Run(mig, cfg.Args()[1:]); err != nil {
+			log.WithError(err).Fatal("Migration failure")
+		}
+		db.Close()
+		return
+	}
+	done, err := mig.Up()
+	if err != nil {
+		log.WithError(err).Fatal("Migration failure")
+	}
+	log.WithField("applied", len(done)).Info("Database schema is up to date")
+
+	// Export traces
+	log.WithFields(log.Fields{
+		"exporter": exporter,
+		"endpoint": endpoint,
+	}).Info("Setting up tracing")
+	stopTracing, err := tracing.Init("tmicro", exporter, endpoint)
+	if err != nil {
+		log.WithField("exporter", exporter).WithError(err).Fatal("Can't set up tracing")
+	}
+
+	// Open AMQP connection
+	log.WithField("url", rabbit).Info("Connecting to AMQP server")
+	conn, err := amqp.Dial(rabbit)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"url": rabbit,
+			"err": err,
+		}).Fatal("AMQP server connection failure")
+	}
 
 	// Create data manager
 	tm := tmicro.NewManager(db)
@@ -73,7 +151,7 @@ func main() {
 		"exchange": exchange,
 		"key":      key,
 	}).Info("Creating AMQP publisher")
-	pub, err := publisher.New(conn, exchange, key)
+	pub, err := publisher.New(conn, exchange, key, amqpSecret)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"exchange": exchange,
@@ -88,7 +166,8 @@ func main() {
 		"queue":    queue,
 		"tag":      ctag,
 	}).Info("Creating AMQP consumer")
-	c, err := consumer.New(conn, exchange, queue, ctag)
+	consumer.MaxSkew = cfg.Duration(config.MaxSkew.Name)
+	c, err := consumer.New(conn, exchange, queue, ctag, amqpSecret)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"exchange": exchange,
@@ -98,26 +177,54 @@ func main() {
 		}).Fatal("Can't create consumer")
 	}
 
-	// Create channel to listen for OS signals
-	sch := make(chan os.Signal, 1)
-	signal.Notify(sch, os.Interrupt, os.Kill)
-
 	// Create context that can be cancelled
 	ctx, cfunc := context.WithCancel(context.Background())
 	defer cfunc()
 
 	log.Info("Starting AMQP consumer")
-	c.Start(ctx, tmicro.MessageHandler(tm, pub)) // start consumer
-
-	<-sch // blocking until we receive a signal
-}
-
-func checkSchema(db *gorm.DB) {
-	if !db.HasTable(&expense.Expense{}) {
-		db.CreateTable(&expense.Expense{})
+	if err := c.Start(ctx, tmicro.MessageHandler(tm, pub)); err != nil {
+		log.WithError(err).Fatal("Can't start consumer")
 	}
 
-	if !db.HasTable(&payment.Payment{}) {
-		db.CreateTable(&payment.Payment{})
+	// Check dependencies for readiness probes
+	checker := health.NewChecker(5 * time.Second)
+	checker.Add("database", health.Database(db.DB()))
+	checker.Add("amqp", health.AMQP(conn))
+	checker.Add("consumer", health.Done(c.Done(), "Consumer isn't running"))
+
+	// Serve probes
+	root := http.NewServeMux()
+	root.HandleFunc("/livez", health.LiveHandler)
+	root.HandleFunc("/readyz", health.ReadyHandler(checker))
+	root.Handle("/metrics", metrics.Handler())
+
+	// Start HTTP server for probes
+	srv := &http.Server{Addr: fmt.Sprintf(":%d", port), Handler: root}
+	go func() {
+		log.WithField("port", port).Info("Starting HTTP server")
+		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+			log.WithError(err).Fatal("Server fail")
+		}
+	}()
+
+	sig := shutdown.Wait() // blocking until we receive a signal
+	log.WithFields(log.Fields{
+		"signal":  sig,
+		"timeout": timeout,
+	}).Info("Shutting down")
+
+	failed := shutdown.Run(timeout,
+		shutdown.Step{Name: "http", Stop: srv.Shutdown},
+		shutdown.Step{Name: "consumer", Stop: func(ctx context.Context) error {
+			cfunc()
+			return shutdown.Done(c.Done())(ctx)
+		}},
+		shutdown.Step{Name: "publisher", Stop: pub.Close},
+		shutdown.Step{Name: "amqp", Stop: shutdown.Close(conn.Close)},
+		shutdown.Step{Name: "tracing", Stop: stopTracing},
+		shutdown.Step{Name: "database", Stop: shutdown.Close(db.Close)},
+	)
+	if failed > 0 {
+		os.Exit(1)
 	}
 }
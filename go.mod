@@ -6,6 +6,11 @@ require (
 	github.com/google/uuid v1.1.1
 	github.com/gorilla/mux v1.7.3
 	github.com/jinzhu/gorm v1.9.11
-	github.com/sirupsen/logrus v1.2.0
+	github.com/lib/pq v1.1.1
+	github.com/prometheus/client_golang v1.2.1
+	github.com/sirupsen/logrus v1.4.2
 	github.com/streadway/amqp v0.0.0-20190827072141-edfb9018d271
+	go.opentelemetry.io/otel v0.8.0
+	go.opentelemetry.io/otel/exporters/otlp v0.8.0
+	gopkg.in/yaml.v2 v2.4.0
 )
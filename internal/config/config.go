@@ -0,0 +1,295 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Redacted replaces the value of secrets when the configuration is printed.
+const Redacted = "<redacted>"
+
+// Field of a service's configuration. Values come from the field's default,
+// the configuration file, the environment and command-line flags, each one
+// overriding the previous ones.
+type Field struct {
+	Name     string             // flag name and configuration file key
+	Env      string             // environment variable
+	Default  string             // value when no other source sets it
+	Usage    string             // description shown by --help
+	Required bool               // value must be set
+	Secret   bool               // value is redacted by --print-config
+	Check    func(string) error // validates values that are set
+}
+
+// Fields shared by the services.
+var (
+	Port            = Field{Name: "port", Env: "PORT", Default: "8080", Usage: "HTTP server port", Check: PortNumber}
+	ShutdownTimeout = Field{Name: "shutdown-timeout", Env: "SHUTDOWN_TIMEOUT", Default: "30s", Usage: "Time to drain requests and messages when stopping", Check: Duration}
+
+	LogFormat = Field{Name: "log-format", Env: "LOG_FORMAT", Default: "text", Usage: "Format of log output", Check: OneOf("text", "json")}
+	LogLevel  = Field{Name: "log-level", Env: "LOG_LEVEL", Default: "info", Usage: "Minimum level of logged entries", Check: OneOf("trace", "debug", "info", "warn", "error", "fatal", "panic")}
+
+	RabbitConn  = Field{Name: "rabbit-conn", Env: "RABBIT_CONN", Usage: "AMQP server URL", Required: true, Secret: true, Check: URL}
+	AMQPSecret  = Field{Name: "amqp-secret", Env: "AMQP_SECRET", Usage: "Secret that signs AMQP messages", Required: true, Secret: true}
+	MaxSkew     = Field{Name: "max-skew", Env: "MAX_SKEW", Default: "24h", Usage: "Age of new AMQP messages before they're rejected as stale", Check: Duration}
+	Exchange    = Field{Name: "exchange", Env: "EXCHANGE", Usage: "AMQP exchange", Required: true}
+	Key         = Field{Name: "key", Env: "KEY", Usage: "Routing key of published messages", Required: true}
+	Queue       = Field{Name: "queue", Env: "QUEUE", Usage: "AMQP queue consumed", Required: true}
+	ConsumerTag = Field{Name: "ctag", Env: "CTAG", Usage: "AMQP consumer tag"}
+	EventsKey   = Field{Name: "events-key", Env: "EVENTS_KEY", Default: "balance-events", Usage: "Routing key of domain events", Required: true}
+
+	DBType = Field{Name: "db-type", Env: "DB_TYPE", Default: "postgres", Usage: "Database driver", Required: true, Check: OneOf("postgres", "sqlite3")}
+	DBConn = Field{Name: "db-conn", Env: "DB_CONN", Usage: "Database connection string", Required: true, Secret: true}
+
+	ServiceSecret = Field{Name: "service-secret", Env: "SERVICE_SECRET", Usage: "Secret that signs gateway requests", Required: true, Secret: true}
+
+	TraceExporter = Field{Name: "trace-exporter", Env: "TRACE_EXPORTER", Default: "none", Usage: "Exporter of trace spans", Check: OneOf("none", "stdout", "otlp")}
+	OTLPEndpoint  = Field{Name: "otlp-endpoint", Env: "OTLP_ENDPOINT", Default: "localhost:55680", Usage: "Address of the OTLP collector receiving spans"}
+)
+
+// Config of a service.
+type Config struct {
+	fields []Field
+	values map[string]string
+	print  bool
+	args   []string
+}
+
+// Load the configuration of the service from the command-line arguments,
+// the environment and the configuration file given by --config or
+// CONFIG_FILE. Values aren't validated until Validate is called.
+func Load(service string, fields []Field, args []string) (*Config, error) {
+	c := &Config{fields: fields, values: make(map[string]string, len(fields))}
+
+	fs := flag.NewFlagSet(service, flag.ContinueOnError)
+	file := fs.String("config", os.Getenv("CONFIG_FILE"), "Configuration file in YAML")
+	fs.BoolVar(&c.print, "print-config", false, "Print the configuration, with secrets redacted, and exit")
+	for _, f := range fields {
+		usage := f.Usage
+		if f.Env != "" {
+			usage += " (" + f.Env + ")"
+		}
+		fs.String(f.Name, f.Default, usage)
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	c.args = fs.Args()
+
+	// Defaults
+	for _, f := range fields {
+		c.values[f.Name] = f.Default
+	}
+
+	// Configuration file
+	if *file != "" {
+		if err := c.loadFile(*file); err != nil {
+			return nil, err
+		}
+	}
+
+	// Environment, where empty variables count as unset
+	for _, f := range fields {
+		if v := os.Getenv(f.Env); f.Env != "" && v != "" {
+			c.values[f.Name] = v
+		}
+	}
+
+	// Flags
+	fs.Visit(func(fl *flag.Flag) {
+		if _, ok := c.values[fl.Name]; ok {
+			c.values[fl.Name] = fl.Value.String()
+		}
+	})
+
+	return c, nil
+}
+
+func (c *Config) loadFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("Can't read configuration file [File]: %s [Error]: %s", path, err.Error())
+	}
+
+	var settings map[string]interface{}
+	if err := yaml.Unmarshal(data, &settings); err != nil {
+		return fmt.Errorf("Can't parse configuration file [File]: %s [Error]: %s", path, err.Error())
+	}
+
+	for k, v := range settings {
+		if _, ok := c.values[k]; !ok {
+			return fmt.Errorf("Unknown setting in configuration file [File]: %s [Setting]: %s", path, k)
+		}
+		if v != nil {
+			c.values[k] = fmt.Sprint(v)
+		}
+	}
+
+	return nil
+}
+
+// Validate the given fields, or every field if none is given, returning an
+// error that lists every problem found.
+func (c *Config) Validate(names ...string) error {
+	only := make(map[string]bool, len(names))
+	for _, n := range names {
+		only[n] = true
+	}
+
+	var problems []string
+	for _, f := range c.fields {
+		if len(only) > 0 && !only[f.Name] {
+			continue
+		}
+
+		v := c.values[f.Name]
+		switch {
+		case v == "" && f.Required:
+			problems = append(problems, fmt.Sprintf("%s is required, set it with --%s, %s or the configuration file", f.Name, f.Name, f.Env))
+		case v != "" && f.Check != nil:
+			if err := f.Check(v); err != nil {
+				problems = append(problems, fmt.Sprintf("%s %s", f.Name, err.Error()))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return &Error{problems}
+	}
+
+	return nil
+}
+
+// String value of the field.
+func (c *Config) String(name string) string {
+	return c.values[name]
+}
+
+// Bytes of the field's value, for secrets.
+func (c *Config) Bytes(name string) []byte {
+	return []byte(c.values[name])
+}
+
+// Int value of the field, or 0 if it isn't an integer.
+func (c *Config) Int(name string) int {
+	n, _ := strconv.Atoi(c.values[name])
+	return n
+}
+
+// Duration value of the field, or 0 if it isn't a duration.
+func (c *Config) Duration(name string) time.Duration {
+	d, _ := time.ParseDuration(c.values[name])
+	return d
+}
+
+// Args left after the flags, such as subcommands.
+func (c *Config) Args() []string {
+	return c.args
+}
+
+// PrintRequested reports whether --print-config was given.
+func (c *Config) PrintRequested() bool {
+	return c.print
+}
+
+// Print the configuration as YAML, with the values of secrets redacted.
+func (c *Config) Print(w io.Writer) error {
+	out := make(yaml.MapSlice, 0, len(c.fields))
+	for _, f := range c.fields {
+		v := c.values[f.Name]
+		if f.Secret && v != "" {
+			v = Redacted
+		}
+		out = append(out, yaml.MapItem{Key: f.Name, Value: v})
+	}
+
+	data, err := yaml.Marshal(out)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
+}
+
+// Int checks that the value is an integer.
+func Int(v string) error {
+	if _, err := strconv.Atoi(v); err != nil {
+		return errors.New("must be an integer")
+	}
+
+	return nil
+}
+
+// PositiveInt checks that the value is an integer greater than 0.
+func PositiveInt(v string) error {
+	if n, err := strconv.Atoi(v); err != nil || n <= 0 {
+		return errors.New("must be a positive integer")
+	}
+
+	return nil
+}
+
+// PortNumber checks that the value is a TCP port number.
+func PortNumber(v string) error {
+	if n, err := strconv.Atoi(v); err != nil || n < 1 || n > 65535 {
+		return errors.New("must be a port number between 1 and 65535")
+	}
+
+	return nil
+}
+
+// Duration checks that the value is a positive duration, such as 30s.
+func Duration(v string) error {
+	if d, err := time.ParseDuration(v); err != nil || d <= 0 {
+		return errors.New("must be a positive duration, such as 30s")
+	}
+
+	return nil
+}
+
+// URL checks that the value is an absolute URL.
+func URL(v string) error {
+	if u, err := url.Parse(v); err != nil || u.Scheme == "" || u.Host == "" {
+		return errors.New("must be an absolute URL")
+	}
+
+	return nil
+}
+
+// OneOf checks that the value is one of the given ones.
+func OneOf(allowed ...string) func(string) error {
+	return func(v string) error {
+		for _, a := range allowed {
+			if v == a {
+				return nil
+			}
+		}
+
+		return fmt.Errorf("must be one of %s", strings.Join(allowed, ", "))
+	}
+}
+
+// Error returned when the configuration isn't valid.
+type Error struct {
+	problems []string
+}
+
+func (e *Error) Error() string {
+	return "Invalid configuration: " + strings.Join(e.problems, "; ")
+}
+
+// Problems found in the configuration.
+func (e *Error) Problems() []string {
+	return e.problems
+}
@@ -0,0 +1,166 @@
+package config_test
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/varrrro/pay-up/internal/config"
+)
+
+var fields = []config.Field{
+	config.Port,
+	config.DBType,
+	config.DBConn,
+	{Name: "name", Env: "TEST_NAME", Default: "default", Usage: "Test name"},
+}
+
+// writeFile with the given content in a temporary directory, returning its
+// path.
+func writeFile(t *testing.T, content string) string {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatalf("Can't create temporary directory [Error]: %v", err)
+	}
+	path := filepath.Join(dir, "config.yml")
+	ioutil.WriteFile(path, []byte(content), 0600)
+
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	file := writeFile(t, "port: 9000\ndb-conn: file\nname: file\n")
+
+	os.Setenv("DB_CONN", "env")
+	os.Setenv("TEST_NAME", "")
+	defer os.Unsetenv("DB_CONN")
+	defer os.Unsetenv("TEST_NAME")
+
+	cfg, err := config.Load("test", fields, []string{"--config", file, "--port", "9090", "migrate", "up"})
+	if err != nil {
+		t.Fatalf("Can't load configuration [Error]: %v", err)
+	}
+
+	cases := []struct {
+		name     string
+		expected string
+	}{
+		{"db-type", "postgres"}, // default
+		{"name", "file"},        // file, since empty variables are unset
+		{"db-conn", "env"},      // environment over file
+		{"port", "9090"},        // flag over file
+	}
+
+	for _, tc := range cases {
+		if v := cfg.String(tc.name); v != tc.expected {
+			t.Errorf("Wrong %s [Expected]: %s [Actual]: %s", tc.name, tc.expected, v)
+		}
+	}
+
+	if cfg.Int("port") != 9090 {
+		t.Errorf("Wrong port [Expected]: %d [Actual]: %d", 9090, cfg.Int("port"))
+	}
+	if args := cfg.Args(); len(args) != 2 || args[0] != "migrate" {
+		t.Errorf("Wrong arguments [Expected]: %v [Actual]: %v", []string{"migrate", "up"}, args)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	cases := []struct {
+		name string
+		args []string
+	}{
+		{"Unknown flag", []string{"--test"}},
+		{"Missing file", []string{"--config", "/nonexistent.yml"}},
+		{"Malformed file", []string{"--config", writeFile(t, "port: [")}},
+		{"Unknown setting", []string{"--config", writeFile(t, "test: 1")}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := config.Load("test", fields, tc.args); err == nil {
+				t.Error("Loading wrong configuration didn't return an error")
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	cfg, _ := config.Load("test", fields, []string{"--port", "test", "--db-type", "mysql"})
+
+	err := cfg.Validate()
+	var cerr *config.Error
+	if !errors.As(err, &cerr) {
+		t.Fatalf("Wrong error [Expected]: %T [Actual]: %v", cerr, err)
+	}
+
+	// Every problem is reported at once
+	if n := len(cerr.Problems()); n != 3 {
+		t.Errorf("Wrong problem count [Expected]: %d [Actual]: %d", 3, n)
+	}
+	for _, name := range []string{"port", "db-type", "db-conn is required"} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("Problem not reported [Expected]: %s [Actual]: %v", name, err)
+		}
+	}
+
+	// Only the given fields are validated
+	if err := cfg.Validate("name"); err != nil {
+		t.Errorf("Validating valid field returned an error [Error]: %v", err)
+	}
+}
+
+func TestChecks(t *testing.T) {
+	cases := []struct {
+		name  string
+		check func(string) error
+		value string
+		valid bool
+	}{
+		{"Int", config.Int, "-1", true},
+		{"Int", config.Int, "test", false},
+		{"PositiveInt", config.PositiveInt, "1", true},
+		{"PositiveInt", config.PositiveInt, "0", false},
+		{"PositiveInt", config.PositiveInt, "-1", false},
+		{"PositiveInt", config.PositiveInt, "test", false},
+		{"PortNumber", config.PortNumber, "8080", true},
+		{"PortNumber", config.PortNumber, "0", false},
+		{"PortNumber", config.PortNumber, "-1", false},
+		{"PortNumber", config.PortNumber, "65536", false},
+		{"Duration", config.Duration, "30s", true},
+		{"Duration", config.Duration, "0s", false},
+		{"Duration", config.Duration, "-1s", false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name+" "+tc.value, func(t *testing.T) {
+			if err := tc.check(tc.value); (err == nil) != tc.valid {
+				t.Errorf("Wrong result [Expected valid]: %v [Error]: %v", tc.valid, err)
+			}
+		})
+	}
+}
+
+func TestPrint(t *testing.T) {
+	cfg, _ := config.Load("test", fields, []string{"--print-config", "--db-conn", "password=secret"})
+	if !cfg.PrintRequested() {
+		t.Error("Printing configuration wasn't requested")
+	}
+
+	var buf bytes.Buffer
+	if err := cfg.Print(&buf); err != nil {
+		t.Fatalf("Can't print configuration [Error]: %v", err)
+	}
+
+	out := buf.String()
+	if strings.Contains(out, "secret") {
+		t.Errorf("Secret wasn't redacted [Output]: %s", out)
+	}
+	if !strings.Contains(out, "db-conn: "+config.Redacted) || !strings.Contains(out, "port: \"8080\"") {
+		t.Errorf("Wrong output [Output]: %s", out)
+	}
+}
@@ -2,36 +2,63 @@ package consumer
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"time"
 
+	log "github.com/sirupsen/logrus"
 	"github.com/streadway/amqp"
+	"github.com/varrrro/pay-up/internal/inbox"
+	"github.com/varrrro/pay-up/internal/logging"
+	"github.com/varrrro/pay-up/internal/metrics"
+	"github.com/varrrro/pay-up/internal/signature"
+	"github.com/varrrro/pay-up/internal/tracing"
+	"go.opentelemetry.io/otel/api/kv"
+	"go.opentelemetry.io/otel/api/trace"
 )
 
+// MaxSkew is the maximum age of a message, when first delivered, before it's
+// rejected as stale. It's long enough for the messages that waited in a queue
+// while its consumers were down or behind, and redelivered messages aren't
+// checked, so messages requeued after a temporary error are never stale.
+var MaxSkew = 24 * time.Hour
+
+// RetryDelay before requeueing a message whose handler failed temporarily.
+const RetryDelay = time.Second
+
 // Consumer of AMQP messages.
 type Consumer struct {
-	conn  *amqp.Connection
-	queue string
-	tag   string
+	conn     *amqp.Connection
+	queue    string
+	tag      string
+	verifier *signature.Verifier
+	done     chan struct{}
+}
+
+// New Consumer instance that only accepts messages signed with the shared
+// secret.
+func New(conn *amqp.Connection, exchange, queue, tag string, secret []byte) (*Consumer, error) {
+	return NewBound(conn, exchange, queue, queue, tag, secret)
 }
 
-// New Consumer instance.
-func New(conn *amqp.Connection, exchange, queue, tag string) (*Consumer, error) {
+// NewBound Consumer instance of a durable queue bound to the routing key, so
+// services sharing the queue split its messages and none is lost while they
+// are down. Rejected messages are dead-lettered to the queue's dead-letter
+// queue, where they can be inspected and moved back once fixed.
+func NewBound(conn *amqp.Connection, exchange, queue, key, tag string, secret []byte) (*Consumer, error) {
 	ch, err := conn.Channel()
 	if err != nil {
 		return nil, fmt.Errorf("Couldn't create channel. Error: %s", err.Error())
 	}
 	defer ch.Close()
 
-	if err = ch.ExchangeDeclare(
-		exchange, // name
-		"direct", // type
-		true,     // durable
-		false,    // autoDelete
-		false,    // internal
-		false,    // noWait
-		nil,      // args
-	); err != nil {
-		return nil, fmt.Errorf("Couldn't declare exchange. Error: %s", err.Error())
+	if err = declareExchange(ch, exchange); err != nil {
+		return nil, err
+	}
+
+	dlx, err := declareDeadLetters(ch, exchange, queue)
+	if err != nil {
+		return nil, err
 	}
 
 	if _, err = ch.QueueDeclare(
@@ -40,14 +67,62 @@ func New(conn *amqp.Connection, exchange, queue, tag string) (*Consumer, error)
 		false, // autoDelete
 		false, // exclusive
 		false, // noWait
-		nil,   // args
+		amqp.Table{
+			"x-dead-letter-exchange":    dlx,
+			"x-dead-letter-routing-key": queue,
+		}, // args
 	); err != nil {
 		return nil, fmt.Errorf("Couldn't declare queue. Error: %s", err.Error())
 	}
 
 	if err = ch.QueueBind(
 		queue,    // queue name
-		queue,    // routing key
+		key,      // routing key
+		exchange, // exchange name
+		false,    // noWait
+		nil,      // args
+	); err != nil {
+		return nil, fmt.Errorf("Couldn't bind queue to exchange. Error: %s", err.Error())
+	}
+
+	return &Consumer{
+		conn:     conn,
+		queue:    queue,
+		tag:      tag,
+		verifier: signature.NewVerifier(secret, MaxSkew),
+		done:     make(chan struct{}),
+	}, nil
+}
+
+// NewSubscriber Consumer instance with its own exclusive queue, bound to the
+// routing key, so every subscriber gets a copy of each message. The queue is
+// deleted when the connection closes.
+func NewSubscriber(conn *amqp.Connection, exchange, key, tag string, secret []byte) (*Consumer, error) {
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't create channel. Error: %s", err.Error())
+	}
+	defer ch.Close()
+
+	if err = declareExchange(ch, exchange); err != nil {
+		return nil, err
+	}
+
+	q, err := ch.QueueDeclare(
+		"",    // name, generated by the server
+		false, // durable
+		true,  // autoDelete
+		true,  // exclusive
+		false, // noWait
+		nil,   // args
+	)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't declare queue. Error: %s", err.Error())
+	}
+
+	if err = ch.QueueBind(
+		q.Name,   // queue name
+		key,      // routing key
 		exchange, // exchange name
 		false,    // noWait
 		nil,      // args
@@ -56,16 +131,19 @@ func New(conn *amqp.Connection, exchange, queue, tag string) (*Consumer, error)
 	}
 
 	return &Consumer{
-		conn:  conn,
-		queue: queue,
-		tag:   tag,
+		conn:     conn,
+		queue:    q.Name,
+		tag:      tag,
+		verifier: signature.NewVerifier(secret, MaxSkew),
+		done:     make(chan struct{}),
 	}, nil
 }
 
-// Start consuming messages with the given handler.
+// Start consuming messages with the given handler, which receives a context
+// carrying the trace context of each message.
 //
 // Based on https://codereview.stackexchange.com/a/199894
-func (c *Consumer) Start(ctx context.Context, handle func(string, []byte) error) error {
+func (c *Consumer) Start(ctx context.Context, handle func(context.Context, string, []byte) error) error {
 	ch, err := c.conn.Channel()
 	if err != nil {
 		return err
@@ -84,21 +162,90 @@ func (c *Consumer) Start(ctx context.Context, handle func(string, []byte) error)
 		return err
 	}
 
-	// Handle the messages
+	// Handle the messages until the context is cancelled. Closing the channel
+	// afterwards requeues the messages delivered but not handled yet.
 	go func() {
-		select {
-		case <-ctx.Done():
-			return
-		case msg := <-msgs:
-			op, ok := msg.Headers["operation"]
-			if !ok {
-				msg.Nack(false, false)
-			}
+		defer close(c.done)
+		defer ch.Close()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-msgs:
+				if !ok {
+					return
+				}
+
+				op, ok := msg.Headers["operation"].(string)
+				if !ok {
+					metrics.MessagesConsumed.WithLabelValues("unknown").Inc()
+					metrics.MessagesNacked.WithLabelValues("unknown", "false").Inc()
+					msg.Nack(false, false)
+					continue
+				}
+				metrics.MessagesConsumed.WithLabelValues(op).Inc()
+
+				mctx := inbox.WithMessageID(messageContext(msg), msg.MessageId)
+				logger := logging.FromContext(mctx).WithFields(log.Fields{
+					"operation": op,
+					"id":        msg.MessageId,
+				})
+
+				// Reject unsigned or stale messages. Only new messages can
+				// be stale, the broker sets the redelivered flag and
+				// publishers can't. Replays are detected by the handlers,
+				// which record the messages handled in their database.
+				sig, _ := msg.Headers["signature"].(string)
+				err := c.verifier.Verify(sig, op, msg.MessageId, msg.Timestamp, msg.Body)
+				if err == nil && !msg.Redelivered {
+					err = c.verifier.Fresh(msg.Timestamp, time.Now())
+				}
+				if err != nil {
+					logger.WithError(err).Warn("Rejecting AMQP message")
+					metrics.MessagesNacked.WithLabelValues(op, "false").Inc()
+					msg.Nack(false, false)
+					continue
+				}
 
-			if err := handle(op.(string), msg.Body); err != nil {
-				msg.Nack(false, false)
-			} else {
-				msg.Ack(false)
+				mctx, span := tracing.Tracer().Start(mctx, "consume "+op,
+					trace.WithSpanKind(trace.SpanKindConsumer),
+					trace.WithAttributes(
+						kv.String("messaging.system", "rabbitmq"),
+						kv.String("messaging.destination", c.queue),
+						kv.String("messaging.operation", op),
+						kv.String("messaging.message_id", msg.MessageId),
+					),
+				)
+
+				start := time.Now()
+				metrics.ConsumerLag.WithLabelValues(op).Observe(start.Sub(msg.Timestamp).Seconds())
+				err = handle(mctx, op, msg.Body)
+				metrics.HandlerDuration.WithLabelValues(op).Observe(time.Since(start).Seconds())
+				tracing.End(mctx, span, err)
+
+				if errors.Is(err, inbox.ErrHandled) {
+					logger.Warn("Skipping AMQP message already handled")
+					metrics.MessagesAcked.WithLabelValues(op).Inc()
+					msg.Ack(false)
+				} else if err != nil {
+					logger := logger.WithError(err)
+
+					// Requeue messages that may succeed later, dead-letter the rest
+					if temporary(err) {
+						logger.Warn("Requeueing AMQP message")
+						time.Sleep(RetryDelay)
+						metrics.MessagesNacked.WithLabelValues(op, "true").Inc()
+						msg.Nack(false, true)
+					} else {
+						logger.Warn("Rejecting AMQP message")
+						metrics.MessagesNacked.WithLabelValues(op, "false").Inc()
+						msg.Nack(false, false)
+					}
+				} else {
+					metrics.MessagesAcked.WithLabelValues(op).Inc()
+					msg.Ack(false)
+				}
 			}
 		}
 	}()
@@ -111,3 +258,84 @@ func (c *Consumer) Start(ctx context.Context, handle func(string, []byte) error)
 
 	return nil
 }
+
+// declareExchange where messages are published, shared by every service.
+func declareExchange(ch *amqp.Channel, exchange string) error {
+	if err := ch.ExchangeDeclare(
+		exchange, // name
+		"direct", // type
+		true,     // durable
+		false,    // autoDelete
+		false,    // internal
+		false,    // noWait
+		nil,      // args
+	); err != nil {
+		return fmt.Errorf("Couldn't declare exchange. Error: %s", err.Error())
+	}
+	return nil
+}
+
+// declareDeadLetters exchange and queue of a queue, where the messages it
+// rejects are kept, and return the exchange's name.
+func declareDeadLetters(ch *amqp.Channel, exchange, queue string) (string, error) {
+	dlx := exchange + ".dead"
+	if err := ch.ExchangeDeclare(
+		dlx,      // name
+		"direct", // type
+		true,     // durable
+		false,    // autoDelete
+		false,    // internal
+		false,    // noWait
+		nil,      // args
+	); err != nil {
+		return "", fmt.Errorf("Couldn't declare dead-letter exchange. Error: %s", err.Error())
+	}
+
+	dlq := queue + ".dead"
+	if _, err := ch.QueueDeclare(
+		dlq,   // name
+		true,  // durable
+		false, // autoDelete
+		false, // exclusive
+		false, // noWait
+		nil,   // args
+	); err != nil {
+		return "", fmt.Errorf("Couldn't declare dead-letter queue. Error: %s", err.Error())
+	}
+
+	if err := ch.QueueBind(
+		dlq,   // queue name
+		queue, // routing key
+		dlx,   // exchange name
+		false, // noWait
+		nil,   // args
+	); err != nil {
+		return "", fmt.Errorf("Couldn't bind dead-letter queue. Error: %s", err.Error())
+	}
+
+	return dlx, nil
+}
+
+// Done is closed once the consumer stopped after its context was cancelled,
+// with every message it handled acked or nacked.
+func (c *Consumer) Done() <-chan struct{} {
+	return c.done
+}
+
+// temporary reports whether a handler error may go away if the message is
+// handled again, which handlers signal with a Temporary method.
+func temporary(err error) bool {
+	var t interface{ Temporary() bool }
+	return errors.As(err, &t) && t.Temporary()
+}
+
+// messageContext with the trace context and request ID carried in the headers
+// of the message.
+func messageContext(msg amqp.Delivery) context.Context {
+	ctx := tracing.ExtractAMQP(context.Background(), msg.Headers)
+	if id, ok := msg.Headers[logging.RequestIDField].(string); ok && id != "" {
+		ctx = logging.WithRequestID(ctx, id)
+	}
+
+	return ctx
+}
@@ -0,0 +1,144 @@
+package dberr
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+	"github.com/lib/pq"
+	"github.com/varrrro/pay-up/internal/problem"
+)
+
+// Kind of database error.
+type Kind int
+
+// Kinds of database errors, from the caller's point of view.
+const (
+	Unknown     Kind = iota // unexpected failure
+	NotFound                // no record matches the query
+	Conflict                // unique key already in use
+	Constraint              // foreign key, not null or check constraint violated
+	Unavailable             // database can't be reached, worth retrying later
+)
+
+func (k Kind) String() string {
+	switch k {
+	case NotFound:
+		return "not found"
+	case Conflict:
+		return "conflict"
+	case Constraint:
+		return "constraint violation"
+	case Unavailable:
+		return "unavailable"
+	default:
+		return "unknown"
+	}
+}
+
+// Error returned by a database operation.
+type Error struct {
+	Op   string
+	Kind Kind
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("Database %s error on %s [Error]: %s", e.Kind, e.Op, e.Err.Error())
+}
+
+// Unwrap returns the error returned by the database driver.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Temporary reports whether the operation may succeed if retried.
+func (e *Error) Temporary() bool {
+	return e.Kind == Unavailable
+}
+
+// Problem describing the error, without leaking the driver's message.
+func (e *Error) Problem() *problem.Problem {
+	switch e.Kind {
+	case NotFound:
+		return problem.New(http.StatusNotFound, "not_found", "No record found")
+	case Conflict:
+		return problem.New(http.StatusConflict, "conflict", "Record already exists")
+	case Constraint:
+		return problem.New(http.StatusConflict, "constraint_violation", "Record violates a database constraint")
+	case Unavailable:
+		return problem.New(http.StatusServiceUnavailable, "db_unavailable", "Database is unavailable, try again later")
+	default:
+		return problem.New(http.StatusInternalServerError, "db_error", "The request couldn't be completed")
+	}
+}
+
+// Wrap the error returned by a database operation, or return nil if there's
+// none.
+func Wrap(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &Error{op, classify(err), err}
+}
+
+// Is reports whether the error is a database error of the given kind.
+func Is(err error, k Kind) bool {
+	var e *Error
+	return errors.As(err, &e) && e.Kind == k
+}
+
+// Temporary reports whether the error may go away if the operation is retried.
+func Temporary(err error) bool {
+	var t interface{ Temporary() bool }
+	return errors.As(err, &t) && t.Temporary()
+}
+
+func classify(err error) Kind {
+	if gorm.IsRecordNotFoundError(err) {
+		return NotFound
+	}
+
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, sql.ErrConnDone) {
+		return Unavailable
+	}
+
+	var nerr net.Error
+	if errors.As(err, &nerr) {
+		return Unavailable
+	}
+
+	// PostgreSQL reports SQLSTATE codes
+	var pqerr *pq.Error
+	if errors.As(err, &pqerr) {
+		switch {
+		case pqerr.Code == "23505":
+			return Conflict
+		case pqerr.Code.Class() == "23":
+			return Constraint
+		case pqerr.Code.Class() == "08", pqerr.Code.Class() == "53", pqerr.Code.Class() == "57":
+			return Unavailable
+		default:
+			return Unknown
+		}
+	}
+
+	// Other drivers only report messages
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "UNIQUE constraint failed"):
+		return Conflict
+	case strings.Contains(msg, "constraint failed"):
+		return Constraint
+	case strings.Contains(msg, "database is closed"), strings.Contains(msg, "database is locked"):
+		return Unavailable
+	default:
+		return Unknown
+	}
+}
@@ -0,0 +1,57 @@
+package dberr_test
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	"github.com/lib/pq"
+	"github.com/varrrro/pay-up/internal/dberr"
+	"github.com/varrrro/pay-up/internal/problem"
+)
+
+func TestWrap(t *testing.T) {
+	cases := []struct {
+		err       error
+		kind      dberr.Kind
+		status    int
+		temporary bool
+	}{
+		{gorm.ErrRecordNotFound, dberr.NotFound, http.StatusNotFound, false},
+		{errors.New("UNIQUE constraint failed: groups.id"), dberr.Conflict, http.StatusConflict, false},
+		{errors.New("FOREIGN KEY constraint failed"), dberr.Constraint, http.StatusConflict, false},
+		{errors.New("sql: database is closed"), dberr.Unavailable, http.StatusServiceUnavailable, true},
+		{driver.ErrBadConn, dberr.Unavailable, http.StatusServiceUnavailable, true},
+		{fmt.Errorf("query failed: %w", driver.ErrBadConn), dberr.Unavailable, http.StatusServiceUnavailable, true},
+		{&pq.Error{Code: "23505"}, dberr.Conflict, http.StatusConflict, false},
+		{&pq.Error{Code: "23503"}, dberr.Constraint, http.StatusConflict, false},
+		{&pq.Error{Code: "57P01"}, dberr.Unavailable, http.StatusServiceUnavailable, true},
+		{&pq.Error{Code: "42P01"}, dberr.Unknown, http.StatusInternalServerError, false},
+		{errors.New("no such table: groups"), dberr.Unknown, http.StatusInternalServerError, false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.err.Error(), func(t *testing.T) {
+			err := dberr.Wrap("test", tc.err)
+
+			if !dberr.Is(err, tc.kind) {
+				t.Errorf("Wrong kind [Expected]: %v [Actual]: %v", tc.kind, err)
+			} else if !errors.Is(err, tc.err) {
+				t.Error("Driver error isn't wrapped")
+			} else if dberr.Temporary(err) != tc.temporary {
+				t.Errorf("Wrong retry decision [Expected]: %t [Actual]: %t", tc.temporary, dberr.Temporary(err))
+			} else if p := problem.FromError(err); p.Status != tc.status {
+				t.Errorf("Wrong status code [Expected]: %d [Actual]: %d", tc.status, p.Status)
+			}
+		})
+	}
+}
+
+func TestWrapNil(t *testing.T) {
+	if err := dberr.Wrap("test", nil); err != nil {
+		t.Errorf("Wrapping no error returned an error [Error]: %v", err)
+	}
+}
@@ -0,0 +1,136 @@
+package gateway
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/varrrro/pay-up/internal/logging"
+	"github.com/varrrro/pay-up/internal/problem"
+)
+
+// APIKeyHeader carries the API key of automated clients.
+const APIKeyHeader = "X-API-Key"
+
+// Scopes that can be granted to an API key.
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+)
+
+// APIKey for automated clients, acting on behalf of a user.
+type APIKey struct {
+	ID       string   `json:"id"`
+	Hash     string   `json:"hash"`
+	UserID   string   `json:"user_id"`
+	UserName string   `json:"user_name"`
+	Scopes   []string `json:"scopes"`
+	Rate     int      `json:"rate"`
+}
+
+// APIKeys indexed by the hex SHA-256 hash of the key.
+type APIKeys map[string]APIKey
+
+// HashAPIKey returns the value stored in place of a key.
+func HashAPIKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
+
+// LoadAPIKeys from a JSON file with a list of keys.
+func LoadAPIKeys(path string) (APIKeys, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var list []APIKey
+	if err := json.NewDecoder(f).Decode(&list); err != nil {
+		return nil, err
+	}
+
+	keys := make(APIKeys, len(list))
+	for _, k := range list {
+		keys[k.Hash] = k
+	}
+
+	return keys, nil
+}
+
+// allows checks if the key has been granted the given scope.
+func (k APIKey) allows(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// APIKeyMiddleware that authenticates requests carrying an API key, checks
+// its scope and applies the key's own rate limit (requests per minute). The
+// user headers are only ever set from a valid key, so clients can't send
+// them themselves and have the services trust them.
+func APIKeyMiddleware(keys APIKeys) func(http.Handler) http.Handler {
+	store := NewMemoryStore()
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			r.Header.Del(UserHeader)
+			r.Header.Del(UserNameHeader)
+
+			raw := r.Header.Get(APIKeyHeader)
+			if raw == "" {
+				next.ServeHTTP(rw, r)
+				return
+			}
+
+			logger := logging.FromContext(r.Context())
+
+			// Look for the key
+			k, ok := keys[HashAPIKey(raw)]
+			if !ok {
+				logger.Warn("Unknown API key")
+				problem.Write(rw, r, problem.New(http.StatusUnauthorized, "invalid_api_key", "Unknown API key"))
+				return
+			}
+			logger = logger.WithField("key", k.ID)
+
+			// Check key's scope
+			scope := ScopeWrite
+			if r.Method == "GET" || r.Method == "HEAD" {
+				scope = ScopeRead
+			}
+			if !k.allows(scope) {
+				logger.WithField("scope", scope).Warn("API key doesn't allow scope")
+				problem.Write(rw, r, problem.New(http.StatusForbidden, "insufficient_scope", "API key doesn't allow this request").With("scope", scope))
+				return
+			}
+
+			// Check key's rate limit
+			if k.Rate > 0 {
+				limit := PerMinute(k.Rate)
+				if res, _ := store.Take([]string{k.ID}, limit, time.Now()); !res.Allowed {
+					logger.Warn("API key rate limit exceeded")
+					writeRateLimit(rw, limit, res)
+					problem.Write(rw, r, problem.New(http.StatusTooManyRequests, "rate_limited", "API key rate limit exceeded"))
+					return
+				}
+			}
+
+			// Act on behalf of the key's user
+			r.Header.Set(UserHeader, k.UserID)
+			if k.UserName != "" {
+				r.Header.Set(UserNameHeader, k.UserName)
+			}
+			r.Header.Del(APIKeyHeader)
+
+			next.ServeHTTP(rw, r)
+		})
+	}
+}
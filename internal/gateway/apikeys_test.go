@@ -0,0 +1,126 @@
+package gateway_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/varrrro/pay-up/internal/gateway"
+)
+
+func TestAPIKeyMiddleware(t *testing.T) {
+	keys := gateway.APIKeys{
+		gateway.HashAPIKey("reader"): {ID: "reader", UserID: "bot", Scopes: []string{gateway.ScopeRead}, Rate: 2},
+		gateway.HashAPIKey("writer"): {ID: "writer", UserID: "bot", UserName: "Bot", Scopes: []string{gateway.ScopeRead, gateway.ScopeWrite}},
+	}
+
+	// Handler that echoes the user the request acts on behalf of
+	h := gateway.APIKeyMiddleware(keys)(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("X-User-ID", r.Header.Get("X-User-ID"))
+		rw.Header().Set("X-User-Name", r.Header.Get("X-User-Name"))
+		rw.WriteHeader(http.StatusOK)
+	}))
+
+	cases := []struct {
+		method     string
+		key        string
+		statusCode int
+		user       string
+		name       string
+	}{
+		{"GET", "", http.StatusOK, "", ""},
+		{"GET", "unknown", http.StatusUnauthorized, "", ""},
+		{"GET", "reader", http.StatusOK, "bot", ""},
+		{"POST", "reader", http.StatusForbidden, "", ""},
+		{"POST", "writer", http.StatusOK, "bot", "Bot"},
+		{"GET", "reader", http.StatusOK, "bot", ""},
+		{"GET", "reader", http.StatusTooManyRequests, "", ""},
+	}
+
+	for _, tc := range cases {
+		t.Run(fmt.Sprintf("%s %s %d", tc.method, tc.key, tc.statusCode), func(t *testing.T) {
+			// Create request
+			req, err := http.NewRequest(tc.method, "/groups", nil)
+			if err != nil {
+				t.Errorf("Can't create request [Error]: %v", err)
+			}
+			if tc.key != "" {
+				req.Header.Set("X-API-Key", tc.key)
+			}
+			req.Header.Set("X-User-ID", "forged")
+			req.Header.Set("X-User-Name", "Forged")
+
+			// Serve test request
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			res := rec.Result() // get response
+			defer res.Body.Close()
+
+			// Check response status code and user
+			if res.StatusCode != tc.statusCode {
+				t.Errorf("Wrong status code [Expected]: %d [Actual]: %d", tc.statusCode, res.StatusCode)
+			} else if res.Header.Get("X-User-ID") != tc.user {
+				t.Errorf("Wrong user [Expected]: %s [Actual]: %s", tc.user, res.Header.Get("X-User-ID"))
+			} else if res.Header.Get("X-User-Name") != tc.name {
+				t.Errorf("Wrong user name [Expected]: %s [Actual]: %s", tc.name, res.Header.Get("X-User-Name"))
+			}
+
+			// Rate limited keys get the same headers as rate limited clients
+			if res.StatusCode == http.StatusTooManyRequests {
+				for _, name := range []string{"RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Retry-After"} {
+					if res.Header.Get(name) == "" {
+						t.Errorf("Missing header [Name]: %s", name)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestAPIKeyMiddlewareProxy(t *testing.T) {
+	keys := gateway.APIKeys{
+		gateway.HashAPIKey("writer"): {ID: "writer", UserID: "bot", Scopes: []string{gateway.ScopeRead, gateway.ScopeWrite}},
+	}
+
+	// Groups microservice that records the user of each request
+	var users []string
+	gmicro := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		users = append(users, r.Header.Get("X-User-ID"))
+		rw.WriteHeader(http.StatusOK)
+	}))
+	defer gmicro.Close()
+
+	u, _ := url.Parse(gmicro.URL)
+	proxy := gateway.NewProxy(u, []byte("secret"))
+	h := gateway.APIKeyMiddleware(keys)(http.HandlerFunc(gateway.ProxyHandler(proxy)))
+
+	cases := []struct {
+		key  string
+		user string
+	}{
+		{"", ""},
+		{"writer", "bot"},
+	}
+
+	for _, tc := range cases {
+		users = nil
+
+		// Create request claiming to be the group's owner
+		req, _ := http.NewRequest("GET", "/groups", nil)
+		req.Header.Set("X-User-ID", "owner")
+		if tc.key != "" {
+			req.Header.Set("X-API-Key", tc.key)
+		}
+
+		// Serve test request
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		// Check the user that reached the service
+		if len(users) != 1 || users[0] != tc.user {
+			t.Errorf("Wrong user proxied [Key]: %s [Expected]: %s [Actual]: %v", tc.key, tc.user, users)
+		}
+	}
+}
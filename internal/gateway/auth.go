@@ -0,0 +1,147 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/varrrro/pay-up/internal/gmicro/group"
+	"github.com/varrrro/pay-up/internal/gmicro/member"
+	"github.com/varrrro/pay-up/internal/logging"
+	"github.com/varrrro/pay-up/internal/signature"
+	"github.com/varrrro/pay-up/internal/tracing"
+	"go.opentelemetry.io/otel/api/standard"
+	"go.opentelemetry.io/otel/api/trace"
+)
+
+// UserHeader carries the ID of the authenticated user making the request.
+const UserHeader = "X-User-ID"
+
+// UserNameHeader carries the display name of the authenticated user.
+const UserNameHeader = "X-User-Name"
+
+// Authorizer that resolves the member linked to a user in a group, as part
+// of the request in ctx.
+type Authorizer interface {
+	Member(ctx context.Context, gid, uid string) (member.Member, error)
+}
+
+// MockAuthorizer used in tests.
+type MockAuthorizer func(gid, uid string) (member.Member, error)
+
+// Member function that calls the mock function.
+func (a MockAuthorizer) Member(ctx context.Context, gid, uid string) (member.Member, error) {
+	return a(gid, uid)
+}
+
+// AuthorizeTimeout of the lookups made by authorizers, which every expense and
+// payment request waits for.
+var AuthorizeTimeout = 5 * time.Second
+
+// HTTPAuthorizer that asks the groups microservice.
+type HTTPAuthorizer struct {
+	url    *url.URL
+	secret []byte
+	client *http.Client
+}
+
+// NewAuthorizer for the groups microservice at the given URL, signing
+// requests with the shared secret.
+func NewAuthorizer(u *url.URL, secret []byte) *HTTPAuthorizer {
+	return &HTTPAuthorizer{
+		url:    u,
+		secret: secret,
+		client: &http.Client{Timeout: AuthorizeTimeout},
+	}
+}
+
+// Member fetches the group on behalf of the user and looks for its member.
+// Archived groups can't take new transactions, so they have no members. The
+// request continues the trace and carries the ID of the request in ctx.
+func (a *HTTPAuthorizer) Member(ctx context.Context, gid, uid string) (member.Member, error) {
+	req, err := http.NewRequest("GET", a.url.String()+"/groups/"+gid, nil)
+	if err != nil {
+		return member.Member{}, err
+	}
+
+	ctx, span := tracing.Tracer().Start(ctx, "authorize GET /groups/{groupid}",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(standard.HTTPClientAttributesFromHTTPRequest(req)...),
+	)
+	mb, err := a.member(req.WithContext(ctx), gid, uid)
+	tracing.End(ctx, span, err)
+
+	return mb, err
+}
+
+func (a *HTTPAuthorizer) member(req *http.Request, gid, uid string) (member.Member, error) {
+	ctx := req.Context()
+
+	req.Header.Set(UserHeader, uid)
+	if id := logging.RequestID(ctx); id != "" {
+		req.Header.Set(logging.RequestIDHeader, id)
+	}
+	tracing.InjectHTTP(ctx, req.Header)
+
+	if err := signature.Sign(req, a.secret, time.Now()); err != nil {
+		return member.Member{}, err
+	}
+
+	res, err := a.client.Do(req)
+	if err != nil {
+		return member.Member{}, &UpstreamError{err.Error(), 0}
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		break
+	case http.StatusUnauthorized:
+		return member.Member{}, &UnauthorizedError{"No user in request"}
+	case http.StatusForbidden:
+		return member.Member{}, &ForbiddenError{"User isn't a member of the group", gid, uid}
+	case http.StatusNotFound:
+		return member.Member{}, &NotFoundError{"No group found", gid}
+	default:
+		return member.Member{}, &UpstreamError{"Unexpected status fetching group", res.StatusCode}
+	}
+
+	var g group.Group
+	if err := json.NewDecoder(res.Body).Decode(&g); err != nil {
+		return member.Member{}, &UpstreamError{err.Error(), res.StatusCode}
+	}
+
+	if g.Archived() {
+		return member.Member{}, &ArchivedError{"Archived groups can't take new transactions", gid}
+	}
+
+	for _, mb := range g.Members {
+		if mb.UserID == uid {
+			return mb, nil
+		}
+	}
+
+	return member.Member{}, &ForbiddenError{"User isn't a member of the group", gid, uid}
+}
+
+// authorize checks that the user making the request has at least the given
+// role in the group, returning the member linked to the user.
+func authorize(a Authorizer, r *http.Request, gid string, role member.Role) (member.Member, error) {
+	uid := r.Header.Get(UserHeader)
+	if uid == "" {
+		return member.Member{}, &UnauthorizedError{"No user in request"}
+	}
+
+	mb, err := a.Member(r.Context(), gid, uid)
+	if err != nil {
+		return mb, err
+	}
+
+	if !mb.Role.Includes(role) {
+		return mb, &ForbiddenError{"User doesn't have the required role", gid, uid}
+	}
+
+	return mb, nil
+}
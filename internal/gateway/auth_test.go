@@ -0,0 +1,85 @@
+package gateway_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/varrrro/pay-up/internal/gateway"
+	"github.com/varrrro/pay-up/internal/gmicro/group"
+	"github.com/varrrro/pay-up/internal/gmicro/member"
+	"github.com/varrrro/pay-up/internal/logging"
+	"go.opentelemetry.io/otel/api/global"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+func TestHTTPAuthorizer(t *testing.T) {
+	tp, err := sdktrace.NewProvider()
+	if err != nil {
+		t.Fatalf("Can't create trace provider [Error]: %v", err)
+	}
+	prev := global.TraceProvider()
+	global.SetTraceProvider(tp)
+	defer global.SetTraceProvider(prev)
+
+	gid := uuid.New()
+	mb := member.Member{ID: uuid.New(), Name: "Test", UserID: "user1", Role: member.RoleMember}
+
+	// Groups microservice that records the headers of the request
+	var headers http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		headers = r.Header
+		json.NewEncoder(rw).Encode(&group.Group{ID: gid, Name: "Test", Members: []member.Member{mb}})
+	}))
+	defer srv.Close()
+
+	u, _ := url.Parse(srv.URL)
+	auth := gateway.NewAuthorizer(u, []byte("secret"))
+
+	ctx := logging.WithRequestID(context.Background(), "request1")
+	m, err := auth.Member(ctx, gid.String(), "user1")
+	if err != nil || m.ID != mb.ID {
+		t.Fatalf("Wrong member [Expected]: %v [Actual]: %v [Error]: %v", mb.ID, m.ID, err)
+	}
+
+	// The lookup is correlated with the request it authorizes
+	if id := headers.Get(logging.RequestIDHeader); id != "request1" {
+		t.Errorf("Wrong request ID [Expected]: %s [Actual]: %s", "request1", id)
+	}
+	if headers.Get("traceparent") == "" {
+		t.Error("Trace context wasn't propagated")
+	}
+	if uid := headers.Get("X-User-ID"); uid != "user1" {
+		t.Errorf("Wrong user [Expected]: %s [Actual]: %s", "user1", uid)
+	}
+}
+
+func TestHTTPAuthorizerTimeout(t *testing.T) {
+	prev := gateway.AuthorizeTimeout
+	gateway.AuthorizeTimeout = 50 * time.Millisecond
+	defer func() { gateway.AuthorizeTimeout = prev }()
+
+	// Groups microservice that doesn't respond in time
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer srv.Close()
+	defer close(done)
+
+	u, _ := url.Parse(srv.URL)
+	auth := gateway.NewAuthorizer(u, []byte("secret"))
+
+	start := time.Now()
+	if _, err := auth.Member(context.Background(), uuid.New().String(), "user1"); err == nil {
+		t.Error("Lookup that timed out didn't return an error")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("Lookup didn't time out [Duration]: %v", d)
+	}
+}
@@ -0,0 +1,85 @@
+package gateway
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/varrrro/pay-up/internal/problem"
+)
+
+// UnauthorizedError used when a request doesn't identify its user.
+type UnauthorizedError struct {
+	msg string
+}
+
+func (e *UnauthorizedError) Error() string {
+	return e.msg
+}
+
+// Problem describing the error.
+func (e *UnauthorizedError) Problem() *problem.Problem {
+	return problem.New(http.StatusUnauthorized, "unauthorized", e.msg)
+}
+
+// ForbiddenError used when a user lacks the role needed for an action.
+type ForbiddenError struct {
+	msg     string
+	groupid string
+	userid  string
+}
+
+func (e *ForbiddenError) Error() string {
+	return fmt.Sprintf("%s [GroupID]: %s [UserID]: %s", e.msg, e.groupid, e.userid)
+}
+
+// Problem describing the error.
+func (e *ForbiddenError) Problem() *problem.Problem {
+	return problem.New(http.StatusForbidden, "forbidden", e.msg).
+		With("group_id", e.groupid).
+		With("user_id", e.userid)
+}
+
+// NotFoundError used when a group isn't found.
+type NotFoundError struct {
+	msg     string
+	groupid string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("%s [GroupID]: %s", e.msg, e.groupid)
+}
+
+// Problem describing the error.
+func (e *NotFoundError) Problem() *problem.Problem {
+	return problem.New(http.StatusNotFound, "not_found", e.msg).With("group_id", e.groupid)
+}
+
+// UpstreamError used when the groups microservice can't answer a request.
+type UpstreamError struct {
+	msg    string
+	status int
+}
+
+func (e *UpstreamError) Error() string {
+	return fmt.Sprintf("%s [Status]: %d", e.msg, e.status)
+}
+
+// Problem describing the error.
+func (e *UpstreamError) Problem() *problem.Problem {
+	return problem.New(http.StatusBadGateway, "upstream_error", "Groups service couldn't answer the request")
+}
+
+// ArchivedError used when adding transactions to an archived group.
+type ArchivedError struct {
+	msg     string
+	groupid string
+}
+
+func (e *ArchivedError) Error() string {
+	return fmt.Sprintf("%s [GroupID]: %s", e.msg, e.groupid)
+}
+
+// Problem describing the error.
+func (e *ArchivedError) Problem() *problem.Problem {
+	return problem.New(http.StatusConflict, "group_archived", e.msg).With("group_id", e.groupid)
+}
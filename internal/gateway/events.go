@@ -0,0 +1,298 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/varrrro/pay-up/internal/gmicro/group"
+	"github.com/varrrro/pay-up/internal/gmicro/member"
+	"github.com/varrrro/pay-up/internal/logging"
+	"github.com/varrrro/pay-up/internal/problem"
+)
+
+// Heartbeat interval of event streams, so proxies don't close idle ones.
+var Heartbeat = 15 * time.Second
+
+// SubscriberBuffer of events waiting to be sent to a client. Clients that
+// fall further behind are disconnected and have to resume the stream.
+const SubscriberBuffer = 16
+
+// ErrHubClosed is returned when subscribing to a hub that was closed.
+var ErrHubClosed = errors.New("Event hub is closed")
+
+// Event of a group streamed to clients.
+type Event struct {
+	ID      string
+	GroupID string
+	Type    string
+	Data    []byte
+
+	seq uint64
+}
+
+// Subscription to the events of a group.
+type Subscription struct {
+	C <-chan Event
+
+	c   chan Event
+	gid string
+	hub *Hub
+}
+
+// Close the subscription, so no more events are sent to it.
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+
+	s.hub.remove(s)
+}
+
+// Hub that fans out the events of every group to the clients subscribed to
+// it, keeping the latest ones so clients can resume after reconnecting.
+type Hub struct {
+	mu     sync.Mutex
+	epoch  string
+	seq    uint64
+	events []Event
+	size   int
+	subs   map[string]map[*Subscription]struct{}
+	closed bool
+}
+
+// NewHub keeping the given number of events for clients resuming a stream.
+// Event IDs are only valid for the hub that issued them.
+func NewHub(size int) *Hub {
+	return &Hub{
+		epoch:  strconv.FormatInt(time.Now().UnixNano(), 36),
+		events: make([]Event, 0, size),
+		size:   size,
+		subs:   make(map[string]map[*Subscription]struct{}),
+	}
+}
+
+// Publish an event of the group to its subscribers. Subscribers that can't
+// keep up are dropped.
+func (h *Hub) Publish(gid, typ string, data []byte) Event {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.seq++
+	ev := Event{
+		ID:      h.epoch + "-" + strconv.FormatUint(h.seq, 10),
+		GroupID: gid,
+		Type:    typ,
+		Data:    data,
+		seq:     h.seq,
+	}
+
+	if len(h.events) == h.size {
+		copy(h.events, h.events[1:])
+		h.events = h.events[:h.size-1]
+	}
+	h.events = append(h.events, ev)
+
+	for s := range h.subs[gid] {
+		select {
+		case s.c <- ev:
+		default:
+			h.remove(s)
+		}
+	}
+
+	return ev
+}
+
+// Subscribe to the events of the group published after the one with the
+// given ID, which are returned. If those events aren't available anymore,
+// resumed is false and the client should fetch the group again.
+func (h *Hub) Subscribe(gid, lastID string) (s *Subscription, missed []Event, resumed bool, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return nil, nil, false, ErrHubClosed
+	}
+
+	resumed = true
+	if lastID != "" {
+		var seq uint64
+		seq, resumed = h.parse(lastID)
+		for _, ev := range h.events {
+			if resumed && ev.seq > seq && ev.GroupID == gid {
+				missed = append(missed, ev)
+			}
+		}
+	}
+
+	c := make(chan Event, SubscriberBuffer)
+	s = &Subscription{C: c, c: c, gid: gid, hub: h}
+	if h.subs[gid] == nil {
+		h.subs[gid] = make(map[*Subscription]struct{})
+	}
+	h.subs[gid][s] = struct{}{}
+
+	return s, missed, resumed, nil
+}
+
+// Close every subscription and refuse new ones.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for _, subs := range h.subs {
+		for s := range subs {
+			h.remove(s)
+		}
+	}
+}
+
+// parse an event ID, reporting whether the events after it are still kept.
+func (h *Hub) parse(id string) (uint64, bool) {
+	i := strings.LastIndex(id, "-")
+	if i < 0 || id[:i] != h.epoch {
+		return 0, false
+	}
+
+	seq, err := strconv.ParseUint(id[i+1:], 10, 64)
+	if err != nil || seq > h.seq {
+		return 0, false
+	}
+
+	// The event right after the given one must still be kept
+	oldest := h.seq + 1
+	if len(h.events) > 0 {
+		oldest = h.events[0].seq
+	}
+	return seq, seq+1 >= oldest
+}
+
+// remove the subscription and close its channel. The lock must be held.
+func (h *Hub) remove(s *Subscription) {
+	subs := h.subs[s.gid]
+	if _, ok := subs[s]; !ok {
+		return
+	}
+
+	delete(subs, s)
+	if len(subs) == 0 {
+		delete(h.subs, s.gid)
+	}
+	close(s.c)
+}
+
+// BalanceEventHandler for AMQP messages with balance changes, which are
+// published to the hub. Other events, meant for other subscribers, are
+// ignored.
+func BalanceEventHandler(h *Hub) func(context.Context, string, []byte) error {
+	return func(ctx context.Context, op string, body []byte) error {
+		logger := logging.FromContext(ctx).WithField("operation", op)
+
+		if op != "balance-changed" {
+			logger.Debug("Ignoring event")
+			return nil
+		}
+
+		var ev group.BalanceChange
+		if err := json.Unmarshal(body, &ev); err != nil {
+			logger.WithError(err).Warn("Can't parse message body as balance change")
+			return err
+		}
+
+		h.Publish(ev.GroupID.String(), op, body)
+		return nil
+	}
+}
+
+// EventsHandler that streams the events of a group as Server-Sent Events,
+// resuming after the event in the Last-Event-ID header.
+func EventsHandler(h *Hub, a Authorizer) func(http.ResponseWriter, *http.Request) {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		logger := logging.FromContext(r.Context())
+
+		gid := mux.Vars(r)["groupid"]
+
+		// Check if group UUID is valid
+		if _, err := uuid.Parse(gid); err != nil {
+			logger.WithField("id", gid).Error("Group ID isn't valid UUID")
+			problem.Write(rw, r, problem.New(http.StatusBadRequest, "invalid_id", "Group ID isn't valid UUID").With("id", gid))
+			return
+		}
+
+		// Check user's role in the group
+		if _, err := authorize(a, r, gid, member.RoleViewer); err != nil {
+			logger.WithError(err).Warn("Can't stream events")
+			problem.Write(rw, r, problem.FromError(err))
+			return
+		}
+
+		flusher, ok := rw.(http.Flusher)
+		if !ok {
+			logger.Error("Response doesn't support streaming")
+			problem.Write(rw, r, problem.New(http.StatusInternalServerError, "streaming_unsupported", "Can't stream events"))
+			return
+		}
+
+		sub, missed, resumed, err := h.Subscribe(gid, r.Header.Get("Last-Event-ID"))
+		if err != nil {
+			logger.WithError(err).Warn("Can't subscribe to events")
+			problem.Write(rw, r, problem.New(http.StatusServiceUnavailable, "shutting_down", "Can't stream events"))
+			return
+		}
+		defer sub.Close()
+
+		rw.Header().Set("Content-Type", "text/event-stream")
+		rw.Header().Set("Cache-Control", "no-cache")
+		rw.Header().Set("X-Accel-Buffering", "no")
+		rw.WriteHeader(http.StatusOK)
+
+		// Tell the client to refetch the group if events were missed
+		if !resumed {
+			fmt.Fprintf(rw, "event: reset\ndata: {\"group_id\":%q}\n\n", gid)
+		}
+		for _, ev := range missed {
+			writeEvent(rw, ev)
+		}
+		flusher.Flush()
+
+		logger.WithField("missed", len(missed)).Info("Streaming events")
+
+		ticker := time.NewTicker(Heartbeat)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case ev, ok := <-sub.C:
+				if !ok {
+					// Dropped for falling behind, or shutting down
+					logger.Info("Event stream closed")
+					return
+				}
+				writeEvent(rw, ev)
+			case <-ticker.C:
+				fmt.Fprint(rw, ": heartbeat\n\n")
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// writeEvent in the Server-Sent Events format.
+func writeEvent(rw http.ResponseWriter, ev Event) {
+	fmt.Fprintf(rw, "id: %s\nevent: %s\n", ev.ID, ev.Type)
+	for _, line := range strings.Split(string(ev.Data), "\n") {
+		fmt.Fprintf(rw, "data: %s\n", line)
+	}
+	fmt.Fprint(rw, "\n")
+}
@@ -0,0 +1,200 @@
+package gateway_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/varrrro/pay-up/internal/gateway"
+	"github.com/varrrro/pay-up/internal/gmicro/group"
+)
+
+func TestHub(t *testing.T) {
+	h := gateway.NewHub(3)
+	a, b := uuid.New().String(), uuid.New().String()
+
+	first := h.Publish(a, "balance-changed", []byte("1"))
+	h.Publish(b, "balance-changed", []byte("2"))
+	third := h.Publish(a, "balance-changed", []byte("3"))
+
+	cases := []struct {
+		name    string
+		lastID  string
+		missed  []string
+		resumed bool
+	}{
+		{"New stream", "", nil, true},
+		{"Resume", first.ID, []string{"3"}, true},
+		{"Up to date", third.ID, nil, true},
+		{"Unknown ID", "0-1", nil, false},
+		{"Future ID", strings.TrimSuffix(third.ID, "3") + "9", nil, false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			sub, missed, resumed, err := h.Subscribe(a, tc.lastID)
+			if err != nil {
+				t.Fatalf("Can't subscribe [Error]: %v", err)
+			}
+			defer sub.Close()
+
+			if resumed != tc.resumed {
+				t.Errorf("Wrong resume result [Expected]: %t [Actual]: %t", tc.resumed, resumed)
+			}
+			if len(missed) != len(tc.missed) {
+				t.Fatalf("Wrong missed events [Expected]: %v [Actual]: %v", tc.missed, missed)
+			}
+			for i, ev := range missed {
+				if string(ev.Data) != tc.missed[i] {
+					t.Errorf("Wrong missed event [Expected]: %s [Actual]: %s", tc.missed[i], ev.Data)
+				}
+			}
+		})
+	}
+
+	// Once dropped from the buffer, events can't be resumed
+	h.Publish(b, "balance-changed", []byte("4"))
+	h.Publish(b, "balance-changed", []byte("5"))
+	sub, _, resumed, _ := h.Subscribe(a, first.ID)
+	sub.Close()
+	if resumed {
+		t.Error("Resumed after events dropped from the buffer")
+	}
+}
+
+func TestHubSlowSubscriber(t *testing.T) {
+	h := gateway.NewHub(100)
+	gid := uuid.New().String()
+
+	sub, _, _, _ := h.Subscribe(gid, "")
+	for i := 0; i <= gateway.SubscriberBuffer; i++ {
+		h.Publish(gid, "balance-changed", []byte("{}"))
+	}
+
+	n := 0
+	for range sub.C {
+		n++
+	}
+	if n != gateway.SubscriberBuffer {
+		t.Errorf("Wrong events received [Expected]: %d [Actual]: %d", gateway.SubscriberBuffer, n)
+	}
+}
+
+func TestHubClose(t *testing.T) {
+	h := gateway.NewHub(10)
+	gid := uuid.New().String()
+
+	sub, _, _, _ := h.Subscribe(gid, "")
+	h.Close()
+
+	if _, ok := <-sub.C; ok {
+		t.Error("Subscription is open after closing the hub")
+	}
+	if _, _, _, err := h.Subscribe(gid, ""); err != gateway.ErrHubClosed {
+		t.Errorf("Wrong error [Expected]: %v [Actual]: %v", gateway.ErrHubClosed, err)
+	}
+}
+
+func TestBalanceEventHandler(t *testing.T) {
+	h := gateway.NewHub(10)
+	ev := group.BalanceChange{GroupID: uuid.New(), Operation: "add-expense"}
+	body, _ := json.Marshal(&ev)
+
+	sub, _, _, _ := h.Subscribe(ev.GroupID.String(), "")
+	defer sub.Close()
+
+	cases := []struct {
+		op    string
+		body  []byte
+		fails bool
+	}{
+		{"balance-changed", body, false},
+		{"balance-changed", []byte("test"), true},
+		{"preferences-changed", body, false},
+	}
+
+	for _, tc := range cases {
+		if err := gateway.BalanceEventHandler(h)(context.Background(), tc.op, tc.body); (err != nil) != tc.fails {
+			t.Errorf("Wrong handler result [Operation]: %s [Expected failure]: %t [Error]: %v", tc.op, tc.fails, err)
+		}
+	}
+
+	if len(sub.C) != 1 {
+		t.Errorf("Wrong events published [Expected]: %d [Actual]: %d", 1, len(sub.C))
+	}
+}
+
+func TestEventsHandler(t *testing.T) {
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	gid := uuid.New().String()
+	seen := hub.Publish(gid, "balance-changed", []byte(`{"n":1}`))
+	missed := hub.Publish(gid, "balance-changed", []byte(`{"n":2}`))
+	hub.Publish(uuid.New().String(), "balance-changed", []byte(`{"n":3}`))
+
+	cases := []struct {
+		name       string
+		gid        string
+		uid        string
+		lastID     string
+		statusCode int
+		replayed   []string
+	}{
+		{"Resume", gid, "viewer", seen.ID, http.StatusOK, []string{"id: " + missed.ID, "event: balance-changed", `data: {"n":2}`, ""}},
+		{"Reset", gid, "viewer", "0-1", http.StatusOK, []string{"event: reset", `data: {"group_id":"` + gid + `"}`, ""}},
+		{"New stream", gid, "viewer", "", http.StatusOK, nil},
+		{"Invalid ID", "test", "viewer", "", http.StatusBadRequest, nil},
+		{"No user", gid, "", "", http.StatusUnauthorized, nil},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			req, _ := http.NewRequest("GET", srv.URL+"/groups/"+tc.gid+"/events", nil)
+			req = req.WithContext(ctx)
+			req.Header.Set("X-User-ID", tc.uid)
+			if tc.lastID != "" {
+				req.Header.Set("Last-Event-ID", tc.lastID)
+			}
+
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("Can't send request [Error]: %v", err)
+			}
+			defer res.Body.Close()
+
+			if res.StatusCode != tc.statusCode {
+				t.Fatalf("Wrong status code [Expected]: %d [Actual]: %d", tc.statusCode, res.StatusCode)
+			}
+			if tc.statusCode != http.StatusOK {
+				return
+			}
+			if ct := res.Header.Get("Content-Type"); ct != "text/event-stream" {
+				t.Errorf("Wrong content type [Expected]: %s [Actual]: %s", "text/event-stream", ct)
+			}
+
+			// Events published while streaming follow the replayed ones
+			live := hub.Publish(gid, "balance-changed", []byte(`{"n":4}`))
+			expected := append(tc.replayed, "id: "+live.ID, "event: balance-changed", `data: {"n":4}`, "")
+
+			sc := bufio.NewScanner(res.Body)
+			for i := 0; i < len(expected); i++ {
+				if !sc.Scan() {
+					t.Fatalf("Stream ended early [Error]: %v", sc.Err())
+				}
+				if sc.Text() != expected[i] {
+					t.Errorf("Wrong stream line [Expected]: %q [Actual]: %q", expected[i], sc.Text())
+				}
+			}
+		})
+	}
+}
@@ -4,25 +4,39 @@ import (
 	"os"
 	"testing"
 
+	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 	"github.com/varrrro/pay-up/internal/gateway"
+	"github.com/varrrro/pay-up/internal/gmicro/member"
+	"github.com/varrrro/pay-up/internal/openapi"
 	"github.com/varrrro/pay-up/internal/publisher"
 )
 
 var r *mux.Router
 
+var hub *gateway.Hub
+
 func TestMain(m *testing.M) {
 	// Create mock publisher
 	pub := publisher.MockPublisher(func(op string, body []byte) error {
 		return nil
 	})
 
+	// Create mock authorizer where user IDs match role names
+	auth := gateway.MockAuthorizer(func(gid, uid string) (member.Member, error) {
+		return member.Member{ID: uuid.New(), UserID: uid, Role: member.Role(uid)}, nil
+	})
+
+	// Create hub for balance events
+	hub = gateway.NewHub(10)
+
 	// Create router
 	r = mux.NewRouter().StrictSlash(true)
-	r.Use(gateway.LoggingMiddleware)
+	r.Use(gateway.LoggingMiddleware, openapi.ValidationMiddleware(openapi.GatewaySpec()))
 	r.HandleFunc("/", gateway.StatusHandler).Methods("GET")
-	r.HandleFunc("/groups/{groupid}/expenses", gateway.ExpensesHandler(pub)).Methods("POST", "DELETE")
-	r.HandleFunc("/groups/{groupid}/payments", gateway.PaymentsHandler(pub)).Methods("POST", "DELETE")
+	r.HandleFunc("/groups/{groupid}/expenses", gateway.ExpensesHandler(pub, auth)).Methods("POST", "DELETE")
+	r.HandleFunc("/groups/{groupid}/payments", gateway.PaymentsHandler(pub, auth)).Methods("POST", "DELETE")
+	r.HandleFunc("/groups/{groupid}/events", gateway.EventsHandler(hub, auth)).Methods("GET")
 
 	// Run tests
 	os.Exit(m.Run())
@@ -6,13 +6,17 @@ import (
 	"net/http/httputil"
 	"strings"
 
-	log "github.com/sirupsen/logrus"
-
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
+	"github.com/varrrro/pay-up/internal/gmicro/member"
+	"github.com/varrrro/pay-up/internal/logging"
+	"github.com/varrrro/pay-up/internal/problem"
 	"github.com/varrrro/pay-up/internal/publisher"
 	"github.com/varrrro/pay-up/internal/tmicro/expense"
 	"github.com/varrrro/pay-up/internal/tmicro/payment"
+	"github.com/varrrro/pay-up/internal/tracing"
+	"go.opentelemetry.io/otel/api/standard"
+	"go.opentelemetry.io/otel/api/trace"
 )
 
 // StatusHandler returns a static message to know the server is working.
@@ -24,137 +28,170 @@ func StatusHandler(rw http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(rw).Encode(&status)
 }
 
-// ProxyHandler for requests that need to be sent to another service.
+// ProxyHandler for requests that need to be sent to another service, which
+// continue the trace of the request received.
 func ProxyHandler(p *httputil.ReverseProxy) func(http.ResponseWriter, *http.Request) {
 	return func(rw http.ResponseWriter, r *http.Request) {
+		ctx, span := tracing.Tracer().Start(r.Context(), "proxy "+r.Method+" "+r.URL.Path,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(standard.HTTPClientAttributesFromHTTPRequest(r)...),
+		)
+		defer span.End()
+
+		r = r.WithContext(ctx)
+		tracing.InjectHTTP(ctx, r.Header)
+
 		p.ServeHTTP(rw, r)
 	}
 }
 
 // ExpensesHandler that publishes to an AMQP queue.
-func ExpensesHandler(p publisher.Publisher) func(http.ResponseWriter, *http.Request) {
+func ExpensesHandler(p publisher.Publisher, a Authorizer) func(http.ResponseWriter, *http.Request) {
 	return func(rw http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case "POST":
-			postExpenseHandler(p, rw, r)
+			postExpenseHandler(p, a, rw, r)
 			break
 		case "DELETE":
-			deleteExpenseHandler(p, rw, r)
+			deleteExpenseHandler(p, a, rw, r)
 		}
 	}
 }
 
-func postExpenseHandler(p publisher.Publisher, rw http.ResponseWriter, r *http.Request) {
-	logger := log.WithFields(log.Fields{
-		"uri":    r.URL,
-		"method": r.Method,
-	})
+func postExpenseHandler(p publisher.Publisher, a Authorizer, rw http.ResponseWriter, r *http.Request) {
+	logger := logging.FromContext(r.Context())
 
 	// Decode JSON
 	var e expense.Expense
 	if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
 		logger.WithError(err).Error("Can't parse request body as expense")
-		rw.WriteHeader(http.StatusBadRequest)
+		problem.Write(rw, r, problem.New(http.StatusBadRequest, "invalid_body", "Request body isn't a valid expense"))
 		return
 	}
 
 	// Check if group IDs in path and body match
 	if e.GroupID.String() != mux.Vars(r)["groupid"] {
 		logger.WithField("id", e.GroupID).Error("Group IDs in body and path don't match")
-		rw.WriteHeader(http.StatusBadRequest)
+		problem.Write(rw, r, problem.New(http.StatusBadRequest, "id_mismatch", "Group IDs in body and path don't match").
+			With("path", mux.Vars(r)["groupid"]).
+			With("body", e.GroupID))
 		return
 	}
 
 	// Check if recipient UUIDs are valid
 	rec := strings.Split(e.Recipients, ";")
-	for _, r := range rec {
-		if _, err := uuid.Parse(r); err != nil {
-			logger.WithField("id", r).Error("Recipient ID isn't valid UUID")
-			rw.WriteHeader(http.StatusBadRequest)
+	for _, id := range rec {
+		if _, err := uuid.Parse(id); err != nil {
+			logger.WithField("id", id).Error("Recipient ID isn't valid UUID")
+			problem.Write(rw, r, problem.New(http.StatusBadRequest, "invalid_id", "Recipient ID isn't valid UUID").With("id", id))
 			return
 		}
 	}
 
+	// Check user's role in the group
+	if _, err := authorize(a, r, mux.Vars(r)["groupid"], member.RoleMember); err != nil {
+		logger.WithError(err).Warn("Can't post expense")
+		problem.Write(rw, r, problem.FromError(err))
+		return
+	}
+
 	// Encode JSON
 	body, err := json.Marshal(&e)
 	if err != nil {
 		logger.WithError(err).Error("Can't encode body")
-		rw.WriteHeader(http.StatusInternalServerError)
+		problem.Write(rw, r, problem.FromError(err))
 		return
 	}
 
 	// Publish AMQP message
-	if err := p.Publish("add-expense", body); err != nil {
+	if err := p.Publish(r.Context(), "add-expense", body); err != nil {
 		logger.WithError(err).Warn("Can't publish AMQP message")
-		rw.WriteHeader(http.StatusInternalServerError)
+		problem.Write(rw, r, problem.New(http.StatusInternalServerError, "publish_failed", "Can't queue the request"))
 	} else {
 		rw.WriteHeader(http.StatusAccepted)
 	}
 }
 
-func deleteExpenseHandler(p publisher.Publisher, rw http.ResponseWriter, r *http.Request) {
-	logger := log.WithFields(log.Fields{
-		"uri":    r.URL,
-		"method": r.Method,
-	})
+func deleteExpenseHandler(p publisher.Publisher, a Authorizer, rw http.ResponseWriter, r *http.Request) {
+	logger := logging.FromContext(r.Context())
 
 	gid := mux.Vars(r)["groupid"]
 
 	// Check if group UUID is valid
 	if _, err := uuid.Parse(gid); err != nil {
 		logger.WithField("id", gid).Error("Group ID isn't valid UUID")
-		rw.WriteHeader(http.StatusBadRequest)
+		problem.Write(rw, r, problem.New(http.StatusBadRequest, "invalid_id", "Group ID isn't valid UUID").With("id", gid))
 		return
 	}
 
+	// Check user's role in the group
+	mb, err := authorize(a, r, gid, member.RoleMember)
+	if err != nil {
+		logger.WithError(err).Warn("Can't delete expense")
+		problem.Write(rw, r, problem.FromError(err))
+		return
+	}
+
+	// Only admins can delete other members' expenses
+	data := map[string]string{"group_id": gid}
+	if !mb.Role.Includes(member.RoleAdmin) {
+		data["member_id"] = mb.ID.String()
+	}
+
 	// Encode JSON
-	body, err := json.Marshal(&map[string]string{"group_id": gid})
+	body, err := json.Marshal(&data)
 	if err != nil {
 		logger.WithError(err).Error("Can't encode body")
-		rw.WriteHeader(http.StatusInternalServerError)
+		problem.Write(rw, r, problem.FromError(err))
 		return
 	}
 
 	// Publish AMQP message
-	if err := p.Publish("delete-expense", body); err != nil {
+	if err := p.Publish(r.Context(), "delete-expense", body); err != nil {
 		logger.WithError(err).Warn("Can't publish AMQP message")
-		rw.WriteHeader(http.StatusInternalServerError)
+		problem.Write(rw, r, problem.New(http.StatusInternalServerError, "publish_failed", "Can't queue the request"))
 	} else {
 		rw.WriteHeader(http.StatusAccepted)
 	}
 }
 
 // PaymentsHandler that publishes to an AMQP queue.
-func PaymentsHandler(p publisher.Publisher) func(http.ResponseWriter, *http.Request) {
+func PaymentsHandler(p publisher.Publisher, a Authorizer) func(http.ResponseWriter, *http.Request) {
 	return func(rw http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case "POST":
-			postPaymentHandler(p, rw, r)
+			postPaymentHandler(p, a, rw, r)
 			break
 		case "DELETE":
-			deletePaymentHandler(p, rw, r)
+			deletePaymentHandler(p, a, rw, r)
 		}
 	}
 }
 
-func postPaymentHandler(p publisher.Publisher, rw http.ResponseWriter, r *http.Request) {
-	logger := log.WithFields(log.Fields{
-		"uri":    r.URL,
-		"method": r.Method,
-	})
+func postPaymentHandler(p publisher.Publisher, a Authorizer, rw http.ResponseWriter, r *http.Request) {
+	logger := logging.FromContext(r.Context())
 
 	// Decode JSON
 	var pay payment.Payment
 	if err := json.NewDecoder(r.Body).Decode(&pay); err != nil {
 		logger.WithError(err).Error("Can't parse request body as payment")
-		rw.WriteHeader(http.StatusBadRequest)
+		problem.Write(rw, r, problem.New(http.StatusBadRequest, "invalid_body", "Request body isn't a valid payment"))
 		return
 	}
 
 	// Check if group IDs in path and body match
 	if pay.GroupID.String() != mux.Vars(r)["groupid"] {
 		logger.WithField("id", pay.GroupID).Error("Group IDs in body and path don't match")
-		rw.WriteHeader(http.StatusBadRequest)
+		problem.Write(rw, r, problem.New(http.StatusBadRequest, "id_mismatch", "Group IDs in body and path don't match").
+			With("path", mux.Vars(r)["groupid"]).
+			With("body", pay.GroupID))
+		return
+	}
+
+	// Check user's role in the group
+	if _, err := authorize(a, r, mux.Vars(r)["groupid"], member.RoleMember); err != nil {
+		logger.WithError(err).Warn("Can't post payment")
+		problem.Write(rw, r, problem.FromError(err))
 		return
 	}
 
@@ -162,46 +199,57 @@ func postPaymentHandler(p publisher.Publisher, rw http.ResponseWriter, r *http.R
 	body, err := json.Marshal(&pay)
 	if err != nil {
 		logger.WithError(err).Error("Can't encode body")
-		rw.WriteHeader(http.StatusInternalServerError)
+		problem.Write(rw, r, problem.FromError(err))
 		return
 	}
 
 	// Publish AMQP message
-	if err := p.Publish("add-payment", body); err != nil {
+	if err := p.Publish(r.Context(), "add-payment", body); err != nil {
 		logger.WithError(err).Warn("Can't publish AMQP message")
-		rw.WriteHeader(http.StatusInternalServerError)
+		problem.Write(rw, r, problem.New(http.StatusInternalServerError, "publish_failed", "Can't queue the request"))
 	} else {
 		rw.WriteHeader(http.StatusAccepted)
 	}
 }
 
-func deletePaymentHandler(p publisher.Publisher, rw http.ResponseWriter, r *http.Request) {
-	logger := log.WithFields(log.Fields{
-		"uri":    r.URL,
-		"method": r.Method,
-	})
+func deletePaymentHandler(p publisher.Publisher, a Authorizer, rw http.ResponseWriter, r *http.Request) {
+	logger := logging.FromContext(r.Context())
 
 	gid := mux.Vars(r)["groupid"]
 
 	// Check if group UUID is valid
 	if _, err := uuid.Parse(gid); err != nil {
 		logger.WithField("id", gid).Error("Group ID isn't valid UUID")
-		rw.WriteHeader(http.StatusBadRequest)
+		problem.Write(rw, r, problem.New(http.StatusBadRequest, "invalid_id", "Group ID isn't valid UUID").With("id", gid))
+		return
+	}
+
+	// Check user's role in the group
+	mb, err := authorize(a, r, gid, member.RoleMember)
+	if err != nil {
+		logger.WithError(err).Warn("Can't delete payment")
+		problem.Write(rw, r, problem.FromError(err))
 		return
 	}
 
+	// Only admins can delete other members' payments
+	data := map[string]string{"group_id": gid}
+	if !mb.Role.Includes(member.RoleAdmin) {
+		data["member_id"] = mb.ID.String()
+	}
+
 	// Encode JSON
-	body, err := json.Marshal(&map[string]string{"group_id": gid})
+	body, err := json.Marshal(&data)
 	if err != nil {
 		logger.WithError(err).Error("Can't encode body")
-		rw.WriteHeader(http.StatusBadRequest)
+		problem.Write(rw, r, problem.FromError(err))
 		return
 	}
 
 	// Publish AMQP message
-	if err := p.Publish("delete-payment", body); err != nil {
+	if err := p.Publish(r.Context(), "delete-payment", body); err != nil {
 		logger.WithError(err).Warn("Can't publish AMQP message")
-		rw.WriteHeader(http.StatusInternalServerError)
+		problem.Write(rw, r, problem.New(http.StatusInternalServerError, "publish_failed", "Can't queue the request"))
 	} else {
 		rw.WriteHeader(http.StatusAccepted)
 	}
@@ -58,16 +58,21 @@ func TestExpensesHandler(t *testing.T) {
 	cases := []struct {
 		method     string
 		gid        string
+		uid        string
 		reqBody    []byte
 		statusCode int
 	}{
-		{"POST", e.GroupID.String(), body, http.StatusAccepted},
-		{"POST", e.GroupID.String(), []byte(`{"id":"test"}`), http.StatusBadRequest},
-		{"POST", uuid.New().String(), body, http.StatusBadRequest},
-		{"POST", e.GroupID.String(), []byte(`{"recipient":"test;"}`), http.StatusBadRequest},
-
-		{"DELETE", e.GroupID.String(), nil, http.StatusAccepted},
-		{"DELETE", "test", nil, http.StatusBadRequest},
+		{"POST", e.GroupID.String(), "member", body, http.StatusAccepted},
+		{"POST", e.GroupID.String(), "member", []byte(`{"id":"test"}`), http.StatusBadRequest},
+		{"POST", uuid.New().String(), "member", body, http.StatusBadRequest},
+		{"POST", e.GroupID.String(), "member", []byte(`{"recipient":"test;"}`), http.StatusBadRequest},
+		{"POST", e.GroupID.String(), "viewer", body, http.StatusForbidden},
+		{"POST", e.GroupID.String(), "", body, http.StatusUnauthorized},
+
+		{"DELETE", e.GroupID.String(), "member", nil, http.StatusAccepted},
+		{"DELETE", "test", "member", nil, http.StatusBadRequest},
+		{"DELETE", e.GroupID.String(), "admin", nil, http.StatusAccepted},
+		{"DELETE", e.GroupID.String(), "viewer", nil, http.StatusForbidden},
 	}
 
 	for _, tc := range cases {
@@ -83,6 +88,7 @@ func TestExpensesHandler(t *testing.T) {
 			if err != nil {
 				t.Errorf("Can't create request [Error]: %v", err)
 			}
+			req.Header.Set("X-User-ID", tc.uid)
 
 			// Serve test request
 			rec := httptest.NewRecorder()
@@ -111,15 +117,20 @@ func TestPaymentsHandler(t *testing.T) {
 	cases := []struct {
 		method     string
 		gid        string
+		uid        string
 		reqBody    []byte
 		statusCode int
 	}{
-		{"POST", p.GroupID.String(), body, http.StatusAccepted},
-		{"POST", p.GroupID.String(), []byte(`{"id":"test"}`), http.StatusBadRequest},
-		{"POST", uuid.New().String(), body, http.StatusBadRequest},
-
-		{"DELETE", p.GroupID.String(), nil, http.StatusAccepted},
-		{"DELETE", "test", nil, http.StatusBadRequest},
+		{"POST", p.GroupID.String(), "member", body, http.StatusAccepted},
+		{"POST", p.GroupID.String(), "member", []byte(`{"id":"test"}`), http.StatusBadRequest},
+		{"POST", uuid.New().String(), "member", body, http.StatusBadRequest},
+		{"POST", p.GroupID.String(), "viewer", body, http.StatusForbidden},
+		{"POST", p.GroupID.String(), "", body, http.StatusUnauthorized},
+
+		{"DELETE", p.GroupID.String(), "member", nil, http.StatusAccepted},
+		{"DELETE", "test", "member", nil, http.StatusBadRequest},
+		{"DELETE", p.GroupID.String(), "admin", nil, http.StatusAccepted},
+		{"DELETE", p.GroupID.String(), "viewer", nil, http.StatusForbidden},
 	}
 
 	for _, tc := range cases {
@@ -135,6 +146,7 @@ func TestPaymentsHandler(t *testing.T) {
 			if err != nil {
 				t.Errorf("Can't create request [Error]: %v", err)
 			}
+			req.Header.Set("X-User-ID", tc.uid)
 
 			// Serve test request
 			rec := httptest.NewRecorder()
@@ -0,0 +1,145 @@
+package gateway
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Limit for a token bucket of the given size, refilled with rate tokens per
+// second. Limits without a positive size and rate don't limit anything.
+type Limit struct {
+	Size int
+	Rate float64
+}
+
+// PerMinute returns a limit of n requests per minute, allowing bursts of n.
+func PerMinute(n int) Limit {
+	return Limit{Size: n, Rate: float64(n) / 60}
+}
+
+// Result of taking a token from a bucket.
+type Result struct {
+	Allowed    bool
+	Remaining  int
+	Reset      time.Duration
+	RetryAfter time.Duration
+}
+
+// Store of token buckets. Implementations backed by a shared database allow
+// several gateway instances to enforce the same limits. Take only takes the
+// tokens if every key's bucket has one, so requests denied by one bucket
+// don't drain the others, and returns the most restrictive result.
+type Store interface {
+	Take(keys []string, l Limit, now time.Time) (Result, error)
+}
+
+// sweepInterval between the sweeps of the buckets that refilled.
+const sweepInterval = time.Minute
+
+// bucket of tokens refilled at a constant rate.
+type bucket struct {
+	tokens float64
+	last   time.Time
+	limit  Limit
+}
+
+// full checks if the bucket refilled completely at the given time, so it's
+// the same as a new one.
+func (b *bucket) full(now time.Time) bool {
+	return b.tokens+now.Sub(b.last).Seconds()*b.limit.Rate >= float64(b.limit.Size)
+}
+
+// MemoryStore with an in-memory token bucket per key, for a single instance.
+// Buckets are forgotten once they refill, so idle clients take no memory.
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+// NewMemoryStore with no buckets.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: make(map[string]*bucket)}
+}
+
+// Len returns the number of buckets kept.
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.buckets)
+}
+
+// Take a token from the bucket of each key, refilling them first, unless any
+// of them is empty.
+func (s *MemoryStore) Take(keys []string, l Limit, now time.Time) (Result, error) {
+	if l.Size <= 0 || l.Rate <= 0 {
+		return Result{Allowed: true}, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.swept) >= sweepInterval {
+		s.sweep(now)
+	}
+
+	// Refill the buckets and find the one with the fewest tokens, which is
+	// the most restrictive since they share the limit
+	var min *bucket
+	buckets := make([]*bucket, 0, len(keys))
+	for _, key := range keys {
+		b, ok := s.buckets[key]
+		if !ok {
+			b = &bucket{tokens: float64(l.Size), last: now}
+			s.buckets[key] = b
+		}
+		b.limit = l
+
+		// Refill tokens since last request
+		b.tokens = math.Min(float64(l.Size), b.tokens+now.Sub(b.last).Seconds()*l.Rate)
+		b.last = now
+
+		buckets = append(buckets, b)
+		if min == nil || b.tokens < min.tokens {
+			min = b
+		}
+	}
+
+	if min == nil {
+		return Result{Allowed: true, Remaining: l.Size}, nil
+	}
+
+	if min.tokens < 1 {
+		return Result{
+			Allowed:    false,
+			Remaining:  0,
+			Reset:      seconds((float64(l.Size) - min.tokens) / l.Rate),
+			RetryAfter: seconds((1 - min.tokens) / l.Rate),
+		}, nil
+	}
+
+	for _, b := range buckets {
+		b.tokens--
+	}
+	return Result{
+		Allowed:   true,
+		Remaining: int(min.tokens),
+		Reset:     seconds((float64(l.Size) - min.tokens) / l.Rate),
+	}, nil
+}
+
+// sweep the buckets that refilled.
+func (s *MemoryStore) sweep(now time.Time) {
+	for key, b := range s.buckets {
+		if b.full(now) {
+			delete(s.buckets, key)
+		}
+	}
+	s.swept = now
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
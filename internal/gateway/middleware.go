@@ -3,17 +3,16 @@ package gateway
 import (
 	"net/http"
 
-	log "github.com/sirupsen/logrus"
+	"github.com/varrrro/pay-up/internal/logging"
+	"github.com/varrrro/pay-up/internal/metrics"
 )
 
-// LoggingMiddleware that logs requests received.
+// LoggingMiddleware that tags requests with a request ID, logs them and
+// records their metrics.
 func LoggingMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
-		log.WithFields(log.Fields{
-			"uri":    r.URL,
-			"method": r.Method,
-		}).Info("Request received")
+	return metrics.Middleware(logging.Middleware(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		logging.FromContext(r.Context()).Info("Request received")
 
 		next.ServeHTTP(rw, r)
-	})
+	})))
 }
@@ -0,0 +1,28 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"time"
+
+	"github.com/varrrro/pay-up/internal/logging"
+	"github.com/varrrro/pay-up/internal/signature"
+)
+
+// NewProxy to the service at the given URL that signs every request with
+// the shared secret.
+func NewProxy(u *url.URL, secret []byte) *httputil.ReverseProxy {
+	proxy := httputil.NewSingleHostReverseProxy(u)
+
+	director := proxy.Director
+	proxy.Director = func(r *http.Request) {
+		director(r)
+
+		if err := signature.Sign(r, secret, time.Now()); err != nil {
+			logging.FromContext(r.Context()).WithError(err).Error("Can't sign proxied request")
+		}
+	}
+
+	return proxy
+}
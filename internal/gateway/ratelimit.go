@@ -0,0 +1,85 @@
+package gateway
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/varrrro/pay-up/internal/logging"
+	"github.com/varrrro/pay-up/internal/problem"
+)
+
+// RateLimits for reads proxied to gmicro and for writes.
+type RateLimits struct {
+	Read  Limit
+	Write Limit
+}
+
+// RateLimitMiddleware that limits requests per client IP and per user,
+// answering 429 when any of their buckets is empty. It runs after the
+// authentication middlewares, so the user is one they checked and clients
+// can't make up new buckets.
+func RateLimitMiddleware(store Store, limits RateLimits) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			logger := logging.FromContext(r.Context())
+
+			// Pick limit for the kind of request
+			class, limit := "write", limits.Write
+			if r.Method == "GET" || r.Method == "HEAD" {
+				class, limit = "read", limits.Read
+			}
+
+			// Buckets the request takes a token from
+			keys := []string{class + ":ip:" + clientIP(r)}
+			if uid := r.Header.Get(UserHeader); uid != "" {
+				keys = append(keys, class+":user:"+uid)
+			}
+
+			res, err := store.Take(keys, limit, time.Now())
+			if err != nil {
+				logger.WithError(err).Error("Can't check rate limit")
+				problem.Write(rw, r, problem.FromError(err))
+				return
+			}
+
+			writeRateLimit(rw, limit, res)
+			if !res.Allowed {
+				logger.Warn("Rate limit exceeded")
+				problem.Write(rw, r, problem.New(http.StatusTooManyRequests, "rate_limited", "Rate limit exceeded"))
+				return
+			}
+
+			next.ServeHTTP(rw, r)
+		})
+	}
+}
+
+// writeRateLimit headers telling the client about the limit and, once it's
+// exceeded, when to try again.
+func writeRateLimit(rw http.ResponseWriter, l Limit, res Result) {
+	rw.Header().Set("RateLimit-Limit", strconv.Itoa(l.Size))
+	rw.Header().Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	rw.Header().Set("RateLimit-Reset", ceilSeconds(res.Reset))
+
+	if !res.Allowed {
+		rw.Header().Set("Retry-After", ceilSeconds(res.RetryAfter))
+	}
+}
+
+// clientIP returns the IP address the request comes from.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// ceilSeconds formats a duration as a whole number of seconds, rounding up.
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
@@ -0,0 +1,152 @@
+package gateway_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/varrrro/pay-up/internal/gateway"
+)
+
+func TestRateLimitMiddleware(t *testing.T) {
+	limits := gateway.RateLimits{
+		Read:  gateway.PerMinute(2),
+		Write: gateway.PerMinute(1),
+	}
+
+	h := gateway.RateLimitMiddleware(gateway.NewMemoryStore(), limits)(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	}))
+
+	cases := []struct {
+		method     string
+		ip         string
+		statusCode int
+		remaining  string
+	}{
+		{"GET", "10.0.0.1", http.StatusOK, "1"},
+		{"GET", "10.0.0.1", http.StatusOK, "0"},
+		{"GET", "10.0.0.1", http.StatusTooManyRequests, "0"},
+		{"POST", "10.0.0.1", http.StatusOK, "0"},
+		{"POST", "10.0.0.1", http.StatusTooManyRequests, "0"},
+		{"GET", "10.0.0.2", http.StatusOK, "1"},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d %s %s %d", i, tc.method, tc.ip, tc.statusCode), func(t *testing.T) {
+			// Create request
+			req, err := http.NewRequest(tc.method, "/groups", nil)
+			if err != nil {
+				t.Errorf("Can't create request [Error]: %v", err)
+			}
+			req.RemoteAddr = tc.ip + ":1234"
+
+			// Serve test request
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			res := rec.Result() // get response
+			defer res.Body.Close()
+
+			// Check response status code and headers
+			if res.StatusCode != tc.statusCode {
+				t.Errorf("Wrong status code [Expected]: %d [Actual]: %d", tc.statusCode, res.StatusCode)
+			} else if res.Header.Get("RateLimit-Remaining") != tc.remaining {
+				t.Errorf("Wrong remaining requests [Expected]: %s [Actual]: %s", tc.remaining, res.Header.Get("RateLimit-Remaining"))
+			} else if res.StatusCode == http.StatusTooManyRequests && res.Header.Get("Retry-After") == "" {
+				t.Error("Retry-After header isn't present")
+			}
+		})
+	}
+}
+
+func TestRateLimitMiddlewareUsers(t *testing.T) {
+	store := gateway.NewMemoryStore()
+	limits := gateway.RateLimits{
+		Read:  gateway.PerMinute(2),
+		Write: gateway.PerMinute(2),
+	}
+
+	h := gateway.RateLimitMiddleware(store, limits)(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	}))
+
+	// Headers that weren't checked don't get their own buckets
+	for i := 0; i < 10; i++ {
+		req, _ := http.NewRequest("GET", "/groups", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		req.Header.Set("X-API-Key", fmt.Sprintf("random%d", i))
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	if n := store.Len(); n != 1 {
+		t.Errorf("Wrong number of buckets [Expected]: %d [Actual]: %d", 1, n)
+	}
+
+	// Users are limited across IPs
+	cases := []struct {
+		ip         string
+		statusCode int
+	}{
+		{"10.0.0.2", http.StatusOK},
+		{"10.0.0.3", http.StatusOK},
+		{"10.0.0.4", http.StatusTooManyRequests},
+	}
+
+	for _, tc := range cases {
+		req, _ := http.NewRequest("POST", "/groups", nil)
+		req.RemoteAddr = tc.ip + ":1234"
+		req.Header.Set("X-User-ID", "bot")
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tc.statusCode {
+			t.Errorf("Wrong status code [IP]: %s [Expected]: %d [Actual]: %d", tc.ip, tc.statusCode, rec.Code)
+		}
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	store := gateway.NewMemoryStore()
+	now := time.Now()
+
+	for i := 0; i < 100; i++ {
+		store.Take([]string{fmt.Sprintf("ip:%d", i)}, gateway.PerMinute(10), now)
+	}
+	if n := store.Len(); n != 100 {
+		t.Errorf("Wrong number of buckets [Expected]: %d [Actual]: %d", 100, n)
+	}
+
+	// Buckets that refilled are forgotten
+	store.Take([]string{"ip:new"}, gateway.PerMinute(10), now.Add(2*time.Minute))
+	if n := store.Len(); n != 1 {
+		t.Errorf("Wrong number of buckets after refill [Expected]: %d [Actual]: %d", 1, n)
+	}
+
+	// Limits of zero don't limit
+	for _, l := range []gateway.Limit{{}, {Size: 10}, {Rate: 1}} {
+		if res, err := store.Take([]string{"ip:zero"}, l, now); err != nil || !res.Allowed {
+			t.Errorf("Wrong result [Limit]: %+v [Actual]: %+v [Error]: %v", l, res, err)
+		}
+	}
+}
+
+func TestMemoryStoreDenied(t *testing.T) {
+	store := gateway.NewMemoryStore()
+	now := time.Now()
+	l := gateway.PerMinute(2)
+
+	// Empty the IP's bucket
+	store.Take([]string{"ip:1"}, l, now)
+	store.Take([]string{"ip:1"}, l, now)
+
+	// Denied requests don't take tokens from the other buckets
+	for i := 0; i < 5; i++ {
+		if res, _ := store.Take([]string{"ip:1", "user:bot"}, l, now); res.Allowed {
+			t.Fatal("Request with an empty bucket was allowed")
+		}
+	}
+	if res, _ := store.Take([]string{"ip:2", "user:bot"}, l, now); !res.Allowed || res.Remaining != 1 {
+		t.Errorf("Wrong result [Expected remaining]: %d [Actual]: %+v", 1, res)
+	}
+}
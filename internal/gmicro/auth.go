@@ -0,0 +1,42 @@
+package gmicro
+
+import (
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/varrrro/pay-up/internal/gmicro/member"
+)
+
+// UserHeader carries the ID of the authenticated user making the request.
+const UserHeader = "X-User-ID"
+
+// UserNameHeader carries the display name of the authenticated user.
+const UserNameHeader = "X-User-Name"
+
+// authorize checks that the user making the request has at least the given
+// role in the group, returning the member linked to the user.
+func authorize(m Manager, r *http.Request, gid uuid.UUID, role member.Role) (member.Member, error) {
+	uid := r.Header.Get(UserHeader)
+	if uid == "" {
+		return member.Member{}, &UnauthorizedError{"No user in request"}
+	}
+
+	g, err := m.FetchGroup(gid)
+	if err != nil {
+		return member.Member{}, err
+	}
+
+	for _, mb := range g.Members {
+		if mb.UserID != uid {
+			continue
+		}
+
+		if !mb.Role.Includes(role) {
+			return mb, &ForbiddenError{"User doesn't have the required role", gid, uid}
+		}
+
+		return mb, nil
+	}
+
+	return member.Member{}, &ForbiddenError{"User isn't a member of the group", gid, uid}
+}
@@ -1,8 +1,13 @@
 package gmicro
 
-import "fmt"
+import (
+	"fmt"
+	"net/http"
 
-import "github.com/google/uuid"
+	"github.com/google/uuid"
+	"github.com/varrrro/pay-up/internal/openapi"
+	"github.com/varrrro/pay-up/internal/problem"
+)
 
 // NotFoundError used when an item isn't found.
 type NotFoundError struct {
@@ -14,6 +19,11 @@ func (e *NotFoundError) Error() string {
 	return fmt.Sprintf("%s [ID]: %v", e.msg, e.id)
 }
 
+// Problem describing the error.
+func (e *NotFoundError) Problem() *problem.Problem {
+	return problem.New(http.StatusNotFound, "not_found", e.msg).With("id", e.id)
+}
+
 // AlreadyPresentError used when trying to insert a member name already in use.
 type AlreadyPresentError struct {
 	msg     string
@@ -25,6 +35,28 @@ func (e *AlreadyPresentError) Error() string {
 	return fmt.Sprintf("%s [GroupID]: %v [Name]: %s", e.msg, e.groupid, e.name)
 }
 
+// Problem describing the error.
+func (e *AlreadyPresentError) Problem() *problem.Problem {
+	return problem.New(http.StatusConflict, "already_present", e.msg).
+		With("group_id", e.groupid).
+		With("name", e.name)
+}
+
+// DuplicateIDError used when creating an item with an ID already in use.
+type DuplicateIDError struct {
+	msg string
+	id  uuid.UUID
+}
+
+func (e *DuplicateIDError) Error() string {
+	return fmt.Sprintf("%s [ID]: %v", e.msg, e.id)
+}
+
+// Problem describing the error.
+func (e *DuplicateIDError) Problem() *problem.Problem {
+	return problem.New(http.StatusConflict, "duplicate_id", e.msg).With("id", e.id)
+}
+
 // BalanceError used when trying to delete a member with non-zero balance.
 type BalanceError struct {
 	msg      string
@@ -36,3 +68,180 @@ type BalanceError struct {
 func (e *BalanceError) Error() string {
 	return fmt.Sprintf("%s [GroupID]: %v [MemberID]: %v [Balance]: %f", e.msg, e.groupid, e.memberid, e.balance)
 }
+
+// Problem describing the error.
+func (e *BalanceError) Problem() *problem.Problem {
+	return problem.New(http.StatusConflict, "non_zero_balance", e.msg).
+		With("group_id", e.groupid).
+		With("member_id", e.memberid).
+		With("balance", e.balance)
+}
+
+// VersionError used when an item changed since the version being updated.
+type VersionError struct {
+	msg     string
+	id      uuid.UUID
+	version int
+}
+
+func (e *VersionError) Error() string {
+	return fmt.Sprintf("%s [ID]: %v [Version]: %d", e.msg, e.id, e.version)
+}
+
+// Problem describing the error.
+func (e *VersionError) Problem() *problem.Problem {
+	return problem.New(http.StatusConflict, "version_conflict", e.msg).
+		With("id", e.id).
+		With("version", e.version)
+}
+
+// PreconditionError used when a conditional request doesn't match the
+// current ETag of a resource.
+type PreconditionError struct {
+	msg      string
+	expected string
+	actual   string
+}
+
+func (e *PreconditionError) Error() string {
+	return fmt.Sprintf("%s [Expected]: %s [Actual]: %s", e.msg, e.expected, e.actual)
+}
+
+// Problem describing the error.
+func (e *PreconditionError) Problem() *problem.Problem {
+	return problem.New(http.StatusPreconditionFailed, "precondition_failed", e.msg).With("etag", e.actual)
+}
+
+// UnauthorizedError used when a request doesn't identify its user.
+type UnauthorizedError struct {
+	msg string
+}
+
+func (e *UnauthorizedError) Error() string {
+	return e.msg
+}
+
+// Problem describing the error.
+func (e *UnauthorizedError) Problem() *problem.Problem {
+	return problem.New(http.StatusUnauthorized, "unauthorized", e.msg)
+}
+
+// ForbiddenError used when a user lacks the role needed for an action.
+type ForbiddenError struct {
+	msg     string
+	groupid uuid.UUID
+	userid  string
+}
+
+func (e *ForbiddenError) Error() string {
+	return fmt.Sprintf("%s [GroupID]: %v [UserID]: %s", e.msg, e.groupid, e.userid)
+}
+
+// Problem describing the error.
+func (e *ForbiddenError) Problem() *problem.Problem {
+	return problem.New(http.StatusForbidden, "forbidden", e.msg).
+		With("group_id", e.groupid).
+		With("user_id", e.userid)
+}
+
+// InviteError used when redeeming an invite that's revoked, expired or used up.
+type InviteError struct {
+	msg string
+	id  uuid.UUID
+}
+
+func (e *InviteError) Error() string {
+	return fmt.Sprintf("%s [InviteID]: %v", e.msg, e.id)
+}
+
+// Problem describing the error.
+func (e *InviteError) Problem() *problem.Problem {
+	return problem.New(http.StatusGone, "invite_unusable", e.msg).With("invite_id", e.id)
+}
+
+// UUIDParseError used when a string can't be parsed as a UUID.
+type UUIDParseError struct {
+	msg string
+	val string
+	err error
+}
+
+func (e *UUIDParseError) Error() string {
+	return fmt.Sprintf("%s [Value]: %s [Error]: %s", e.msg, e.val, e.err.Error())
+}
+
+// Problem describing the error.
+func (e *UUIDParseError) Problem() *problem.Problem {
+	return problem.New(http.StatusBadRequest, "invalid_uuid", e.msg).With("value", e.val)
+}
+
+// IdempotencyError used when an idempotency key can't be used for a request.
+type IdempotencyError struct {
+	msg    string
+	key    string
+	reused bool
+}
+
+func (e *IdempotencyError) Error() string {
+	return fmt.Sprintf("%s [Key]: %s", e.msg, e.key)
+}
+
+// Problem describing the error.
+func (e *IdempotencyError) Problem() *problem.Problem {
+	if e.reused {
+		return problem.New(http.StatusUnprocessableEntity, "idempotency_key_reused", e.msg).With("key", e.key)
+	}
+
+	return problem.New(http.StatusConflict, "idempotency_key_in_use", e.msg).With("key", e.key)
+}
+
+// PatchError used when a patch leaves a resource in an invalid state.
+type PatchError struct {
+	msg    string
+	fields []openapi.FieldError
+}
+
+func (e *PatchError) Error() string {
+	return fmt.Sprintf("%s [Fields]: %v", e.msg, e.fields)
+}
+
+// Problem describing the error.
+func (e *PatchError) Problem() *problem.Problem {
+	return problem.New(http.StatusUnprocessableEntity, "unprocessable_patch", e.msg).With("fields", e.fields)
+}
+
+// CursorError used when a pagination cursor can't be used for a query.
+type CursorError struct {
+	msg    string
+	cursor string
+}
+
+func (e *CursorError) Error() string {
+	return fmt.Sprintf("%s [Cursor]: %s", e.msg, e.cursor)
+}
+
+// Problem describing the error.
+func (e *CursorError) Problem() *problem.Problem {
+	return problem.New(http.StatusBadRequest, "invalid_cursor", e.msg).With("cursor", e.cursor)
+}
+
+// ArchivedError used when changing an archived group, or restoring a group
+// that isn't archived.
+type ArchivedError struct {
+	msg      string
+	id       uuid.UUID
+	archived bool
+}
+
+func (e *ArchivedError) Error() string {
+	return fmt.Sprintf("%s [ID]: %v", e.msg, e.id)
+}
+
+// Problem describing the error.
+func (e *ArchivedError) Problem() *problem.Problem {
+	if e.archived {
+		return problem.New(http.StatusConflict, "group_archived", e.msg).With("id", e.id)
+	}
+
+	return problem.New(http.StatusConflict, "group_not_archived", e.msg).With("id", e.id)
+}
@@ -0,0 +1,67 @@
+package gmicro
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/varrrro/pay-up/internal/gmicro/group"
+	"github.com/varrrro/pay-up/internal/gmicro/member"
+)
+
+// groupETag identifies the representation of a group, which changes with the
+// version of the group or any of its members.
+func groupETag(g group.Group) string {
+	members := make([]string, 0, len(g.Members))
+	for _, m := range g.Members {
+		members = append(members, fmt.Sprintf("%v:%d", m.ID, m.Version))
+	}
+	sort.Strings(members)
+
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%v:%d;%s", g.ID, g.Version, strings.Join(members, ";"))))
+
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// memberETag identifies the representation of a member.
+func memberETag(m member.Member) string {
+	return fmt.Sprintf(`"%d"`, m.Version)
+}
+
+// checkIfMatch returns an error if the request has an If-Match header that
+// doesn't match the current ETag.
+func checkIfMatch(r *http.Request, etag string) error {
+	h := r.Header.Get("If-Match")
+	if h == "" || matchETag(h, etag, false) {
+		return nil
+	}
+
+	return &PreconditionError{"Resource was modified since it was fetched", h, etag}
+}
+
+// notModified reports whether the request has an If-None-Match header that
+// matches the current ETag.
+func notModified(r *http.Request, etag string) bool {
+	h := r.Header.Get("If-None-Match")
+	return h != "" && matchETag(h, etag, true)
+}
+
+// matchETag reports whether the list of ETags in a header matches the ETag.
+// Weak comparison ignores the W/ prefix.
+func matchETag(header, etag string, weak bool) bool {
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimSpace(t)
+		if weak {
+			t = strings.TrimPrefix(t, "W/")
+		}
+
+		if t == "*" || t == etag {
+			return true
+		}
+	}
+
+	return false
+}
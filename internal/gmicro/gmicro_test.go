@@ -1,48 +1,143 @@
 package gmicro_test
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"os"
 	"testing"
 
+	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/sqlite"
 	"github.com/varrrro/pay-up/internal/gmicro"
 	"github.com/varrrro/pay-up/internal/gmicro/group"
+	"github.com/varrrro/pay-up/internal/gmicro/invite"
 	"github.com/varrrro/pay-up/internal/gmicro/member"
+	"github.com/varrrro/pay-up/internal/gmicro/webhook"
+	"github.com/varrrro/pay-up/internal/inbox"
+	"github.com/varrrro/pay-up/internal/migrate"
+	"github.com/varrrro/pay-up/internal/openapi"
+	"github.com/varrrro/pay-up/internal/publisher"
 )
 
 var db *gorm.DB
 var gm *gmicro.GroupsManager
-var h func(string, []byte) error
+var h func(context.Context, string, []byte) error
 var r *mux.Router
 
+// published keeps the operations of the AMQP messages published, and fails
+// publishing when set to nil.
+var published = []string{}
+
+var pub = publisher.MockPublisher(func(op string, body []byte) error {
+	if published == nil {
+		return errors.New("Publishing failed")
+	}
+	published = append(published, op)
+	return nil
+})
+
+// owner is the user ID of the test groups' owner.
+const owner = "owner"
+
+// secret used to sign test invites.
+var secret = []byte("secret")
+
 func TestMain(m *testing.M) {
 	// Open connection to test DB
 	db, _ = gorm.Open("sqlite3", ":memory:")
 	defer db.Close()
-	db.CreateTable(&group.Group{}, &member.Member{}) // create tables
+
+	// Create tables
+	mig, _ := migrate.New(db, gmicro.MigrationsTable, gmicro.Migrations)
+	if _, err := mig.Up(); err != nil {
+		panic(err)
+	}
 
 	// Create manager with test DB connection
 	gm = gmicro.NewManager(db)
 
 	// Create AMQP message handler
-	h = gmicro.MessageHandler(gm)
+	h = gmicro.MessageHandler(gm, pub)
 
 	// Create router
 	r = mux.NewRouter().StrictSlash(true)
-	r.Use(gmicro.LoggingMiddleware, gmicro.ContentTypeMiddleware)
+	r.Use(
+		gmicro.LoggingMiddleware,
+		gmicro.ContentTypeMiddleware,
+		gmicro.IdempotencyMiddleware(gmicro.NewMemoryIdempotencyStore()),
+		openapi.ValidationMiddleware(openapi.GroupsSpec()),
+	)
 	r.HandleFunc("/", gmicro.StatusHandler).Methods("GET")
-	r.HandleFunc("/groups", gmicro.GroupsHandler(gm)).Methods("POST")
-	r.HandleFunc("/groups/{groupid}", gmicro.GroupHandler(gm)).Methods("GET", "PUT", "DELETE")
+	r.HandleFunc("/groups", gmicro.GroupsHandler(gm)).Methods("GET", "POST")
+	r.HandleFunc("/groups/{groupid}", gmicro.GroupHandler(gm, pub)).Methods("GET", "PUT", "PATCH", "DELETE")
+	r.HandleFunc("/groups/{groupid}/archive", gmicro.ArchiveHandler(gm)).Methods("POST")
+	r.HandleFunc("/groups/{groupid}/restore", gmicro.RestoreHandler(gm)).Methods("POST")
 	r.HandleFunc("/groups/{groupid}/members", gmicro.MembersHandler(gm)).Methods("POST")
-	r.HandleFunc("/groups/{groupid}/members/{memberid}", gmicro.MemberHandler(gm)).Methods("GET", "PUT", "DELETE")
+	r.HandleFunc("/groups/{groupid}/members/{memberid}", gmicro.MemberHandler(gm)).Methods("GET", "PUT", "PATCH", "DELETE")
+	r.HandleFunc("/groups/{groupid}/members/{memberid}/preferences", gmicro.PreferencesHandler(gm, pub)).Methods("GET", "PUT")
+	r.HandleFunc("/groups/{groupid}/invites", gmicro.InvitesHandler(gm, secret)).Methods("POST")
+	r.HandleFunc("/groups/{groupid}/invites/{inviteid}", gmicro.InviteHandler(gm)).Methods("DELETE")
+	r.HandleFunc("/invites/{token}", gmicro.RedeemHandler(gm, secret)).Methods("POST")
+	r.HandleFunc("/groups/{groupid}/webhooks", gmicro.WebhooksHandler(gm)).Methods("GET", "POST")
+	r.HandleFunc("/groups/{groupid}/webhooks/{webhookid}", gmicro.WebhookHandler(gm)).Methods("DELETE")
+	r.HandleFunc("/groups/{groupid}/webhooks/{webhookid}/deliveries", gmicro.DeliveriesHandler(gm)).Methods("GET")
+	r.HandleFunc("/groups/{groupid}/webhooks/{webhookid}/deliveries/{deliveryid}/redeliver", gmicro.RedeliverHandler(gm)).Methods("POST")
 
 	// Run tests
 	os.Exit(m.Run())
 }
 
 func clearDB() {
+	db.Delete(&inbox.Message{})
+	db.Delete(&webhook.Delivery{})
+	db.Delete(&webhook.Webhook{})
+	db.Delete(&member.Preferences{})
+	db.Delete(&invite.Invite{})
 	db.Delete(&member.Member{})
 	db.Delete(&group.Group{})
 }
+
+func addOwner(gid uuid.UUID) {
+	gm.AddMember(gid, &member.Member{ID: uuid.New(), Name: "Owner", UserID: owner, Role: member.RoleOwner})
+}
+
+// addMember to the group with the given balance, which new members can't
+// have otherwise.
+func addMember(gid uuid.UUID, m *member.Member) error {
+	balance := m.Balance
+	if err := gm.AddMember(gid, m); err != nil {
+		return err
+	}
+
+	m.Balance = balance
+	return db.Model(m).UpdateColumn("balance", balance).Error
+}
+
+// readOnly fields of groups and members, which requests can't send.
+var readOnly = []string{"members", "balance", "group_id", "created_at", "updated_at", "active_at", "archived_at", "deleting"}
+
+// requestBody encodes a group or member without its read-only fields.
+func requestBody(v interface{}) []byte {
+	b, _ := json.Marshal(v)
+
+	var fields map[string]interface{}
+	json.Unmarshal(b, &fields)
+	for _, f := range readOnly {
+		delete(fields, f)
+	}
+
+	b, _ = json.Marshal(fields)
+	return b
+}
+
+// closedDB returns a connection that fails every query, to simulate an
+// unavailable database.
+func closedDB() *gorm.DB {
+	bad, _ := gorm.Open("sqlite3", ":memory:")
+	bad.Close()
+
+	return bad
+}
@@ -1,13 +1,69 @@
 package group
 
 import (
+	"time"
+
 	"github.com/google/uuid"
 	"github.com/varrrro/pay-up/internal/gmicro/member"
+	"github.com/varrrro/pay-up/internal/tmicro/expense"
 )
 
-// Group of people, each of which has a balance in the group.
+// Group of people, each of which has a balance in the group. Version is
+// increased on every change to the group itself, while ActiveAt also moves
+// with changes to its members and balances. Archived groups keep their
+// members and history but can't be changed until restored, while groups
+// being deleted stay archived until their transactions are purged.
 type Group struct {
-	ID      uuid.UUID       `json:"id" gorm:"type:uuid;primary_key"`
-	Name    string          `json:"name"`
-	Members []member.Member `json:"members" gorm:"foreignkey:GroupID"`
+	ID          uuid.UUID       `json:"id" gorm:"type:uuid;primary_key"`
+	Name        string          `json:"name"`
+	Description string          `json:"description" gorm:"not null;default:''"`
+	Members     []member.Member `json:"members" gorm:"foreignkey:GroupID"`
+	Version     int             `json:"version" gorm:"not null;default:0"`
+	CreatedAt   time.Time       `json:"created_at" gorm:"not null;default:CURRENT_TIMESTAMP"`
+	UpdatedAt   time.Time       `json:"updated_at" gorm:"not null;default:CURRENT_TIMESTAMP"`
+	ActiveAt    time.Time       `json:"active_at" gorm:"not null;default:CURRENT_TIMESTAMP"`
+	ArchivedAt  *time.Time      `json:"archived_at"`
+	Deleting    bool            `json:"deleting" gorm:"not null;default:false"`
+}
+
+// Archived reports whether the group is archived.
+func (g *Group) Archived() bool {
+	return g.ArchivedAt != nil
+}
+
+// Summary of a group used in listings, with the number of members instead of
+// the members themselves.
+type Summary struct {
+	ID          uuid.UUID  `json:"id"`
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	Members     int        `json:"members"`
+	Version     int        `json:"version"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ActiveAt    time.Time  `json:"active_at"`
+	ArchivedAt  *time.Time `json:"archived_at"`
+}
+
+// BalanceChange published when a transaction changes the balances of a
+// group, with the balance of every member afterwards. Changes made by adding
+// an expense carry the expense.
+type BalanceChange struct {
+	GroupID   uuid.UUID        `json:"group_id"`
+	GroupName string           `json:"group_name"`
+	Operation string           `json:"operation"`
+	Balances  []MemberBalance  `json:"balances"`
+	Expense   *expense.Expense `json:"expense,omitempty"`
+	At        time.Time        `json:"at"`
+}
+
+// Deletion published once a group is purged.
+type Deletion struct {
+	GroupID uuid.UUID `json:"group_id"`
+}
+
+// MemberBalance of a member in a balance change.
+type MemberBalance struct {
+	MemberID uuid.UUID `json:"member_id"`
+	Name     string    `json:"name"`
+	Balance  float32   `json:"balance"`
 }
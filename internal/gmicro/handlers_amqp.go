@@ -1,39 +1,57 @@
 package gmicro
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"math"
 
+	"github.com/google/uuid"
 	log "github.com/sirupsen/logrus"
-
+	"github.com/varrrro/pay-up/internal/gmicro/group"
+	"github.com/varrrro/pay-up/internal/gmicro/webhook"
+	"github.com/varrrro/pay-up/internal/logging"
+	"github.com/varrrro/pay-up/internal/publisher"
 	"github.com/varrrro/pay-up/internal/tmicro/expense"
 	"github.com/varrrro/pay-up/internal/tmicro/payment"
 )
 
-// MessageHandler for AMQP messages.
-func MessageHandler(m Manager) func(string, []byte) error {
-	return func(op string, body []byte) error {
-		log.WithField("operation", op).Info("AMQP message received")
+// groupDeletion published when a group is deleted. The transactions
+// microservice purges the group's transactions and publishes it back, so the
+// group is purged last.
+type groupDeletion struct {
+	GroupID uuid.UUID `json:"group_id"`
+	Force   bool      `json:"force"`
+}
+
+// MessageHandler for AMQP messages, which publishes a balance change to
+// events after applying a transaction, and a deletion after purging a group.
+func MessageHandler(m Manager, events publisher.Publisher) func(context.Context, string, []byte) error {
+	return func(ctx context.Context, op string, body []byte) error {
+		logging.FromContext(ctx).WithField("operation", op).Info("AMQP message received")
+		m := m.WithContext(ctx)
 
 		switch op {
 		case "add-expense":
-			return addExpenseHandler(body, m)
+			return addExpenseHandler(ctx, body, m, events)
 		case "delete-expense":
-			return deleteExpenseHandler(body, m)
+			return deleteExpenseHandler(ctx, body, m, events)
 		case "add-payment":
-			return addPaymentHandler(body, m)
+			return addPaymentHandler(ctx, body, m, events)
 		case "delete-payment":
-			return deletePaymentHandler(body, m)
+			return deletePaymentHandler(ctx, body, m, events)
+		case "delete-group":
+			return purgeGroupHandler(ctx, body, m, events)
 		default:
 			err := errors.New("Wrong operation type")
-			log.WithError(err).Warn("Can't handle message")
+			logging.FromContext(ctx).WithError(err).Warn("Can't handle message")
 			return err
 		}
 	}
 }
 
-func addExpenseHandler(body []byte, m Manager) error {
-	logger := log.WithField("operation", "add-expense")
+func addExpenseHandler(ctx context.Context, body []byte, m Manager, events publisher.Publisher) error {
+	logger := logging.FromContext(ctx).WithField("operation", "add-expense")
 
 	// Decode JSON
 	var e expense.Expense
@@ -48,11 +66,14 @@ func addExpenseHandler(body []byte, m Manager) error {
 		return err
 	}
 
+	balanceChanged(ctx, m, events, "add-expense", e.GroupID, &e)
+	queueEvent(ctx, m, e.GroupID, webhook.ExpenseAdded, &e)
+
 	return nil
 }
 
-func deleteExpenseHandler(body []byte, m Manager) error {
-	logger := log.WithField("operation", "delete-expense")
+func deleteExpenseHandler(ctx context.Context, body []byte, m Manager, events publisher.Publisher) error {
+	logger := logging.FromContext(ctx).WithField("operation", "delete-expense")
 
 	// Decode JSON
 	var e expense.Expense
@@ -67,11 +88,14 @@ func deleteExpenseHandler(body []byte, m Manager) error {
 		return err
 	}
 
+	balanceChanged(ctx, m, events, "delete-expense", e.GroupID, nil)
+	queueEvent(ctx, m, e.GroupID, webhook.ExpenseRemoved, &e)
+
 	return nil
 }
 
-func addPaymentHandler(body []byte, m Manager) error {
-	logger := log.WithField("operation", "add-payment")
+func addPaymentHandler(ctx context.Context, body []byte, m Manager, events publisher.Publisher) error {
+	logger := logging.FromContext(ctx).WithField("operation", "add-payment")
 
 	// Decode JSON
 	var p payment.Payment
@@ -86,11 +110,15 @@ func addPaymentHandler(body []byte, m Manager) error {
 		return err
 	}
 
+	balanceChanged(ctx, m, events, "add-payment", p.GroupID, nil)
+	queueEvent(ctx, m, p.GroupID, webhook.PaymentRecorded, &p)
+	balanceSettled(ctx, m, p.GroupID, p.Payer, p.Recipient)
+
 	return nil
 }
 
-func deletePaymentHandler(body []byte, m Manager) error {
-	logger := log.WithField("operation", "delete-payment")
+func deletePaymentHandler(ctx context.Context, body []byte, m Manager, events publisher.Publisher) error {
+	logger := logging.FromContext(ctx).WithField("operation", "delete-payment")
 
 	// Decode JSON
 	var p payment.Payment
@@ -105,5 +133,90 @@ func deletePaymentHandler(body []byte, m Manager) error {
 		return err
 	}
 
+	balanceChanged(ctx, m, events, "delete-payment", p.GroupID, nil)
+
+	return nil
+}
+
+func purgeGroupHandler(ctx context.Context, body []byte, m Manager, events publisher.Publisher) error {
+	logger := logging.FromContext(ctx).WithField("operation", "delete-group")
+
+	// Decode JSON
+	var d groupDeletion
+	if err := json.Unmarshal(body, &d); err != nil {
+		logger.WithError(err).Error("Can't decode body")
+		return err
+	}
+
+	// Purge group
+	if err := m.PurgeGroup(d.GroupID); err != nil {
+		logger.WithError(err).Error("Can't purge group")
+		return err
+	}
+
+	logger.WithField("group_id", d.GroupID).Info("Group deleted")
+
+	// Best effort, like balance changes
+	if body, err := json.Marshal(&group.Deletion{GroupID: d.GroupID}); err != nil {
+		logger.WithError(err).Warn("Can't encode deletion event")
+	} else if err := events.Publish(ctx, "group-deleted", body); err != nil {
+		logger.WithError(err).Warn("Can't publish deletion event")
+	}
+
 	return nil
 }
+
+// balanceChanged publishes the balances of the group after a transaction,
+// along with the expense added, if any. Events are best effort, since failing
+// the message would apply the transaction again.
+func balanceChanged(ctx context.Context, m Manager, events publisher.Publisher, op string, gid uuid.UUID, e *expense.Expense) {
+	logger := logging.FromContext(ctx).WithFields(log.Fields{
+		"operation": op,
+		"group_id":  gid,
+	})
+
+	g, err := m.FetchGroup(gid)
+	if err != nil {
+		logger.WithError(err).Warn("Can't fetch group for balance event")
+		return
+	}
+
+	ev := group.BalanceChange{
+		GroupID:   gid,
+		GroupName: g.Name,
+		Operation: op,
+		Balances:  make([]group.MemberBalance, 0, len(g.Members)),
+		Expense:   e,
+		At:        g.ActiveAt,
+	}
+	for _, mb := range g.Members {
+		ev.Balances = append(ev.Balances, group.MemberBalance{MemberID: mb.ID, Name: mb.Name, Balance: mb.Balance})
+	}
+
+	body, err := json.Marshal(&ev)
+	if err != nil {
+		logger.WithError(err).Warn("Can't encode balance event")
+		return
+	}
+
+	if err := events.Publish(ctx, "balance-changed", body); err != nil {
+		logger.WithError(err).Warn("Can't publish balance event")
+	}
+}
+
+// balanceSettled queues an event for each of the members whose balance a
+// payment brought to zero.
+func balanceSettled(ctx context.Context, m Manager, gid uuid.UUID, mids ...uuid.UUID) {
+	for _, mid := range mids {
+		mb, err := m.FetchMember(gid, mid)
+		if err != nil {
+			logging.FromContext(ctx).WithField("member_id", mid).WithError(err).Warn("Can't fetch member for settlement event")
+			continue
+		}
+
+		// Balances are rounded to cents
+		if math.Abs(float64(mb.Balance)) < 0.005 {
+			queueEvent(ctx, m, gid, webhook.BalanceSettled, &mb)
+		}
+	}
+}
@@ -1,20 +1,25 @@
 package gmicro_test
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"testing"
 
 	"github.com/google/uuid"
+	"github.com/varrrro/pay-up/internal/dberr"
+	"github.com/varrrro/pay-up/internal/gmicro"
 	"github.com/varrrro/pay-up/internal/gmicro/group"
 	"github.com/varrrro/pay-up/internal/gmicro/member"
+	"github.com/varrrro/pay-up/internal/publisher"
 	"github.com/varrrro/pay-up/internal/tmicro/expense"
 	"github.com/varrrro/pay-up/internal/tmicro/payment"
 )
 
 func TestMessageHandler(t *testing.T) {
 	g := group.Group{ID: uuid.New(), Name: "Test"}
-	gm.CreateGroup(&g)
+	gm.CreateGroup(&g, nil)
 
 	m1 := member.Member{ID: uuid.New(), Name: "Test1"}
 	gm.AddMember(g.ID, &m1)
@@ -46,6 +51,8 @@ func TestMessageHandler(t *testing.T) {
 	p.GroupID = uuid.New()
 	pbody2, _ := json.Marshal(&p)
 
+	gbody, _ := json.Marshal(map[string]interface{}{"group_id": g.ID, "force": true})
+
 	cases := []struct {
 		op   string
 		body []byte
@@ -67,13 +74,16 @@ func TestMessageHandler(t *testing.T) {
 		{"delete-payment", []byte(`{"id":"test"}`), true},
 		{"delete-payment", pbody2, true},
 
+		{"delete-group", gbody, false},
+		{"delete-group", []byte(`{"group_id":"test"}`), true},
+
 		{"test", nil, true},
 	}
 
 	for _, tc := range cases {
 		if !tc.fail {
 			t.Run(fmt.Sprintf("Correct %s", tc.op), func(t *testing.T) {
-				err := h(tc.op, tc.body)
+				err := h(context.Background(), tc.op, tc.body)
 
 				if err != nil {
 					t.Errorf("Operation can't finish correctly [Error]: %v", err)
@@ -81,7 +91,7 @@ func TestMessageHandler(t *testing.T) {
 			})
 		} else {
 			t.Run(fmt.Sprintf("Error %s", tc.op), func(t *testing.T) {
-				err := h(tc.op, tc.body)
+				err := h(context.Background(), tc.op, tc.body)
 
 				if err == nil {
 					t.Error("Using wrong values didn't return an error")
@@ -90,3 +100,108 @@ func TestMessageHandler(t *testing.T) {
 		}
 	}
 }
+
+func TestMessageHandlerRetry(t *testing.T) {
+	g := group.Group{ID: uuid.New(), Name: "Test"}
+	gm.CreateGroup(&g, nil)
+
+	m := member.Member{ID: uuid.New(), Name: "Test"}
+	gm.AddMember(g.ID, &m)
+
+	p := payment.Payment{ID: uuid.New(), GroupID: g.ID, Amount: 14.6, Payer: m.ID, Recipient: uuid.New()}
+	body, _ := json.Marshal(&p)
+
+	bad := gmicro.MessageHandler(gmicro.NewManager(closedDB()), pub)
+
+	cases := []struct {
+		name  string
+		h     func(context.Context, string, []byte) error
+		retry bool
+	}{
+		{"Database unavailable", bad, true},
+		{"Member not found", h, false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.h(context.Background(), "add-payment", body)
+
+			if err == nil {
+				t.Fatal("Failing operation didn't return an error")
+			}
+			if dberr.Temporary(err) != tc.retry {
+				t.Errorf("Wrong retry decision [Expected]: %t [Actual]: %t", tc.retry, dberr.Temporary(err))
+			}
+		})
+	}
+
+	clearDB()
+}
+
+func TestMessageHandlerBalanceEvent(t *testing.T) {
+	g := group.Group{ID: uuid.New(), Name: "Test"}
+	gm.CreateGroup(&g, nil)
+
+	m1 := member.Member{ID: uuid.New(), Name: "Test1"}
+	gm.AddMember(g.ID, &m1)
+
+	m2 := member.Member{ID: uuid.New(), Name: "Test2"}
+	gm.AddMember(g.ID, &m2)
+
+	p := payment.Payment{ID: uuid.New(), GroupID: g.ID, Amount: 10, Payer: m1.ID, Recipient: m2.ID}
+	body, _ := json.Marshal(&p)
+
+	var events []group.BalanceChange
+	fail := false
+	eh := gmicro.MessageHandler(gm, publisher.MockPublisher(func(op string, body []byte) error {
+		if fail {
+			return errors.New("Publishing failed")
+		}
+
+		var ev group.BalanceChange
+		if err := json.Unmarshal(body, &ev); err != nil {
+			t.Fatalf("Can't decode balance event [Error]: %v", err)
+		}
+		if op != "balance-changed" {
+			t.Errorf("Wrong operation [Expected]: %s [Actual]: %s", "balance-changed", op)
+		}
+		events = append(events, ev)
+		return nil
+	}))
+
+	if err := eh(context.Background(), "add-payment", body); err != nil {
+		t.Fatalf("Operation can't finish correctly [Error]: %v", err)
+	}
+
+	if len(events) != 1 {
+		t.Fatalf("Wrong event count [Expected]: %d [Actual]: %d", 1, len(events))
+	}
+	ev := events[0]
+	if ev.GroupID != g.ID || ev.Operation != "add-payment" || len(ev.Balances) != 2 {
+		t.Errorf("Wrong balance event [Actual]: %+v", ev)
+	}
+	for _, b := range ev.Balances {
+		if b.MemberID == m1.ID && b.Balance != 10 {
+			t.Errorf("Wrong payer balance [Expected]: %v [Actual]: %v", 10, b.Balance)
+		}
+	}
+
+	// Expenses are carried by their balance change
+	e := expense.Expense{ID: uuid.New(), GroupID: g.ID, Amount: 10, Payer: m1.ID, Recipients: m2.ID.String()}
+	ebody, _ := json.Marshal(&e)
+	if err := eh(context.Background(), "add-expense", ebody); err != nil {
+		t.Fatalf("Operation can't finish correctly [Error]: %v", err)
+	}
+	ev = events[len(events)-1]
+	if ev.GroupName != g.Name || ev.Expense == nil || ev.Expense.ID != e.ID {
+		t.Errorf("Wrong expense event [Actual]: %+v", ev)
+	}
+
+	// Failing to publish the event doesn't fail the message
+	fail = true
+	if err := eh(context.Background(), "delete-payment", body); err != nil {
+		t.Errorf("Failed event publishing failed the message [Error]: %v", err)
+	}
+
+	clearDB()
+}
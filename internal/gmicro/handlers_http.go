@@ -3,12 +3,22 @@ package gmicro
 import (
 	"encoding/json"
 	"net/http"
+	"net/mail"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 	log "github.com/sirupsen/logrus"
 	"github.com/varrrro/pay-up/internal/gmicro/group"
+	"github.com/varrrro/pay-up/internal/gmicro/invite"
 	"github.com/varrrro/pay-up/internal/gmicro/member"
+	"github.com/varrrro/pay-up/internal/gmicro/webhook"
+	"github.com/varrrro/pay-up/internal/logging"
+	"github.com/varrrro/pay-up/internal/problem"
+	"github.com/varrrro/pay-up/internal/publisher"
 )
 
 // StatusHandler returns a static message to know the server is working.
@@ -19,36 +29,121 @@ func StatusHandler(rw http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(rw).Encode(&status)
 }
 
-// GroupsHandler manages requests for creating new groups.
+// GroupsHandler manages requests for listing or creating groups.
 func GroupsHandler(m Manager) func(http.ResponseWriter, *http.Request) {
 	return func(rw http.ResponseWriter, r *http.Request) {
-		logger := log.WithFields(log.Fields{
-			"uri":    r.URL,
-			"method": r.Method,
-		})
+		m := m.WithContext(r.Context())
+		switch r.Method {
+		case "GET":
+			listGroupsHandler(m, rw, r)
+			break
+		case "POST":
+			createGroupHandler(m, rw, r)
+		}
+	}
+}
 
-		// Parse JSON
-		var g group.Group
-		if err := json.NewDecoder(r.Body).Decode(&g); err != nil {
-			logger.WithError(err).Error("Can't parse request body as group")
-			rw.WriteHeader(http.StatusBadRequest)
+func listGroupsHandler(m Manager, rw http.ResponseWriter, r *http.Request) {
+	logger := logging.FromContext(r.Context())
+
+	// Get user listing the groups
+	uid := r.Header.Get(UserHeader)
+	if uid == "" {
+		logger.Warn("No user in request")
+		problem.Write(rw, r, problem.New(http.StatusUnauthorized, "unauthorized", "No user in request"))
+		return
+	}
+
+	// Build query from parameters, a leading - sorts in descending order
+	params := r.URL.Query()
+	q := GroupQuery{
+		UserID: uid,
+		Name:   params.Get("q"),
+		Sort:   strings.TrimPrefix(params.Get("sort"), "-"),
+		Desc:   strings.HasPrefix(params.Get("sort"), "-"),
+		Cursor: params.Get("cursor"),
+	}
+	if archived := params.Get("archived"); archived != "" {
+		b, err := strconv.ParseBool(archived)
+		if err != nil {
+			logger.WithError(err).Error("Can't parse archived as boolean")
+			problem.Write(rw, r, problem.New(http.StatusBadRequest, "invalid_archived", "Archived isn't a valid boolean"))
 			return
 		}
-
-		// Create group
-		if err := m.CreateGroup(&g); err != nil {
-			logger.WithError(err).Warn("Can't create group")
-			rw.WriteHeader(http.StatusInternalServerError)
+		q.Archived = b
+	}
+	if limit := params.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil {
+			logger.WithError(err).Error("Can't parse limit as integer")
+			problem.Write(rw, r, problem.New(http.StatusBadRequest, "invalid_limit", "Limit isn't a valid integer"))
 			return
 		}
+		q.Limit = n
+	}
 
-		rw.WriteHeader(http.StatusCreated)
+	// List groups
+	page, err := m.ListGroups(q)
+	if err != nil {
+		logger.WithError(err).Warn("Can't list groups")
+		problem.Write(rw, r, problem.FromError(err))
+		return
+	}
+
+	rw.WriteHeader(http.StatusOK)
+	json.NewEncoder(rw).Encode(&page)
+}
+
+func createGroupHandler(m Manager, rw http.ResponseWriter, r *http.Request) {
+	logger := logging.FromContext(r.Context())
+
+	// Get user creating the group
+	uid := r.Header.Get(UserHeader)
+	if uid == "" {
+		logger.Warn("No user in request")
+		problem.Write(rw, r, problem.New(http.StatusUnauthorized, "unauthorized", "No user in request"))
+		return
+	}
+
+	// Parse JSON
+	var g group.Group
+	if err := json.NewDecoder(r.Body).Decode(&g); err != nil {
+		logger.WithError(err).Error("Can't parse request body as group")
+		problem.Write(rw, r, problem.New(http.StatusBadRequest, "invalid_body", "Request body isn't a valid group"))
+		return
+	}
+
+	// Create group with the user as its owner
+	name := r.Header.Get(UserNameHeader)
+	if name == "" {
+		name = uid
+	}
+	owner := member.Member{ID: uuid.New(), Name: name, UserID: uid, Role: member.RoleOwner}
+	if err := m.CreateGroup(&g, &owner); err != nil {
+		logger.WithError(err).Warn("Can't create group")
+		problem.Write(rw, r, problem.FromError(err))
+		return
 	}
+
+	// Fetch created group
+	g, err := m.FetchGroup(g.ID)
+	if err != nil {
+		logger.WithError(err).Warn("Can't fetch created group")
+		problem.Write(rw, r, problem.FromError(err))
+		return
+	}
+
+	rw.Header().Set("Location", "/groups/"+g.ID.String())
+	rw.WriteHeader(http.StatusCreated)
+	json.NewEncoder(rw).Encode(&g)
 }
 
-// GroupHandler manages requests for fetching, updating or deleting a group.
-func GroupHandler(m Manager) func(http.ResponseWriter, *http.Request) {
+// GroupHandler manages requests for fetching, updating, patching or deleting a
+// group. Deletions are published so the group's transactions are purged
+// before the group itself.
+func GroupHandler(m Manager, pub publisher.Publisher) func(http.ResponseWriter, *http.Request) {
 	return func(rw http.ResponseWriter, r *http.Request) {
+		m := m.WithContext(r.Context())
 		switch r.Method {
 		case "GET":
 			getGroupHandler(m, rw, r)
@@ -56,23 +151,30 @@ func GroupHandler(m Manager) func(http.ResponseWriter, *http.Request) {
 		case "PUT":
 			putGroupHandler(m, rw, r)
 			break
+		case "PATCH":
+			patchGroupHandler(m, rw, r)
+			break
 		case "DELETE":
-			deleteGroupHandler(m, rw, r)
+			deleteGroupHandler(m, pub, rw, r)
 		}
 	}
 }
 
 func getGroupHandler(m Manager, rw http.ResponseWriter, r *http.Request) {
-	logger := log.WithFields(log.Fields{
-		"uri":    r.URL,
-		"method": r.Method,
-	})
+	logger := logging.FromContext(r.Context())
 
 	// Get group ID from request path
 	gid, err := uuid.Parse(mux.Vars(r)["groupid"])
 	if err != nil {
 		logger.WithError(err).Error("Can't parse group ID as UUID")
-		rw.WriteHeader(http.StatusBadRequest)
+		problem.Write(rw, r, problem.New(http.StatusBadRequest, "invalid_id", "Group ID isn't a valid UUID"))
+		return
+	}
+
+	// Check user's role in the group
+	if _, err := authorize(m, r, gid, member.RoleViewer); err != nil {
+		logger.WithError(err).Warn("Can't fetch group")
+		problem.Write(rw, r, problem.FromError(err))
 		return
 	}
 
@@ -80,13 +182,15 @@ func getGroupHandler(m Manager, rw http.ResponseWriter, r *http.Request) {
 	g, err := m.FetchGroup(gid)
 	if err != nil {
 		logger.WithError(err).Warn("Can't fetch group")
+		problem.Write(rw, r, problem.FromError(err))
+		return
+	}
 
-		if _, ok := err.(*NotFoundError); ok {
-			rw.WriteHeader(http.StatusNotFound)
-		} else {
-			rw.WriteHeader(http.StatusInternalServerError)
-		}
-
+	// Skip the body if the client has the current version
+	etag := groupETag(g)
+	rw.Header().Set("ETag", etag)
+	if notModified(r, etag) {
+		rw.WriteHeader(http.StatusNotModified)
 		return
 	}
 
@@ -95,16 +199,13 @@ func getGroupHandler(m Manager, rw http.ResponseWriter, r *http.Request) {
 }
 
 func putGroupHandler(m Manager, rw http.ResponseWriter, r *http.Request) {
-	logger := log.WithFields(log.Fields{
-		"uri":    r.URL,
-		"method": r.Method,
-	})
+	logger := logging.FromContext(r.Context())
 
 	// Get group ID from request path
 	gid, err := uuid.Parse(mux.Vars(r)["groupid"])
 	if err != nil {
 		logger.WithError(err).Error("Can't parse group ID as UUID")
-		rw.WriteHeader(http.StatusBadRequest)
+		problem.Write(rw, r, problem.New(http.StatusBadRequest, "invalid_id", "Group ID isn't a valid UUID"))
 		return
 	}
 
@@ -112,7 +213,7 @@ func putGroupHandler(m Manager, rw http.ResponseWriter, r *http.Request) {
 	var g group.Group
 	if err := json.NewDecoder(r.Body).Decode(&g); err != nil {
 		logger.WithError(err).Error("Can't parse request body as group")
-		rw.WriteHeader(http.StatusBadRequest)
+		problem.Write(rw, r, problem.New(http.StatusBadRequest, "invalid_body", "Request body isn't a valid group"))
 		return
 	}
 
@@ -122,69 +223,267 @@ func putGroupHandler(m Manager, rw http.ResponseWriter, r *http.Request) {
 			"path": gid,
 			"body": g.ID,
 		}).Error("Group IDs in path and body don't match")
-		rw.WriteHeader(http.StatusBadRequest)
+		problem.Write(rw, r, problem.New(http.StatusBadRequest, "id_mismatch", "Group IDs in path and body don't match").With("path", gid).With("body", g.ID))
+		return
+	}
+
+	// Check user's role in the group
+	if _, err := authorize(m, r, gid, member.RoleAdmin); err != nil {
+		logger.WithError(err).Warn("Can't update group")
+		problem.Write(rw, r, problem.FromError(err))
+		return
+	}
+
+	// Updates must say which version of the group they replace, so they
+	// can't overwrite changes the client didn't see
+	if r.Header.Get("If-Match") == "" && g.Version == 0 {
+		logger.Warn("Group update without If-Match or version")
+		problem.Write(rw, r, problem.New(http.StatusPreconditionRequired, "precondition_required", "Group updates need an If-Match header or the group's version"))
 		return
 	}
 
+	// Check the group didn't change since the client fetched it. The body's
+	// version, if any, is compared with the stored one too.
+	cur, err := m.FetchGroup(gid)
+	if err == nil {
+		err = checkIfMatch(r, groupETag(cur))
+	}
+	if err != nil {
+		logger.WithError(err).Warn("Can't update group")
+		problem.Write(rw, r, problem.FromError(err))
+		return
+	}
+	if g.Version == 0 {
+		g.Version = cur.Version
+	}
+
 	// Update group
 	if err := m.UpdateGroup(&g); err != nil {
 		logger.WithError(err).Warn("Can't update group")
+		problem.Write(rw, r, problem.FromError(err))
+		return
+	}
+	cur.Version = g.Version
 
-		if _, ok := err.(*NotFoundError); ok {
-			rw.WriteHeader(http.StatusNotFound)
-		} else {
-			rw.WriteHeader(http.StatusInternalServerError)
-		}
+	rw.Header().Set("ETag", groupETag(cur))
+	rw.WriteHeader(http.StatusOK)
+}
+
+func patchGroupHandler(m Manager, rw http.ResponseWriter, r *http.Request) {
+	logger := logging.FromContext(r.Context())
+
+	// Get group ID from request path
+	gid, err := uuid.Parse(mux.Vars(r)["groupid"])
+	if err != nil {
+		logger.WithError(err).Error("Can't parse group ID as UUID")
+		problem.Write(rw, r, problem.New(http.StatusBadRequest, "invalid_id", "Group ID isn't a valid UUID"))
+		return
+	}
+
+	// Check user's role in the group
+	if _, err := authorize(m, r, gid, member.RoleAdmin); err != nil {
+		logger.WithError(err).Warn("Can't patch group")
+		problem.Write(rw, r, problem.FromError(err))
+		return
+	}
 
+	// Check the group didn't change since the client fetched it
+	cur, err := m.FetchGroup(gid)
+	if err == nil {
+		err = checkIfMatch(r, groupETag(cur))
+	}
+	if err != nil {
+		logger.WithError(err).Warn("Can't patch group")
+		problem.Write(rw, r, problem.FromError(err))
+		return
+	}
+
+	// Apply patch
+	var g group.Group
+	changed, err := applyPatch(r, &cur, "Group", groupPatchFields, &g)
+	if err != nil {
+		logger.WithError(err).Warn("Can't patch group")
+		problem.Write(rw, r, problem.FromError(err))
 		return
 	}
 
+	// Update group
+	if len(changed) > 0 {
+		g.Version = cur.Version
+		if err := m.UpdateGroup(&g); err != nil {
+			logger.WithError(err).Warn("Can't patch group")
+			problem.Write(rw, r, problem.FromError(err))
+			return
+		}
+
+		logger.WithFields(log.Fields{
+			"user_id":  r.Header.Get(UserHeader),
+			"group_id": gid,
+			"changed":  changed,
+		}).Info("Group patched")
+	}
+
+	rw.Header().Set("ETag", groupETag(g))
 	rw.WriteHeader(http.StatusOK)
+	json.NewEncoder(rw).Encode(&g)
 }
 
-func deleteGroupHandler(m Manager, rw http.ResponseWriter, r *http.Request) {
-	logger := log.WithFields(log.Fields{
-		"uri":    r.URL,
-		"method": r.Method,
-	})
+func deleteGroupHandler(m Manager, pub publisher.Publisher, rw http.ResponseWriter, r *http.Request) {
+	logger := logging.FromContext(r.Context())
 
 	// Get group ID from request path
 	gid, err := uuid.Parse(mux.Vars(r)["groupid"])
 	if err != nil {
 		logger.WithError(err).Error("Can't parse group ID as UUID")
-		rw.WriteHeader(http.StatusBadRequest)
+		problem.Write(rw, r, problem.New(http.StatusBadRequest, "invalid_id", "Group ID isn't a valid UUID"))
 		return
 	}
 
-	// Remove group
-	if err := m.RemoveGroup(gid); err != nil {
+	// Groups with unsettled balances are only deleted if forced
+	var force bool
+	if f := r.URL.Query().Get("force"); f != "" {
+		if force, err = strconv.ParseBool(f); err != nil {
+			logger.WithError(err).Error("Can't parse force as boolean")
+			problem.Write(rw, r, problem.New(http.StatusBadRequest, "invalid_force", "Force isn't a valid boolean"))
+			return
+		}
+	}
+
+	// Check user's role in the group
+	if _, err := authorize(m, r, gid, member.RoleOwner); err != nil {
 		logger.WithError(err).Warn("Can't remove group")
+		problem.Write(rw, r, problem.FromError(err))
+		return
+	}
 
-		if _, ok := err.(*NotFoundError); ok {
-			rw.WriteHeader(http.StatusNotFound)
-		} else {
-			rw.WriteHeader(http.StatusInternalServerError)
+	// Check the group didn't change since the client fetched it
+	if r.Header.Get("If-Match") != "" {
+		cur, err := m.FetchGroup(gid)
+		if err == nil {
+			err = checkIfMatch(r, groupETag(cur))
+		}
+		if err != nil {
+			logger.WithError(err).Warn("Can't remove group")
+			problem.Write(rw, r, problem.FromError(err))
+			return
 		}
+	}
 
+	// Start deletion, which archives the group until it's purged
+	if err := m.DeleteGroup(gid, force); err != nil {
+		logger.WithError(err).Warn("Can't remove group")
+		problem.Write(rw, r, problem.FromError(err))
 		return
 	}
 
-	rw.WriteHeader(http.StatusNoContent)
+	// Publish AMQP message so the group's transactions are purged
+	body, _ := json.Marshal(&groupDeletion{gid, force})
+	if err := pub.Publish(r.Context(), "delete-group", body); err != nil {
+		logger.WithError(err).Warn("Can't publish AMQP message")
+		if err := m.CancelDeleteGroup(gid); err != nil {
+			logger.WithError(err).Error("Can't cancel group deletion")
+		}
+		problem.Write(rw, r, problem.New(http.StatusInternalServerError, "publish_failed", "Can't queue the request"))
+		return
+	}
+
+	logger.WithFields(log.Fields{
+		"user_id":  r.Header.Get(UserHeader),
+		"group_id": gid,
+		"force":    force,
+	}).Info("Group deletion started")
+
+	rw.WriteHeader(http.StatusAccepted)
+}
+
+// ArchiveHandler manages requests for archiving groups.
+func ArchiveHandler(m Manager) func(http.ResponseWriter, *http.Request) {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		m := m.WithContext(r.Context())
+		logger := logging.FromContext(r.Context())
+
+		// Get group ID from request path
+		gid, err := uuid.Parse(mux.Vars(r)["groupid"])
+		if err != nil {
+			logger.WithError(err).Error("Can't parse group ID as UUID")
+			problem.Write(rw, r, problem.New(http.StatusBadRequest, "invalid_id", "Group ID isn't a valid UUID"))
+			return
+		}
+
+		// Check user's role in the group
+		if _, err := authorize(m, r, gid, member.RoleOwner); err != nil {
+			logger.WithError(err).Warn("Can't archive group")
+			problem.Write(rw, r, problem.FromError(err))
+			return
+		}
+
+		// Archive group, keeping its members and history
+		if err := m.ArchiveGroup(gid); err != nil {
+			logger.WithError(err).Warn("Can't archive group")
+			problem.Write(rw, r, problem.FromError(err))
+			return
+		}
+
+		// Fetch archived group
+		g, err := m.FetchGroup(gid)
+		if err != nil {
+			logger.WithError(err).Warn("Can't fetch archived group")
+			problem.Write(rw, r, problem.FromError(err))
+			return
+		}
+
+		rw.Header().Set("ETag", groupETag(g))
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(&g)
+	}
+}
+
+// RestoreHandler manages requests for restoring archived groups.
+func RestoreHandler(m Manager) func(http.ResponseWriter, *http.Request) {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		m := m.WithContext(r.Context())
+		logger := logging.FromContext(r.Context())
+
+		// Get group ID from request path
+		gid, err := uuid.Parse(mux.Vars(r)["groupid"])
+		if err != nil {
+			logger.WithError(err).Error("Can't parse group ID as UUID")
+			problem.Write(rw, r, problem.New(http.StatusBadRequest, "invalid_id", "Group ID isn't a valid UUID"))
+			return
+		}
+
+		// Check user's role in the group
+		if _, err := authorize(m, r, gid, member.RoleOwner); err != nil {
+			logger.WithError(err).Warn("Can't restore group")
+			problem.Write(rw, r, problem.FromError(err))
+			return
+		}
+
+		// Restore group
+		g, err := m.RestoreGroup(gid)
+		if err != nil {
+			logger.WithError(err).Warn("Can't restore group")
+			problem.Write(rw, r, problem.FromError(err))
+			return
+		}
+
+		rw.Header().Set("ETag", groupETag(g))
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(&g)
+	}
 }
 
 // MembersHandler manages requests for adding new members.
 func MembersHandler(m Manager) func(http.ResponseWriter, *http.Request) {
 	return func(rw http.ResponseWriter, r *http.Request) {
-		logger := log.WithFields(log.Fields{
-			"uri":    r.URL,
-			"method": r.Method,
-		})
+		m := m.WithContext(r.Context())
+		logger := logging.FromContext(r.Context())
 
 		// Get group ID from request path
 		gid, err := uuid.Parse(mux.Vars(r)["groupid"])
 		if err != nil {
 			logger.WithError(err).Error("Can't parse group ID as UUID")
-			rw.WriteHeader(http.StatusBadRequest)
+			problem.Write(rw, r, problem.New(http.StatusBadRequest, "invalid_id", "Group ID isn't a valid UUID"))
 			return
 		}
 
@@ -192,32 +491,47 @@ func MembersHandler(m Manager) func(http.ResponseWriter, *http.Request) {
 		var mb member.Member
 		if err := json.NewDecoder(r.Body).Decode(&mb); err != nil {
 			logger.WithError(err).Error("Can't parse request body as member")
-			rw.WriteHeader(http.StatusBadRequest)
+			problem.Write(rw, r, problem.New(http.StatusBadRequest, "invalid_body", "Request body isn't a valid member"))
 			return
 		}
 
-		// Add member
-		if err := m.AddMember(gid, &mb); err != nil {
+		// Check user's role in the group
+		caller, err := authorize(m, r, gid, member.RoleAdmin)
+		if err != nil {
 			logger.WithError(err).Warn("Can't add member")
+			problem.Write(rw, r, problem.FromError(err))
+			return
+		}
 
-			if _, ok := err.(*NotFoundError); ok {
-				rw.WriteHeader(http.StatusNotFound)
-			} else if _, ok := err.(*AlreadyPresentError); ok {
-				rw.WriteHeader(http.StatusConflict)
-			} else {
-				rw.WriteHeader(http.StatusInternalServerError)
-			}
+		// Users can't grant roles above their own
+		if mb.Role != "" && (!mb.Role.Valid() || !caller.Role.Includes(mb.Role)) {
+			logger.WithField("role", mb.Role).Warn("Can't grant role to member")
+			problem.Write(rw, r, problem.New(http.StatusForbidden, "forbidden", "Can't grant a role above your own").With("role", mb.Role))
+			return
+		}
+		if mb.UserID != "" && mb.Role == "" {
+			mb.Role = member.RoleMember
+		}
 
+		// Add member
+		if err := m.AddMember(gid, &mb); err != nil {
+			logger.WithError(err).Warn("Can't add member")
+			problem.Write(rw, r, problem.FromError(err))
 			return
 		}
+		queueEvent(r.Context(), m, gid, webhook.MemberJoined, &mb)
 
+		rw.Header().Set("Location", "/groups/"+gid.String()+"/members/"+mb.ID.String())
 		rw.WriteHeader(http.StatusCreated)
+		json.NewEncoder(rw).Encode(&mb)
 	}
 }
 
-// MemberHandler manages requests for fetchinf, updating or deleting members.
+// MemberHandler manages requests for fetching, updating, patching or deleting
+// members.
 func MemberHandler(m Manager) func(http.ResponseWriter, *http.Request) {
 	return func(rw http.ResponseWriter, r *http.Request) {
+		m := m.WithContext(r.Context())
 		switch r.Method {
 		case "GET":
 			getMemberHandler(m, rw, r)
@@ -225,6 +539,9 @@ func MemberHandler(m Manager) func(http.ResponseWriter, *http.Request) {
 		case "PUT":
 			putMemberHandler(m, rw, r)
 			break
+		case "PATCH":
+			patchMemberHandler(m, rw, r)
+			break
 		case "DELETE":
 			deleteMemberHandler(m, rw, r)
 		}
@@ -232,16 +549,13 @@ func MemberHandler(m Manager) func(http.ResponseWriter, *http.Request) {
 }
 
 func getMemberHandler(m Manager, rw http.ResponseWriter, r *http.Request) {
-	logger := log.WithFields(log.Fields{
-		"uri":    r.URL,
-		"method": r.Method,
-	})
+	logger := logging.FromContext(r.Context())
 
 	// Get group ID from request path
 	gid, err := uuid.Parse(mux.Vars(r)["groupid"])
 	if err != nil {
 		logger.WithError(err).Error("Can't parse group ID as UUID")
-		rw.WriteHeader(http.StatusBadRequest)
+		problem.Write(rw, r, problem.New(http.StatusBadRequest, "invalid_id", "Group ID isn't a valid UUID"))
 		return
 	}
 
@@ -249,7 +563,14 @@ func getMemberHandler(m Manager, rw http.ResponseWriter, r *http.Request) {
 	mid, err := uuid.Parse(mux.Vars(r)["memberid"])
 	if err != nil {
 		logger.WithError(err).Error("Can't parse member ID as UUID")
-		rw.WriteHeader(http.StatusBadRequest)
+		problem.Write(rw, r, problem.New(http.StatusBadRequest, "invalid_id", "Member ID isn't a valid UUID"))
+		return
+	}
+
+	// Check user's role in the group
+	if _, err := authorize(m, r, gid, member.RoleViewer); err != nil {
+		logger.WithError(err).Warn("Can't fetch member")
+		problem.Write(rw, r, problem.FromError(err))
 		return
 	}
 
@@ -257,13 +578,15 @@ func getMemberHandler(m Manager, rw http.ResponseWriter, r *http.Request) {
 	mb, err := m.FetchMember(gid, mid)
 	if err != nil {
 		logger.WithError(err).Warn("Can't fetch member")
+		problem.Write(rw, r, problem.FromError(err))
+		return
+	}
 
-		if _, ok := err.(*NotFoundError); ok {
-			rw.WriteHeader(http.StatusNotFound)
-		} else {
-			rw.WriteHeader(http.StatusInternalServerError)
-		}
-
+	// Skip the body if the client has the current version
+	etag := memberETag(mb)
+	rw.Header().Set("ETag", etag)
+	if notModified(r, etag) {
+		rw.WriteHeader(http.StatusNotModified)
 		return
 	}
 
@@ -272,16 +595,13 @@ func getMemberHandler(m Manager, rw http.ResponseWriter, r *http.Request) {
 }
 
 func putMemberHandler(m Manager, rw http.ResponseWriter, r *http.Request) {
-	logger := log.WithFields(log.Fields{
-		"uri":    r.URL,
-		"method": r.Method,
-	})
+	logger := logging.FromContext(r.Context())
 
 	// Get group ID from request path
 	gid, err := uuid.Parse(mux.Vars(r)["groupid"])
 	if err != nil {
 		logger.WithError(err).Error("Can't parse group ID as UUID")
-		rw.WriteHeader(http.StatusBadRequest)
+		problem.Write(rw, r, problem.New(http.StatusBadRequest, "invalid_id", "Group ID isn't a valid UUID"))
 		return
 	}
 
@@ -289,7 +609,7 @@ func putMemberHandler(m Manager, rw http.ResponseWriter, r *http.Request) {
 	mid, err := uuid.Parse(mux.Vars(r)["memberid"])
 	if err != nil {
 		logger.WithError(err).Error("Can't parse member ID as UUID")
-		rw.WriteHeader(http.StatusBadRequest)
+		problem.Write(rw, r, problem.New(http.StatusBadRequest, "invalid_id", "Member ID isn't a valid UUID"))
 		return
 	}
 
@@ -297,7 +617,7 @@ func putMemberHandler(m Manager, rw http.ResponseWriter, r *http.Request) {
 	var mb member.Member
 	if err := json.NewDecoder(r.Body).Decode(&mb); err != nil {
 		logger.WithError(err).Error("Can't parse request body as member")
-		rw.WriteHeader(http.StatusBadRequest)
+		problem.Write(rw, r, problem.New(http.StatusBadRequest, "invalid_body", "Request body isn't a valid member"))
 		return
 	}
 
@@ -307,39 +627,126 @@ func putMemberHandler(m Manager, rw http.ResponseWriter, r *http.Request) {
 			"path": mid,
 			"body": mb.ID,
 		}).Error("Member IDs in path and body don't match")
-		rw.WriteHeader(http.StatusBadRequest)
+		problem.Write(rw, r, problem.New(http.StatusBadRequest, "id_mismatch", "Member IDs in path and body don't match").With("path", mid).With("body", mb.ID))
 		return
 	}
 
+	// Check user's role in the group, members can update themselves
+	caller, err := authorize(m, r, gid, member.RoleMember)
+	if err == nil && caller.ID != mid && !caller.Role.Includes(member.RoleAdmin) {
+		err = &ForbiddenError{"Only admins can update other members", gid, caller.UserID}
+	}
+	if err != nil {
+		logger.WithError(err).Warn("Can't update member")
+		problem.Write(rw, r, problem.FromError(err))
+		return
+	}
+
+	// Check the member didn't change since the client fetched it
+	if r.Header.Get("If-Match") != "" {
+		cur, err := m.FetchMember(gid, mid)
+		if err == nil {
+			err = checkIfMatch(r, memberETag(cur))
+		}
+		if err != nil {
+			logger.WithError(err).Warn("Can't update member")
+			problem.Write(rw, r, problem.FromError(err))
+			return
+		}
+		mb.Version = cur.Version
+	}
+
 	// Update member
 	if err := m.UpdateMember(gid, &mb); err != nil {
 		logger.WithError(err).Warn("Can't update member")
+		problem.Write(rw, r, problem.FromError(err))
+		return
+	}
 
-		if _, ok := err.(*NotFoundError); ok {
-			rw.WriteHeader(http.StatusNotFound)
-		} else if _, ok := err.(*AlreadyPresentError); ok {
-			rw.WriteHeader(http.StatusConflict)
-		} else {
-			rw.WriteHeader(http.StatusInternalServerError)
-		}
+	rw.Header().Set("ETag", memberETag(mb))
+	rw.WriteHeader(http.StatusOK)
+}
+
+func patchMemberHandler(m Manager, rw http.ResponseWriter, r *http.Request) {
+	logger := logging.FromContext(r.Context())
 
+	// Get group ID from request path
+	gid, err := uuid.Parse(mux.Vars(r)["groupid"])
+	if err != nil {
+		logger.WithError(err).Error("Can't parse group ID as UUID")
+		problem.Write(rw, r, problem.New(http.StatusBadRequest, "invalid_id", "Group ID isn't a valid UUID"))
 		return
 	}
 
+	// Get member ID from request path
+	mid, err := uuid.Parse(mux.Vars(r)["memberid"])
+	if err != nil {
+		logger.WithError(err).Error("Can't parse member ID as UUID")
+		problem.Write(rw, r, problem.New(http.StatusBadRequest, "invalid_id", "Member ID isn't a valid UUID"))
+		return
+	}
+
+	// Check user's role in the group, members can patch themselves
+	caller, err := authorize(m, r, gid, member.RoleMember)
+	if err == nil && caller.ID != mid && !caller.Role.Includes(member.RoleAdmin) {
+		err = &ForbiddenError{"Only admins can update other members", gid, caller.UserID}
+	}
+	if err != nil {
+		logger.WithError(err).Warn("Can't patch member")
+		problem.Write(rw, r, problem.FromError(err))
+		return
+	}
+
+	// Check the member didn't change since the client fetched it
+	cur, err := m.FetchMember(gid, mid)
+	if err == nil {
+		err = checkIfMatch(r, memberETag(cur))
+	}
+	if err != nil {
+		logger.WithError(err).Warn("Can't patch member")
+		problem.Write(rw, r, problem.FromError(err))
+		return
+	}
+
+	// Apply patch
+	var mb member.Member
+	changed, err := applyPatch(r, &cur, "Member", memberPatchFields, &mb)
+	if err != nil {
+		logger.WithError(err).Warn("Can't patch member")
+		problem.Write(rw, r, problem.FromError(err))
+		return
+	}
+
+	// Update member
+	if len(changed) > 0 {
+		mb.Version = cur.Version
+		if err := m.UpdateMember(gid, &mb); err != nil {
+			logger.WithError(err).Warn("Can't patch member")
+			problem.Write(rw, r, problem.FromError(err))
+			return
+		}
+
+		logger.WithFields(log.Fields{
+			"user_id":   r.Header.Get(UserHeader),
+			"group_id":  gid,
+			"member_id": mid,
+			"changed":   changed,
+		}).Info("Member patched")
+	}
+
+	rw.Header().Set("ETag", memberETag(mb))
 	rw.WriteHeader(http.StatusOK)
+	json.NewEncoder(rw).Encode(&mb)
 }
 
 func deleteMemberHandler(m Manager, rw http.ResponseWriter, r *http.Request) {
-	logger := log.WithFields(log.Fields{
-		"uri":    r.URL,
-		"method": r.Method,
-	})
+	logger := logging.FromContext(r.Context())
 
 	// Get group ID from request path
 	gid, err := uuid.Parse(mux.Vars(r)["groupid"])
 	if err != nil {
 		logger.WithError(err).Error("Can't parse group ID as UUID")
-		rw.WriteHeader(http.StatusBadRequest)
+		problem.Write(rw, r, problem.New(http.StatusBadRequest, "invalid_id", "Group ID isn't a valid UUID"))
 		return
 	}
 
@@ -347,24 +754,541 @@ func deleteMemberHandler(m Manager, rw http.ResponseWriter, r *http.Request) {
 	mid, err := uuid.Parse(mux.Vars(r)["memberid"])
 	if err != nil {
 		logger.WithError(err).Error("Can't parse member ID as UUID")
-		rw.WriteHeader(http.StatusBadRequest)
+		problem.Write(rw, r, problem.New(http.StatusBadRequest, "invalid_id", "Member ID isn't a valid UUID"))
+		return
+	}
+
+	// Check user's role in the group
+	caller, err := authorize(m, r, gid, member.RoleAdmin)
+	if err != nil {
+		logger.WithError(err).Warn("Can't delete member")
+		problem.Write(rw, r, problem.FromError(err))
+		return
+	}
+
+	// Members can only be removed by users with a higher role, and only if
+	// they didn't change since the client fetched them
+	cur, err := m.FetchMember(gid, mid)
+	if err == nil && cur.Role.Includes(caller.Role) {
+		err = &ForbiddenError{"Members with the same or a higher role can't be removed", gid, caller.UserID}
+	}
+	if err == nil && r.Header.Get("If-Match") != "" {
+		err = checkIfMatch(r, memberETag(cur))
+	}
+	if err != nil {
+		logger.WithError(err).Warn("Can't delete member")
+		problem.Write(rw, r, problem.FromError(err))
 		return
 	}
 
 	// Remove member
 	if err := m.RemoveMember(gid, mid); err != nil {
 		logger.WithError(err).Warn("Can't delete member")
+		problem.Write(rw, r, problem.FromError(err))
+		return
+	}
+
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// InviteTTL is the default lifetime of an invite.
+const InviteTTL = 7 * 24 * time.Hour
+
+// InvitesHandler manages requests for creating invites to a group.
+func InvitesHandler(m Manager, secret []byte) func(http.ResponseWriter, *http.Request) {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		m := m.WithContext(r.Context())
+		logger := logging.FromContext(r.Context())
+
+		// Get group ID from request path
+		gid, err := uuid.Parse(mux.Vars(r)["groupid"])
+		if err != nil {
+			logger.WithError(err).Error("Can't parse group ID as UUID")
+			problem.Write(rw, r, problem.New(http.StatusBadRequest, "invalid_id", "Group ID isn't a valid UUID"))
+			return
+		}
+
+		// Parse JSON
+		var i invite.Invite
+		if err := json.NewDecoder(r.Body).Decode(&i); err != nil {
+			logger.WithError(err).Error("Can't parse request body as invite")
+			problem.Write(rw, r, problem.New(http.StatusBadRequest, "invalid_body", "Request body isn't a valid invite"))
+			return
+		}
+
+		// Check user's role in the group
+		caller, err := authorize(m, r, gid, member.RoleAdmin)
+		if err != nil {
+			logger.WithError(err).Warn("Can't create invite")
+			problem.Write(rw, r, problem.FromError(err))
+			return
+		}
+
+		// Users can't grant roles above their own
+		if i.Role == "" {
+			i.Role = member.RoleMember
+		}
+		if !i.Role.Valid() || !caller.Role.Includes(i.Role) {
+			logger.WithField("role", i.Role).Warn("Can't grant role in invite")
+			problem.Write(rw, r, problem.New(http.StatusForbidden, "forbidden", "Can't grant a role above your own").With("role", i.Role))
+			return
+		}
+
+		// Fill server-side values
+		i.ID = uuid.New()
+		i.GroupID = gid
+		i.CreatedBy = caller.UserID
+		i.Uses = 0
+		i.Revoked = false
+		if i.ExpiresAt.IsZero() {
+			i.ExpiresAt = time.Now().Add(InviteTTL)
+		}
+
+		// Create invite
+		if err := m.CreateInvite(&i); err != nil {
+			logger.WithError(err).Warn("Can't create invite")
+			problem.Write(rw, r, problem.FromError(err))
+			return
+		}
+
+		i.Token = invite.Sign(i.ID, secret)
+
+		rw.Header().Set("Location", "/groups/"+gid.String()+"/invites/"+i.ID.String())
+		rw.WriteHeader(http.StatusCreated)
+		json.NewEncoder(rw).Encode(&i)
+	}
+}
+
+// InviteHandler manages requests for revoking invites.
+func InviteHandler(m Manager) func(http.ResponseWriter, *http.Request) {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		m := m.WithContext(r.Context())
+		logger := logging.FromContext(r.Context())
+
+		// Get group ID from request path
+		gid, err := uuid.Parse(mux.Vars(r)["groupid"])
+		if err != nil {
+			logger.WithError(err).Error("Can't parse group ID as UUID")
+			problem.Write(rw, r, problem.New(http.StatusBadRequest, "invalid_id", "Group ID isn't a valid UUID"))
+			return
+		}
+
+		// Get invite ID from request path
+		iid, err := uuid.Parse(mux.Vars(r)["inviteid"])
+		if err != nil {
+			logger.WithError(err).Error("Can't parse invite ID as UUID")
+			problem.Write(rw, r, problem.New(http.StatusBadRequest, "invalid_id", "Invite ID isn't a valid UUID"))
+			return
+		}
 
-		if _, ok := err.(*NotFoundError); ok {
-			rw.WriteHeader(http.StatusNotFound)
-		} else if _, ok := err.(*BalanceError); ok {
-			rw.WriteHeader(http.StatusConflict)
-		} else {
-			rw.WriteHeader(http.StatusInternalServerError)
+		// Check user's role in the group
+		if _, err := authorize(m, r, gid, member.RoleAdmin); err != nil {
+			logger.WithError(err).Warn("Can't revoke invite")
+			problem.Write(rw, r, problem.FromError(err))
+			return
 		}
 
+		// Revoke invite
+		if err := m.RevokeInvite(gid, iid); err != nil {
+			logger.WithError(err).Warn("Can't revoke invite")
+			problem.Write(rw, r, problem.FromError(err))
+			return
+		}
+
+		rw.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// RedeemHandler manages requests for joining a group with an invite token.
+func RedeemHandler(m Manager, secret []byte) func(http.ResponseWriter, *http.Request) {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		m := m.WithContext(r.Context())
+		logger := logging.FromContext(r.Context())
+
+		// Get user redeeming the invite
+		uid := r.Header.Get(UserHeader)
+		if uid == "" {
+			logger.Warn("No user in request")
+			problem.Write(rw, r, problem.New(http.StatusUnauthorized, "unauthorized", "No user in request"))
+			return
+		}
+		name := r.Header.Get(UserNameHeader)
+		if name == "" {
+			name = uid
+		}
+
+		// Verify token from request path
+		iid, err := invite.Verify(mux.Vars(r)["token"], secret)
+		if err != nil {
+			logger.WithError(err).Warn("Can't verify invite token")
+			problem.Write(rw, r, problem.New(http.StatusNotFound, "not_found", "No invite found for the token"))
+			return
+		}
+
+		// Redeem invite
+		mb, err := m.RedeemInvite(iid, uid, name)
+		if err != nil {
+			logger.WithError(err).Warn("Can't redeem invite")
+			problem.Write(rw, r, problem.FromError(err))
+			return
+		}
+		queueEvent(r.Context(), m, mb.GroupID, webhook.MemberJoined, &mb)
+
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(&mb)
+	}
+}
+
+// WebhooksHandler manages requests for listing or creating the webhooks of a
+// group.
+func WebhooksHandler(m Manager) func(http.ResponseWriter, *http.Request) {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		m := m.WithContext(r.Context())
+
+		switch r.Method {
+		case "GET":
+			listWebhooksHandler(m, rw, r)
+		case "POST":
+			createWebhookHandler(m, rw, r)
+		}
+	}
+}
+
+func listWebhooksHandler(m Manager, rw http.ResponseWriter, r *http.Request) {
+	logger := logging.FromContext(r.Context())
+
+	// Get group ID from request path
+	gid, err := uuid.Parse(mux.Vars(r)["groupid"])
+	if err != nil {
+		logger.WithError(err).Error("Can't parse group ID as UUID")
+		problem.Write(rw, r, problem.New(http.StatusBadRequest, "invalid_id", "Group ID isn't a valid UUID"))
 		return
 	}
 
-	rw.WriteHeader(http.StatusNoContent)
+	// Check user's role in the group
+	if _, err := authorize(m, r, gid, member.RoleAdmin); err != nil {
+		logger.WithError(err).Warn("Can't list webhooks")
+		problem.Write(rw, r, problem.FromError(err))
+		return
+	}
+
+	// List webhooks
+	hooks, err := m.ListWebhooks(gid)
+	if err != nil {
+		logger.WithError(err).Warn("Can't list webhooks")
+		problem.Write(rw, r, problem.FromError(err))
+		return
+	}
+
+	rw.WriteHeader(http.StatusOK)
+	json.NewEncoder(rw).Encode(&hooks)
+}
+
+func createWebhookHandler(m Manager, rw http.ResponseWriter, r *http.Request) {
+	logger := logging.FromContext(r.Context())
+
+	// Get group ID from request path
+	gid, err := uuid.Parse(mux.Vars(r)["groupid"])
+	if err != nil {
+		logger.WithError(err).Error("Can't parse group ID as UUID")
+		problem.Write(rw, r, problem.New(http.StatusBadRequest, "invalid_id", "Group ID isn't a valid UUID"))
+		return
+	}
+
+	// Parse JSON
+	var w webhook.Webhook
+	if err := json.NewDecoder(r.Body).Decode(&w); err != nil {
+		logger.WithError(err).Error("Can't parse request body as webhook")
+		problem.Write(rw, r, problem.New(http.StatusBadRequest, "invalid_body", "Request body isn't a valid webhook"))
+		return
+	}
+
+	// Check the URL can receive deliveries
+	if u, err := url.Parse(w.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		logger.WithField("url", w.URL).Warn("Webhook URL isn't valid")
+		problem.Write(rw, r, problem.New(http.StatusBadRequest, "invalid_url", webhook.ErrInvalidURL.Error()).With("url", w.URL))
+		return
+	}
+
+	// Check the filter only has known events
+	if w.Events != "" {
+		for _, e := range strings.Split(w.Events, ";") {
+			if !webhook.Valid(e) {
+				logger.WithField("event", e).Warn("Unknown webhook event")
+				problem.Write(rw, r, problem.New(http.StatusBadRequest, "invalid_event", "Unknown webhook event").With("event", e))
+				return
+			}
+		}
+	}
+
+	// Check user's role in the group
+	caller, err := authorize(m, r, gid, member.RoleAdmin)
+	if err != nil {
+		logger.WithError(err).Warn("Can't create webhook")
+		problem.Write(rw, r, problem.FromError(err))
+		return
+	}
+
+	// Check the URL's host is public, once the caller is known to be an admin
+	if err := webhook.CheckURL(r.Context(), w.URL); err != nil {
+		logger.WithError(err).WithField("url", w.URL).Warn("Webhook URL can't receive deliveries")
+		problem.Write(rw, r, problem.New(http.StatusBadRequest, "invalid_url", err.Error()).With("url", w.URL))
+		return
+	}
+
+	// Fill server-side values
+	w.ID = uuid.New()
+	w.GroupID = gid
+	w.CreatedBy = caller.UserID
+	if w.Secret == "" {
+		if w.Secret, err = webhook.NewSecret(); err != nil {
+			logger.WithError(err).Error("Can't generate webhook secret")
+			problem.Write(rw, r, problem.FromError(err))
+			return
+		}
+	}
+
+	// Create webhook
+	if err := m.CreateWebhook(&w); err != nil {
+		logger.WithError(err).Warn("Can't create webhook")
+		problem.Write(rw, r, problem.FromError(err))
+		return
+	}
+
+	logger.WithFields(log.Fields{
+		"user_id":    caller.UserID,
+		"group_id":   gid,
+		"webhook_id": w.ID,
+		"events":     w.Events,
+	}).Info("Webhook created")
+
+	rw.Header().Set("Location", "/groups/"+gid.String()+"/webhooks/"+w.ID.String())
+	rw.WriteHeader(http.StatusCreated)
+	json.NewEncoder(rw).Encode(&w)
+}
+
+// WebhookHandler manages requests for deleting webhooks.
+func WebhookHandler(m Manager) func(http.ResponseWriter, *http.Request) {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		m := m.WithContext(r.Context())
+		logger := logging.FromContext(r.Context())
+
+		gid, wid, ok := webhookIDs(rw, r)
+		if !ok {
+			return
+		}
+
+		// Check user's role in the group
+		if _, err := authorize(m, r, gid, member.RoleAdmin); err != nil {
+			logger.WithError(err).Warn("Can't delete webhook")
+			problem.Write(rw, r, problem.FromError(err))
+			return
+		}
+
+		// Delete webhook
+		if err := m.DeleteWebhook(gid, wid); err != nil {
+			logger.WithError(err).Warn("Can't delete webhook")
+			problem.Write(rw, r, problem.FromError(err))
+			return
+		}
+
+		rw.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// DeliveriesHandler manages requests for the delivery log of a webhook.
+func DeliveriesHandler(m Manager) func(http.ResponseWriter, *http.Request) {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		m := m.WithContext(r.Context())
+		logger := logging.FromContext(r.Context())
+
+		gid, wid, ok := webhookIDs(rw, r)
+		if !ok {
+			return
+		}
+
+		// Check user's role in the group
+		if _, err := authorize(m, r, gid, member.RoleAdmin); err != nil {
+			logger.WithError(err).Warn("Can't list deliveries")
+			problem.Write(rw, r, problem.FromError(err))
+			return
+		}
+
+		// List deliveries
+		deliveries, err := m.ListDeliveries(gid, wid)
+		if err != nil {
+			logger.WithError(err).Warn("Can't list deliveries")
+			problem.Write(rw, r, problem.FromError(err))
+			return
+		}
+
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(&deliveries)
+	}
+}
+
+// RedeliverHandler manages requests for delivering an event again.
+func RedeliverHandler(m Manager) func(http.ResponseWriter, *http.Request) {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		m := m.WithContext(r.Context())
+		logger := logging.FromContext(r.Context())
+
+		gid, wid, ok := webhookIDs(rw, r)
+		if !ok {
+			return
+		}
+
+		// Get delivery ID from request path
+		did, err := uuid.Parse(mux.Vars(r)["deliveryid"])
+		if err != nil {
+			logger.WithError(err).Error("Can't parse delivery ID as UUID")
+			problem.Write(rw, r, problem.New(http.StatusBadRequest, "invalid_id", "Delivery ID isn't a valid UUID"))
+			return
+		}
+
+		// Check user's role in the group
+		if _, err := authorize(m, r, gid, member.RoleAdmin); err != nil {
+			logger.WithError(err).Warn("Can't redeliver")
+			problem.Write(rw, r, problem.FromError(err))
+			return
+		}
+
+		// Queue a new delivery
+		d, err := m.Redeliver(gid, wid, did)
+		if err != nil {
+			logger.WithError(err).Warn("Can't redeliver")
+			problem.Write(rw, r, problem.FromError(err))
+			return
+		}
+
+		rw.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(rw).Encode(&d)
+	}
+}
+
+// PreferencesHandler for the notification preferences of a member, which
+// only the member can see and change. Changes are published to events for
+// the notifier.
+func PreferencesHandler(m Manager, events publisher.Publisher) func(http.ResponseWriter, *http.Request) {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		m := m.WithContext(r.Context())
+		logger := logging.FromContext(r.Context())
+
+		// Get group ID from request path
+		gid, err := uuid.Parse(mux.Vars(r)["groupid"])
+		if err != nil {
+			logger.WithError(err).Error("Can't parse group ID as UUID")
+			problem.Write(rw, r, problem.New(http.StatusBadRequest, "invalid_id", "Group ID isn't a valid UUID"))
+			return
+		}
+
+		// Get member ID from request path
+		mid, err := uuid.Parse(mux.Vars(r)["memberid"])
+		if err != nil {
+			logger.WithError(err).Error("Can't parse member ID as UUID")
+			problem.Write(rw, r, problem.New(http.StatusBadRequest, "invalid_id", "Member ID isn't a valid UUID"))
+			return
+		}
+
+		// Check the user is the member
+		caller, err := authorize(m, r, gid, member.RoleViewer)
+		if err == nil && caller.ID != mid {
+			err = &ForbiddenError{"Members can only manage their own preferences", gid, caller.UserID}
+		}
+		if err != nil {
+			logger.WithError(err).Warn("Can't access preferences")
+			problem.Write(rw, r, problem.FromError(err))
+			return
+		}
+
+		if r.Method == "GET" {
+			p, err := m.FetchPreferences(gid, mid)
+			if err != nil {
+				logger.WithError(err).Warn("Can't fetch preferences")
+				problem.Write(rw, r, problem.FromError(err))
+				return
+			}
+
+			rw.WriteHeader(http.StatusOK)
+			json.NewEncoder(rw).Encode(&p)
+			return
+		}
+
+		// Parse JSON
+		var p member.Preferences
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			logger.WithError(err).Error("Can't parse request body as preferences")
+			problem.Write(rw, r, problem.New(http.StatusBadRequest, "invalid_body", "Request body isn't valid preferences"))
+			return
+		}
+		p.MemberID = mid
+		p.GroupID = gid
+
+		// Check there's an address to send the emails asked for
+		if p.Email != "" {
+			if a, err := mail.ParseAddress(p.Email); err != nil || a.Address != p.Email {
+				logger.WithField("email", p.Email).Warn("Email address isn't valid")
+				problem.Write(rw, r, problem.New(http.StatusBadRequest, "invalid_email", "Email address isn't valid").With("email", p.Email))
+				return
+			}
+		} else if p.ExpenseAdded || p.OwedThreshold > 0 || p.WeeklyDigest {
+			logger.Warn("Notifications without email address")
+			problem.Write(rw, r, problem.New(http.StatusBadRequest, "missing_email", "Notifications need an email address"))
+			return
+		}
+		if p.OwedThreshold < 0 {
+			logger.WithField("owed_threshold", p.OwedThreshold).Warn("Negative owed threshold")
+			problem.Write(rw, r, problem.New(http.StatusBadRequest, "invalid_threshold", "Owed threshold can't be negative"))
+			return
+		}
+
+		// Update preferences
+		if err := m.UpdatePreferences(&p); err != nil {
+			logger.WithError(err).Warn("Can't update preferences")
+			problem.Write(rw, r, problem.FromError(err))
+			return
+		}
+
+		// Publish the change, so retrying the request publishes it again if
+		// this fails
+		body, _ := json.Marshal(&p)
+		if err := events.Publish(r.Context(), "preferences-changed", body); err != nil {
+			logger.WithError(err).Warn("Can't publish preferences event")
+			problem.Write(rw, r, problem.New(http.StatusInternalServerError, "publish_failed", "Can't queue the request"))
+			return
+		}
+
+		logger.WithFields(log.Fields{
+			"user_id":   r.Header.Get(UserHeader),
+			"group_id":  gid,
+			"member_id": mid,
+		}).Info("Preferences updated")
+
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(&p)
+	}
+}
+
+// webhookIDs parses the group and webhook IDs in the request path, writing
+// the problem if one isn't valid.
+func webhookIDs(rw http.ResponseWriter, r *http.Request) (uuid.UUID, uuid.UUID, bool) {
+	logger := logging.FromContext(r.Context())
+
+	// Get group ID from request path
+	gid, err := uuid.Parse(mux.Vars(r)["groupid"])
+	if err != nil {
+		logger.WithError(err).Error("Can't parse group ID as UUID")
+		problem.Write(rw, r, problem.New(http.StatusBadRequest, "invalid_id", "Group ID isn't a valid UUID"))
+		return gid, uuid.Nil, false
+	}
+
+	// Get webhook ID from request path
+	wid, err := uuid.Parse(mux.Vars(r)["webhookid"])
+	if err != nil {
+		logger.WithError(err).Error("Can't parse webhook ID as UUID")
+		problem.Write(rw, r, problem.New(http.StatusBadRequest, "invalid_id", "Webhook ID isn't a valid UUID"))
+		return gid, wid, false
+	}
+
+	return gid, wid, true
 }
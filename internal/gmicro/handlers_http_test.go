@@ -9,8 +9,13 @@ import (
 	"testing"
 
 	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/varrrro/pay-up/internal/gmicro"
 	"github.com/varrrro/pay-up/internal/gmicro/group"
+	"github.com/varrrro/pay-up/internal/gmicro/invite"
 	"github.com/varrrro/pay-up/internal/gmicro/member"
+	"github.com/varrrro/pay-up/internal/patch"
+	"github.com/varrrro/pay-up/internal/problem"
 )
 
 func TestStatusHandler(t *testing.T) {
@@ -49,7 +54,8 @@ func TestStatusHandler(t *testing.T) {
 
 func TestGroupsHandler(t *testing.T) {
 	g := group.Group{ID: uuid.New(), Name: "Test"}
-	body, _ := json.Marshal(&g)
+	body := requestBody(&g)
+	body2 := requestBody(&group.Group{Name: "Generated"})
 
 	cases := []struct {
 		method     string
@@ -57,6 +63,8 @@ func TestGroupsHandler(t *testing.T) {
 		statusCode int
 	}{
 		{"POST", body, http.StatusCreated},
+		{"POST", body, http.StatusConflict},
+		{"POST", body2, http.StatusCreated},
 		{"POST", []byte(""), http.StatusBadRequest},
 	}
 
@@ -67,6 +75,7 @@ func TestGroupsHandler(t *testing.T) {
 			if err != nil {
 				t.Errorf("Can't create request [Error]: %v", err)
 			}
+			req.Header.Set("X-User-ID", owner)
 
 			// Serve test request
 			rec := httptest.NewRecorder()
@@ -75,25 +84,112 @@ func TestGroupsHandler(t *testing.T) {
 			defer res.Body.Close()
 
 			// Check response Content-Type and status code
-			if res.Header.Get("Content-Type") != "application/json" {
-				t.Errorf("Wrong content type [Expected]: %s [Actual]: %s", "application/json", res.Header.Get("Content-Type"))
+			ct := "application/json"
+			if tc.statusCode >= http.StatusBadRequest {
+				ct = problem.ContentType
+			}
+			if res.Header.Get("Content-Type") != ct {
+				t.Errorf("Wrong content type [Expected]: %s [Actual]: %s", ct, res.Header.Get("Content-Type"))
 			} else if res.StatusCode != tc.statusCode {
 				t.Errorf("Wrong status code [Expected]: %d [Actual]: %d", http.StatusOK, res.StatusCode)
 			}
+
+			// Check created group
+			if tc.statusCode == http.StatusCreated {
+				var g group.Group
+				if err := json.NewDecoder(res.Body).Decode(&g); err != nil {
+					t.Errorf("Can't decode response body [Error]: %v", err)
+				} else if g.ID == uuid.Nil {
+					t.Error("Group ID wasn't generated")
+				} else if res.Header.Get("Location") != "/groups/"+g.ID.String() {
+					t.Errorf("Wrong location [Expected]: %s [Actual]: %s", "/groups/"+g.ID.String(), res.Header.Get("Location"))
+				} else if len(g.Members) != 1 || g.Members[0].UserID != owner {
+					t.Error("Owner wasn't added to the group")
+				}
+			}
+		})
+	}
+
+	clearDB()
+}
+
+func TestListGroupsHandler(t *testing.T) {
+	for _, n := range []string{"First", "Second", "Third"} {
+		g := group.Group{Name: n}
+		gm.CreateGroup(&g, nil)
+		addOwner(g.ID)
+	}
+
+	cases := []struct {
+		user       string
+		query      string
+		statusCode int
+		names      []string
+	}{
+		{owner, "?limit=2", http.StatusOK, []string{"First", "Second"}},
+		{owner, "?sort=-name&q=ir", http.StatusOK, []string{"Third", "First"}},
+		{"user2", "", http.StatusOK, []string{}},
+		{"", "", http.StatusUnauthorized, nil},
+		{owner, "?sort=members", http.StatusBadRequest, nil},
+		{owner, "?limit=0", http.StatusBadRequest, nil},
+		{owner, "?cursor=invalid", http.StatusBadRequest, nil},
+	}
+
+	for _, tc := range cases {
+		t.Run(fmt.Sprintf("%s %s %d", tc.user, tc.query, tc.statusCode), func(t *testing.T) {
+			// Create request
+			req, err := http.NewRequest("GET", "/groups"+tc.query, nil)
+			if err != nil {
+				t.Errorf("Can't create request [Error]: %v", err)
+			}
+			req.Header.Set("X-User-ID", tc.user)
+
+			// Serve test request
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+			res := rec.Result() // get response
+			defer res.Body.Close()
+
+			if res.StatusCode != tc.statusCode {
+				t.Fatalf("Wrong status code [Expected]: %d [Actual]: %d", tc.statusCode, res.StatusCode)
+			}
+			if tc.statusCode != http.StatusOK {
+				return
+			}
+
+			// Check groups listed
+			var page gmicro.GroupPage
+			if err := json.NewDecoder(res.Body).Decode(&page); err != nil {
+				t.Fatalf("Can't decode response body [Error]: %v", err)
+			}
+			names := []string{}
+			for _, g := range page.Groups {
+				names = append(names, g.Name)
+			}
+			if fmt.Sprint(names) != fmt.Sprint(tc.names) {
+				t.Errorf("Wrong groups [Expected]: %v [Actual]: %v", tc.names, names)
+			} else if (len(tc.names) == 2 && tc.query == "?limit=2") != (page.NextCursor != "") {
+				t.Errorf("Wrong next cursor: %q", page.NextCursor)
+			}
 		})
 	}
+
+	clearDB()
 }
 
 func TestGroupHandler(t *testing.T) {
 	g := group.Group{ID: uuid.New(), Name: "Test"}
-	gm.CreateGroup(&g)
+	gm.CreateGroup(&g, nil)
+	addOwner(g.ID)
 
-	g2 := group.Group{ID: g.ID, Name: "Updated"}
-	body1, _ := json.Marshal(&g2)
+	g2 := group.Group{ID: g.ID, Name: "Updated", Version: g.Version}
+	body1 := requestBody(&g2)
+	stale := requestBody(&group.Group{ID: g.ID, Name: "Stale", Version: g.Version})
+	unversioned := requestBody(&group.Group{ID: g.ID, Name: "Unversioned"})
 
 	g3 := group.Group{ID: uuid.New(), Name: "Fail"}
-	g4 := group.Group{ID: g3.ID, Name: "UpdatedFail"}
-	body2, _ := json.Marshal(&g4)
+	g4 := group.Group{ID: g3.ID, Name: "UpdatedFail", Version: 1}
+	body2 := requestBody(&g4)
 
 	cases := []struct {
 		method     string
@@ -107,12 +203,14 @@ func TestGroupHandler(t *testing.T) {
 		{"GET", uuid.New().String(), nil, http.StatusNotFound, nil},
 
 		{"PUT", g.ID.String(), body1, http.StatusOK, nil},
+		{"PUT", g.ID.String(), stale, http.StatusConflict, nil},
+		{"PUT", g.ID.String(), unversioned, http.StatusPreconditionRequired, nil},
 		{"PUT", "test", body1, http.StatusBadRequest, nil},
 		{"PUT", g.ID.String(), []byte(""), http.StatusBadRequest, nil},
 		{"PUT", uuid.New().String(), body1, http.StatusBadRequest, nil},
 		{"PUT", g3.ID.String(), body2, http.StatusNotFound, nil},
 
-		{"DELETE", g.ID.String(), nil, http.StatusNoContent, nil},
+		{"DELETE", g.ID.String(), nil, http.StatusAccepted, nil},
 		{"DELETE", "test", nil, http.StatusBadRequest, nil},
 		{"DELETE", g3.ID.String(), nil, http.StatusNotFound, nil},
 	}
@@ -130,6 +228,7 @@ func TestGroupHandler(t *testing.T) {
 			if err != nil {
 				t.Errorf("Can't create request [Error]: %v", err)
 			}
+			req.Header.Set("X-User-ID", owner)
 
 			// Serve test request
 			rec := httptest.NewRecorder()
@@ -138,8 +237,12 @@ func TestGroupHandler(t *testing.T) {
 			defer res.Body.Close()
 
 			// Check response Content-Type and status code
-			if res.Header.Get("Content-Type") != "application/json" {
-				t.Errorf("Wrong content type [Expected]: %s [Actual]: %s", "application/json", res.Header.Get("Content-Type"))
+			ct := "application/json"
+			if tc.statusCode >= http.StatusBadRequest {
+				ct = problem.ContentType
+			}
+			if res.Header.Get("Content-Type") != ct {
+				t.Errorf("Wrong content type [Expected]: %s [Actual]: %s", ct, res.Header.Get("Content-Type"))
 			} else if res.StatusCode != tc.statusCode {
 				t.Errorf("Wrong status code [Expected]: %d [Actual]: %d", http.StatusOK, res.StatusCode)
 			}
@@ -165,12 +268,138 @@ func TestGroupHandler(t *testing.T) {
 	}
 }
 
+func TestRestoreHandler(t *testing.T) {
+	g := group.Group{ID: uuid.New(), Name: "Test"}
+	gm.CreateGroup(&g, nil)
+	addOwner(g.ID)
+
+	path := "/groups/" + g.ID.String()
+	body := requestBody(&group.Group{ID: g.ID, Name: "Updated", Version: g.Version + 2}) // archived and restored
+
+	cases := []struct {
+		method     string
+		path       string
+		reqBody    []byte
+		statusCode int
+		code       string
+	}{
+		{"POST", path + "/restore", nil, http.StatusConflict, "group_not_archived"},
+		{"POST", path + "/archive", nil, http.StatusOK, ""},
+		{"PUT", path, body, http.StatusConflict, "group_archived"},
+		{"POST", path + "/restore", nil, http.StatusOK, ""},
+		{"PUT", path, body, http.StatusOK, ""},
+	}
+
+	for _, tc := range cases {
+		t.Run(fmt.Sprintf("%s %s %d", tc.method, tc.path, tc.statusCode), func(t *testing.T) {
+			// Create request
+			req, err := http.NewRequest(tc.method, tc.path, bytes.NewBuffer(tc.reqBody))
+			if err != nil {
+				t.Errorf("Can't create request [Error]: %v", err)
+			}
+			req.Header.Set("X-User-ID", owner)
+
+			// Serve test request
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+			res := rec.Result() // get response
+			defer res.Body.Close()
+
+			if res.StatusCode != tc.statusCode {
+				t.Fatalf("Wrong status code [Expected]: %d [Actual]: %d", tc.statusCode, res.StatusCode)
+			}
+
+			// Check problem code
+			if tc.code != "" {
+				var p problem.Problem
+				if err := json.NewDecoder(res.Body).Decode(&p); err != nil {
+					t.Fatalf("Can't decode response body [Error]: %v", err)
+				} else if p.Code != tc.code {
+					t.Errorf("Wrong error code [Expected]: %s [Actual]: %s", tc.code, p.Code)
+				}
+			}
+		})
+	}
+
+	clearDB()
+}
+
+func TestDeleteGroupHandler(t *testing.T) {
+	g := group.Group{ID: uuid.New(), Name: "Test"}
+	gm.CreateGroup(&g, nil)
+	addOwner(g.ID)
+	addMember(g.ID, &member.Member{ID: uuid.New(), Name: "Test", Balance: 10})
+
+	path := "/groups/" + g.ID.String()
+
+	cases := []struct {
+		path       string
+		fail       bool
+		statusCode int
+		code       string
+	}{
+		{path + "?force=test", false, http.StatusBadRequest, "validation_failed"},
+		{path, false, http.StatusConflict, "non_zero_balance"},
+		{path + "?force=true", true, http.StatusInternalServerError, "publish_failed"},
+		{path + "?force=true", false, http.StatusAccepted, ""},
+	}
+
+	for _, tc := range cases {
+		t.Run(fmt.Sprintf("DELETE %s %d", tc.path, tc.statusCode), func(t *testing.T) {
+			published = []string{}
+			if tc.fail {
+				published = nil
+			}
+
+			// Create request
+			req, err := http.NewRequest("DELETE", tc.path, nil)
+			if err != nil {
+				t.Errorf("Can't create request [Error]: %v", err)
+			}
+			req.Header.Set("X-User-ID", owner)
+
+			// Serve test request
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+			res := rec.Result() // get response
+			defer res.Body.Close()
+
+			if res.StatusCode != tc.statusCode {
+				t.Fatalf("Wrong status code [Expected]: %d [Actual]: %d", tc.statusCode, res.StatusCode)
+			}
+
+			// Check problem code
+			if tc.code != "" {
+				var p problem.Problem
+				if err := json.NewDecoder(res.Body).Decode(&p); err != nil {
+					t.Fatalf("Can't decode response body [Error]: %v", err)
+				} else if p.Code != tc.code {
+					t.Errorf("Wrong error code [Expected]: %s [Actual]: %s", tc.code, p.Code)
+				}
+			}
+
+			// Deletion is only in progress once it's been queued
+			g2, _ := gm.FetchGroup(g.ID)
+			if deleting := tc.statusCode == http.StatusAccepted; g2.Deleting != deleting {
+				t.Errorf("Wrong deleting flag [Expected]: %v [Actual]: %v", deleting, g2.Deleting)
+			}
+			if tc.statusCode == http.StatusAccepted && (len(published) != 1 || published[0] != "delete-group") {
+				t.Errorf("Wrong messages published [Expected]: %v [Actual]: %v", []string{"delete-group"}, published)
+			}
+		})
+	}
+
+	published = []string{}
+	clearDB()
+}
+
 func TestMembersHandler(t *testing.T) {
 	g := group.Group{ID: uuid.New(), Name: "Test"}
-	gm.CreateGroup(&g)
+	gm.CreateGroup(&g, nil)
+	addOwner(g.ID)
 
 	m := member.Member{ID: uuid.New(), Name: "Test"}
-	body, _ := json.Marshal(&m)
+	body := requestBody(&m)
 
 	cases := []struct {
 		method     string
@@ -183,6 +412,7 @@ func TestMembersHandler(t *testing.T) {
 		{"POST", g.ID.String(), []byte(""), http.StatusBadRequest},
 		{"POST", uuid.New().String(), body, http.StatusNotFound},
 		{"POST", g.ID.String(), body, http.StatusConflict},
+		{"POST", g.ID.String(), []byte(`{"name":"Rich","balance":1e6}`), http.StatusBadRequest},
 	}
 
 	for _, tc := range cases {
@@ -192,6 +422,7 @@ func TestMembersHandler(t *testing.T) {
 			if err != nil {
 				t.Errorf("Can't create request [Error]: %v", err)
 			}
+			req.Header.Set("X-User-ID", owner)
 
 			// Serve test request
 			rec := httptest.NewRecorder()
@@ -200,27 +431,46 @@ func TestMembersHandler(t *testing.T) {
 			defer res.Body.Close()
 
 			// Check response Content-Type and status code
-			if res.Header.Get("Content-Type") != "application/json" {
-				t.Errorf("Wrong content type [Expected]: %s [Actual]: %s", "application/json", res.Header.Get("Content-Type"))
+			ct := "application/json"
+			if tc.statusCode >= http.StatusBadRequest {
+				ct = problem.ContentType
+			}
+			if res.Header.Get("Content-Type") != ct {
+				t.Errorf("Wrong content type [Expected]: %s [Actual]: %s", ct, res.Header.Get("Content-Type"))
 			} else if res.StatusCode != tc.statusCode {
 				t.Errorf("Wrong status code [Expected]: %d [Actual]: %d", http.StatusOK, res.StatusCode)
 			}
+
+			// Check created member
+			if tc.statusCode == http.StatusCreated {
+				var mb member.Member
+				if err := json.NewDecoder(res.Body).Decode(&mb); err != nil {
+					t.Errorf("Can't decode response body [Error]: %v", err)
+				} else if loc := "/groups/" + tc.gid + "/members/" + mb.ID.String(); res.Header.Get("Location") != loc {
+					t.Errorf("Wrong location [Expected]: %s [Actual]: %s", loc, res.Header.Get("Location"))
+				} else if mb.GroupID.String() != tc.gid {
+					t.Errorf("Wrong group ID [Expected]: %s [Actual]: %v", tc.gid, mb.GroupID)
+				}
+			}
 		})
 	}
 }
 
 func TestMemberHandler(t *testing.T) {
 	g := group.Group{ID: uuid.New(), Name: "Test"}
-	gm.CreateGroup(&g)
+	gm.CreateGroup(&g, nil)
+	addOwner(g.ID)
 
 	m := member.Member{ID: uuid.New(), Name: "Test"}
 	gm.AddMember(g.ID, &m)
 
 	m2 := member.Member{ID: m.ID, Name: "Updated"}
-	body, _ := json.Marshal(&m2)
+	body := requestBody(&m2)
 
 	m3 := member.Member{ID: uuid.New(), Name: "Balance", Balance: 23.3}
-	gm.AddMember(g.ID, &m3)
+	addMember(g.ID, &m3)
+	cbody := requestBody(&member.Member{ID: m.ID, Name: m3.Name})
+	rbody := requestBody(&member.Member{ID: m.ID, Name: "Promoted", Role: member.RoleOwner})
 
 	cases := []struct {
 		method     string
@@ -241,7 +491,9 @@ func TestMemberHandler(t *testing.T) {
 		{"PUT", g.ID.String(), "test", body, http.StatusBadRequest, nil},
 		{"PUT", g.ID.String(), uuid.New().String(), body, http.StatusBadRequest, nil},
 		{"PUT", uuid.New().String(), m.ID.String(), body, http.StatusNotFound, nil},
-		{"PUT", g.ID.String(), m.ID.String(), body, http.StatusConflict, nil},
+		{"PUT", g.ID.String(), m.ID.String(), body, http.StatusOK, nil},
+		{"PUT", g.ID.String(), m.ID.String(), cbody, http.StatusConflict, nil},
+		{"PUT", g.ID.String(), m.ID.String(), rbody, http.StatusBadRequest, nil},
 
 		{"DELETE", g.ID.String(), m.ID.String(), nil, http.StatusNoContent, nil},
 		{"DELETE", "test", m.ID.String(), nil, http.StatusBadRequest, nil},
@@ -263,6 +515,7 @@ func TestMemberHandler(t *testing.T) {
 			if err != nil {
 				t.Errorf("Can't create request [Error]: %v", err)
 			}
+			req.Header.Set("X-User-ID", owner)
 
 			// Serve test request
 			rec := httptest.NewRecorder()
@@ -271,8 +524,12 @@ func TestMemberHandler(t *testing.T) {
 			defer res.Body.Close()
 
 			// Check response Content-Type and status code
-			if res.Header.Get("Content-Type") != "application/json" {
-				t.Errorf("Wrong content type [Expected]: %s [Actual]: %s", "application/json", res.Header.Get("Content-Type"))
+			ct := "application/json"
+			if tc.statusCode >= http.StatusBadRequest {
+				ct = problem.ContentType
+			}
+			if res.Header.Get("Content-Type") != ct {
+				t.Errorf("Wrong content type [Expected]: %s [Actual]: %s", ct, res.Header.Get("Content-Type"))
 			} else if res.StatusCode != tc.statusCode {
 				t.Errorf("Wrong status code [Expected]: %d [Actual]: %d", http.StatusOK, res.StatusCode)
 			}
@@ -297,3 +554,474 @@ func TestMemberHandler(t *testing.T) {
 		})
 	}
 }
+
+func TestConditionalRequests(t *testing.T) {
+	g := group.Group{ID: uuid.New(), Name: "Test"}
+	gm.CreateGroup(&g, nil)
+	addOwner(g.ID)
+
+	m := member.Member{ID: uuid.New(), Name: "Test"}
+	gm.AddMember(g.ID, &m)
+
+	gpath := "/groups/" + g.ID.String()
+	mpath := gpath + "/members/" + m.ID.String()
+	gbody := requestBody(&group.Group{ID: g.ID, Name: "Updated", Version: g.Version})
+	mbody := requestBody(&member.Member{ID: m.ID, Name: "Updated"})
+
+	send := func(method, path string, body []byte, header, etag string) *http.Response {
+		req, err := http.NewRequest(method, path, bytes.NewBuffer(body))
+		if err != nil {
+			t.Fatalf("Can't create request [Error]: %v", err)
+		}
+		req.Header.Set("X-User-ID", owner)
+		if header != "" {
+			req.Header.Set(header, etag)
+		}
+
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec.Result()
+	}
+
+	check := func(res *http.Response, status int) {
+		t.Helper()
+		if res.StatusCode != status {
+			t.Errorf("Wrong status code [Expected]: %d [Actual]: %d", status, res.StatusCode)
+		}
+	}
+
+	// Groups
+	res := send("GET", gpath, nil, "", "")
+	check(res, http.StatusOK)
+	etag := res.Header.Get("ETag")
+	if etag == "" {
+		t.Fatal("No ETag in response")
+	}
+
+	check(send("GET", gpath, nil, "If-None-Match", etag), http.StatusNotModified)
+	check(send("GET", gpath, nil, "If-None-Match", `"stale"`), http.StatusOK)
+	check(send("PUT", gpath, gbody, "If-Match", `"stale"`), http.StatusPreconditionFailed)
+
+	res = send("PUT", gpath, gbody, "If-Match", etag)
+	check(res, http.StatusOK)
+	updated := res.Header.Get("ETag")
+	if updated == etag {
+		t.Error("ETag didn't change after update")
+	}
+
+	check(send("GET", gpath, nil, "If-None-Match", updated), http.StatusNotModified)
+
+	// Members
+	res = send("GET", mpath, nil, "", "")
+	check(res, http.StatusOK)
+	metag := res.Header.Get("ETag")
+
+	check(send("GET", mpath, nil, "If-None-Match", metag), http.StatusNotModified)
+	check(send("PUT", mpath, mbody, "If-Match", `"0"`), http.StatusPreconditionFailed)
+	check(send("PUT", mpath, mbody, "If-Match", metag), http.StatusOK)
+	check(send("DELETE", mpath, nil, "If-Match", metag), http.StatusPreconditionFailed)
+
+	// Changing a member changes the group's ETag
+	check(send("GET", gpath, nil, "If-None-Match", updated), http.StatusOK)
+	check(send("DELETE", gpath, nil, "If-Match", updated), http.StatusPreconditionFailed)
+	check(send("DELETE", gpath, nil, "If-Match", "*"), http.StatusAccepted)
+
+	clearDB()
+}
+
+func TestPatchHandlers(t *testing.T) {
+	g := group.Group{ID: uuid.New(), Name: "Test"}
+	gm.CreateGroup(&g, nil)
+	addOwner(g.ID)
+
+	m := member.Member{ID: uuid.New(), Name: "Test"}
+	gm.AddMember(g.ID, &m)
+
+	gpath := "/groups/" + g.ID.String()
+	mpath := gpath + "/members/" + m.ID.String()
+
+	cases := []struct {
+		path        string
+		contentType string
+		reqBody     string
+		ifMatch     string
+		statusCode  int
+		code        string
+		name        string
+	}{
+		{gpath, patch.MergeContentType, `{"name":"Merged"}`, "", http.StatusOK, "", "Merged"},
+		{gpath, patch.JSONContentType, `[{"op":"test","path":"/name","value":"Merged"},{"op":"replace","path":"/name","value":"Patched"}]`, "", http.StatusOK, "", "Patched"},
+		{gpath, patch.MergeContentType, `{}`, "", http.StatusOK, "", "Patched"},
+		{gpath, patch.JSONContentType, `[{"op":"test","path":"/name","value":"Merged"}]`, "", http.StatusConflict, "patch_test_failed", ""},
+		{gpath, patch.MergeContentType, `{"id":"` + uuid.New().String() + `"}`, "", http.StatusUnprocessableEntity, "unprocessable_patch", ""},
+		{gpath, patch.MergeContentType, `{"name":null}`, "", http.StatusUnprocessableEntity, "unprocessable_patch", ""},
+		{gpath, patch.MergeContentType, `{"name":"Test"}`, `"stale"`, http.StatusPreconditionFailed, "precondition_failed", ""},
+		{gpath, "application/json", `{"name":"Test"}`, "", http.StatusUnsupportedMediaType, "unsupported_patch", ""},
+		{mpath, patch.MergeContentType, `{"name":"Merged"}`, "", http.StatusOK, "", "Merged"},
+		{mpath, patch.JSONContentType, `[{"op":"replace","path":"/balance","value":100}]`, "", http.StatusUnprocessableEntity, "unprocessable_patch", ""},
+		{mpath, patch.JSONContentType, `[{"op":"replace","path":"/missing","value":1}]`, "", http.StatusBadRequest, "invalid_patch", ""},
+	}
+
+	for _, tc := range cases {
+		t.Run(fmt.Sprintf("%s %s %d", tc.path, tc.reqBody, tc.statusCode), func(t *testing.T) {
+			// Create request
+			req, err := http.NewRequest("PATCH", tc.path, bytes.NewBufferString(tc.reqBody))
+			if err != nil {
+				t.Errorf("Can't create request [Error]: %v", err)
+			}
+			req.Header.Set("X-User-ID", owner)
+			req.Header.Set("Content-Type", tc.contentType)
+			if tc.ifMatch != "" {
+				req.Header.Set("If-Match", tc.ifMatch)
+			}
+
+			// Serve test request
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+			res := rec.Result() // get response
+			defer res.Body.Close()
+
+			if res.StatusCode != tc.statusCode {
+				t.Fatalf("Wrong status code [Expected]: %d [Actual]: %d", tc.statusCode, res.StatusCode)
+			}
+
+			// Check problem code or patched name
+			var body struct {
+				Code string `json:"code"`
+				Name string `json:"name"`
+			}
+			if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+				t.Fatalf("Can't decode response body [Error]: %v", err)
+			}
+			if body.Code != tc.code {
+				t.Errorf("Wrong error code [Expected]: %s [Actual]: %s", tc.code, body.Code)
+			} else if body.Name != tc.name {
+				t.Errorf("Wrong name [Expected]: %s [Actual]: %s", tc.name, body.Name)
+			} else if tc.statusCode == http.StatusOK && res.Header.Get("ETag") == "" {
+				t.Error("No ETag in response")
+			}
+		})
+	}
+
+	// Check changes were stored
+	if g, _ := gm.FetchGroup(g.ID); g.Name != "Patched" || g.Version != 3 {
+		t.Errorf("Wrong group [Name]: %s [Version]: %d", g.Name, g.Version)
+	}
+	if m, _ := gm.FetchMember(g.ID, m.ID); m.Name != "Merged" || m.Balance != 0 {
+		t.Errorf("Wrong member [Name]: %s [Balance]: %f", m.Name, m.Balance)
+	}
+
+	clearDB()
+}
+
+func TestProblemCodes(t *testing.T) {
+	g := group.Group{ID: uuid.New(), Name: "Test"}
+	gm.CreateGroup(&g, nil)
+	addOwner(g.ID)
+
+	m := member.Member{ID: uuid.New(), Name: "Taken"}
+	gm.AddMember(g.ID, &m)
+	body := requestBody(&member.Member{Name: "Taken"})
+
+	m2 := member.Member{ID: uuid.New(), Name: "Balance", Balance: 23.3}
+	addMember(g.ID, &m2)
+
+	cases := []struct {
+		method     string
+		path       string
+		reqBody    []byte
+		statusCode int
+		code       string
+	}{
+		{"GET", "/groups/test", nil, http.StatusBadRequest, "validation_failed"},
+		{"GET", "/groups/" + uuid.New().String(), nil, http.StatusNotFound, "not_found"},
+		{"POST", "/groups/" + g.ID.String() + "/members", body, http.StatusConflict, "already_present"},
+		{"DELETE", "/groups/" + g.ID.String() + "/members/" + m2.ID.String(), nil, http.StatusConflict, "non_zero_balance"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.code, func(t *testing.T) {
+			// Create request
+			req, err := http.NewRequest(tc.method, tc.path, bytes.NewBuffer(tc.reqBody))
+			if err != nil {
+				t.Errorf("Can't create request [Error]: %v", err)
+			}
+			req.Header.Set("X-User-ID", owner)
+
+			// Serve test request
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+			res := rec.Result() // get response
+			defer res.Body.Close()
+
+			// Decode problem
+			var p problem.Problem
+			if err := json.NewDecoder(res.Body).Decode(&p); err != nil {
+				t.Fatalf("Can't decode response body [Error]: %v", err)
+			}
+
+			// Check values
+			if res.StatusCode != tc.statusCode || p.Status != tc.statusCode {
+				t.Errorf("Wrong status code [Expected]: %d [Actual]: %d", tc.statusCode, res.StatusCode)
+			} else if p.Code != tc.code {
+				t.Errorf("Wrong error code [Expected]: %s [Actual]: %s", tc.code, p.Code)
+			} else if p.Instance != tc.path {
+				t.Errorf("Wrong instance [Expected]: %s [Actual]: %s", tc.path, p.Instance)
+			}
+		})
+	}
+}
+
+func TestHandlersDatabaseUnavailable(t *testing.T) {
+	bad := gmicro.NewManager(closedDB())
+
+	br := mux.NewRouter()
+	br.HandleFunc("/groups", gmicro.GroupsHandler(bad)).Methods("POST")
+	br.HandleFunc("/groups/{groupid}", gmicro.GroupHandler(bad, pub)).Methods("GET")
+
+	body := requestBody(&group.Group{Name: "Test"})
+
+	cases := []struct {
+		method  string
+		path    string
+		reqBody []byte
+	}{
+		{"POST", "/groups", body},
+		{"GET", "/groups/" + uuid.New().String(), nil},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.method, func(t *testing.T) {
+			// Create request
+			req, err := http.NewRequest(tc.method, tc.path, bytes.NewBuffer(tc.reqBody))
+			if err != nil {
+				t.Errorf("Can't create request [Error]: %v", err)
+			}
+			req.Header.Set("X-User-ID", owner)
+
+			// Serve test request
+			rec := httptest.NewRecorder()
+			br.ServeHTTP(rec, req)
+			res := rec.Result() // get response
+			defer res.Body.Close()
+
+			// Decode problem
+			var p problem.Problem
+			if err := json.NewDecoder(res.Body).Decode(&p); err != nil {
+				t.Fatalf("Can't decode response body [Error]: %v", err)
+			}
+
+			// Check values
+			if res.StatusCode != http.StatusServiceUnavailable {
+				t.Errorf("Wrong status code [Expected]: %d [Actual]: %d", http.StatusServiceUnavailable, res.StatusCode)
+			} else if p.Code != "db_unavailable" {
+				t.Errorf("Wrong error code [Expected]: %s [Actual]: %s", "db_unavailable", p.Code)
+			}
+		})
+	}
+}
+
+func TestHandlersAuthorization(t *testing.T) {
+	g := group.Group{ID: uuid.New(), Name: "Test"}
+	gm.CreateGroup(&g, nil)
+	addOwner(g.ID)
+
+	viewer := member.Member{ID: uuid.New(), Name: "Viewer", UserID: "viewer", Role: member.RoleViewer}
+	gm.AddMember(g.ID, &viewer)
+
+	admin := member.Member{ID: uuid.New(), Name: "Admin", UserID: "admin", Role: member.RoleAdmin}
+	gm.AddMember(g.ID, &admin)
+
+	admin2 := member.Member{ID: uuid.New(), Name: "Admin2", UserID: "admin2", Role: member.RoleAdmin}
+	gm.AddMember(g.ID, &admin2)
+
+	owner2 := member.Member{ID: uuid.New(), Name: "Owner2", UserID: "owner2", Role: member.RoleOwner}
+	gm.AddMember(g.ID, &owner2)
+
+	gbody := requestBody(&group.Group{ID: g.ID, Name: "Updated", Version: g.Version})
+	mbody := requestBody(&member.Member{ID: uuid.New(), Name: "New"})
+	obody := requestBody(&member.Member{ID: uuid.New(), Name: "Owner2", UserID: "owner2", Role: member.RoleOwner})
+	vbody := requestBody(&member.Member{ID: viewer.ID, Name: "Renamed"})
+
+	cases := []struct {
+		method     string
+		path       string
+		uid        string
+		reqBody    []byte
+		statusCode int
+	}{
+		{"GET", "/groups/" + g.ID.String(), "", nil, http.StatusUnauthorized},
+		{"GET", "/groups/" + g.ID.String(), "stranger", nil, http.StatusForbidden},
+		{"GET", "/groups/" + g.ID.String(), "viewer", nil, http.StatusOK},
+		{"PUT", "/groups/" + g.ID.String(), "viewer", gbody, http.StatusForbidden},
+		{"PUT", "/groups/" + g.ID.String(), "admin", gbody, http.StatusOK},
+		{"POST", "/groups/" + g.ID.String() + "/members", "viewer", mbody, http.StatusForbidden},
+		{"POST", "/groups/" + g.ID.String() + "/members", "admin", obody, http.StatusForbidden},
+		{"POST", "/groups/" + g.ID.String() + "/members", "admin", mbody, http.StatusCreated},
+		{"PUT", "/groups/" + g.ID.String() + "/members/" + viewer.ID.String(), "viewer", vbody, http.StatusForbidden},
+		{"DELETE", "/groups/" + g.ID.String() + "/members/" + viewer.ID.String(), "viewer", nil, http.StatusForbidden},
+		{"DELETE", "/groups/" + g.ID.String() + "/members/" + admin2.ID.String(), "admin", nil, http.StatusForbidden},
+		{"DELETE", "/groups/" + g.ID.String() + "/members/" + owner2.ID.String(), "admin", nil, http.StatusForbidden},
+		{"DELETE", "/groups/" + g.ID.String() + "/members/" + owner2.ID.String(), owner, nil, http.StatusForbidden},
+		{"DELETE", "/groups/" + g.ID.String() + "/members/" + admin2.ID.String(), owner, nil, http.StatusNoContent},
+		{"DELETE", "/groups/" + g.ID.String() + "/members/" + viewer.ID.String(), "admin", nil, http.StatusNoContent},
+		{"DELETE", "/groups/" + g.ID.String(), "admin", nil, http.StatusForbidden},
+		{"DELETE", "/groups/" + g.ID.String(), owner, nil, http.StatusAccepted},
+		{"POST", "/groups", "", gbody, http.StatusUnauthorized},
+	}
+
+	for _, tc := range cases {
+		t.Run(fmt.Sprintf("%s %s %d", tc.method, tc.uid, tc.statusCode), func(t *testing.T) {
+			// Create request
+			req, err := http.NewRequest(tc.method, tc.path, bytes.NewBuffer(tc.reqBody))
+			if err != nil {
+				t.Errorf("Can't create request [Error]: %v", err)
+			}
+			req.Header.Set("X-User-ID", tc.uid)
+
+			// Serve test request
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+			res := rec.Result() // get response
+			defer res.Body.Close()
+
+			// Check response status code
+			if res.StatusCode != tc.statusCode {
+				t.Errorf("Wrong status code [Expected]: %d [Actual]: %d", tc.statusCode, res.StatusCode)
+			}
+		})
+	}
+
+	clearDB()
+}
+
+func TestInviteHandlers(t *testing.T) {
+	g := group.Group{ID: uuid.New(), Name: "Test"}
+	gm.CreateGroup(&g, nil)
+	addOwner(g.ID)
+
+	// Create invite
+	req, _ := http.NewRequest("POST", "/groups/"+g.ID.String()+"/invites", bytes.NewBufferString(`{"max_uses":1}`))
+	req.Header.Set("X-User-ID", owner)
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	res := rec.Result()
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("Wrong status code [Expected]: %d [Actual]: %d", http.StatusCreated, res.StatusCode)
+	}
+
+	var i invite.Invite
+	if err := json.NewDecoder(res.Body).Decode(&i); err != nil {
+		t.Fatalf("Can't decode response body [Error]: %v", err)
+	}
+
+	cases := []struct {
+		method     string
+		path       string
+		uid        string
+		statusCode int
+	}{
+		{"POST", "/invites/" + i.Token + "x", "user1", http.StatusNotFound},
+		{"POST", "/invites/" + i.Token, "", http.StatusUnauthorized},
+		{"POST", "/invites/" + i.Token, "user1", http.StatusOK},
+		{"POST", "/invites/" + i.Token, "user2", http.StatusGone},
+		{"DELETE", "/groups/" + g.ID.String() + "/invites/" + i.ID.String(), "user1", http.StatusForbidden},
+		{"DELETE", "/groups/" + g.ID.String() + "/invites/" + i.ID.String(), owner, http.StatusNoContent},
+		{"DELETE", "/groups/" + g.ID.String() + "/invites/" + uuid.New().String(), owner, http.StatusNotFound},
+	}
+
+	for _, tc := range cases {
+		t.Run(fmt.Sprintf("%s %s %d", tc.method, tc.uid, tc.statusCode), func(t *testing.T) {
+			// Create request
+			req, err := http.NewRequest(tc.method, tc.path, nil)
+			if err != nil {
+				t.Errorf("Can't create request [Error]: %v", err)
+			}
+			req.Header.Set("X-User-ID", tc.uid)
+
+			// Serve test request
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+			res := rec.Result() // get response
+			defer res.Body.Close()
+
+			// Check response status code
+			if res.StatusCode != tc.statusCode {
+				t.Errorf("Wrong status code [Expected]: %d [Actual]: %d", tc.statusCode, res.StatusCode)
+			}
+		})
+	}
+
+	clearDB()
+}
+
+func TestPreferencesHandler(t *testing.T) {
+	g := group.Group{ID: uuid.New(), Name: "Test"}
+	gm.CreateGroup(&g, nil)
+	addOwner(g.ID)
+	mb := member.Member{ID: uuid.New(), Name: "Test", UserID: "user1", Role: member.RoleViewer}
+	gm.AddMember(g.ID, &mb)
+
+	path := "/groups/" + g.ID.String() + "/members/" + mb.ID.String() + "/preferences"
+	published = []string{}
+
+	cases := []struct {
+		method     string
+		path       string
+		uid        string
+		reqBody    string
+		statusCode int
+	}{
+		{"GET", path, "user1", "", http.StatusOK},
+		{"GET", path, owner, "", http.StatusForbidden},
+		{"PUT", path, "user1", `{"email":"test@example.com","expense_added":true,"owed_threshold":20,"weekly_digest":true}`, http.StatusOK},
+		{"PUT", path, "user1", `{"expense_added":true}`, http.StatusBadRequest},
+		{"PUT", path, "user1", `{"email":"Test <test@example.com>"}`, http.StatusBadRequest},
+		{"PUT", path, "user1", `{"email":"test@example.com","owed_threshold":-1}`, http.StatusBadRequest},
+		{"PUT", path, owner, `{"email":"owner@example.com"}`, http.StatusForbidden},
+		{"PUT", "/groups/" + g.ID.String() + "/members/" + uuid.New().String() + "/preferences", "user1", `{}`, http.StatusForbidden},
+	}
+
+	for _, tc := range cases {
+		t.Run(fmt.Sprintf("%s %s %d", tc.method, tc.uid, tc.statusCode), func(t *testing.T) {
+			// Create request
+			req, err := http.NewRequest(tc.method, tc.path, bytes.NewBufferString(tc.reqBody))
+			if err != nil {
+				t.Errorf("Can't create request [Error]: %v", err)
+			}
+			req.Header.Set("X-User-ID", tc.uid)
+
+			// Serve test request
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+			res := rec.Result() // get response
+			defer res.Body.Close()
+
+			// Check response status code
+			if res.StatusCode != tc.statusCode {
+				t.Errorf("Wrong status code [Expected]: %d [Actual]: %d", tc.statusCode, res.StatusCode)
+			}
+		})
+	}
+
+	// Only the valid update is stored and published
+	p, err := gm.FetchPreferences(g.ID, mb.ID)
+	if err != nil || p.Email != "test@example.com" || !p.ExpenseAdded || p.OwedThreshold != 20 || !p.WeeklyDigest {
+		t.Errorf("Wrong preferences [Actual]: %+v [Error]: %v", p, err)
+	}
+	if len(published) != 1 || published[0] != "preferences-changed" {
+		t.Errorf("Wrong messages published [Expected]: %v [Actual]: %v", []string{"preferences-changed"}, published)
+	}
+
+	// Preferences go away with the member
+	gm.RemoveMember(g.ID, mb.ID)
+	var count int
+	db.Model(&member.Preferences{}).Where("member_id = ?", mb.ID).Count(&count)
+	if count != 0 {
+		t.Errorf("Wrong preferences count [Expected]: %d [Actual]: %d", 0, count)
+	}
+
+	clearDB()
+}
@@ -0,0 +1,204 @@
+package gmicro
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/varrrro/pay-up/internal/logging"
+	"github.com/varrrro/pay-up/internal/problem"
+)
+
+// IdempotencyHeader lets clients retry a POST request without repeating it.
+const IdempotencyHeader = "Idempotency-Key"
+
+// IdempotencyTTL is how long the response to a request is kept for replays.
+const IdempotencyTTL = 24 * time.Hour
+
+// idempotencySweepInterval between the sweeps of expired keys.
+const idempotencySweepInterval = time.Minute
+
+// StoredResponse to a request made with an idempotency key.
+type StoredResponse struct {
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+// IdempotencyStore that keeps the responses to requests made with an
+// idempotency key.
+type IdempotencyStore interface {
+	// Reserve the key for a request with the given fingerprint, returning
+	// the stored response if the request was already completed.
+	Reserve(key, fingerprint string, now time.Time) (*StoredResponse, error)
+	// Complete the request made with the key, storing its response.
+	Complete(key string, res *StoredResponse, now time.Time) error
+	// Release the key so the request can be retried.
+	Release(key string) error
+}
+
+type idempotencyEntry struct {
+	fingerprint string
+	res         *StoredResponse
+	expires     time.Time
+}
+
+// MemoryIdempotencyStore that keeps responses in memory, forgetting expired
+// keys periodically.
+type MemoryIdempotencyStore struct {
+	mu      sync.Mutex
+	entries map[string]*idempotencyEntry
+	swept   time.Time
+}
+
+// NewMemoryIdempotencyStore with no keys.
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{entries: make(map[string]*idempotencyEntry)}
+}
+
+// Reserve the key, failing if it's in use by an unfinished request or by a
+// request with a different fingerprint.
+func (s *MemoryIdempotencyStore) Reserve(key, fingerprint string, now time.Time) (*StoredResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Forget expired keys
+	if now.Sub(s.swept) >= idempotencySweepInterval {
+		for k, e := range s.entries {
+			if now.After(e.expires) {
+				delete(s.entries, k)
+			}
+		}
+		s.swept = now
+	}
+
+	e, ok := s.entries[key]
+	if !ok || now.After(e.expires) {
+		s.entries[key] = &idempotencyEntry{fingerprint: fingerprint, expires: now.Add(IdempotencyTTL)}
+		return nil, nil
+	}
+
+	if e.fingerprint != fingerprint {
+		return nil, &IdempotencyError{"Idempotency key already used for another request", key, true}
+	}
+
+	if e.res == nil {
+		return nil, &IdempotencyError{"Request with the same idempotency key in progress", key, false}
+	}
+
+	return e.res, nil
+}
+
+// Complete the request, storing its response.
+func (s *MemoryIdempotencyStore) Complete(key string, res *StoredResponse, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e, ok := s.entries[key]; ok {
+		e.res = res
+		e.expires = now.Add(IdempotencyTTL)
+	}
+
+	return nil
+}
+
+// Release the key.
+func (s *MemoryIdempotencyStore) Release(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, key)
+
+	return nil
+}
+
+// recorder that keeps a copy of the response written.
+type recorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *recorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *recorder) Write(b []byte) (int, error) {
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+// IdempotencyMiddleware that replays the response to POST requests retried
+// with the same idempotency key. Keys are scoped to the user making the
+// request, and responses to requests that fail on the server aren't kept.
+func IdempotencyMiddleware(store IdempotencyStore) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(IdempotencyHeader)
+			if key == "" || r.Method != "POST" {
+				next.ServeHTTP(rw, r)
+				return
+			}
+			key = r.Header.Get(UserHeader) + ":" + key
+
+			logger := logging.FromContext(r.Context()).WithField("key", key)
+
+			// Fingerprint the request
+			var body []byte
+			if r.Body != nil {
+				body, _ = ioutil.ReadAll(r.Body)
+				r.Body.Close()
+				r.Body = ioutil.NopCloser(bytes.NewReader(body))
+			}
+			sum := sha256.Sum256(append([]byte(r.Method+" "+r.URL.Path+"\n"), body...))
+
+			res, err := store.Reserve(key, hex.EncodeToString(sum[:]), time.Now())
+			if err != nil {
+				logger.WithError(err).Warn("Can't use idempotency key")
+				problem.Write(rw, r, problem.FromError(err))
+				return
+			}
+
+			// Replay the stored response
+			if res != nil {
+				logger.Info("Replaying response for idempotency key")
+				for k, v := range res.Header {
+					rw.Header()[k] = v
+				}
+				rw.Header().Set("Idempotent-Replayed", "true")
+				rw.WriteHeader(res.Status)
+				rw.Write(res.Body)
+				return
+			}
+
+			// Release the key unless the response is stored, also if the
+			// handler panics, so the request can be retried
+			completed := false
+			defer func() {
+				if completed {
+					return
+				}
+				if err := store.Release(key); err != nil {
+					logger.WithError(err).Error("Can't release idempotency key")
+				}
+			}()
+
+			rec := &recorder{ResponseWriter: rw, status: http.StatusOK}
+			next.ServeHTTP(rec, r)
+
+			if rec.status >= http.StatusInternalServerError {
+				return
+			}
+			if err := store.Complete(key, &StoredResponse{rec.status, rw.Header().Clone(), rec.body.Bytes()}, time.Now()); err != nil {
+				logger.WithError(err).Error("Can't store response for idempotency key")
+				return
+			}
+			completed = true
+		})
+	}
+}
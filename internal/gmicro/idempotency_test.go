@@ -0,0 +1,134 @@
+package gmicro_test
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/varrrro/pay-up/internal/gmicro"
+	"github.com/varrrro/pay-up/internal/gmicro/group"
+)
+
+func TestIdempotencyMiddleware(t *testing.T) {
+	body := requestBody(&group.Group{Name: "Idempotent"})
+	body2 := requestBody(&group.Group{Name: "Other"})
+
+	cases := []struct {
+		user       string
+		key        string
+		reqBody    []byte
+		statusCode int
+		replayed   bool
+	}{
+		{owner, "key1", body, http.StatusCreated, false},
+		{owner, "key1", body, http.StatusCreated, true},
+		{owner, "key1", body2, http.StatusUnprocessableEntity, false},
+		{"user2", "key1", body, http.StatusCreated, false},
+		{owner, "key2", body, http.StatusCreated, false},
+	}
+
+	var first string
+	for _, tc := range cases {
+		t.Run(fmt.Sprintf("%s %s %d", tc.user, tc.key, tc.statusCode), func(t *testing.T) {
+			// Create request
+			req, err := http.NewRequest("POST", "/groups", bytes.NewBuffer(tc.reqBody))
+			if err != nil {
+				t.Errorf("Can't create request [Error]: %v", err)
+			}
+			req.Header.Set("X-User-ID", tc.user)
+			req.Header.Set(gmicro.IdempotencyHeader, tc.key)
+
+			// Serve test request
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+			res := rec.Result() // get response
+			defer res.Body.Close()
+
+			// Check status code and replay header
+			if res.StatusCode != tc.statusCode {
+				t.Errorf("Wrong status code [Expected]: %d [Actual]: %d", tc.statusCode, res.StatusCode)
+			} else if replayed := res.Header.Get("Idempotent-Replayed") == "true"; replayed != tc.replayed {
+				t.Errorf("Wrong replay [Expected]: %t [Actual]: %t", tc.replayed, replayed)
+			}
+
+			// Check replays return the first group created
+			if tc.statusCode == http.StatusCreated && tc.user == owner && tc.key == "key1" {
+				if first == "" {
+					first = res.Header.Get("Location")
+				} else if res.Header.Get("Location") != first {
+					t.Errorf("Wrong location [Expected]: %s [Actual]: %s", first, res.Header.Get("Location"))
+				}
+			}
+		})
+	}
+
+	clearDB()
+}
+
+func TestIdempotencyMiddlewarePanic(t *testing.T) {
+	panics := true
+	h := gmicro.IdempotencyMiddleware(gmicro.NewMemoryIdempotencyStore())(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if panics {
+			panic("handler failed")
+		}
+		rw.WriteHeader(http.StatusCreated)
+	}))
+
+	serve := func() *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/groups", bytes.NewBufferString(`{"name":"Test"}`))
+		req.Header.Set("X-User-ID", owner)
+		req.Header.Set(gmicro.IdempotencyHeader, "key")
+
+		rec := httptest.NewRecorder()
+		defer func() { recover() }()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// The key is released when the handler panics, so the retry goes through
+	serve()
+	panics = false
+	if rec := serve(); rec.Code != http.StatusCreated {
+		t.Errorf("Wrong status code [Expected]: %d [Actual]: %d", http.StatusCreated, rec.Code)
+	}
+}
+
+func TestMemoryIdempotencyStore(t *testing.T) {
+	s := gmicro.NewMemoryIdempotencyStore()
+	now := time.Now()
+
+	// Reserve a new key
+	if res, err := s.Reserve("key", "a", now); err != nil || res != nil {
+		t.Fatalf("Can't reserve new key [Response]: %v [Error]: %v", res, err)
+	}
+
+	// Requests in progress can't be repeated
+	if _, err := s.Reserve("key", "a", now); err == nil {
+		t.Error("Reserving key in progress didn't return an error")
+	}
+
+	// Completed requests are replayed
+	s.Complete("key", &gmicro.StoredResponse{Status: http.StatusCreated}, now)
+	if res, err := s.Reserve("key", "a", now); err != nil || res == nil || res.Status != http.StatusCreated {
+		t.Errorf("Stored response wasn't returned [Response]: %v [Error]: %v", res, err)
+	}
+
+	// Keys can't be used for other requests
+	if _, err := s.Reserve("key", "b", now); err == nil {
+		t.Error("Reusing key for another request didn't return an error")
+	}
+
+	// Keys expire
+	if res, err := s.Reserve("key", "b", now.Add(gmicro.IdempotencyTTL+time.Second)); err != nil || res != nil {
+		t.Errorf("Expired key wasn't forgotten [Response]: %v [Error]: %v", res, err)
+	}
+
+	// Released keys can be reserved again
+	s.Release("key")
+	if res, err := s.Reserve("key", "c", now); err != nil || res != nil {
+		t.Errorf("Released key wasn't forgotten [Response]: %v [Error]: %v", res, err)
+	}
+}
@@ -0,0 +1,62 @@
+package invite
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/varrrro/pay-up/internal/gmicro/member"
+)
+
+// Invite to join a group, optionally claiming an existing member.
+type Invite struct {
+	ID        uuid.UUID   `json:"id" gorm:"type:uuid;primary_key"`
+	GroupID   uuid.UUID   `json:"group_id" gorm:"type:uuid"`
+	MemberID  uuid.UUID   `json:"member_id" gorm:"type:uuid"`
+	Role      member.Role `json:"role"`
+	CreatedBy string      `json:"created_by"`
+	ExpiresAt time.Time   `json:"expires_at"`
+	MaxUses   int         `json:"max_uses"`
+	Uses      int         `json:"uses"`
+	Revoked   bool        `json:"revoked"`
+	Token     string      `json:"token,omitempty" gorm:"-"`
+}
+
+// Usable checks if the invite can still be redeemed at the given time.
+func (i *Invite) Usable(now time.Time) bool {
+	return !i.Revoked && now.Before(i.ExpiresAt) && (i.MaxUses == 0 || i.Uses < i.MaxUses)
+}
+
+// Sign an invite ID, returning a token that can be shared with others.
+func Sign(id uuid.UUID, secret []byte) string {
+	return id.String() + "." + signature(id, secret)
+}
+
+// Verify a token, returning the ID of the invite it was created for.
+func Verify(token string, secret []byte) (uuid.UUID, error) {
+	parts := strings.SplitN(token, ".", 2)
+	if len(parts) != 2 {
+		return uuid.Nil, errors.New("Malformed invite token")
+	}
+
+	id, err := uuid.Parse(parts[0])
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	if !hmac.Equal([]byte(parts[1]), []byte(signature(id, secret))) {
+		return uuid.Nil, errors.New("Invalid invite token signature")
+	}
+
+	return id, nil
+}
+
+func signature(id uuid.UUID, secret []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(id[:])
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
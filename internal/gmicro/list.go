@@ -0,0 +1,93 @@
+package gmicro
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/varrrro/pay-up/internal/gmicro/group"
+)
+
+// Limits on the number of groups listed in a page.
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+// Columns groups can be sorted by.
+var sortColumns = map[string]string{
+	"name":       "name",
+	"created_at": "created_at",
+	"active_at":  "active_at",
+}
+
+// GroupQuery to list the groups of a user.
+type GroupQuery struct {
+	UserID   string // only groups the user is a member of
+	Name     string // case-insensitive search in the group's name
+	Sort     string // name, created_at or active_at
+	Desc     bool
+	Limit    int
+	Cursor   string // returned with the previous page
+	Archived bool   // include archived groups
+}
+
+// GroupPage with some of the groups matching a query.
+type GroupPage struct {
+	Groups     []group.Summary `json:"groups"`
+	NextCursor string          `json:"next_cursor,omitempty"`
+}
+
+// cursor points to the last group of a page, so the next one starts after it
+// even if groups are added or removed in between.
+type cursor struct {
+	Sort  string    `json:"s"`
+	Desc  bool      `json:"d,omitempty"`
+	Value string    `json:"v"`
+	ID    uuid.UUID `json:"id"`
+}
+
+func encodeCursor(q GroupQuery, g group.Summary) string {
+	c := cursor{Sort: q.Sort, Desc: q.Desc, ID: g.ID}
+	switch q.Sort {
+	case "created_at":
+		c.Value = g.CreatedAt.UTC().Format(time.RFC3339Nano)
+	case "active_at":
+		c.Value = g.ActiveAt.UTC().Format(time.RFC3339Nano)
+	default:
+		c.Value = g.Name
+	}
+
+	b, _ := json.Marshal(&c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodeCursor returns the sort value and ID the page starts after. The
+// cursor must come from a query with the same sort.
+func decodeCursor(q GroupQuery) (interface{}, uuid.UUID, error) {
+	b, err := base64.RawURLEncoding.DecodeString(q.Cursor)
+	if err != nil {
+		return nil, uuid.Nil, &CursorError{"Cursor isn't valid", q.Cursor}
+	}
+
+	var c cursor
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, uuid.Nil, &CursorError{"Cursor isn't valid", q.Cursor}
+	}
+
+	if c.Sort != q.Sort || c.Desc != q.Desc {
+		return nil, uuid.Nil, &CursorError{"Cursor was returned for another sort order", q.Cursor}
+	}
+
+	if q.Sort == "name" {
+		return c.Value, c.ID, nil
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, c.Value)
+	if err != nil {
+		return nil, uuid.Nil, &CursorError{"Cursor isn't valid", q.Cursor}
+	}
+
+	return t, c.ID, nil
+}
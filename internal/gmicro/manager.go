@@ -1,36 +1,61 @@
 package gmicro
 
 import (
+	"context"
 	"math"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/jinzhu/gorm"
+	"github.com/varrrro/pay-up/internal/dberr"
 	"github.com/varrrro/pay-up/internal/gmicro/group"
+	"github.com/varrrro/pay-up/internal/gmicro/invite"
 	"github.com/varrrro/pay-up/internal/gmicro/member"
+	"github.com/varrrro/pay-up/internal/gmicro/webhook"
+	"github.com/varrrro/pay-up/internal/inbox"
 	"github.com/varrrro/pay-up/internal/tmicro/expense"
 	"github.com/varrrro/pay-up/internal/tmicro/payment"
+	"github.com/varrrro/pay-up/internal/tracing"
 )
 
 // Manager interface for the groups microservice.
 type Manager interface {
-	CreateGroup(g *group.Group) error
+	CreateGroup(g *group.Group, owner *member.Member) error
+	ListGroups(q GroupQuery) (GroupPage, error)
 	FetchGroup(id uuid.UUID) (group.Group, error)
 	UpdateGroup(g *group.Group) error
-	RemoveGroup(id uuid.UUID) error
+	ArchiveGroup(id uuid.UUID) error
+	RestoreGroup(id uuid.UUID) (group.Group, error)
+	DeleteGroup(id uuid.UUID, force bool) error
+	CancelDeleteGroup(id uuid.UUID) error
+	PurgeGroup(id uuid.UUID) error
 	AddMember(gid uuid.UUID, m *member.Member) error
 	FetchMember(gid uuid.UUID, mid uuid.UUID) (member.Member, error)
 	UpdateMember(gid uuid.UUID, m *member.Member) error
 	RemoveMember(gid uuid.UUID, mid uuid.UUID) error
+	CreateInvite(i *invite.Invite) error
+	RevokeInvite(gid uuid.UUID, iid uuid.UUID) error
+	RedeemInvite(iid uuid.UUID, uid, name string) (member.Member, error)
 	AddExpense(e *expense.Expense) error
 	RemoveExpense(e *expense.Expense) error
 	AddPayment(p *payment.Payment) error
 	RemovePayment(p *payment.Payment) error
+	CreateWebhook(w *webhook.Webhook) error
+	ListWebhooks(gid uuid.UUID) ([]webhook.Webhook, error)
+	DeleteWebhook(gid uuid.UUID, wid uuid.UUID) error
+	ListDeliveries(gid uuid.UUID, wid uuid.UUID) ([]webhook.Delivery, error)
+	Redeliver(gid uuid.UUID, wid uuid.UUID, did uuid.UUID) (webhook.Delivery, error)
+	QueueEvent(gid uuid.UUID, event string, data interface{}) error
+	FetchPreferences(gid uuid.UUID, mid uuid.UUID) (member.Preferences, error)
+	UpdatePreferences(p *member.Preferences) error
+	WithContext(ctx context.Context) Manager
 }
 
 // GroupsManager that works as single source of truth.
 type GroupsManager struct {
-	DB *gorm.DB
+	DB      *gorm.DB
+	message string // ID of the AMQP message being handled, if any
 }
 
 // NewManager with the given database connection.
@@ -38,64 +63,292 @@ func NewManager(db *gorm.DB) *GroupsManager {
 	return &GroupsManager{DB: db}
 }
 
-// CreateGroup with the given name.
-func (gm *GroupsManager) CreateGroup(g *group.Group) error {
-	gm.DB.Create(g)
+// WithContext returns a manager whose queries are traced as part of the
+// request or message being handled in ctx. Balance changes applied by a
+// message record it as handled, and return inbox.ErrHandled if it already was.
+func (gm *GroupsManager) WithContext(ctx context.Context) Manager {
+	return &GroupsManager{DB: tracing.WithContext(ctx, gm.DB), message: inbox.MessageID(ctx)}
+}
 
-	return nil
+// CreateGroup and its owner in one transaction, so groups are never left
+// without one, generating the group's ID if it has none. The owner gets the
+// owner role and a zero balance; a nil owner creates the group alone.
+func (gm *GroupsManager) CreateGroup(g *group.Group, owner *member.Member) error {
+	if g.ID == uuid.Nil {
+		g.ID = uuid.New()
+	}
+	g.Version = 1
+	g.CreatedAt = time.Now().UTC()
+	g.UpdatedAt = g.CreatedAt
+	g.ActiveAt = g.CreatedAt
+	g.ArchivedAt = nil
+
+	var prevg group.Group
+
+	err := first(gm.DB, "create group", nil, &prevg, "id = ?", g.ID)
+	if err == nil {
+		return &DuplicateIDError{"Group ID already in use", g.ID}
+	} else if !dberr.Is(err, dberr.NotFound) {
+		return err
+	}
+
+	tx := gm.DB.Begin()
+	if err := tx.Error; err != nil {
+		return dberr.Wrap("begin transaction", err)
+	}
+
+	if err := tx.Create(g).Error; err != nil {
+		tx.Rollback()
+		return dberr.Wrap("create group", err)
+	}
+
+	if owner != nil {
+		if owner.ID == uuid.Nil {
+			owner.ID = uuid.New()
+		}
+		owner.GroupID = g.ID
+		owner.Role = member.RoleOwner
+		owner.Balance = 0
+		owner.Version = 1
+
+		if err := tx.Create(owner).Error; err != nil {
+			tx.Rollback()
+			return dberr.Wrap("create group", err)
+		}
+	}
+
+	return dberr.Wrap("commit transaction", tx.Commit().Error)
 }
 
 // FetchGroup with the given ID.
 func (gm *GroupsManager) FetchGroup(id uuid.UUID) (group.Group, error) {
 	var g group.Group
 
-	gm.DB.Preload("Members").First(&g, "id = ?", id)
+	err := first(gm.DB.Preload("Members"), "fetch group", &NotFoundError{"No group found", id}, &g, "id = ?", id)
+
+	return g, err
+}
+
+// ListGroups the user is a member of that match the query, sorted by name
+// unless given another column. Groups are summarized, so their members aren't
+// loaded, and archived groups are left out unless asked for.
+func (gm *GroupsManager) ListGroups(q GroupQuery) (GroupPage, error) {
+	page := GroupPage{Groups: []group.Summary{}}
+
+	col, ok := sortColumns[q.Sort]
+	if !ok {
+		q.Sort, col = "name", "name"
+	}
+	if q.Limit <= 0 {
+		q.Limit = DefaultPageSize
+	} else if q.Limit > MaxPageSize {
+		q.Limit = MaxPageSize
+	}
+
+	db := gm.DB.Table("groups").
+		Select("groups.id, groups.name, groups.description, groups.version, "+
+			"groups.created_at, groups.active_at, groups.archived_at, "+
+			"(SELECT COUNT(*) FROM members WHERE members.group_id = groups.id) AS members").
+		Where("groups.id IN (SELECT group_id FROM members WHERE user_id = ?)", q.UserID)
+
+	if !q.Archived {
+		db = db.Where("groups.archived_at IS NULL")
+	}
+
+	if q.Name != "" {
+		esc := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(strings.ToLower(q.Name))
+		db = db.Where(`LOWER(groups.name) LIKE ? ESCAPE '\'`, "%"+esc+"%")
+	}
 
-	if g.ID != id {
-		return g, &NotFoundError{"No group found", id}
+	// Start after the last group of the previous page
+	op, dir := ">", "ASC"
+	if q.Desc {
+		op, dir = "<", "DESC"
+	}
+	if q.Cursor != "" {
+		v, id, err := decodeCursor(q)
+		if err != nil {
+			return page, err
+		}
+		db = db.Where("groups."+col+" "+op+" ? OR (groups."+col+" = ? AND groups.id "+op+" ?)", v, v, id)
 	}
 
-	return g, nil
+	// Fetch one more group to know if there's another page
+	err := db.Order("groups." + col + " " + dir).
+		Order("groups.id " + dir).
+		Limit(q.Limit + 1).
+		Scan(&page.Groups).Error
+	if err != nil {
+		return page, dberr.Wrap("list groups", err)
+	}
+
+	if len(page.Groups) > q.Limit {
+		page.Groups = page.Groups[:q.Limit]
+		page.NextCursor = encodeCursor(q, page.Groups[q.Limit-1])
+	}
+
+	return page, nil
 }
 
-// UpdateGroup with a new name.
+// UpdateGroup with a new name and description. If the group has a version,
+// it must match the stored one. Archived groups can't be updated.
 func (gm *GroupsManager) UpdateGroup(g *group.Group) error {
 	var prevg group.Group
 
-	gm.DB.First(&prevg, "id = ?", g.ID)
+	if err := first(gm.DB, "update group", &NotFoundError{"No group found", g.ID}, &prevg, "id = ?", g.ID); err != nil {
+		return err
+	}
 
-	if prevg.ID != g.ID {
-		return &NotFoundError{"No group found", g.ID}
+	if prevg.Archived() {
+		return &ArchivedError{"Archived groups can't be changed", g.ID, true}
 	}
 
-	gm.DB.Model(&prevg).Update("name", g.Name)
+	if g.Version != 0 && g.Version != prevg.Version {
+		return &VersionError{"Group was modified by another request", g.ID, g.Version}
+	}
+
+	now := time.Now().UTC()
+	if err := updateGroupVersioned(gm.DB, "update group", &prevg, map[string]interface{}{"name": g.Name, "description": g.Description, "active_at": now}); err != nil {
+		return err
+	}
+	g.Version = prevg.Version
 
 	return nil
 }
 
-// RemoveGroup with the given ID.
-func (gm *GroupsManager) RemoveGroup(id uuid.UUID) error {
+// ArchiveGroup with the given ID, so its members and transactions are kept
+// but can't be changed. Archiving an archived group does nothing.
+func (gm *GroupsManager) ArchiveGroup(id uuid.UUID) error {
+	var g group.Group
+
+	if err := first(gm.DB, "archive group", &NotFoundError{"No group found", id}, &g, "id = ?", id); err != nil {
+		return err
+	}
+
+	if g.Archived() {
+		return nil
+	}
+
+	return updateGroupVersioned(gm.DB, "archive group", &g, map[string]interface{}{"archived_at": time.Now().UTC()})
+}
+
+// DeleteGroup with the given ID, which starts its deletion by archiving it
+// and marking it as being deleted. Groups where a member has a balance can
+// only be deleted if forced.
+func (gm *GroupsManager) DeleteGroup(id uuid.UUID, force bool) error {
 	var g group.Group
 
-	gm.DB.First(&g, "id = ?", id)
+	if err := first(gm.DB.Preload("Members"), "delete group", &NotFoundError{"No group found", id}, &g, "id = ?", id); err != nil {
+		return err
+	}
 
-	if g.ID != id {
-		return &NotFoundError{"No group found", id}
+	if g.Deleting {
+		return nil
 	}
 
-	gm.DB.Delete(&g)
+	if !force {
+		for _, m := range g.Members {
+			if m.Balance != 0.0 {
+				return &BalanceError{"Can't delete group with unsettled balances", id, m.ID, m.Balance}
+			}
+		}
+	}
 
-	return nil
+	changes := map[string]interface{}{"deleting": true}
+	if !g.Archived() {
+		changes["archived_at"] = time.Now().UTC()
+	}
+
+	return updateGroupVersioned(gm.DB, "delete group", &g, changes)
+}
+
+// CancelDeleteGroup with the given ID when its deletion can't go on. The
+// group is left archived.
+func (gm *GroupsManager) CancelDeleteGroup(id uuid.UUID) error {
+	var g group.Group
+
+	if err := first(gm.DB, "cancel group deletion", &NotFoundError{"No group found", id}, &g, "id = ?", id); err != nil {
+		return err
+	}
+
+	return updateGroupVersioned(gm.DB, "cancel group deletion", &g, map[string]interface{}{"deleting": false})
+}
+
+// PurgeGroup with the given ID, removing it with its members, invites and
+// webhooks once its transactions were purged. Purging a missing group does nothing, so
+// repeated messages are harmless.
+func (gm *GroupsManager) PurgeGroup(id uuid.UUID) error {
+	tx := gm.DB.Begin()
+	if err := tx.Error; err != nil {
+		return dberr.Wrap("begin transaction", err)
+	}
+
+	if err := tx.Where("group_id = ?", id).Delete(&invite.Invite{}).Error; err != nil {
+		tx.Rollback()
+		return dberr.Wrap("purge group", err)
+	}
+
+	if err := tx.Where("group_id = ?", id).Delete(&webhook.Delivery{}).Error; err != nil {
+		tx.Rollback()
+		return dberr.Wrap("purge group", err)
+	}
+
+	if err := tx.Where("group_id = ?", id).Delete(&webhook.Webhook{}).Error; err != nil {
+		tx.Rollback()
+		return dberr.Wrap("purge group", err)
+	}
+
+	if err := tx.Where("group_id = ?", id).Delete(&member.Preferences{}).Error; err != nil {
+		tx.Rollback()
+		return dberr.Wrap("purge group", err)
+	}
+
+	if err := tx.Where("group_id = ?", id).Delete(&member.Member{}).Error; err != nil {
+		tx.Rollback()
+		return dberr.Wrap("purge group", err)
+	}
+
+	if err := tx.Where("id = ?", id).Delete(&group.Group{}).Error; err != nil {
+		tx.Rollback()
+		return dberr.Wrap("purge group", err)
+	}
+
+	return dberr.Wrap("commit transaction", tx.Commit().Error)
 }
 
-// AddMember to the given group.
+// RestoreGroup with the given ID from the archive, returning it.
+func (gm *GroupsManager) RestoreGroup(id uuid.UUID) (group.Group, error) {
+	var g group.Group
+
+	if err := first(gm.DB, "restore group", &NotFoundError{"No group found", id}, &g, "id = ?", id); err != nil {
+		return g, err
+	}
+
+	if !g.Archived() {
+		return g, &ArchivedError{"Group isn't archived", id, false}
+	}
+
+	if g.Deleting {
+		return g, &ArchivedError{"Group is being deleted", id, true}
+	}
+
+	if err := updateGroupVersioned(gm.DB, "restore group", &g, map[string]interface{}{"archived_at": nil}); err != nil {
+		return g, err
+	}
+
+	return gm.FetchGroup(id)
+}
+
+// AddMember to the given group, generating its ID if it has none.
 func (gm *GroupsManager) AddMember(gid uuid.UUID, m *member.Member) error {
 	var g group.Group
 
-	gm.DB.Preload("Members").First(&g, "id = ?", gid)
+	if err := first(gm.DB.Preload("Members"), "add member", &NotFoundError{"No group found", gid}, &g, "id = ?", gid); err != nil {
+		return err
+	}
 
-	if g.ID != gid {
-		return &NotFoundError{"No group found", gid}
+	if g.Archived() {
+		return &ArchivedError{"Archived groups can't be changed", gid, true}
 	}
 
 	for _, prevm := range g.Members {
@@ -104,75 +357,292 @@ func (gm *GroupsManager) AddMember(gid uuid.UUID, m *member.Member) error {
 		}
 	}
 
-	gm.DB.Model(&g).Association("Members").Append(m)
+	if m.ID == uuid.Nil {
+		m.ID = uuid.New()
+	}
+	m.Version = 1
 
-	return nil
+	// Balances only change with transactions, and the rest of the server's
+	// fields are set when the member is stored
+	m.Balance = 0
+	m.GroupID = gid
+	m.CreatedAt, m.UpdatedAt = time.Time{}, time.Time{}
+
+	var prevm member.Member
+
+	err := first(gm.DB, "add member", nil, &prevm, "id = ?", m.ID)
+	if err == nil {
+		return &DuplicateIDError{"Member ID already in use", m.ID}
+	} else if !dberr.Is(err, dberr.NotFound) {
+		return err
+	}
+
+	if err := gm.DB.Model(&g).Association("Members").Append(m).Error; err != nil {
+		return dberr.Wrap("add member", err)
+	}
+
+	return touch(gm.DB, "add member", gid)
 }
 
 // FetchMember with the given ID and group ID.
 func (gm *GroupsManager) FetchMember(gid, mid uuid.UUID) (member.Member, error) {
 	var m member.Member
 
-	gm.DB.First(&m, "id = ? AND group_id = ?", mid, gid)
+	err := first(gm.DB, "fetch member", &NotFoundError{"No member found", mid}, &m, "id = ? AND group_id = ?", mid, gid)
 
-	if m.ID != mid {
-		return m, &NotFoundError{"No member found", mid}
-	}
-
-	return m, nil
+	return m, err
 }
 
-// UpdateMember with a new name.
+// UpdateMember with a new name. If the member has a version, it must match
+// the stored one. Versions start at 1, so 0 means no version.
 func (gm *GroupsManager) UpdateMember(gid uuid.UUID, m *member.Member) error {
+	var g group.Group
 	var members []member.Member
 
-	gm.DB.Where("group_id = ?", gid).Find(&members)
+	if err := first(gm.DB, "update member", &NotFoundError{"No group found", gid}, &g, "id = ?", gid); err != nil {
+		return err
+	}
+
+	if g.Archived() {
+		return &ArchivedError{"Archived groups can't be changed", gid, true}
+	}
+
+	if err := gm.DB.Where("group_id = ?", gid).Find(&members).Error; err != nil {
+		return dberr.Wrap("update member", err)
+	}
 
 	if len(members) == 0 {
 		return &NotFoundError{"No member found for the group", gid}
 	}
 
+	// Keeping the member's own name isn't a conflict
 	for _, prevm := range members {
-		if prevm.Name == m.Name {
+		if prevm.ID != m.ID && prevm.Name == m.Name {
 			return &AlreadyPresentError{"Name already in use in the group", gid, m.Name}
 		}
 	}
 
 	var prevm member.Member
 
-	gm.DB.First(&prevm, "id = ? AND group_id = ?", m.ID, gid)
+	if err := first(gm.DB, "update member", &NotFoundError{"No member found", m.ID}, &prevm, "id = ? AND group_id = ?", m.ID, gid); err != nil {
+		return err
+	}
 
-	if prevm.ID != m.ID {
-		return &NotFoundError{"No member found", m.ID}
+	if m.Version != 0 && m.Version != prevm.Version {
+		return &VersionError{"Member was modified by another request", m.ID, m.Version}
 	}
 
-	gm.DB.Model(&prevm).Update("name", m.Name)
+	if err := updateVersioned(gm.DB, "update member", &prevm, map[string]interface{}{"name": m.Name}); err != nil {
+		return err
+	}
 
-	return nil
+	// Only the name changes, the rest is returned as stored
+	m.Balance, m.GroupID = prevm.Balance, prevm.GroupID
+	m.UserID, m.Role = prevm.UserID, prevm.Role
+	m.Version, m.CreatedAt, m.UpdatedAt = prevm.Version, prevm.CreatedAt, prevm.UpdatedAt
+
+	return touch(gm.DB, "update member", gid)
 }
 
-// RemoveMember with the given ID and group ID.
+// RemoveMember with the given ID and group ID, unless it's the group's last
+// owner.
 func (gm *GroupsManager) RemoveMember(gid, mid uuid.UUID) error {
+	var g group.Group
 	var m member.Member
 
-	gm.DB.First(&m, "id = ? AND group_id = ?", mid, gid)
+	if err := first(gm.DB, "remove member", &NotFoundError{"No group found", gid}, &g, "id = ?", gid); err != nil {
+		return err
+	}
 
-	if m.ID != mid {
-		return &NotFoundError{"No member found", mid}
+	if g.Archived() {
+		return &ArchivedError{"Archived groups can't be changed", gid, true}
+	}
+
+	if err := first(gm.DB, "remove member", &NotFoundError{"No member found", mid}, &m, "id = ? AND group_id = ?", mid, gid); err != nil {
+		return err
 	}
 
 	if m.Balance != 0.0 {
 		return &BalanceError{"Can't delete member with balance", gid, mid, m.Balance}
 	}
 
-	gm.DB.Delete(&m)
+	// Groups are never left without an owner
+	if m.Role == member.RoleOwner {
+		var owners int
+		if err := gm.DB.Model(&member.Member{}).Where("group_id = ? AND role = ?", gid, member.RoleOwner).Count(&owners).Error; err != nil {
+			return dberr.Wrap("remove member", err)
+		}
+		if owners <= 1 {
+			return &ForbiddenError{"The group's last owner can't be removed", gid, m.UserID}
+		}
+	}
 
-	return nil
+	// Don't delete the member if its balance changed since it was read
+	res := gm.DB.Where("version = ?", m.Version).Delete(&m)
+	if res.Error != nil {
+		return dberr.Wrap("remove member", res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return &VersionError{"Member was modified by another request", mid, m.Version}
+	}
+
+	if err := gm.DB.Where("member_id = ?", mid).Delete(&member.Preferences{}).Error; err != nil {
+		return dberr.Wrap("remove member", err)
+	}
+
+	return touch(gm.DB, "remove member", gid)
+}
+
+// CreateInvite to join a group, checking that the member to claim is unlinked.
+func (gm *GroupsManager) CreateInvite(i *invite.Invite) error {
+	var g group.Group
+
+	if err := first(gm.DB, "create invite", &NotFoundError{"No group found", i.GroupID}, &g, "id = ?", i.GroupID); err != nil {
+		return err
+	}
+
+	if g.Archived() {
+		return &ArchivedError{"Archived groups can't be changed", i.GroupID, true}
+	}
+
+	if i.MemberID != uuid.Nil {
+		var m member.Member
+
+		if err := first(gm.DB, "create invite", &NotFoundError{"No member found", i.MemberID}, &m, "id = ? AND group_id = ?", i.MemberID, i.GroupID); err != nil {
+			return err
+		}
+
+		if m.UserID != "" {
+			return &AlreadyPresentError{"Member already linked to a user", i.GroupID, m.Name}
+		}
+	}
+
+	return dberr.Wrap("create invite", gm.DB.Create(i).Error)
+}
+
+// RevokeInvite with the given ID and group ID.
+func (gm *GroupsManager) RevokeInvite(gid, iid uuid.UUID) error {
+	var i invite.Invite
+
+	if err := first(gm.DB, "revoke invite", &NotFoundError{"No invite found", iid}, &i, "id = ? AND group_id = ?", iid, gid); err != nil {
+		return err
+	}
+
+	return dberr.Wrap("revoke invite", gm.DB.Model(&i).Update("revoked", true).Error)
+}
+
+// RedeemInvite for the given user, either claiming the invite's member or
+// adding a new one with the given name.
+func (gm *GroupsManager) RedeemInvite(iid uuid.UUID, uid, name string) (member.Member, error) {
+	var m member.Member
+
+	tx := gm.DB.Begin()
+	if err := tx.Error; err != nil {
+		return m, dberr.Wrap("redeem invite", err)
+	}
+
+	m, err := redeemInvite(tx, iid, uid, name)
+	if err != nil {
+		tx.Rollback()
+		return m, err
+	}
+
+	return m, dberr.Wrap("redeem invite", tx.Commit().Error)
+}
+
+func redeemInvite(tx *gorm.DB, iid uuid.UUID, uid, name string) (member.Member, error) {
+	var i invite.Invite
+	var m member.Member
+
+	if err := first(tx, "redeem invite", &NotFoundError{"No invite found", iid}, &i, "id = ?", iid); err != nil {
+		return m, err
+	}
+
+	// Count the use only if the invite is still usable, in a single update so
+	// concurrent redemptions can't use it more times than allowed
+	res := tx.Model(&invite.Invite{}).
+		Where("id = ? AND revoked = ? AND expires_at > ? AND (max_uses = 0 OR uses < max_uses)", iid, false, time.Now()).
+		UpdateColumn("uses", gorm.Expr("uses + 1"))
+	if res.Error != nil {
+		return m, dberr.Wrap("redeem invite", res.Error)
+	}
+	if res.RowsAffected != 1 {
+		return m, &InviteError{"Invite is revoked, expired or used up", iid}
+	}
+
+	var g group.Group
+	if err := first(tx, "redeem invite", &NotFoundError{"No group found", i.GroupID}, &g, "id = ?", i.GroupID); err != nil {
+		return m, err
+	}
+	if g.Archived() {
+		return m, &ArchivedError{"Archived groups can't be changed", i.GroupID, true}
+	}
+
+	// Check if the user is already in the group
+	var members []member.Member
+	if err := tx.Where("group_id = ?", i.GroupID).Find(&members).Error; err != nil {
+		return m, dberr.Wrap("redeem invite", err)
+	}
+	for _, prevm := range members {
+		if prevm.UserID == uid {
+			return m, &AlreadyPresentError{"User already in the group", i.GroupID, prevm.Name}
+		}
+	}
+
+	if i.MemberID != uuid.Nil {
+		// Claim existing member
+		if err := first(tx, "redeem invite", &NotFoundError{"No member found", i.MemberID}, &m, "id = ? AND group_id = ?", i.MemberID, i.GroupID); err != nil {
+			return m, err
+		}
+
+		if m.UserID != "" {
+			return m, &AlreadyPresentError{"Member already linked to a user", i.GroupID, m.Name}
+		}
+
+		if err := updateVersioned(tx, "redeem invite", &m, map[string]interface{}{"user_id": uid, "role": i.Role}); err != nil {
+			return m, err
+		}
+		m.UserID, m.Role = uid, i.Role
+	} else {
+		// Add new member
+		for _, prevm := range members {
+			if prevm.Name == name {
+				return m, &AlreadyPresentError{"Name already in use in the group", i.GroupID, name}
+			}
+		}
+
+		m = member.Member{ID: uuid.New(), Name: name, GroupID: i.GroupID, UserID: uid, Role: i.Role, Version: 1}
+		if err := tx.Create(&m).Error; err != nil {
+			return m, dberr.Wrap("redeem invite", err)
+		}
+	}
+
+	return m, touch(tx, "redeem invite", i.GroupID)
 }
 
 // AddExpense to a group, updating the balance of the members involved.
 func (gm *GroupsManager) AddExpense(e *expense.Expense) error {
+	var g group.Group
+
 	tx := gm.DB.Begin()
+	if err := tx.Error; err != nil {
+		return dberr.Wrap("begin transaction", err)
+	}
+
+	if err := inbox.Record(tx, gm.message); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := first(tx, "add expense", &NotFoundError{"No group found", e.GroupID}, &g, "id = ?", e.GroupID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if g.Archived() {
+		tx.Rollback()
+		return &ArchivedError{"Archived groups can't be changed", e.GroupID, true}
+	}
 
 	// Update payer's balance
 	if err := updateBalance(tx, e.GroupID, e.Payer, e.Amount); err != nil {
@@ -188,7 +658,7 @@ func (gm *GroupsManager) AddExpense(e *expense.Expense) error {
 		rid, err := uuid.Parse(r)
 		if err != nil {
 			tx.Rollback()
-			return err
+			return &UUIDParseError{"Couldn't parse expense recipient ID", r, err}
 		}
 
 		if err := updateBalance(tx, e.GroupID, rid, -recAmount); err != nil {
@@ -197,13 +667,25 @@ func (gm *GroupsManager) AddExpense(e *expense.Expense) error {
 		}
 	}
 
-	tx.Commit()
-	return nil
+	if err := touch(tx, "update balances", e.GroupID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return dberr.Wrap("commit transaction", tx.Commit().Error)
 }
 
 // RemoveExpense from a group, updating the balance of the members involved.
 func (gm *GroupsManager) RemoveExpense(e *expense.Expense) error {
 	tx := gm.DB.Begin()
+	if err := tx.Error; err != nil {
+		return dberr.Wrap("begin transaction", err)
+	}
+
+	if err := inbox.Record(tx, gm.message); err != nil {
+		tx.Rollback()
+		return err
+	}
 
 	// Update payer's balance
 	if err := updateBalance(tx, e.GroupID, e.Payer, -e.Amount); err != nil {
@@ -219,7 +701,7 @@ func (gm *GroupsManager) RemoveExpense(e *expense.Expense) error {
 		rid, err := uuid.Parse(r)
 		if err != nil {
 			tx.Rollback()
-			return err
+			return &UUIDParseError{"Couldn't parse expense recipient ID", r, err}
 		}
 
 		if err := updateBalance(tx, e.GroupID, rid, recAmount); err != nil {
@@ -228,13 +710,37 @@ func (gm *GroupsManager) RemoveExpense(e *expense.Expense) error {
 		}
 	}
 
-	tx.Commit()
-	return nil
+	if err := touch(tx, "update balances", e.GroupID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return dberr.Wrap("commit transaction", tx.Commit().Error)
 }
 
 // AddPayment to a group, updating the balance of the members involved.
 func (gm *GroupsManager) AddPayment(p *payment.Payment) error {
+	var g group.Group
+
 	tx := gm.DB.Begin()
+	if err := tx.Error; err != nil {
+		return dberr.Wrap("begin transaction", err)
+	}
+
+	if err := inbox.Record(tx, gm.message); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := first(tx, "add payment", &NotFoundError{"No group found", p.GroupID}, &g, "id = ?", p.GroupID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if g.Archived() {
+		tx.Rollback()
+		return &ArchivedError{"Archived groups can't be changed", p.GroupID, true}
+	}
 
 	// Update payer's balance
 	if err := updateBalance(tx, p.GroupID, p.Payer, p.Amount); err != nil {
@@ -248,13 +754,25 @@ func (gm *GroupsManager) AddPayment(p *payment.Payment) error {
 		return err
 	}
 
-	tx.Commit()
-	return nil
+	if err := touch(tx, "update balances", p.GroupID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return dberr.Wrap("commit transaction", tx.Commit().Error)
 }
 
 // RemovePayment from a group, updating the balance of the members involved.
 func (gm *GroupsManager) RemovePayment(p *payment.Payment) error {
 	tx := gm.DB.Begin()
+	if err := tx.Error; err != nil {
+		return dberr.Wrap("begin transaction", err)
+	}
+
+	if err := inbox.Record(tx, gm.message); err != nil {
+		tx.Rollback()
+		return err
+	}
 
 	// Update payer's balance
 	if err := updateBalance(tx, p.GroupID, p.Payer, -p.Amount); err != nil {
@@ -268,20 +786,272 @@ func (gm *GroupsManager) RemovePayment(p *payment.Payment) error {
 		return err
 	}
 
-	tx.Commit()
+	if err := touch(tx, "update balances", p.GroupID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return dberr.Wrap("commit transaction", tx.Commit().Error)
+}
+
+// DeliveryLogSize is the number of deliveries listed for a webhook.
+const DeliveryLogSize = 50
+
+// CreateWebhook for a group, which receives the group's events from then on.
+func (gm *GroupsManager) CreateWebhook(w *webhook.Webhook) error {
+	var g group.Group
+
+	if err := first(gm.DB, "create webhook", &NotFoundError{"No group found", w.GroupID}, &g, "id = ?", w.GroupID); err != nil {
+		return err
+	}
+
+	if g.Archived() {
+		return &ArchivedError{"Archived groups can't be changed", w.GroupID, true}
+	}
+
+	if w.ID == uuid.Nil {
+		w.ID = uuid.New()
+	}
+	w.CreatedAt = time.Now().UTC()
+
+	return dberr.Wrap("create webhook", gm.DB.Create(w).Error)
+}
+
+// ListWebhooks of a group, oldest first, without their secrets.
+func (gm *GroupsManager) ListWebhooks(gid uuid.UUID) ([]webhook.Webhook, error) {
+	hooks := []webhook.Webhook{}
+
+	if err := gm.DB.Where("group_id = ?", gid).Order("created_at").Find(&hooks).Error; err != nil {
+		return hooks, dberr.Wrap("list webhooks", err)
+	}
+
+	for i := range hooks {
+		hooks[i].Secret = ""
+	}
+
+	return hooks, nil
+}
+
+// DeleteWebhook with the given ID and group ID, along with its deliveries.
+func (gm *GroupsManager) DeleteWebhook(gid, wid uuid.UUID) error {
+	var w webhook.Webhook
+
+	if err := first(gm.DB, "delete webhook", &NotFoundError{"No webhook found", wid}, &w, "id = ? AND group_id = ?", wid, gid); err != nil {
+		return err
+	}
+
+	tx := gm.DB.Begin()
+	if err := tx.Error; err != nil {
+		return dberr.Wrap("begin transaction", err)
+	}
+
+	if err := tx.Where("webhook_id = ?", wid).Delete(&webhook.Delivery{}).Error; err != nil {
+		tx.Rollback()
+		return dberr.Wrap("delete webhook", err)
+	}
+
+	if err := tx.Delete(&w).Error; err != nil {
+		tx.Rollback()
+		return dberr.Wrap("delete webhook", err)
+	}
+
+	return dberr.Wrap("commit transaction", tx.Commit().Error)
+}
+
+// ListDeliveries of a webhook, latest first, up to DeliveryLogSize.
+func (gm *GroupsManager) ListDeliveries(gid, wid uuid.UUID) ([]webhook.Delivery, error) {
+	var w webhook.Webhook
+	deliveries := []webhook.Delivery{}
+
+	if err := first(gm.DB, "list deliveries", &NotFoundError{"No webhook found", wid}, &w, "id = ? AND group_id = ?", wid, gid); err != nil {
+		return deliveries, err
+	}
+
+	err := gm.DB.Where("webhook_id = ?", wid).
+		Order("created_at DESC").
+		Limit(DeliveryLogSize).
+		Find(&deliveries).Error
+
+	return deliveries, dberr.Wrap("list deliveries", err)
+}
+
+// Redeliver the event of a delivery as a new delivery, attempted as soon as
+// possible. The original delivery is kept in the log.
+func (gm *GroupsManager) Redeliver(gid, wid, did uuid.UUID) (webhook.Delivery, error) {
+	var d webhook.Delivery
+
+	if err := first(gm.DB, "redeliver", &NotFoundError{"No delivery found", did}, &d, "id = ? AND webhook_id = ? AND group_id = ?", did, wid, gid); err != nil {
+		return d, err
+	}
+
+	now := time.Now().UTC()
+	redelivery := webhook.Delivery{
+		ID:            uuid.New(),
+		WebhookID:     d.WebhookID,
+		GroupID:       d.GroupID,
+		EventID:       d.EventID,
+		Event:         d.Event,
+		Payload:       d.Payload,
+		State:         webhook.StatePending,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+	}
+
+	return redelivery, dberr.Wrap("redeliver", gm.DB.Create(&redelivery).Error)
+}
+
+// QueueEvent of a group for delivery to the webhooks that receive it.
+func (gm *GroupsManager) QueueEvent(gid uuid.UUID, event string, data interface{}) error {
+	var hooks []webhook.Webhook
+
+	if err := gm.DB.Where("group_id = ?", gid).Find(&hooks).Error; err != nil {
+		return dberr.Wrap("queue event", err)
+	}
+
+	var eid uuid.UUID
+	var payload string
+	now := time.Now().UTC()
+
+	for _, w := range hooks {
+		if !w.Matches(event) {
+			continue
+		}
+
+		// Every webhook gets the same payload
+		if payload == "" {
+			var err error
+			if eid, payload, err = webhook.NewPayload(event, gid, data); err != nil {
+				return err
+			}
+		}
+
+		d := webhook.Delivery{
+			ID:            uuid.New(),
+			WebhookID:     w.ID,
+			GroupID:       gid,
+			EventID:       eid,
+			Event:         event,
+			Payload:       payload,
+			State:         webhook.StatePending,
+			NextAttemptAt: now,
+			CreatedAt:     now,
+		}
+		if err := gm.DB.Create(&d).Error; err != nil {
+			return dberr.Wrap("queue event", err)
+		}
+	}
+
 	return nil
 }
 
-func updateBalance(tx *gorm.DB, gid, mid uuid.UUID, amount float32) error {
+// FetchPreferences of a member, which has every notification turned off
+// until the member sets them.
+func (gm *GroupsManager) FetchPreferences(gid, mid uuid.UUID) (member.Preferences, error) {
+	p := member.Preferences{MemberID: mid, GroupID: gid}
+
 	var m member.Member
+	if err := first(gm.DB, "fetch preferences", &NotFoundError{"No member found", mid}, &m, "id = ? AND group_id = ?", mid, gid); err != nil {
+		return p, err
+	}
 
-	tx.First(&m, "id = ? AND group_id = ?", mid, gid)
+	err := first(gm.DB, "fetch preferences", nil, &p, "member_id = ?", mid)
+	if dberr.Is(err, dberr.NotFound) {
+		return p, nil
+	}
 
-	if m.ID != mid {
+	return p, err
+}
+
+// UpdatePreferences of a member, replacing the previous ones.
+func (gm *GroupsManager) UpdatePreferences(p *member.Preferences) error {
+	var m member.Member
+
+	if err := first(gm.DB, "update preferences", &NotFoundError{"No member found", p.MemberID}, &m, "id = ? AND group_id = ?", p.MemberID, p.GroupID); err != nil {
+		return err
+	}
+
+	p.UpdatedAt = time.Now().UTC()
+
+	return dberr.Wrap("update preferences", gm.DB.Save(p).Error)
+}
+
+// updateBalance adds the amount to the member's balance in a single
+// statement, so concurrent updates can't overwrite each other.
+func updateBalance(tx *gorm.DB, gid, mid uuid.UUID, amount float32) error {
+	res := tx.Model(&member.Member{}).
+		Where("id = ? AND group_id = ?", mid, gid).
+		UpdateColumns(map[string]interface{}{
+			"balance":    gorm.Expr("balance + ?", amount),
+			"version":    gorm.Expr("version + 1"),
+			"updated_at": time.Now().UTC(),
+		})
+
+	if res.Error != nil {
+		return dberr.Wrap("update balance", res.Error)
+	}
+	if res.RowsAffected == 0 {
 		return &NotFoundError{"No member found", mid}
 	}
 
-	tx.Model(&m).Update("balance", m.Balance+amount)
+	return nil
+}
+
+// updateVersioned applies the changes to the member if its version didn't
+// change since it was read, increasing the version.
+func updateVersioned(db *gorm.DB, op string, m *member.Member, changes map[string]interface{}) error {
+	now := time.Now().UTC()
+	changes["version"] = m.Version + 1
+	changes["updated_at"] = now
+
+	res := db.Model(&member.Member{}).
+		Where("id = ? AND version = ?", m.ID, m.Version).
+		UpdateColumns(changes)
+
+	if res.Error != nil {
+		return dberr.Wrap(op, res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return &VersionError{"Member was modified by another request", m.ID, m.Version}
+	}
+
+	m.Version++
+	m.UpdatedAt = now
+	return nil
+}
+
+// updateGroupVersioned applies the changes to the group if its version
+// didn't change since it was read, increasing the version.
+func updateGroupVersioned(db *gorm.DB, op string, g *group.Group, changes map[string]interface{}) error {
+	changes["version"] = g.Version + 1
+	changes["updated_at"] = time.Now().UTC()
+
+	res := db.Model(&group.Group{}).
+		Where("id = ? AND version = ?", g.ID, g.Version).
+		UpdateColumns(changes)
+
+	if res.Error != nil {
+		return dberr.Wrap(op, res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return &VersionError{"Group was modified by another request", g.ID, g.Version}
+	}
 
+	g.Version++
 	return nil
 }
+
+// first loads the first record matching the query, returning notFound
+// instead if there's none and it isn't nil.
+func first(db *gorm.DB, op string, notFound error, out interface{}, where ...interface{}) error {
+	err := db.First(out, where...).Error
+	if notFound != nil && gorm.IsRecordNotFoundError(err) {
+		return notFound
+	}
+
+	return dberr.Wrap(op, err)
+}
+
+// touch records activity in the group, moving its last activity time.
+func touch(db *gorm.DB, op string, gid uuid.UUID) error {
+	return dberr.Wrap(op, db.Model(&group.Group{}).Where("id = ?", gid).UpdateColumn("active_at", time.Now().UTC()).Error)
+}
@@ -1,11 +1,25 @@
 package gmicro_test
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+	"github.com/varrrro/pay-up/internal/dberr"
+	"github.com/varrrro/pay-up/internal/gmicro"
 	"github.com/varrrro/pay-up/internal/gmicro/group"
+	"github.com/varrrro/pay-up/internal/gmicro/invite"
 	"github.com/varrrro/pay-up/internal/gmicro/member"
+	"github.com/varrrro/pay-up/internal/inbox"
 	"github.com/varrrro/pay-up/internal/tmicro/expense"
 	"github.com/varrrro/pay-up/internal/tmicro/payment"
 )
@@ -13,17 +27,123 @@ import (
 func TestCreateGroup(t *testing.T) {
 	g := group.Group{ID: uuid.New(), Name: "test"}
 
-	if err := gm.CreateGroup(&g); err != nil {
+	if err := gm.CreateGroup(&g, nil); err != nil {
 		t.Errorf("Couldn't create group. Error: %s", err.Error())
 	}
 
 	clearDB()
 }
 
+func TestCreateGroupOwner(t *testing.T) {
+	g := group.Group{ID: uuid.New(), Name: "test"}
+	owner := member.Member{Name: "owner", UserID: "user1", Role: member.RoleViewer, Balance: 10}
+
+	if err := gm.CreateGroup(&g, &owner); err != nil {
+		t.Errorf("Couldn't create group. Error: %s", err.Error())
+	}
+
+	if g, err := gm.FetchGroup(g.ID); err != nil {
+		t.Errorf("Couldn't fetch group. Error: %s", err.Error())
+	} else if len(g.Members) != 1 || g.Members[0].UserID != "user1" || g.Members[0].Role != member.RoleOwner || g.Members[0].Balance != 0 {
+		t.Errorf("Owner wasn't added correctly [Members]: %+v", g.Members)
+	}
+
+	clearDB()
+}
+
+func TestCreateGroupOwnerFails(t *testing.T) {
+	g := group.Group{ID: uuid.New(), Name: "test"}
+	gm.CreateGroup(&g, nil)
+	m := member.Member{ID: uuid.New(), Name: "test"}
+	gm.AddMember(g.ID, &m)
+
+	// The owner can't be added with an ID in use, so the group isn't created
+	g2 := group.Group{ID: uuid.New(), Name: "test2"}
+	if err := gm.CreateGroup(&g2, &member.Member{ID: m.ID, Name: "owner", UserID: "user1"}); err == nil {
+		t.Error("Creating group with a failing owner didn't return an error")
+	}
+
+	if _, err := gm.FetchGroup(g2.ID); err == nil {
+		t.Error("Group was created without its owner")
+	}
+
+	clearDB()
+}
+
+func TestCreateGroupGeneratedID(t *testing.T) {
+	g := group.Group{Name: "test"}
+
+	if err := gm.CreateGroup(&g, nil); err != nil {
+		t.Errorf("Couldn't create group. Error: %s", err.Error())
+	} else if g.ID == uuid.Nil {
+		t.Error("Group ID wasn't generated")
+	}
+
+	clearDB()
+}
+
+func TestCreateGroupDuplicate(t *testing.T) {
+	g := group.Group{ID: uuid.New(), Name: "test"}
+
+	if err := gm.CreateGroup(&g, nil); err != nil {
+		t.Errorf("Couldn't create group. Error: %s", err.Error())
+	}
+
+	g2 := group.Group{ID: g.ID, Name: "duplicate"}
+
+	if err := gm.CreateGroup(&g2, nil); err == nil {
+		t.Error("Creating group with duplicate ID didn't return an error")
+	}
+
+	if g3, err := gm.FetchGroup(g.ID); err != nil {
+		t.Errorf("Couldn't fetch group. Error: %s", err.Error())
+	} else if g3.Name != g.Name {
+		t.Error("Group was overwritten")
+	}
+
+	clearDB()
+}
+
+func TestManagerDatabaseUnavailable(t *testing.T) {
+	bad := gmicro.NewManager(closedDB())
+	id := uuid.New()
+
+	cases := []struct {
+		name string
+		call func() error
+	}{
+		{"CreateGroup", func() error { return bad.CreateGroup(&group.Group{Name: "test"}, nil) }},
+		{"FetchGroup", func() error { _, err := bad.FetchGroup(id); return err }},
+		{"ListGroups", func() error { _, err := bad.ListGroups(gmicro.GroupQuery{UserID: "test"}); return err }},
+		{"UpdateGroup", func() error { return bad.UpdateGroup(&group.Group{ID: id, Name: "test"}) }},
+		{"ArchiveGroup", func() error { return bad.ArchiveGroup(id) }},
+		{"DeleteGroup", func() error { return bad.DeleteGroup(id, false) }},
+		{"PurgeGroup", func() error { return bad.PurgeGroup(id) }},
+		{"RestoreGroup", func() error { _, err := bad.RestoreGroup(id); return err }},
+		{"AddMember", func() error { return bad.AddMember(id, &member.Member{Name: "test"}) }},
+		{"FetchMember", func() error { _, err := bad.FetchMember(id, id); return err }},
+		{"UpdateMember", func() error { return bad.UpdateMember(id, &member.Member{ID: id, Name: "test"}) }},
+		{"RemoveMember", func() error { return bad.RemoveMember(id, id) }},
+		{"CreateInvite", func() error { return bad.CreateInvite(&invite.Invite{ID: id, GroupID: id}) }},
+		{"RevokeInvite", func() error { return bad.RevokeInvite(id, id) }},
+		{"RedeemInvite", func() error { _, err := bad.RedeemInvite(id, "user", "test"); return err }},
+		{"AddExpense", func() error { return bad.AddExpense(&expense.Expense{GroupID: id, Payer: id, Recipients: id.String()}) }},
+		{"AddPayment", func() error { return bad.AddPayment(&payment.Payment{GroupID: id, Payer: id, Recipient: id}) }},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.call(); !dberr.Is(err, dberr.Unavailable) {
+				t.Errorf("Wrong error [Expected]: %v [Actual]: %v", dberr.Unavailable, err)
+			}
+		})
+	}
+}
+
 func TestFetchGroup(t *testing.T) {
 	g := group.Group{ID: uuid.New(), Name: "test"}
 
-	if err := gm.CreateGroup(&g); err != nil {
+	if err := gm.CreateGroup(&g, nil); err != nil {
 		t.Errorf("Couldn't create group. Error: %s", err.Error())
 	}
 
@@ -46,10 +166,88 @@ func TestFetchGroupNotFound(t *testing.T) {
 	clearDB()
 }
 
+func TestListGroups(t *testing.T) {
+	names := []string{"Delta trip", "Alpha", "Gamma trip", "Epsilon", "Beta 100%"}
+	ids := make(map[string]uuid.UUID)
+	for _, n := range names {
+		g := group.Group{Name: n}
+		gm.CreateGroup(&g, nil)
+		addOwner(g.ID)
+		ids[n] = g.ID
+	}
+	gm.AddMember(ids["Alpha"], &member.Member{Name: "Other"})
+
+	// Groups of other users aren't listed
+	other := group.Group{Name: "Another user's"}
+	gm.CreateGroup(&other, nil)
+
+	list := func(q gmicro.GroupQuery) []string {
+		t.Helper()
+		var res []string
+		for {
+			page, err := gm.ListGroups(q)
+			if err != nil {
+				t.Fatalf("Couldn't list groups. Error: %s", err.Error())
+			}
+			if q.Limit > 0 && len(page.Groups) > q.Limit {
+				t.Fatalf("Page is over the limit [Limit]: %d [Size]: %d", q.Limit, len(page.Groups))
+			}
+			for _, g := range page.Groups {
+				res = append(res, g.Name)
+			}
+			if page.NextCursor == "" {
+				return res
+			}
+			q.Cursor = page.NextCursor
+		}
+	}
+
+	check := func(expected, actual []string) {
+		t.Helper()
+		if !reflect.DeepEqual(expected, actual) {
+			t.Errorf("Wrong groups [Expected]: %v [Actual]: %v", expected, actual)
+		}
+	}
+
+	check([]string{"Alpha", "Beta 100%", "Delta trip", "Epsilon", "Gamma trip"}, list(gmicro.GroupQuery{UserID: owner, Limit: 2}))
+	check([]string{"Gamma trip", "Epsilon", "Delta trip", "Beta 100%", "Alpha"}, list(gmicro.GroupQuery{UserID: owner, Sort: "name", Desc: true, Limit: 2}))
+	check([]string{"Beta 100%", "Epsilon", "Gamma trip", "Alpha", "Delta trip"}, list(gmicro.GroupQuery{UserID: owner, Sort: "created_at", Desc: true, Limit: 3}))
+	check([]string{"Delta trip", "Gamma trip"}, list(gmicro.GroupQuery{UserID: owner, Name: "TRIP"}))
+	check([]string{"Beta 100%"}, list(gmicro.GroupQuery{UserID: owner, Name: "0%"}))
+	check(nil, list(gmicro.GroupQuery{UserID: owner, Name: "_"}))
+	check(nil, list(gmicro.GroupQuery{UserID: "nobody"}))
+
+	// Archived groups are only listed when asked for
+	gm.ArchiveGroup(ids["Epsilon"])
+	check([]string{"Beta 100%", "Delta trip"}, list(gmicro.GroupQuery{UserID: owner, Name: "e", Limit: 1}))
+	check([]string{"Alpha", "Beta 100%", "Delta trip", "Epsilon", "Gamma trip"}, list(gmicro.GroupQuery{UserID: owner, Archived: true}))
+
+	// Activity moves groups to the front
+	gm.UpdateGroup(&group.Group{ID: ids["Delta trip"], Name: "Delta trip"})
+	check([]string{"Delta trip", "Alpha"}, list(gmicro.GroupQuery{UserID: owner, Sort: "active_at", Desc: true})[:2])
+
+	// Summaries count members
+	page, _ := gm.ListGroups(gmicro.GroupQuery{UserID: owner, Name: "Alpha"})
+	if len(page.Groups) != 1 || page.Groups[0].Members != 2 || page.Groups[0].CreatedAt.IsZero() {
+		t.Errorf("Wrong summary: %+v", page.Groups)
+	}
+
+	// Cursors only work with the sort they were returned for
+	page, _ = gm.ListGroups(gmicro.GroupQuery{UserID: owner, Limit: 1})
+	if _, err := gm.ListGroups(gmicro.GroupQuery{UserID: owner, Sort: "created_at", Cursor: page.NextCursor}); err == nil {
+		t.Error("Listing with cursor for another sort didn't return an error")
+	}
+	if _, err := gm.ListGroups(gmicro.GroupQuery{UserID: owner, Cursor: "invalid"}); err == nil {
+		t.Error("Listing with invalid cursor didn't return an error")
+	}
+
+	clearDB()
+}
+
 func TestUpdateGroup(t *testing.T) {
 	g := group.Group{ID: uuid.New(), Name: "test"}
 
-	if err := gm.CreateGroup(&g); err != nil {
+	if err := gm.CreateGroup(&g, nil); err != nil {
 		t.Errorf("Couldn't create group. Error: %s", err.Error())
 	}
 
@@ -68,6 +266,34 @@ func TestUpdateGroup(t *testing.T) {
 	clearDB()
 }
 
+func TestUpdateGroupVersionConflict(t *testing.T) {
+	g := group.Group{ID: uuid.New(), Name: "test"}
+
+	if err := gm.CreateGroup(&g, nil); err != nil {
+		t.Errorf("Couldn't create group. Error: %s", err.Error())
+	}
+
+	first := group.Group{ID: g.ID, Name: "first", Version: g.Version}
+	if err := gm.UpdateGroup(&first); err != nil {
+		t.Errorf("Couldn't update group. Error: %s", err.Error())
+	} else if first.Version != g.Version+1 {
+		t.Errorf("Version wasn't increased [Expected]: %d [Actual]: %d", g.Version+1, first.Version)
+	}
+
+	second := group.Group{ID: g.ID, Name: "second", Version: g.Version}
+	if err := gm.UpdateGroup(&second); err == nil {
+		t.Error("Updating stale group didn't return an error")
+	}
+
+	if g, err := gm.FetchGroup(g.ID); err != nil {
+		t.Errorf("Couldn't fetch group. Error: %s", err.Error())
+	} else if g.Name != "first" {
+		t.Errorf("Stale update was applied [Name]: %s", g.Name)
+	}
+
+	clearDB()
+}
+
 func TestUpdateGroupNotFound(t *testing.T) {
 	g := group.Group{ID: uuid.New(), Name: "updated"}
 
@@ -78,27 +304,190 @@ func TestUpdateGroupNotFound(t *testing.T) {
 	clearDB()
 }
 
-func TestRemoveGroup(t *testing.T) {
+func TestArchiveGroup(t *testing.T) {
 	g := group.Group{ID: uuid.New(), Name: "test"}
 
-	if err := gm.CreateGroup(&g); err != nil {
+	if err := gm.CreateGroup(&g, nil); err != nil {
 		t.Errorf("Couldn't create group. Error: %s", err.Error())
 	}
 
-	if err := gm.RemoveGroup(g.ID); err != nil {
-		t.Errorf("Couldn't delete group. Error: %s", err.Error())
+	if err := gm.ArchiveGroup(g.ID); err != nil {
+		t.Errorf("Couldn't archive group. Error: %s", err.Error())
 	}
 
-	if _, err := gm.FetchGroup(g.ID); err == nil {
-		t.Error("Fetching group after deletion didn't return an error")
+	if g2, err := gm.FetchGroup(g.ID); err != nil {
+		t.Errorf("Couldn't fetch archived group. Error: %s", err.Error())
+	} else if !g2.Archived() || g2.Version != 2 {
+		t.Error("Group wasn't archived")
+	}
+
+	if err := gm.ArchiveGroup(g.ID); err != nil {
+		t.Errorf("Archiving archived group returned an error. Error: %s", err.Error())
 	}
 
 	clearDB()
 }
 
-func TestRemoveGroupNotFound(t *testing.T) {
-	if err := gm.RemoveGroup(uuid.New()); err == nil {
-		t.Error("Deleting non-existant group didn't return an error")
+func TestArchivedGroupChanges(t *testing.T) {
+	g := group.Group{ID: uuid.New(), Name: "test"}
+	gm.CreateGroup(&g, nil)
+	m1 := member.Member{ID: uuid.New(), Name: "test1"}
+	gm.AddMember(g.ID, &m1)
+	m2 := member.Member{ID: uuid.New(), Name: "test2"}
+	gm.AddMember(g.ID, &m2)
+	gm.ArchiveGroup(g.ID)
+
+	cases := []struct {
+		name   string
+		change func() error
+	}{
+		{"Update member", func() error { return gm.UpdateMember(g.ID, &member.Member{ID: m1.ID, Name: "Renamed"}) }},
+		{"Remove member", func() error { return gm.RemoveMember(g.ID, m1.ID) }},
+		{"Add expense", func() error {
+			return gm.AddExpense(&expense.Expense{GroupID: g.ID, Amount: 10, Payer: m1.ID, Recipients: m2.ID.String()})
+		}},
+		{"Add payment", func() error {
+			return gm.AddPayment(&payment.Payment{GroupID: g.ID, Amount: 10, Payer: m1.ID, Recipient: m2.ID})
+		}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var aerr *gmicro.ArchivedError
+			if err := tc.change(); !errors.As(err, &aerr) {
+				t.Errorf("Wrong error [Expected]: %T [Actual]: %v", aerr, err)
+			}
+		})
+	}
+
+	// Nothing changed
+	if m, _ := gm.FetchMember(g.ID, m1.ID); m.Name != "test1" || m.Balance != 0 {
+		t.Errorf("Archived member changed [Name]: %s [Balance]: %f", m.Name, m.Balance)
+	}
+
+	clearDB()
+}
+
+func TestRestoreGroup(t *testing.T) {
+	g := group.Group{ID: uuid.New(), Name: "test"}
+	gm.CreateGroup(&g, nil)
+	gm.AddMember(g.ID, &member.Member{Name: "test"})
+
+	if _, err := gm.RestoreGroup(g.ID); err == nil {
+		t.Error("Restoring group that isn't archived didn't return an error")
+	}
+
+	gm.ArchiveGroup(g.ID)
+
+	// Archived groups can't be changed
+	if err := gm.UpdateGroup(&group.Group{ID: g.ID, Name: "updated"}); err == nil {
+		t.Error("Updating archived group didn't return an error")
+	}
+	if err := gm.AddMember(g.ID, &member.Member{Name: "other"}); err == nil {
+		t.Error("Adding member to archived group didn't return an error")
+	}
+
+	if g2, err := gm.RestoreGroup(g.ID); err != nil {
+		t.Errorf("Couldn't restore group. Error: %s", err.Error())
+	} else if g2.Archived() || g2.Version != 3 || len(g2.Members) != 1 {
+		t.Error("Group wasn't restored with its members")
+	}
+
+	if _, err := gm.RestoreGroup(uuid.New()); err == nil {
+		t.Error("Restoring non-existant group didn't return an error")
+	}
+
+	clearDB()
+}
+
+func TestTimestamps(t *testing.T) {
+	before := time.Now().Add(-time.Second)
+
+	g := group.Group{ID: uuid.New(), Name: "test", Description: "Test group"}
+	gm.CreateGroup(&g, nil)
+	m := member.Member{ID: uuid.New(), Name: "test"}
+	gm.AddMember(g.ID, &m)
+
+	g2, _ := gm.FetchGroup(g.ID)
+	if g2.Description != "Test group" {
+		t.Errorf("Wrong description [Expected]: %s [Actual]: %s", "Test group", g2.Description)
+	}
+	if g2.CreatedAt.Before(before) || g2.UpdatedAt.Before(before) || g2.Members[0].CreatedAt.Before(before) {
+		t.Error("Timestamps weren't set on creation")
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	gm.UpdateGroup(&group.Group{ID: g.ID, Name: "updated"})
+	gm.UpdateMember(g.ID, &member.Member{ID: m.ID, Name: "updated"})
+
+	g3, _ := gm.FetchGroup(g.ID)
+	if !g3.UpdatedAt.After(g2.UpdatedAt) || !g3.Members[0].UpdatedAt.After(g2.Members[0].UpdatedAt) {
+		t.Error("Update timestamps didn't move")
+	}
+	if !g3.CreatedAt.Equal(g2.CreatedAt) {
+		t.Error("Creation timestamp changed on update")
+	}
+
+	clearDB()
+}
+
+func TestArchiveGroupNotFound(t *testing.T) {
+	if err := gm.ArchiveGroup(uuid.New()); err == nil {
+		t.Error("Archiving non-existant group didn't return an error")
+	}
+
+	clearDB()
+}
+
+func TestDeleteGroup(t *testing.T) {
+	g := group.Group{ID: uuid.New(), Name: "test"}
+	gm.CreateGroup(&g, nil)
+	m := member.Member{ID: uuid.New(), Name: "test", Balance: 10}
+	addMember(g.ID, &m)
+	gm.CreateInvite(&invite.Invite{ID: uuid.New(), GroupID: g.ID, Role: member.RoleMember, ExpiresAt: time.Now().Add(time.Hour)})
+
+	// Unsettled balances need forcing
+	if err := gm.DeleteGroup(g.ID, false); err == nil {
+		t.Error("Deleting group with balances didn't return an error")
+	}
+	if err := gm.DeleteGroup(g.ID, true); err != nil {
+		t.Errorf("Couldn't delete group. Error: %s", err.Error())
+	}
+
+	if g2, err := gm.FetchGroup(g.ID); err != nil {
+		t.Errorf("Couldn't fetch group being deleted. Error: %s", err.Error())
+	} else if !g2.Archived() || !g2.Deleting {
+		t.Error("Group wasn't archived and marked as being deleted")
+	}
+	if _, err := gm.RestoreGroup(g.ID); err == nil {
+		t.Error("Restoring group being deleted didn't return an error")
+	}
+
+	// Cancelled deletions leave the group archived
+	if err := gm.CancelDeleteGroup(g.ID); err != nil {
+		t.Errorf("Couldn't cancel group deletion. Error: %s", err.Error())
+	}
+	if g2, _ := gm.FetchGroup(g.ID); !g2.Archived() || g2.Deleting {
+		t.Error("Group deletion wasn't cancelled")
+	}
+
+	// Purging removes the group with its members and invites
+	if err := gm.PurgeGroup(g.ID); err != nil {
+		t.Errorf("Couldn't purge group. Error: %s", err.Error())
+	}
+	if _, err := gm.FetchGroup(g.ID); err == nil {
+		t.Error("Fetching purged group didn't return an error")
+	}
+
+	var members, invites int
+	db.Model(&member.Member{}).Where("group_id = ?", g.ID).Count(&members)
+	db.Model(&invite.Invite{}).Where("group_id = ?", g.ID).Count(&invites)
+	if members != 0 || invites != 0 {
+		t.Errorf("Group wasn't purged [Members]: %d [Invites]: %d", members, invites)
+	}
+
+	if err := gm.PurgeGroup(g.ID); err != nil {
+		t.Errorf("Purging missing group returned an error. Error: %s", err.Error())
 	}
 
 	clearDB()
@@ -107,7 +496,7 @@ func TestRemoveGroupNotFound(t *testing.T) {
 func TestAddMember(t *testing.T) {
 	g := group.Group{ID: uuid.New(), Name: "test"}
 
-	if err := gm.CreateGroup(&g); err != nil {
+	if err := gm.CreateGroup(&g, nil); err != nil {
 		t.Errorf("Couldn't create group. Error: %s", err.Error())
 	}
 
@@ -126,6 +515,83 @@ func TestAddMember(t *testing.T) {
 	clearDB()
 }
 
+func TestAddMemberServerFields(t *testing.T) {
+	g := group.Group{ID: uuid.New(), Name: "test"}
+	gm.CreateGroup(&g, nil)
+
+	// Balances can't be set from outside, only changed by transactions
+	m := member.Member{ID: uuid.New(), Name: "test", Balance: 1e6, GroupID: uuid.New()}
+	if err := gm.AddMember(g.ID, &m); err != nil {
+		t.Errorf("Couldn't create member. Error: %s", err.Error())
+	}
+
+	if m, err := gm.FetchMember(g.ID, m.ID); err != nil {
+		t.Errorf("Couldn't fetch member. Error: %s", err.Error())
+	} else if m.Balance != 0 || m.GroupID != g.ID {
+		t.Errorf("Server fields were taken from the member [Balance]: %f [Group ID]: %s", m.Balance, m.GroupID)
+	}
+
+	m.Name = "updated"
+	m.Balance = 1e6
+	if err := gm.UpdateMember(g.ID, &m); err != nil {
+		t.Errorf("Couldn't update member. Error: %s", err.Error())
+	} else if m.Balance != 0 {
+		t.Errorf("Wrong balance returned [Expected]: %d [Actual]: %f", 0, m.Balance)
+	}
+
+	if m, err := gm.FetchMember(g.ID, m.ID); err != nil {
+		t.Errorf("Couldn't fetch member. Error: %s", err.Error())
+	} else if m.Name != "updated" || m.Balance != 0 {
+		t.Errorf("Member wasn't updated correctly [Name]: %s [Balance]: %f", m.Name, m.Balance)
+	}
+
+	clearDB()
+}
+
+func TestAddMemberGeneratedID(t *testing.T) {
+	g := group.Group{ID: uuid.New(), Name: "test"}
+
+	if err := gm.CreateGroup(&g, nil); err != nil {
+		t.Errorf("Couldn't create group. Error: %s", err.Error())
+	}
+
+	m := member.Member{Name: "test"}
+
+	if err := gm.AddMember(g.ID, &m); err != nil {
+		t.Errorf("Couldn't create member. Error: %s", err.Error())
+	} else if m.ID == uuid.Nil {
+		t.Error("Member ID wasn't generated")
+	}
+
+	if _, err := gm.FetchMember(g.ID, m.ID); err != nil {
+		t.Errorf("Couldn't fetch member. Error: %s", err.Error())
+	}
+
+	clearDB()
+}
+
+func TestAddMemberDuplicateID(t *testing.T) {
+	g := group.Group{ID: uuid.New(), Name: "test"}
+
+	if err := gm.CreateGroup(&g, nil); err != nil {
+		t.Errorf("Couldn't create group. Error: %s", err.Error())
+	}
+
+	m := member.Member{ID: uuid.New(), Name: "test"}
+
+	if err := gm.AddMember(g.ID, &m); err != nil {
+		t.Errorf("Couldn't create member. Error: %s", err.Error())
+	}
+
+	m2 := member.Member{ID: m.ID, Name: "other"}
+
+	if err := gm.AddMember(g.ID, &m2); err == nil {
+		t.Error("Adding member with duplicate ID didn't return an error")
+	}
+
+	clearDB()
+}
+
 func TestAddMemberNotFound(t *testing.T) {
 	m := member.Member{ID: uuid.New(), Name: "test"}
 
@@ -139,7 +605,7 @@ func TestAddMemberNotFound(t *testing.T) {
 func TestAddMemberRepeated(t *testing.T) {
 	g := group.Group{ID: uuid.New(), Name: "test"}
 
-	if err := gm.CreateGroup(&g); err != nil {
+	if err := gm.CreateGroup(&g, nil); err != nil {
 		t.Errorf("Couldn't create group. Error: %s", err.Error())
 	}
 
@@ -165,7 +631,7 @@ func TestAddMemberRepeated(t *testing.T) {
 func TestFetchMember(t *testing.T) {
 	g := group.Group{ID: uuid.New(), Name: "test"}
 
-	if err := gm.CreateGroup(&g); err != nil {
+	if err := gm.CreateGroup(&g, nil); err != nil {
 		t.Errorf("Couldn't create group. Error: %s", err.Error())
 	}
 
@@ -187,7 +653,7 @@ func TestFetchMember(t *testing.T) {
 func TestFetchMemberNotFound(t *testing.T) {
 	g := group.Group{ID: uuid.New(), Name: "test"}
 
-	if err := gm.CreateGroup(&g); err != nil {
+	if err := gm.CreateGroup(&g, nil); err != nil {
 		t.Errorf("Couldn't create group. Error: %s", err.Error())
 	}
 
@@ -201,7 +667,7 @@ func TestFetchMemberNotFound(t *testing.T) {
 func TestUpdateMember(t *testing.T) {
 	g := group.Group{ID: uuid.New(), Name: "test"}
 
-	if err := gm.CreateGroup(&g); err != nil {
+	if err := gm.CreateGroup(&g, nil); err != nil {
 		t.Errorf("Couldn't create group. Error: %s", err.Error())
 	}
 
@@ -226,10 +692,23 @@ func TestUpdateMember(t *testing.T) {
 	clearDB()
 }
 
+func TestUpdateMemberSameName(t *testing.T) {
+	g := group.Group{ID: uuid.New(), Name: "test"}
+	gm.CreateGroup(&g, nil)
+	m := member.Member{ID: uuid.New(), Name: "test"}
+	gm.AddMember(g.ID, &m)
+
+	if err := gm.UpdateMember(g.ID, &member.Member{ID: m.ID, Name: "test"}); err != nil {
+		t.Errorf("Keeping member's name returned an error [Error]: %v", err)
+	}
+
+	clearDB()
+}
+
 func TestUpdateMemberNotFound(t *testing.T) {
 	g := group.Group{ID: uuid.New(), Name: "test"}
 
-	if err := gm.CreateGroup(&g); err != nil {
+	if err := gm.CreateGroup(&g, nil); err != nil {
 		t.Errorf("Couldn't create group. Error: %s", err.Error())
 	}
 
@@ -242,10 +721,197 @@ func TestUpdateMemberNotFound(t *testing.T) {
 	clearDB()
 }
 
+func TestUpdateMemberVersionConflict(t *testing.T) {
+	g := group.Group{ID: uuid.New(), Name: "test"}
+
+	if err := gm.CreateGroup(&g, nil); err != nil {
+		t.Errorf("Couldn't create group. Error: %s", err.Error())
+	}
+
+	m := member.Member{ID: uuid.New(), Name: "test"}
+
+	if err := gm.AddMember(g.ID, &m); err != nil {
+		t.Errorf("Couldn't create member. Error: %s", err.Error())
+	}
+
+	// Change the member's balance after it was read
+	p := payment.Payment{GroupID: g.ID, Amount: 5, Payer: m.ID, Recipient: m.ID}
+	if err := gm.AddPayment(&p); err != nil {
+		t.Errorf("Couldn't add payment. Error: %s", err.Error())
+	}
+
+	stale := member.Member{ID: m.ID, Name: "stale", Version: m.Version}
+	if err := gm.UpdateMember(g.ID, &stale); err == nil {
+		t.Error("Updating stale member didn't return an error")
+	}
+
+	current, _ := gm.FetchMember(g.ID, m.ID)
+	current.Name = "current"
+	if err := gm.UpdateMember(g.ID, &current); err != nil {
+		t.Errorf("Couldn't update member. Error: %s", err.Error())
+	}
+
+	if m, err := gm.FetchMember(g.ID, m.ID); err != nil {
+		t.Errorf("Couldn't fetch member. Error: %s", err.Error())
+	} else if m.Name != "current" || m.Version != current.Version {
+		t.Errorf("Member wasn't updated correctly [Name]: %s [Version]: %d", m.Name, m.Version)
+	}
+
+	clearDB()
+}
+
+func TestConcurrentBalanceUpdates(t *testing.T) {
+	const workers = 8
+	const expenses = 1500
+	const payments = 500
+
+	// Use a file so every connection shares the database
+	dir, err := ioutil.TempDir("", "gmicro")
+	if err != nil {
+		t.Fatalf("Can't create temporary directory [Error]: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cdb, err := gorm.Open("sqlite3", "file:"+filepath.Join(dir, "test.db")+"?_busy_timeout=10000&_journal_mode=WAL")
+	if err != nil {
+		t.Fatalf("Can't open database [Error]: %v", err)
+	}
+	defer cdb.Close()
+	cdb.CreateTable(&group.Group{}, &member.Member{})
+
+	cgm := gmicro.NewManager(cdb)
+
+	g := group.Group{Name: "test"}
+	cgm.CreateGroup(&g, nil)
+	a := member.Member{Name: "a"}
+	cgm.AddMember(g.ID, &a)
+	b := member.Member{Name: "b"}
+	cgm.AddMember(g.ID, &b)
+
+	// Each expense moves 1 from b to a, each payment moves it back
+	ops := make(chan func() error, expenses+payments)
+	for i := 0; i < expenses+payments; i++ {
+		if i%4 == 3 {
+			ops <- func() error {
+				return cgm.AddPayment(&payment.Payment{GroupID: g.ID, Amount: 1, Payer: b.ID, Recipient: a.ID})
+			}
+		} else {
+			ops <- func() error {
+				return cgm.AddExpense(&expense.Expense{GroupID: g.ID, Amount: 2, Payer: a.ID, Recipients: a.ID.String() + ";" + b.ID.String()})
+			}
+		}
+	}
+	close(ops)
+
+	// Apply operations concurrently, retrying like the AMQP consumer does
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for op := range ops {
+				err := op()
+				for dberr.Temporary(err) {
+					err = op()
+				}
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Fatalf("Couldn't update balances [Error]: %v", err)
+	}
+
+	if a, err := cgm.FetchMember(g.ID, a.ID); err != nil {
+		t.Errorf("Couldn't fetch member. Error: %s", err.Error())
+	} else if a.Balance != expenses-payments {
+		t.Errorf("Balance wasn't updated correctly. [Expected]: %d [Actual]: %f", expenses-payments, a.Balance)
+	} else if a.Version != 1+2*expenses+payments {
+		t.Errorf("Version wasn't updated correctly. [Expected]: %d [Actual]: %d", 1+2*expenses+payments, a.Version)
+	}
+
+	if b, err := cgm.FetchMember(g.ID, b.ID); err != nil {
+		t.Errorf("Couldn't fetch member. Error: %s", err.Error())
+	} else if b.Balance != payments-expenses {
+		t.Errorf("Balance wasn't updated correctly. [Expected]: %d [Actual]: %f", payments-expenses, b.Balance)
+	}
+}
+
+func TestConcurrentRedeemInvite(t *testing.T) {
+	const users = 8
+
+	// Use a file so every connection shares the database
+	dir, err := ioutil.TempDir("", "gmicro")
+	if err != nil {
+		t.Fatalf("Can't create temporary directory [Error]: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cdb, err := gorm.Open("sqlite3", "file:"+filepath.Join(dir, "test.db")+"?_busy_timeout=10000&_journal_mode=WAL")
+	if err != nil {
+		t.Fatalf("Can't open database [Error]: %v", err)
+	}
+	defer cdb.Close()
+	cdb.CreateTable(&group.Group{}, &member.Member{}, &invite.Invite{})
+
+	cgm := gmicro.NewManager(cdb)
+
+	g := group.Group{Name: "test"}
+	cgm.CreateGroup(&g, nil)
+	i := invite.Invite{ID: uuid.New(), GroupID: g.ID, Role: member.RoleMember, ExpiresAt: time.Now().Add(time.Hour), MaxUses: 1}
+	cgm.CreateInvite(&i)
+
+	// Redeem the single-use invite for every user at once, retrying like the
+	// handler's callers would
+	var wg sync.WaitGroup
+	results := make(chan error, users)
+	for u := 0; u < users; u++ {
+		wg.Add(1)
+		go func(u int) {
+			defer wg.Done()
+			uid := fmt.Sprintf("user%d", u)
+			_, err := cgm.RedeemInvite(i.ID, uid, uid)
+			for dberr.Temporary(err) {
+				_, err = cgm.RedeemInvite(i.ID, uid, uid)
+			}
+			results <- err
+		}(u)
+	}
+	wg.Wait()
+	close(results)
+
+	redeemed := 0
+	for err := range results {
+		if err == nil {
+			redeemed++
+		} else if _, ok := err.(*gmicro.InviteError); !ok {
+			t.Errorf("Wrong redeem error [Error]: %v", err)
+		}
+	}
+	if redeemed != 1 {
+		t.Errorf("Wrong number of redemptions [Expected]: %d [Actual]: %d", 1, redeemed)
+	}
+
+	var used invite.Invite
+	cdb.First(&used, "id = ?", i.ID)
+	var members int
+	cdb.Model(&member.Member{}).Where("group_id = ?", g.ID).Count(&members)
+	if used.Uses != 1 || members != 1 {
+		t.Errorf("Invite was used more than allowed [Uses]: %d [Members]: %d", used.Uses, members)
+	}
+}
+
 func TestUpdateMemberAlreadyPresent(t *testing.T) {
 	g := group.Group{ID: uuid.New(), Name: "test"}
 
-	if err := gm.CreateGroup(&g); err != nil {
+	if err := gm.CreateGroup(&g, nil); err != nil {
 		t.Errorf("Couldn't create group. Error: %s", err.Error())
 	}
 
@@ -279,7 +945,7 @@ func TestUpdateMemberAlreadyPresent(t *testing.T) {
 func TestRemoveMember(t *testing.T) {
 	g := group.Group{ID: uuid.New(), Name: "test"}
 
-	if err := gm.CreateGroup(&g); err != nil {
+	if err := gm.CreateGroup(&g, nil); err != nil {
 		t.Errorf("Couldn't create group. Error: %s", err.Error())
 	}
 
@@ -306,10 +972,29 @@ func TestRemoveMember(t *testing.T) {
 	clearDB()
 }
 
+func TestRemoveMemberLastOwner(t *testing.T) {
+	g := group.Group{ID: uuid.New(), Name: "test"}
+	o1 := member.Member{ID: uuid.New(), Name: "Owner1", UserID: "owner1"}
+	gm.CreateGroup(&g, &o1)
+	o2 := member.Member{ID: uuid.New(), Name: "Owner2", UserID: "owner2", Role: member.RoleOwner}
+	gm.AddMember(g.ID, &o2)
+
+	if err := gm.RemoveMember(g.ID, o1.ID); err != nil {
+		t.Errorf("Couldn't remove owner [Error]: %v", err)
+	}
+
+	var ferr *gmicro.ForbiddenError
+	if err := gm.RemoveMember(g.ID, o2.ID); !errors.As(err, &ferr) {
+		t.Errorf("Wrong error removing last owner [Expected]: %T [Actual]: %v", ferr, err)
+	}
+
+	clearDB()
+}
+
 func TestRemoveMemberNotFound(t *testing.T) {
 	g := group.Group{ID: uuid.New(), Name: "test"}
 
-	if err := gm.CreateGroup(&g); err != nil {
+	if err := gm.CreateGroup(&g, nil); err != nil {
 		t.Errorf("Couldn't create group. Error: %s", err.Error())
 	}
 
@@ -320,10 +1005,103 @@ func TestRemoveMemberNotFound(t *testing.T) {
 	clearDB()
 }
 
+func TestRedeemInviteNewMember(t *testing.T) {
+	g := group.Group{ID: uuid.New(), Name: "test"}
+
+	if err := gm.CreateGroup(&g, nil); err != nil {
+		t.Errorf("Couldn't create group. Error: %s", err.Error())
+	}
+
+	i := invite.Invite{ID: uuid.New(), GroupID: g.ID, Role: member.RoleMember, ExpiresAt: time.Now().Add(time.Hour), MaxUses: 1}
+
+	if err := gm.CreateInvite(&i); err != nil {
+		t.Errorf("Couldn't create invite. Error: %s", err.Error())
+	}
+
+	if m, err := gm.RedeemInvite(i.ID, "user1", "test1"); err != nil {
+		t.Errorf("Couldn't redeem invite. Error: %s", err.Error())
+	} else if m.UserID != "user1" || m.Role != member.RoleMember || m.GroupID != g.ID {
+		t.Error("New member wasn't linked to user correctly")
+	}
+
+	if _, err := gm.RedeemInvite(i.ID, "user2", "test2"); err == nil {
+		t.Error("Redeeming used up invite didn't return an error")
+	}
+
+	clearDB()
+}
+
+func TestRedeemInviteClaimMember(t *testing.T) {
+	g := group.Group{ID: uuid.New(), Name: "test"}
+
+	if err := gm.CreateGroup(&g, nil); err != nil {
+		t.Errorf("Couldn't create group. Error: %s", err.Error())
+	}
+
+	m := member.Member{ID: uuid.New(), Name: "test", Balance: 12.5}
+
+	if err := addMember(g.ID, &m); err != nil {
+		t.Errorf("Couldn't create member. Error: %s", err.Error())
+	}
+
+	i := invite.Invite{ID: uuid.New(), GroupID: g.ID, MemberID: m.ID, Role: member.RoleAdmin, ExpiresAt: time.Now().Add(time.Hour)}
+
+	if err := gm.CreateInvite(&i); err != nil {
+		t.Errorf("Couldn't create invite. Error: %s", err.Error())
+	}
+
+	if m2, err := gm.RedeemInvite(i.ID, "user1", "ignored"); err != nil {
+		t.Errorf("Couldn't redeem invite. Error: %s", err.Error())
+	} else if m2.ID != m.ID || m2.UserID != "user1" || m2.Role != member.RoleAdmin || m2.Balance != m.Balance {
+		t.Error("Existing member wasn't claimed correctly")
+	}
+
+	if _, err := gm.RedeemInvite(i.ID, "user2", "test2"); err == nil {
+		t.Error("Claiming already linked member didn't return an error")
+	}
+
+	if err := gm.CreateInvite(&invite.Invite{ID: uuid.New(), GroupID: g.ID, MemberID: m.ID}); err == nil {
+		t.Error("Creating invite for linked member didn't return an error")
+	}
+
+	clearDB()
+}
+
+func TestRedeemInviteUnusable(t *testing.T) {
+	g := group.Group{ID: uuid.New(), Name: "test"}
+
+	if err := gm.CreateGroup(&g, nil); err != nil {
+		t.Errorf("Couldn't create group. Error: %s", err.Error())
+	}
+
+	expired := invite.Invite{ID: uuid.New(), GroupID: g.ID, ExpiresAt: time.Now().Add(-time.Hour)}
+	revoked := invite.Invite{ID: uuid.New(), GroupID: g.ID, ExpiresAt: time.Now().Add(time.Hour)}
+	gm.CreateInvite(&expired)
+	gm.CreateInvite(&revoked)
+
+	if err := gm.RevokeInvite(g.ID, revoked.ID); err != nil {
+		t.Errorf("Couldn't revoke invite. Error: %s", err.Error())
+	}
+
+	if _, err := gm.RedeemInvite(expired.ID, "user1", "test1"); err == nil {
+		t.Error("Redeeming expired invite didn't return an error")
+	}
+
+	if _, err := gm.RedeemInvite(revoked.ID, "user1", "test1"); err == nil {
+		t.Error("Redeeming revoked invite didn't return an error")
+	}
+
+	if _, err := gm.RedeemInvite(uuid.New(), "user1", "test1"); err == nil {
+		t.Error("Redeeming non-existant invite didn't return an error")
+	}
+
+	clearDB()
+}
+
 func TestAddExpense(t *testing.T) {
 	g := group.Group{ID: uuid.New(), Name: "test"}
 
-	if err := gm.CreateGroup(&g); err != nil {
+	if err := gm.CreateGroup(&g, nil); err != nil {
 		t.Errorf("Couldn't create group. Error: %s", err.Error())
 	}
 
@@ -380,7 +1158,7 @@ func TestAddExpense(t *testing.T) {
 func TestRemoveExpense(t *testing.T) {
 	g := group.Group{ID: uuid.New(), Name: "test"}
 
-	if err := gm.CreateGroup(&g); err != nil {
+	if err := gm.CreateGroup(&g, nil); err != nil {
 		t.Errorf("Couldn't create group. Error: %s", err.Error())
 	}
 
@@ -437,7 +1215,7 @@ func TestRemoveExpense(t *testing.T) {
 func TestAddPayment(t *testing.T) {
 	g := group.Group{ID: uuid.New(), Name: "test"}
 
-	if err := gm.CreateGroup(&g); err != nil {
+	if err := gm.CreateGroup(&g, nil); err != nil {
 		t.Errorf("Couldn't create group. Error: %s", err.Error())
 	}
 
@@ -479,10 +1257,35 @@ func TestAddPayment(t *testing.T) {
 	clearDB()
 }
 
+func TestAddPaymentReplayed(t *testing.T) {
+	g := group.Group{ID: uuid.New(), Name: "test"}
+	gm.CreateGroup(&g, nil)
+	m1 := member.Member{ID: uuid.New(), Name: "test1"}
+	gm.AddMember(g.ID, &m1)
+	m2 := member.Member{ID: uuid.New(), Name: "test2"}
+	gm.AddMember(g.ID, &m2)
+
+	// Every replica records the message in the same database
+	p := payment.Payment{GroupID: g.ID, Amount: 10, Payer: m1.ID, Recipient: m2.ID}
+	ctx := inbox.WithMessageID(context.Background(), "msg1")
+	if err := gm.WithContext(ctx).AddPayment(&p); err != nil {
+		t.Fatalf("Couldn't add payment [Error]: %v", err)
+	}
+	if err := gmicro.NewManager(db).WithContext(ctx).AddPayment(&p); err != inbox.ErrHandled {
+		t.Errorf("Wrong error [Expected]: %v [Actual]: %v", inbox.ErrHandled, err)
+	}
+
+	if m1, _ := gm.FetchMember(g.ID, m1.ID); m1.Balance != 10 {
+		t.Errorf("Wrong balance [Expected]: %f [Actual]: %f", 10.0, m1.Balance)
+	}
+
+	clearDB()
+}
+
 func TestRemovePayment(t *testing.T) {
 	g := group.Group{ID: uuid.New(), Name: "test"}
 
-	if err := gm.CreateGroup(&g); err != nil {
+	if err := gm.CreateGroup(&g, nil); err != nil {
 		t.Errorf("Couldn't create group. Error: %s", err.Error())
 	}
 
@@ -1,11 +1,62 @@
 package member
 
-import "github.com/google/uuid"
+import (
+	"time"
 
-// Member of a group.
+	"github.com/google/uuid"
+)
+
+// Member of a group. Version is increased on every change, so concurrent
+// updates can be detected.
 type Member struct {
-	ID      uuid.UUID `json:"id" gorm:"type:uuid;primary_key"`
-	Name    string    `json:"name"`
-	Balance float32   `json:"balance"`
-	GroupID uuid.UUID `json:"group_id" gorm:"type:uuid"`
+	ID        uuid.UUID `json:"id" gorm:"type:uuid;primary_key"`
+	Name      string    `json:"name"`
+	Balance   float32   `json:"balance"`
+	GroupID   uuid.UUID `json:"group_id" gorm:"type:uuid"`
+	UserID    string    `json:"user_id,omitempty"`
+	Role      Role      `json:"role,omitempty"`
+	Version   int       `json:"version" gorm:"not null;default:0"`
+	CreatedAt time.Time `json:"created_at" gorm:"not null;default:CURRENT_TIMESTAMP"`
+	UpdatedAt time.Time `json:"updated_at" gorm:"not null;default:CURRENT_TIMESTAMP"`
+}
+
+// Role of a user account inside a group.
+type Role string
+
+// Roles ordered from least to most privileged.
+const (
+	RoleViewer Role = "viewer"
+	RoleMember Role = "member"
+	RoleAdmin  Role = "admin"
+	RoleOwner  Role = "owner"
+)
+
+var ranks = map[Role]int{
+	RoleViewer: 1,
+	RoleMember: 2,
+	RoleAdmin:  3,
+	RoleOwner:  4,
+}
+
+// Valid checks if the role is one of the known roles.
+func (r Role) Valid() bool {
+	_, ok := ranks[r]
+	return ok
+}
+
+// Includes checks if the role grants at least the permissions of another one.
+func (r Role) Includes(o Role) bool {
+	return ranks[r] >= ranks[o]
+}
+
+// Preferences of a member about the emails they get from the notifier. A
+// threshold of 0 means they aren't told when they're owed money.
+type Preferences struct {
+	MemberID      uuid.UUID `json:"member_id" gorm:"type:uuid;primary_key"`
+	GroupID       uuid.UUID `json:"group_id" gorm:"type:uuid"`
+	Email         string    `json:"email"`
+	ExpenseAdded  bool      `json:"expense_added"`
+	OwedThreshold float32   `json:"owed_threshold"`
+	WeeklyDigest  bool      `json:"weekly_digest"`
+	UpdatedAt     time.Time `json:"updated_at" gorm:"not null;default:CURRENT_TIMESTAMP"`
 }
@@ -0,0 +1,73 @@
+package gmicro
+
+import (
+	"github.com/jinzhu/gorm"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/varrrro/pay-up/internal/metrics"
+)
+
+var (
+	groupsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metrics.Namespace, "gmicro", "groups"),
+		"Groups stored, by state.",
+		[]string{"state"}, nil,
+	)
+	outstandingDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metrics.Namespace, "gmicro", "outstanding_balance"),
+		"Total owed to members across every group, as the sum of positive balances.",
+		nil, nil,
+	)
+)
+
+// StatsCollector exposes the number of groups and the outstanding balance,
+// read from the database each time metrics are scraped.
+type StatsCollector struct {
+	db *gorm.DB
+}
+
+// NewStatsCollector that reads from the given database.
+func NewStatsCollector(db *gorm.DB) *StatsCollector {
+	return &StatsCollector{db: db}
+}
+
+// Describe the metrics of the collector.
+func (c *StatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- groupsDesc
+	ch <- outstandingDesc
+}
+
+// Collect the current number of groups and outstanding balance.
+func (c *StatsCollector) Collect(ch chan<- prometheus.Metric) {
+	var states []struct {
+		State string
+		Count int
+	}
+	err := c.db.Table("groups").
+		Select(`CASE WHEN deleting THEN 'deleting' WHEN archived_at IS NOT NULL THEN 'archived' ELSE 'active' END AS state, COUNT(*) AS count`).
+		Group("state").
+		Scan(&states).Error
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(groupsDesc, err)
+	} else {
+		counts := map[string]int{"active": 0, "archived": 0, "deleting": 0}
+		for _, s := range states {
+			counts[s.State] = s.Count
+		}
+		for state, n := range counts {
+			ch <- prometheus.MustNewConstMetric(groupsDesc, prometheus.GaugeValue, float64(n), state)
+		}
+	}
+
+	var total struct {
+		Total float64
+	}
+	err = c.db.Table("members").
+		Select("COALESCE(SUM(balance), 0) AS total").
+		Where("balance > 0").
+		Scan(&total).Error
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(outstandingDesc, err)
+	} else {
+		ch <- prometheus.MustNewConstMetric(outstandingDesc, prometheus.GaugeValue, total.Total)
+	}
+}
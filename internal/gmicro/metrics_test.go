@@ -0,0 +1,63 @@
+package gmicro_test
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/varrrro/pay-up/internal/gmicro"
+	"github.com/varrrro/pay-up/internal/gmicro/group"
+	"github.com/varrrro/pay-up/internal/gmicro/member"
+)
+
+func TestStatsCollector(t *testing.T) {
+	active := group.Group{ID: uuid.New(), Name: "active"}
+	archived := group.Group{ID: uuid.New(), Name: "archived"}
+	gm.CreateGroup(&active, nil)
+	gm.CreateGroup(&archived, nil)
+	addMember(active.ID, &member.Member{ID: uuid.New(), Name: "lender", Balance: 12.5})
+	addMember(active.ID, &member.Member{ID: uuid.New(), Name: "borrower", Balance: -12.5})
+	addMember(archived.ID, &member.Member{ID: uuid.New(), Name: "lender", Balance: 2.5})
+	gm.ArchiveGroup(archived.ID)
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(gmicro.NewStatsCollector(db))
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Couldn't gather metrics [Error]: %v", err)
+	}
+
+	values := map[string]float64{}
+	for _, f := range families {
+		for _, m := range f.GetMetric() {
+			name := f.GetName()
+			for _, l := range m.GetLabel() {
+				name += "/" + l.GetValue()
+			}
+			values[name] = m.GetGauge().GetValue()
+		}
+	}
+
+	expected := map[string]float64{
+		"payup_gmicro_groups/active":       1,
+		"payup_gmicro_groups/archived":     1,
+		"payup_gmicro_groups/deleting":     0,
+		"payup_gmicro_outstanding_balance": 15,
+	}
+	for name, v := range expected {
+		if values[name] != v {
+			t.Errorf("Wrong metric value [Metric]: %s [Expected]: %v [Actual]: %v", name, v, values[name])
+		}
+	}
+
+	clearDB()
+}
+
+func TestStatsCollectorDatabaseUnavailable(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(gmicro.NewStatsCollector(closedDB()))
+
+	if _, err := reg.Gather(); err == nil {
+		t.Error("Gathering from an unavailable database didn't return an error")
+	}
+}
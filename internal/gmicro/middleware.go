@@ -2,20 +2,25 @@ package gmicro
 
 import (
 	"net/http"
+	"time"
 
-	log "github.com/sirupsen/logrus"
+	"github.com/varrrro/pay-up/internal/logging"
+	"github.com/varrrro/pay-up/internal/metrics"
+	"github.com/varrrro/pay-up/internal/problem"
+	"github.com/varrrro/pay-up/internal/signature"
 )
 
-// LoggingMiddleware that logs requests received.
+// SignatureSkew is the maximum clock difference allowed for signed requests.
+const SignatureSkew = 5 * time.Minute
+
+// LoggingMiddleware that tags requests with a request ID, logs them and
+// records their metrics.
 func LoggingMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
-		log.WithFields(log.Fields{
-			"uri":    r.URL,
-			"method": r.Method,
-		}).Info("Request received")
+	return metrics.Middleware(logging.Middleware(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		logging.FromContext(r.Context()).Info("Request received")
 
 		next.ServeHTTP(rw, r)
-	})
+	})))
 }
 
 // ContentTypeMiddleware that sets application/json in response header.
@@ -25,3 +30,18 @@ func ContentTypeMiddleware(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// SignatureMiddleware that rejects requests not signed by the gateway.
+func SignatureMiddleware(secret []byte) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			if err := signature.Verify(r, secret, SignatureSkew, time.Now()); err != nil {
+				logging.FromContext(r.Context()).WithError(err).Warn("Can't verify request signature")
+				problem.Write(rw, r, problem.New(http.StatusUnauthorized, "invalid_signature", "Request isn't signed by the gateway"))
+				return
+			}
+
+			next.ServeHTTP(rw, r)
+		})
+	}
+}
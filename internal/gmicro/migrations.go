@@ -0,0 +1,258 @@
+package gmicro
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+	"github.com/varrrro/pay-up/internal/migrate"
+)
+
+// MigrationsTable keeping the applied migrations of the groups' schema.
+const MigrationsTable = "gmicro_migrations"
+
+// Migrations of the groups' schema, in order. Applied migrations must never
+// change, since deployed databases already went through them.
+var Migrations = []migrate.Migration{
+	{
+		Version: 1,
+		Name:    "create_groups_and_members",
+		Up: func(tx *gorm.DB) error {
+			if err := migrate.CreateTable(tx, "groups", &groupV1{}); err != nil {
+				return err
+			}
+			return migrate.CreateTable(tx, "members", &memberV1{})
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := migrate.DropTable(tx, "members"); err != nil {
+				return err
+			}
+			return migrate.DropTable(tx, "groups")
+		},
+	},
+	{
+		Version: 2,
+		Name:    "add_member_roles",
+		Up: func(tx *gorm.DB) error {
+			return migrate.AddColumns(tx, "members", &memberV2{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return migrate.DropColumns(tx, "members", &memberV1{}, "user_id", "role")
+		},
+	},
+	{
+		Version: 3,
+		Name:    "create_invites",
+		Up: func(tx *gorm.DB) error {
+			return migrate.CreateTable(tx, "invites", &inviteV3{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return migrate.DropTable(tx, "invites")
+		},
+	},
+	{
+		Version: 4,
+		Name:    "add_versions",
+		Up: func(tx *gorm.DB) error {
+			if err := migrate.AddColumns(tx, "groups", &groupV4{}); err != nil {
+				return err
+			}
+			return migrate.AddColumns(tx, "members", &memberV4{})
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := migrate.DropColumns(tx, "groups", &groupV1{}, "version"); err != nil {
+				return err
+			}
+			return migrate.DropColumns(tx, "members", &memberV2{}, "version")
+		},
+	},
+	{
+		Version: 5,
+		Name:    "add_timestamps_descriptions_and_archival",
+		Up: func(tx *gorm.DB) error {
+			if err := migrate.AddColumns(tx, "groups", &groupV5{}); err != nil {
+				return err
+			}
+			return migrate.AddColumns(tx, "members", &memberV5{})
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := migrate.DropColumns(tx, "groups", &groupV4{}, "description", "created_at", "updated_at", "active_at", "archived_at"); err != nil {
+				return err
+			}
+			return migrate.DropColumns(tx, "members", &memberV4{}, "created_at", "updated_at")
+		},
+	},
+	{
+		Version: 6,
+		Name:    "add_group_deleting",
+		Up: func(tx *gorm.DB) error {
+			return migrate.AddColumns(tx, "groups", &groupV6{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return migrate.DropColumns(tx, "groups", &groupV5{}, "deleting")
+		},
+	},
+	{
+		Version: 7,
+		Name:    "create_webhooks",
+		Up: func(tx *gorm.DB) error {
+			if err := migrate.CreateTable(tx, "webhooks", &webhookV7{}); err != nil {
+				return err
+			}
+			return migrate.CreateTable(tx, "deliveries", &deliveryV7{})
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := migrate.DropTable(tx, "deliveries"); err != nil {
+				return err
+			}
+			return migrate.DropTable(tx, "webhooks")
+		},
+	},
+	{
+		Version: 8,
+		Name:    "create_preferences",
+		Up: func(tx *gorm.DB) error {
+			return migrate.CreateTable(tx, "preferences", &preferencesV8{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return migrate.DropTable(tx, "preferences")
+		},
+	},
+	{
+		Version: 9,
+		Name:    "create_handled_messages",
+		Up: func(tx *gorm.DB) error {
+			return migrate.CreateTable(tx, "handled_messages", &handledMessageV9{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return migrate.DropTable(tx, "handled_messages")
+		},
+	},
+}
+
+// Snapshots of the schema at each version.
+
+type groupV1 struct {
+	ID   uuid.UUID `gorm:"type:uuid;primary_key"`
+	Name string
+}
+
+type groupV4 struct {
+	ID      uuid.UUID `gorm:"type:uuid;primary_key"`
+	Name    string
+	Version int `gorm:"not null;default:0"`
+}
+
+type groupV5 struct {
+	ID          uuid.UUID `gorm:"type:uuid;primary_key"`
+	Name        string
+	Description string    `gorm:"not null;default:''"`
+	Version     int       `gorm:"not null;default:0"`
+	CreatedAt   time.Time `gorm:"not null;default:CURRENT_TIMESTAMP"`
+	UpdatedAt   time.Time `gorm:"not null;default:CURRENT_TIMESTAMP"`
+	ActiveAt    time.Time `gorm:"not null;default:CURRENT_TIMESTAMP"`
+	ArchivedAt  *time.Time
+}
+
+type groupV6 struct {
+	ID          uuid.UUID `gorm:"type:uuid;primary_key"`
+	Name        string
+	Description string    `gorm:"not null;default:''"`
+	Version     int       `gorm:"not null;default:0"`
+	CreatedAt   time.Time `gorm:"not null;default:CURRENT_TIMESTAMP"`
+	UpdatedAt   time.Time `gorm:"not null;default:CURRENT_TIMESTAMP"`
+	ActiveAt    time.Time `gorm:"not null;default:CURRENT_TIMESTAMP"`
+	ArchivedAt  *time.Time
+	Deleting    bool `gorm:"not null;default:false"`
+}
+
+type memberV1 struct {
+	ID      uuid.UUID `gorm:"type:uuid;primary_key"`
+	Name    string
+	Balance float32
+	GroupID uuid.UUID `gorm:"type:uuid"`
+}
+
+type memberV2 struct {
+	ID      uuid.UUID `gorm:"type:uuid;primary_key"`
+	Name    string
+	Balance float32
+	GroupID uuid.UUID `gorm:"type:uuid"`
+	UserID  string
+	Role    string
+}
+
+type memberV4 struct {
+	ID      uuid.UUID `gorm:"type:uuid;primary_key"`
+	Name    string
+	Balance float32
+	GroupID uuid.UUID `gorm:"type:uuid"`
+	UserID  string
+	Role    string
+	Version int `gorm:"not null;default:0"`
+}
+
+type memberV5 struct {
+	ID        uuid.UUID `gorm:"type:uuid;primary_key"`
+	Name      string
+	Balance   float32
+	GroupID   uuid.UUID `gorm:"type:uuid"`
+	UserID    string
+	Role      string
+	Version   int       `gorm:"not null;default:0"`
+	CreatedAt time.Time `gorm:"not null;default:CURRENT_TIMESTAMP"`
+	UpdatedAt time.Time `gorm:"not null;default:CURRENT_TIMESTAMP"`
+}
+
+type inviteV3 struct {
+	ID        uuid.UUID `gorm:"type:uuid;primary_key"`
+	GroupID   uuid.UUID `gorm:"type:uuid"`
+	MemberID  uuid.UUID `gorm:"type:uuid"`
+	Role      string
+	CreatedBy string
+	ExpiresAt time.Time
+	MaxUses   int
+	Uses      int
+	Revoked   bool
+}
+
+type webhookV7 struct {
+	ID        uuid.UUID `gorm:"type:uuid;primary_key"`
+	GroupID   uuid.UUID `gorm:"type:uuid;index"`
+	URL       string
+	Secret    string
+	Events    string
+	CreatedBy string
+	CreatedAt time.Time `gorm:"not null;default:CURRENT_TIMESTAMP"`
+}
+
+type deliveryV7 struct {
+	ID            uuid.UUID `gorm:"type:uuid;primary_key"`
+	WebhookID     uuid.UUID `gorm:"type:uuid;index"`
+	GroupID       uuid.UUID `gorm:"type:uuid"`
+	EventID       uuid.UUID `gorm:"type:uuid"`
+	Event         string
+	Payload       string `gorm:"type:text"`
+	State         string `gorm:"index"`
+	Attempts      int
+	StatusCode    int
+	LastError     string
+	NextAttemptAt time.Time
+	CreatedAt     time.Time `gorm:"not null;default:CURRENT_TIMESTAMP"`
+	DeliveredAt   *time.Time
+}
+
+type preferencesV8 struct {
+	MemberID      uuid.UUID `gorm:"type:uuid;primary_key"`
+	GroupID       uuid.UUID `gorm:"type:uuid;index"`
+	Email         string
+	ExpenseAdded  bool
+	OwedThreshold float32
+	WeeklyDigest  bool
+	UpdatedAt     time.Time `gorm:"not null;default:CURRENT_TIMESTAMP"`
+}
+
+type handledMessageV9 struct {
+	ID        string `gorm:"primary_key"`
+	HandledAt time.Time
+}
@@ -0,0 +1,121 @@
+package gmicro_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/postgres"
+	"github.com/varrrro/pay-up/internal/gmicro"
+	"github.com/varrrro/pay-up/internal/gmicro/group"
+	"github.com/varrrro/pay-up/internal/gmicro/invite"
+	"github.com/varrrro/pay-up/internal/gmicro/member"
+	"github.com/varrrro/pay-up/internal/gmicro/webhook"
+	"github.com/varrrro/pay-up/internal/inbox"
+	"github.com/varrrro/pay-up/internal/migrate"
+)
+
+// migrationDBs to run the migrations against, which include PostgreSQL when
+// TEST_POSTGRES_CONN is set.
+func migrationDBs(t *testing.T) map[string]*gorm.DB {
+	dbs := make(map[string]*gorm.DB)
+
+	mdb, _ := gorm.Open("sqlite3", ":memory:")
+	mdb.DB().SetMaxOpenConns(1) // every connection opens a new in-memory database
+	dbs["sqlite3"] = mdb
+
+	if conn := os.Getenv("TEST_POSTGRES_CONN"); conn != "" {
+		pdb, err := gorm.Open("postgres", conn)
+		if err != nil {
+			t.Fatalf("Can't open PostgreSQL database [Error]: %v", err)
+		}
+		dbs["postgres"] = pdb
+	}
+
+	return dbs
+}
+
+// checkModels fails the test unless every column of the models exists.
+func checkModels(t *testing.T, mdb *gorm.DB) {
+	for _, model := range []interface{}{&group.Group{}, &member.Member{}, &invite.Invite{}, &webhook.Webhook{}, &webhook.Delivery{}, &member.Preferences{}, &inbox.Message{}} {
+		scope := mdb.NewScope(model)
+		for _, f := range scope.GetModelStruct().StructFields {
+			if f.IsNormal && !f.IsIgnored && !mdb.Dialect().HasColumn(scope.TableName(), f.DBName) {
+				t.Errorf("Missing column [Table]: %s [Column]: %s", scope.TableName(), f.DBName)
+			}
+		}
+	}
+}
+
+func TestMigrations(t *testing.T) {
+	for name, mdb := range migrationDBs(t) {
+		t.Run(name, func(t *testing.T) {
+			defer mdb.Close()
+			defer mdb.DropTableIfExists(gmicro.MigrationsTable)
+			mig, _ := migrate.New(mdb, gmicro.MigrationsTable, gmicro.Migrations)
+
+			if _, err := mig.Up(); err != nil {
+				t.Fatalf("Couldn't apply migrations [Error]: %v", err)
+			}
+			checkModels(t, mdb)
+
+			// Groups work on the migrated schema
+			mgm := gmicro.NewManager(mdb)
+			g := group.Group{ID: uuid.New(), Name: "Test"}
+			if err := mgm.CreateGroup(&g, nil); err != nil {
+				t.Errorf("Couldn't create group [Error]: %v", err)
+			}
+			if err := mgm.AddMember(g.ID, &member.Member{ID: uuid.New(), Name: "Test"}); err != nil {
+				t.Errorf("Couldn't add member [Error]: %v", err)
+			}
+
+			// Every migration can be reverted and applied again
+			if done, err := mig.Down(len(gmicro.Migrations) - 1); err != nil || len(done) != len(gmicro.Migrations)-1 {
+				t.Fatalf("Couldn't revert migrations [Reverted]: %v [Error]: %v", done, err)
+			}
+			var count int
+			mdb.Table("members").Count(&count)
+			if count != 1 {
+				t.Errorf("Wrong member count [Expected]: %d [Actual]: %d", 1, count)
+			}
+			if _, err := mig.Up(); err != nil {
+				t.Fatalf("Couldn't reapply migrations [Error]: %v", err)
+			}
+			checkModels(t, mdb)
+
+			if _, err := mig.Down(len(gmicro.Migrations)); err != nil {
+				t.Errorf("Couldn't revert every migration [Error]: %v", err)
+			}
+			for _, table := range []string{"groups", "members", "invites", "webhooks", "deliveries", "preferences", "handled_messages"} {
+				if mdb.HasTable(table) {
+					t.Errorf("Table wasn't dropped [Table]: %s", table)
+				}
+			}
+		})
+	}
+}
+
+func TestMigrationsExistingSchema(t *testing.T) {
+	for name, mdb := range migrationDBs(t) {
+		t.Run(name, func(t *testing.T) {
+			defer mdb.Close()
+			defer mdb.DropTableIfExists(gmicro.MigrationsTable, "invites", "members", "groups")
+
+			// Databases created before migrations already have the tables
+			mdb.CreateTable(&group.Group{}, &member.Member{}, &invite.Invite{})
+			g := group.Group{ID: uuid.New(), Name: "Test"}
+			mdb.Create(&g)
+
+			mig, _ := migrate.New(mdb, gmicro.MigrationsTable, gmicro.Migrations)
+			if _, err := mig.Up(); err != nil {
+				t.Fatalf("Couldn't apply migrations [Error]: %v", err)
+			}
+			checkModels(t, mdb)
+
+			if _, err := gmicro.NewManager(mdb).FetchGroup(g.ID); err != nil {
+				t.Errorf("Couldn't fetch existing group [Error]: %v", err)
+			}
+		})
+	}
+}
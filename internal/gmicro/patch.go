@@ -0,0 +1,62 @@
+package gmicro
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/varrrro/pay-up/internal/openapi"
+	"github.com/varrrro/pay-up/internal/patch"
+)
+
+// Fields of each resource that can be changed with a patch. The rest are
+// read-only and patches that change them are rejected.
+var (
+	groupPatchFields  = map[string]bool{"name": true, "description": true}
+	memberPatchFields = map[string]bool{"name": true}
+)
+
+// applyPatch in the request body to the current resource, decoding the
+// result into out. Only the given fields can change, and the result must
+// match the resource's schema. It returns the names of the changed fields.
+func applyPatch(r *http.Request, cur interface{}, schema string, fields map[string]bool, out interface{}) ([]string, error) {
+	doc, err := json.Marshal(cur)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	patched, err := patch.Patch(r.Header.Get("Content-Type"), doc, body)
+	if err != nil {
+		return nil, err
+	}
+
+	changed, err := patch.Changed(doc, patched)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check fields changed and the resulting resource
+	var errs []openapi.FieldError
+	for _, f := range changed {
+		if !fields[f] {
+			errs = append(errs, openapi.FieldError{Field: f, Message: "is read-only"})
+		}
+	}
+
+	var v interface{}
+	if err := json.Unmarshal(patched, &v); err != nil {
+		return nil, err
+	}
+	errs = append(errs, openapi.GroupsSpec().ValidateResource(&openapi.Schema{Ref: "#/components/schemas/" + schema}, v, "")...)
+
+	if len(errs) > 0 {
+		return nil, &PatchError{"Patched resource isn't valid", errs}
+	}
+
+	return changed, json.Unmarshal(patched, out)
+}
@@ -0,0 +1,120 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// Errors of webhook URLs that can't receive deliveries.
+var (
+	ErrInvalidURL       = errors.New("Webhook URL must be an absolute HTTP or HTTPS URL")
+	ErrUnresolvableHost = errors.New("Webhook host can't be resolved")
+	ErrInternalAddress  = errors.New("Webhook URL points at an internal address")
+)
+
+// internal networks that aren't covered by the checks of net.IP.
+var internal = networks(
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"172.16.0.0/12",
+	"192.0.0.0/24",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"240.0.0.0/4", // reserved, including the broadcast address
+	"fc00::/7",
+)
+
+func networks(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+// Public checks if the address can receive deliveries, which excludes
+// loopback, private, link-local, multicast, reserved and unspecified
+// addresses, so webhooks can't reach the services next to gmicro.
+func Public(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() || ip.IsMulticast() {
+		return false
+	}
+
+	for _, n := range internal {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckURL checks that the URL is an absolute HTTP or HTTPS URL whose host
+// only resolves to public addresses.
+func CheckURL(ctx context.Context, raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return ErrInvalidURL
+	}
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, u.Hostname())
+	if err != nil || len(addrs) == 0 {
+		return ErrUnresolvableHost
+	}
+
+	for _, a := range addrs {
+		if !Public(a.IP) {
+			return ErrInternalAddress
+		}
+	}
+	return nil
+}
+
+// NewClient for deliveries with the given timeout. It only connects to public
+// addresses, which are checked after resolving the host so that it can't be
+// changed to an internal one once the webhook is created, and doesn't follow
+// redirects.
+func NewClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   control,
+	}
+
+	return &http.Client{
+		Timeout: timeout,
+		// No proxy, so the dialer sees the webhook's address
+		Transport: &http.Transport{
+			DialContext:           dialer.DialContext,
+			MaxIdleConns:          100,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ExpectContinueTimeout: time.Second,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// control rejects connections to addresses that aren't public.
+func control(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	if ip := net.ParseIP(host); ip == nil || !Public(ip) {
+		return ErrInternalAddress
+	}
+	return nil
+}
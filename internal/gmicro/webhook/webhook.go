@@ -0,0 +1,156 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Events delivered to webhooks.
+const (
+	ExpenseAdded    = "expense.added"
+	ExpenseRemoved  = "expense.removed"
+	PaymentRecorded = "payment.recorded"
+	MemberJoined    = "member.joined"
+	BalanceSettled  = "balance.settled"
+)
+
+// Events that webhooks can subscribe to.
+var Events = []string{ExpenseAdded, ExpenseRemoved, PaymentRecorded, MemberJoined, BalanceSettled}
+
+// Headers sent with every delivery.
+const (
+	EventHeader     = "X-PayUp-Event"
+	DeliveryHeader  = "X-PayUp-Delivery"
+	SignatureHeader = "X-PayUp-Signature"
+)
+
+// Webhook of a group, receiving the events in its filter, separated by ';',
+// or every event if the filter is empty. The secret signs the payloads and
+// is only returned when the webhook is created.
+type Webhook struct {
+	ID        uuid.UUID `json:"id" gorm:"type:uuid;primary_key"`
+	GroupID   uuid.UUID `json:"group_id" gorm:"type:uuid"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"`
+	Events    string    `json:"events"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at" gorm:"not null;default:CURRENT_TIMESTAMP"`
+}
+
+// Matches checks if the webhook receives the given event.
+func (w *Webhook) Matches(event string) bool {
+	if w.Events == "" {
+		return true
+	}
+
+	for _, e := range strings.Split(w.Events, ";") {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Valid checks if the event is one of the known events.
+func Valid(event string) bool {
+	for _, e := range Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// NewSecret generates a random secret to sign payloads with.
+func NewSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// States of a delivery.
+const (
+	StatePending   = "pending"
+	StateDelivered = "delivered"
+	StateFailed    = "failed"
+)
+
+// Delivery of an event to a webhook, kept as a log of its attempts. Pending
+// deliveries are attempted again at NextAttemptAt.
+type Delivery struct {
+	ID            uuid.UUID  `json:"id" gorm:"type:uuid;primary_key"`
+	WebhookID     uuid.UUID  `json:"webhook_id" gorm:"type:uuid"`
+	GroupID       uuid.UUID  `json:"group_id" gorm:"type:uuid"`
+	EventID       uuid.UUID  `json:"event_id" gorm:"type:uuid"`
+	Event         string     `json:"event"`
+	Payload       string     `json:"payload" gorm:"type:text"`
+	State         string     `json:"state"`
+	Attempts      int        `json:"attempts"`
+	StatusCode    int        `json:"status_code"`
+	LastError     string     `json:"last_error"`
+	NextAttemptAt time.Time  `json:"next_attempt_at"`
+	CreatedAt     time.Time  `json:"created_at" gorm:"not null;default:CURRENT_TIMESTAMP"`
+	DeliveredAt   *time.Time `json:"delivered_at"`
+}
+
+// Payload of an event sent to webhooks.
+type Payload struct {
+	ID        uuid.UUID   `json:"id"`
+	Event     string      `json:"event"`
+	GroupID   uuid.UUID   `json:"group_id"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// NewPayload of an event of the group, encoded as JSON.
+func NewPayload(event string, gid uuid.UUID, data interface{}) (uuid.UUID, string, error) {
+	p := Payload{
+		ID:        uuid.New(),
+		Event:     event,
+		GroupID:   gid,
+		CreatedAt: time.Now().UTC(),
+		Data:      data,
+	}
+
+	body, err := json.Marshal(&p)
+	if err != nil {
+		return uuid.Nil, "", err
+	}
+	return p.ID, string(body), nil
+}
+
+// Sign a payload sent at the given time, returning the value of the
+// signature header. Receivers compute the HMAC-SHA256 of the timestamp, a
+// '.' and the body with the webhook's secret, and compare it to v1.
+func Sign(secret string, ts time.Time, body []byte) string {
+	t := strconv.FormatInt(ts.Unix(), 10)
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(t + "."))
+	mac.Write(body)
+
+	return "t=" + t + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Backoff before the next attempt of a delivery that failed the given number
+// of times, doubling from base up to max.
+func Backoff(attempts int, base, max time.Duration) time.Duration {
+	d := base
+	for i := 1; i < attempts && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d
+}
@@ -0,0 +1,220 @@
+package gmicro
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+	log "github.com/sirupsen/logrus"
+	"github.com/varrrro/pay-up/internal/dberr"
+	"github.com/varrrro/pay-up/internal/gmicro/webhook"
+	"github.com/varrrro/pay-up/internal/logging"
+)
+
+// Settings of webhook deliveries, overridden by the dispatcher's fields.
+var (
+	DeliveryInterval    = 5 * time.Second
+	DeliveryTimeout     = 10 * time.Second
+	DeliveryMaxAttempts = 8
+	DeliveryBaseBackoff = 30 * time.Second
+	DeliveryMaxBackoff  = 6 * time.Hour
+)
+
+// deliveryBatch is the number of deliveries attempted in each pass.
+const deliveryBatch = 50
+
+// Dispatcher of webhook deliveries, which attempts the pending ones
+// periodically, retrying failures with exponential backoff until they
+// succeed or run out of attempts.
+type Dispatcher struct {
+	DB          *gorm.DB
+	Client      *http.Client
+	Interval    time.Duration
+	MaxAttempts int
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+
+	done chan struct{}
+}
+
+// NewDispatcher with the given database connection and the default settings.
+func NewDispatcher(db *gorm.DB) *Dispatcher {
+	return &Dispatcher{
+		DB:          db,
+		Client:      webhook.NewClient(DeliveryTimeout),
+		Interval:    DeliveryInterval,
+		MaxAttempts: DeliveryMaxAttempts,
+		BaseBackoff: DeliveryBaseBackoff,
+		MaxBackoff:  DeliveryMaxBackoff,
+		done:        make(chan struct{}),
+	}
+}
+
+// Start attempting pending deliveries until the context is cancelled.
+func (d *Dispatcher) Start(ctx context.Context) {
+	go func() {
+		defer close(d.done)
+
+		ticker := time.NewTicker(d.Interval)
+		defer ticker.Stop()
+
+		for {
+			if _, err := d.Dispatch(ctx); err != nil {
+				log.WithError(err).Warn("Can't dispatch webhook deliveries")
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Done is closed once the dispatcher stopped after its context was
+// cancelled.
+func (d *Dispatcher) Done() <-chan struct{} {
+	return d.done
+}
+
+// Dispatch the deliveries that are due, returning how many were attempted.
+func (d *Dispatcher) Dispatch(ctx context.Context) (int, error) {
+	var due []webhook.Delivery
+
+	err := d.DB.Where("state = ? AND next_attempt_at <= ?", webhook.StatePending, time.Now().UTC()).
+		Order("next_attempt_at").
+		Limit(deliveryBatch).
+		Find(&due).Error
+	if err != nil {
+		return 0, dberr.Wrap("find deliveries", err)
+	}
+
+	n := 0
+	for _, dl := range due {
+		if ctx.Err() != nil {
+			break
+		}
+
+		claimed, err := d.claim(&dl)
+		if err != nil {
+			return n, err
+		}
+		if !claimed {
+			continue // attempted by another instance
+		}
+
+		if err := d.attempt(ctx, &dl); err != nil {
+			return n, err
+		}
+		n++
+	}
+
+	return n, nil
+}
+
+// claim a delivery by counting the attempt and moving its next attempt past
+// the timeout, unless another instance counted it first.
+func (d *Dispatcher) claim(dl *webhook.Delivery) (bool, error) {
+	res := d.DB.Model(&webhook.Delivery{}).
+		Where("id = ? AND state = ? AND attempts = ?", dl.ID, webhook.StatePending, dl.Attempts).
+		UpdateColumns(map[string]interface{}{
+			"attempts":        dl.Attempts + 1,
+			"next_attempt_at": time.Now().UTC().Add(d.Client.Timeout + d.Interval),
+		})
+	if res.Error != nil {
+		return false, dberr.Wrap("claim delivery", res.Error)
+	}
+
+	dl.Attempts++
+	return res.RowsAffected == 1, nil
+}
+
+// attempt a delivery, recording its result.
+func (d *Dispatcher) attempt(ctx context.Context, dl *webhook.Delivery) error {
+	logger := log.WithFields(log.Fields{
+		"delivery_id": dl.ID,
+		"webhook_id":  dl.WebhookID,
+		"event":       dl.Event,
+		"attempt":     dl.Attempts,
+	})
+
+	var w webhook.Webhook
+	var status int
+	err := first(d.DB, "attempt delivery", &NotFoundError{"No webhook found", dl.WebhookID}, &w, "id = ?", dl.WebhookID)
+	if err == nil {
+		status, err = d.post(ctx, &w, dl)
+	}
+
+	// Deliveries interrupted by shutdown are attempted again once their claim
+	// expires
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	changes := map[string]interface{}{"status_code": status, "last_error": ""}
+	switch {
+	case err == nil:
+		changes["state"] = webhook.StateDelivered
+		changes["delivered_at"] = now
+		logger.WithField("status", status).Info("Webhook delivered")
+	case dl.Attempts >= d.MaxAttempts:
+		changes["state"] = webhook.StateFailed
+		changes["last_error"] = err.Error()
+		logger.WithError(err).Warn("Webhook delivery failed, no attempts left")
+	default:
+		next := webhook.Backoff(dl.Attempts, d.BaseBackoff, d.MaxBackoff)
+		changes["next_attempt_at"] = now.Add(next)
+		changes["last_error"] = err.Error()
+		logger.WithError(err).WithField("retry_in", next).Warn("Webhook delivery failed")
+	}
+
+	return dberr.Wrap("record delivery", d.DB.Model(&webhook.Delivery{}).Where("id = ?", dl.ID).UpdateColumns(changes).Error)
+}
+
+// post the delivery's payload to the webhook, signed with its secret. Any
+// response other than 2xx is a failure.
+func (d *Dispatcher) post(ctx context.Context, w *webhook.Webhook, dl *webhook.Delivery) (int, error) {
+	body := []byte(dl.Payload)
+
+	req, err := http.NewRequest("POST", w.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "PayUp-Webhooks/1.0")
+	req.Header.Set(webhook.EventHeader, dl.Event)
+	req.Header.Set(webhook.DeliveryHeader, dl.ID.String())
+	req.Header.Set(webhook.SignatureHeader, webhook.Sign(w.Secret, time.Now(), body))
+
+	res, err := d.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(res.Body, 64<<10))
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("Webhook responded with status %d", res.StatusCode)
+	}
+	return res.StatusCode, nil
+}
+
+// queueEvent of the group for its webhooks. Events are best effort, like
+// balance changes, so failing to queue them doesn't fail the change itself.
+func queueEvent(ctx context.Context, m Manager, gid uuid.UUID, event string, data interface{}) {
+	if err := m.QueueEvent(gid, event, data); err != nil {
+		logging.FromContext(ctx).WithFields(log.Fields{
+			"group_id": gid,
+			"event":    event,
+		}).WithError(err).Warn("Can't queue webhook event")
+	}
+}
@@ -0,0 +1,353 @@
+package gmicro_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/varrrro/pay-up/internal/gmicro"
+	"github.com/varrrro/pay-up/internal/gmicro/group"
+	"github.com/varrrro/pay-up/internal/gmicro/member"
+	"github.com/varrrro/pay-up/internal/gmicro/webhook"
+	"github.com/varrrro/pay-up/internal/tmicro/expense"
+	"github.com/varrrro/pay-up/internal/tmicro/payment"
+)
+
+// receiver of webhook deliveries, answering with the given status code.
+type receiver struct {
+	mu       sync.Mutex
+	status   int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (rc *receiver) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.requests = append(rc.requests, r)
+	rc.bodies = append(rc.bodies, body)
+	rw.WriteHeader(rc.status)
+}
+
+// createWebhook through the API, returning it with its secret.
+func createWebhook(t *testing.T, gid uuid.UUID, body string) webhook.Webhook {
+	req, _ := http.NewRequest("POST", "/groups/"+gid.String()+"/webhooks", bytes.NewBufferString(body))
+	req.Header.Set("X-User-ID", owner)
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+
+	var w webhook.Webhook
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Wrong status code [Expected]: %d [Actual]: %d", http.StatusCreated, rec.Code)
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&w); err != nil {
+		t.Fatalf("Can't decode response body [Error]: %v", err)
+	}
+
+	return w
+}
+
+func TestWebhooksHandler(t *testing.T) {
+	g := group.Group{ID: uuid.New(), Name: "Test"}
+	gm.CreateGroup(&g, nil)
+	addOwner(g.ID)
+	gm.AddMember(g.ID, &member.Member{ID: uuid.New(), Name: "Viewer", UserID: "viewer", Role: member.RoleViewer})
+
+	w := createWebhook(t, g.ID, `{"url":"https://93.184.216.34/hook","events":"expense.added;member.joined"}`)
+	if w.Secret == "" {
+		t.Error("Created webhook has no secret")
+	}
+
+	path := "/groups/" + g.ID.String() + "/webhooks"
+	cases := []struct {
+		method     string
+		path       string
+		uid        string
+		reqBody    string
+		statusCode int
+	}{
+		{"GET", path, owner, "", http.StatusOK},
+		{"GET", path, "viewer", "", http.StatusForbidden},
+		{"POST", path, owner, `{"url":"ftp://example.com"}`, http.StatusBadRequest},
+		{"POST", path, owner, `{"url":"/hook"}`, http.StatusBadRequest},
+		{"POST", path, owner, `{"url":"https://example.com","events":"group.deleted"}`, http.StatusBadRequest},
+		{"POST", path, owner, `{"url":"https://example.com","secret":"short"}`, http.StatusBadRequest},
+		{"POST", path, "viewer", `{"url":"https://example.com"}`, http.StatusForbidden},
+		{"POST", "/groups/" + uuid.New().String() + "/webhooks", owner, `{"url":"https://example.com"}`, http.StatusNotFound},
+		{"POST", path, owner, `{"url":"http://127.0.0.1:8080/hook"}`, http.StatusBadRequest},
+		{"POST", path, owner, `{"url":"http://localhost/hook"}`, http.StatusBadRequest},
+		{"POST", path, owner, `{"url":"http://[::1]/hook"}`, http.StatusBadRequest},
+		{"POST", path, owner, `{"url":"http://0.0.0.0/hook"}`, http.StatusBadRequest},
+		{"POST", path, owner, `{"url":"http://10.0.0.1/hook"}`, http.StatusBadRequest},
+		{"POST", path, owner, `{"url":"http://172.18.0.5:15672/api"}`, http.StatusBadRequest},
+		{"POST", path, owner, `{"url":"http://192.168.1.1/hook"}`, http.StatusBadRequest},
+		{"POST", path, owner, `{"url":"http://169.254.169.254/latest/meta-data"}`, http.StatusBadRequest},
+		{"POST", path, owner, `{"url":"http://[fd00::1]/hook"}`, http.StatusBadRequest},
+		{"GET", path + "/" + w.ID.String() + "/deliveries", owner, "", http.StatusOK},
+		{"GET", path + "/" + uuid.New().String() + "/deliveries", owner, "", http.StatusNotFound},
+		{"POST", path + "/" + w.ID.String() + "/deliveries/" + uuid.New().String() + "/redeliver", owner, "", http.StatusNotFound},
+		{"DELETE", path + "/" + w.ID.String(), "viewer", "", http.StatusForbidden},
+		{"DELETE", path + "/" + w.ID.String(), owner, "", http.StatusNoContent},
+		{"DELETE", path + "/" + w.ID.String(), owner, "", http.StatusNotFound},
+	}
+
+	for _, tc := range cases {
+		t.Run(fmt.Sprintf("%s %s %d", tc.method, tc.uid, tc.statusCode), func(t *testing.T) {
+			// Create request
+			req, err := http.NewRequest(tc.method, tc.path, bytes.NewBufferString(tc.reqBody))
+			if err != nil {
+				t.Errorf("Can't create request [Error]: %v", err)
+			}
+			req.Header.Set("X-User-ID", tc.uid)
+
+			// Serve test request
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+			res := rec.Result() // get response
+			defer res.Body.Close()
+
+			// Check response status code
+			if res.StatusCode != tc.statusCode {
+				t.Errorf("Wrong status code [Expected]: %d [Actual]: %d", tc.statusCode, res.StatusCode)
+			}
+
+			// Secrets aren't listed
+			if tc.method == "GET" && tc.statusCode == http.StatusOK && strings.Contains(rec.Body.String(), w.Secret) {
+				t.Error("Listed webhooks include their secret")
+			}
+		})
+	}
+
+	clearDB()
+}
+
+func TestDispatcher(t *testing.T) {
+	rc := &receiver{status: http.StatusOK}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+
+	g := group.Group{ID: uuid.New(), Name: "Test"}
+	gm.CreateGroup(&g, nil)
+	addOwner(g.ID)
+	// The API rejects the test server's loopback address, so the webhook is
+	// created directly and delivered without the address checks
+	w := webhook.Webhook{GroupID: g.ID, URL: srv.URL, Secret: "secret", Events: webhook.MemberJoined}
+	gm.CreateWebhook(&w)
+
+	// Only events in the webhook's filter are queued
+	gm.QueueEvent(g.ID, webhook.ExpenseAdded, map[string]string{})
+	gm.QueueEvent(g.ID, webhook.MemberJoined, map[string]string{"name": "Test"})
+
+	d := gmicro.NewDispatcher(db)
+	d.Client.Transport = http.DefaultTransport
+	d.MaxAttempts = 2
+	d.BaseBackoff = time.Hour
+
+	// Delivery succeeds with a signed payload
+	if n, err := d.Dispatch(context.Background()); err != nil || n != 1 {
+		t.Fatalf("Wrong dispatch result [Expected]: %d [Actual]: %d [Error]: %v", 1, n, err)
+	}
+	req, body := rc.requests[0], rc.bodies[0]
+	if req.Header.Get(webhook.EventHeader) != webhook.MemberJoined {
+		t.Errorf("Wrong event header [Expected]: %s [Actual]: %s", webhook.MemberJoined, req.Header.Get(webhook.EventHeader))
+	}
+	var ts, sig string
+	fmt.Sscanf(strings.Replace(req.Header.Get(webhook.SignatureHeader), ",", " ", 1), "t=%s v1=%s", &ts, &sig)
+	mac := hmac.New(sha256.New, []byte(w.Secret))
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+	if sig != hex.EncodeToString(mac.Sum(nil)) {
+		t.Errorf("Wrong signature [Header]: %s", req.Header.Get(webhook.SignatureHeader))
+	}
+	var p webhook.Payload
+	if err := json.Unmarshal(body, &p); err != nil || p.Event != webhook.MemberJoined || p.GroupID != g.ID {
+		t.Errorf("Wrong payload [Actual]: %s [Error]: %v", body, err)
+	}
+
+	deliveries, _ := gm.ListDeliveries(g.ID, w.ID)
+	if len(deliveries) != 1 || deliveries[0].State != webhook.StateDelivered || deliveries[0].StatusCode != http.StatusOK {
+		t.Fatalf("Wrong delivery log [Actual]: %+v", deliveries)
+	}
+
+	// Failures are retried later, until there are no attempts left
+	rc.mu.Lock()
+	rc.status = http.StatusInternalServerError
+	rc.mu.Unlock()
+	redelivery, err := gm.Redeliver(g.ID, w.ID, deliveries[0].ID)
+	if err != nil {
+		t.Fatalf("Can't redeliver [Error]: %v", err)
+	}
+
+	states := []string{webhook.StatePending, webhook.StateFailed}
+	for i, state := range states {
+		// Make the retry due
+		db.Model(&webhook.Delivery{}).Where("id = ?", redelivery.ID).Update("next_attempt_at", time.Now().UTC().Add(-time.Second))
+
+		if n, err := d.Dispatch(context.Background()); err != nil || n != 1 {
+			t.Fatalf("Wrong dispatch result [Expected]: %d [Actual]: %d [Error]: %v", 1, n, err)
+		}
+
+		var dl webhook.Delivery
+		db.First(&dl, "id = ?", redelivery.ID)
+		if dl.State != state || dl.Attempts != i+1 || dl.StatusCode != http.StatusInternalServerError || dl.LastError == "" {
+			t.Errorf("Wrong delivery after attempt %d [Expected state]: %s [Actual]: %+v", i+1, state, dl)
+		}
+		if state == webhook.StatePending && dl.NextAttemptAt.Before(time.Now().Add(59*time.Minute)) {
+			t.Errorf("Retry isn't backed off [Next attempt]: %v", dl.NextAttemptAt)
+		}
+	}
+
+	// Nothing else is due
+	if n, _ := d.Dispatch(context.Background()); n != 0 {
+		t.Errorf("Wrong dispatch result [Expected]: %d [Actual]: %d", 0, n)
+	}
+
+	clearDB()
+}
+
+func TestDispatcherInternalAddresses(t *testing.T) {
+	target := &receiver{status: http.StatusOK}
+	internal := httptest.NewServer(target)
+	defer internal.Close()
+
+	// Redirects aren't followed
+	redirect := httptest.NewServer(http.RedirectHandler(internal.URL, http.StatusFound))
+	defer redirect.Close()
+
+	g := group.Group{ID: uuid.New(), Name: "Test"}
+	gm.CreateGroup(&g, nil)
+	addOwner(g.ID)
+
+	cases := []struct {
+		url        string
+		transport  http.RoundTripper
+		statusCode int
+	}{
+		// A host resolving to an internal address after the webhook was created
+		{internal.URL, nil, 0},
+		{redirect.URL, http.DefaultTransport, http.StatusFound},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.url, func(t *testing.T) {
+			w := webhook.Webhook{GroupID: g.ID, URL: tc.url, Secret: "secret"}
+			gm.CreateWebhook(&w)
+			gm.QueueEvent(g.ID, webhook.MemberJoined, map[string]string{"name": "Test"})
+
+			d := gmicro.NewDispatcher(db)
+			if tc.transport != nil {
+				d.Client.Transport = tc.transport
+			}
+			if n, err := d.Dispatch(context.Background()); err != nil || n != 1 {
+				t.Fatalf("Wrong dispatch result [Expected]: %d [Actual]: %d [Error]: %v", 1, n, err)
+			}
+
+			deliveries, _ := gm.ListDeliveries(g.ID, w.ID)
+			if len(deliveries) != 1 || deliveries[0].State != webhook.StatePending || deliveries[0].StatusCode != tc.statusCode || deliveries[0].LastError == "" {
+				t.Errorf("Wrong delivery log [Actual]: %+v", deliveries)
+			}
+
+			target.mu.Lock()
+			defer target.mu.Unlock()
+			if len(target.requests) != 0 {
+				t.Errorf("Internal address was reached [Requests]: %d", len(target.requests))
+			}
+
+			gm.DeleteWebhook(g.ID, w.ID)
+		})
+	}
+
+	clearDB()
+}
+
+func TestCheckURL(t *testing.T) {
+	cases := []struct {
+		url      string
+		expected error
+	}{
+		{"https://93.184.216.34/hook", nil},
+		{"http://[2606:2800:220:1::248]/hook", nil},
+		{"ftp://93.184.216.34", webhook.ErrInvalidURL},
+		{"/hook", webhook.ErrInvalidURL},
+		{"http://127.0.0.1/hook", webhook.ErrInternalAddress},
+		{"http://localhost:5672", webhook.ErrInternalAddress},
+		{"http://[::ffff:127.0.0.1]/hook", webhook.ErrInternalAddress},
+		{"http://10.1.2.3/hook", webhook.ErrInternalAddress},
+		{"http://100.64.0.1/hook", webhook.ErrInternalAddress},
+		{"http://169.254.169.254/latest/meta-data", webhook.ErrInternalAddress},
+		{"http://[fe80::1]/hook", webhook.ErrInternalAddress},
+		{"http://224.0.0.1/hook", webhook.ErrInternalAddress},
+		{"http://[ff02::1]/hook", webhook.ErrInternalAddress},
+		{"http://255.255.255.255/hook", webhook.ErrInternalAddress},
+		{"http://192.0.0.8/hook", webhook.ErrInternalAddress},
+		{"http://198.18.0.1/hook", webhook.ErrInternalAddress},
+		{"http://240.0.0.1/hook", webhook.ErrInternalAddress},
+		{"http://host.invalid/hook", webhook.ErrUnresolvableHost},
+	}
+
+	for _, tc := range cases {
+		if err := webhook.CheckURL(context.Background(), tc.url); err != tc.expected {
+			t.Errorf("Wrong result [URL]: %s [Expected]: %v [Actual]: %v", tc.url, tc.expected, err)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	cases := []struct {
+		attempts int
+		expected time.Duration
+	}{
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{4, 8 * time.Minute},
+		{10, time.Hour},
+	}
+
+	for _, tc := range cases {
+		if d := webhook.Backoff(tc.attempts, time.Minute, time.Hour); d != tc.expected {
+			t.Errorf("Wrong backoff [Attempts]: %d [Expected]: %v [Actual]: %v", tc.attempts, tc.expected, d)
+		}
+	}
+}
+
+func TestMessageHandlerWebhookEvents(t *testing.T) {
+	g := group.Group{ID: uuid.New(), Name: "Test"}
+	gm.CreateGroup(&g, nil)
+	m1 := member.Member{ID: uuid.New(), Name: "Test1"}
+	m2 := member.Member{ID: uuid.New(), Name: "Test2"}
+	gm.AddMember(g.ID, &m1)
+	gm.AddMember(g.ID, &m2)
+	w := webhook.Webhook{GroupID: g.ID, URL: "https://example.com", Secret: "secret"}
+	gm.CreateWebhook(&w)
+
+	e := expense.Expense{ID: uuid.New(), GroupID: g.ID, Amount: 10, Payer: m1.ID, Recipients: m2.ID.String()}
+	eb, _ := json.Marshal(&e)
+	p := payment.Payment{ID: uuid.New(), GroupID: g.ID, Amount: 10, Payer: m2.ID, Recipient: m1.ID}
+	pb, _ := json.Marshal(&p)
+
+	h(context.Background(), "add-expense", eb)
+	h(context.Background(), "add-payment", pb)
+
+	var events []string
+	db.Model(&webhook.Delivery{}).Order("created_at").Pluck("event", &events)
+	expected := []string{webhook.ExpenseAdded, webhook.PaymentRecorded, webhook.BalanceSettled, webhook.BalanceSettled}
+	if strings.Join(events, ",") != strings.Join(expected, ",") {
+		t.Errorf("Wrong events queued [Expected]: %v [Actual]: %v", expected, events)
+	}
+
+	clearDB()
+}
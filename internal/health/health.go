@@ -0,0 +1,190 @@
+package health
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// Check of a dependency, which returns an error while it's unhealthy.
+type Check func(ctx context.Context) error
+
+// Status of a dependency or the whole service.
+const (
+	StatusOK          = "ok"
+	StatusUnavailable = "unavailable"
+)
+
+// Result of a check.
+type Result struct {
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+}
+
+// Report of the service's readiness.
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]Result `json:"checks"`
+}
+
+// Checker of the service's dependencies.
+type Checker struct {
+	timeout time.Duration
+	names   []string
+	checks  map[string]Check
+}
+
+// NewChecker that fails checks taking longer than the timeout.
+func NewChecker(timeout time.Duration) *Checker {
+	return &Checker{timeout: timeout, checks: make(map[string]Check)}
+}
+
+// Add a named check of a dependency.
+func (c *Checker) Add(name string, check Check) {
+	if _, ok := c.checks[name]; !ok {
+		c.names = append(c.names, name)
+		sort.Strings(c.names)
+	}
+	c.checks[name] = check
+}
+
+// Run every check concurrently, reporting the service ready only if all of
+// them pass.
+func (c *Checker) Run(ctx context.Context) Report {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	rep := Report{Status: StatusOK, Checks: make(map[string]Result, len(c.names))}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for _, name := range c.names {
+		wg.Add(1)
+		go func(name string, check Check) {
+			defer wg.Done()
+
+			start := time.Now()
+			res := Result{Status: StatusOK}
+			if err := run(ctx, check); err != nil {
+				res = Result{Status: StatusUnavailable, Error: err.Error()}
+			}
+			res.Duration = time.Since(start).String()
+
+			mu.Lock()
+			defer mu.Unlock()
+			rep.Checks[name] = res
+			if res.Status != StatusOK {
+				rep.Status = StatusUnavailable
+			}
+		}(name, c.checks[name])
+	}
+	wg.Wait()
+
+	return rep
+}
+
+// run the check, giving up when the context is done even if the check
+// ignores it.
+func run(ctx context.Context, check Check) error {
+	errc := make(chan error, 1)
+	go func() { errc <- check(ctx) }()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+		return errors.New("Check timed out")
+	}
+}
+
+// LiveHandler reports the process is running, without checking dependencies,
+// so a failing dependency doesn't get the service restarted.
+func LiveHandler(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	json.NewEncoder(rw).Encode(map[string]string{"status": StatusOK})
+}
+
+// ReadyHandler reports whether every dependency is healthy, responding with
+// 503 Service Unavailable otherwise.
+func ReadyHandler(c *Checker) func(http.ResponseWriter, *http.Request) {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		rep := c.Run(r.Context())
+
+		status := http.StatusOK
+		if rep.Status != StatusOK {
+			status = http.StatusServiceUnavailable
+		}
+
+		rw.Header().Set("Content-Type", "application/json")
+		rw.Header().Set("Cache-Control", "no-store")
+		rw.WriteHeader(status)
+		json.NewEncoder(rw).Encode(&rep)
+	}
+}
+
+// Database check that pings the database.
+func Database(db *sql.DB) Check {
+	return func(ctx context.Context) error {
+		return db.PingContext(ctx)
+	}
+}
+
+// AMQP check that the connection is open and can open channels.
+func AMQP(conn *amqp.Connection) Check {
+	return func(ctx context.Context) error {
+		if conn.IsClosed() {
+			return errors.New("Connection is closed")
+		}
+
+		ch, err := conn.Channel()
+		if err != nil {
+			return err
+		}
+
+		return ch.Close()
+	}
+}
+
+// Done check that fails once the channel is closed, such as when a
+// long-running loop stops.
+func Done(done <-chan struct{}, msg string) Check {
+	return func(ctx context.Context) error {
+		select {
+		case <-done:
+			return errors.New(msg)
+		default:
+			return nil
+		}
+	}
+}
+
+// HTTP check that the URL responds with a 2xx status code.
+func HTTP(client *http.Client, url string) Check {
+	return func(ctx context.Context) error {
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			return err
+		}
+
+		res, err := client.Do(req.WithContext(ctx))
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+
+		if res.StatusCode < 200 || res.StatusCode > 299 {
+			return errors.New("Unexpected status " + res.Status)
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,130 @@
+package health_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"github.com/varrrro/pay-up/internal/health"
+)
+
+func ok(ctx context.Context) error {
+	return nil
+}
+
+func failing(ctx context.Context) error {
+	return errors.New("failed")
+}
+
+func hanging(ctx context.Context) error {
+	time.Sleep(time.Second)
+	return nil
+}
+
+func TestLiveHandler(t *testing.T) {
+	rec := httptest.NewRecorder()
+	health.LiveHandler(rec, httptest.NewRequest("GET", "/livez", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("Wrong status code [Expected]: %d [Actual]: %d", http.StatusOK, rec.Code)
+	}
+}
+
+func TestReadyHandler(t *testing.T) {
+	cases := []struct {
+		name       string
+		checks     map[string]health.Check
+		statusCode int
+		failed     []string
+	}{
+		{"Healthy", map[string]health.Check{"database": ok, "amqp": ok}, http.StatusOK, nil},
+		{"Failing", map[string]health.Check{"database": ok, "amqp": failing}, http.StatusServiceUnavailable, []string{"amqp"}},
+		{"Timeout", map[string]health.Check{"database": hanging, "amqp": ok}, http.StatusServiceUnavailable, []string{"database"}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := health.NewChecker(50 * time.Millisecond)
+			for name, check := range tc.checks {
+				c.Add(name, check)
+			}
+
+			rec := httptest.NewRecorder()
+			health.ReadyHandler(c)(rec, httptest.NewRequest("GET", "/readyz", nil))
+
+			if rec.Code != tc.statusCode {
+				t.Errorf("Wrong status code [Expected]: %d [Actual]: %d", tc.statusCode, rec.Code)
+			}
+
+			var rep health.Report
+			if err := json.NewDecoder(rec.Body).Decode(&rep); err != nil {
+				t.Fatalf("Can't decode response body [Error]: %v", err)
+			}
+			if len(rep.Checks) != len(tc.checks) {
+				t.Errorf("Wrong check count [Expected]: %d [Actual]: %d", len(tc.checks), len(rep.Checks))
+			}
+			for _, name := range tc.failed {
+				if res := rep.Checks[name]; res.Status != health.StatusUnavailable || res.Error == "" {
+					t.Errorf("Failed check not reported [Check]: %s [Result]: %v", name, res)
+				}
+			}
+		})
+	}
+}
+
+func TestDatabase(t *testing.T) {
+	db, _ := gorm.Open("sqlite3", ":memory:")
+	check := health.Database(db.DB())
+
+	if err := check(context.Background()); err != nil {
+		t.Errorf("Open database isn't healthy [Error]: %v", err)
+	}
+
+	db.Close()
+	if err := check(context.Background()); err == nil {
+		t.Error("Closed database is healthy")
+	}
+}
+
+func TestDone(t *testing.T) {
+	done := make(chan struct{})
+	check := health.Done(done, "Stopped")
+
+	if err := check(context.Background()); err != nil {
+		t.Errorf("Running loop isn't healthy [Error]: %v", err)
+	}
+
+	close(done)
+	if err := check(context.Background()); err == nil {
+		t.Error("Stopped loop is healthy")
+	}
+}
+
+func TestHTTP(t *testing.T) {
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	check := health.HTTP(srv.Client(), srv.URL+"/livez")
+	if err := check(context.Background()); err != nil {
+		t.Errorf("Healthy upstream isn't healthy [Error]: %v", err)
+	}
+
+	status = http.StatusServiceUnavailable
+	if err := check(context.Background()); err == nil {
+		t.Error("Unavailable upstream is healthy")
+	}
+
+	srv.Close()
+	if err := check(context.Background()); err == nil {
+		t.Error("Unreachable upstream is healthy")
+	}
+}
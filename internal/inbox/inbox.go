@@ -0,0 +1,60 @@
+// Package inbox records the AMQP messages a service handled in its own
+// database, so a replayed message is detected by every replica of the
+// service, even after a restart.
+package inbox
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/varrrro/pay-up/internal/dberr"
+)
+
+// ErrHandled is returned when a message was already handled.
+var ErrHandled = errors.New("Message already handled")
+
+// Message handled by the service.
+type Message struct {
+	ID        string `gorm:"primary_key"`
+	HandledAt time.Time
+}
+
+// TableName of handled messages, shared by every service.
+func (Message) TableName() string {
+	return "handled_messages"
+}
+
+type contextKey struct{}
+
+// WithMessageID returns a context carrying the ID of the message handled.
+func WithMessageID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// MessageID carried by the context, or an empty string if it isn't handling
+// a message.
+func MessageID(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+// Record the message as handled in tx, the transaction applying it, so it's
+// only recorded if its changes are committed. Returns ErrHandled if it was
+// already recorded, and does nothing for changes that don't come from a
+// message.
+func Record(tx *gorm.DB, id string) error {
+	if id == "" {
+		return nil
+	}
+
+	// Inserting the ID also waits for the transaction of a replica handling
+	// the same message, and fails if that one committed
+	err := dberr.Wrap("record message", tx.Create(&Message{ID: id, HandledAt: time.Now().UTC()}).Error)
+	if dberr.Is(err, dberr.Conflict) {
+		return ErrHandled
+	}
+
+	return err
+}
@@ -0,0 +1,69 @@
+package inbox_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"github.com/varrrro/pay-up/internal/inbox"
+)
+
+func TestMessageID(t *testing.T) {
+	if id := inbox.MessageID(context.Background()); id != "" {
+		t.Errorf("Wrong message ID [Expected]: %q [Actual]: %q", "", id)
+	}
+
+	ctx := inbox.WithMessageID(context.Background(), "msg1")
+	if id := inbox.MessageID(ctx); id != "msg1" {
+		t.Errorf("Wrong message ID [Expected]: %q [Actual]: %q", "msg1", id)
+	}
+}
+
+func TestRecord(t *testing.T) {
+	db, _ := gorm.Open("sqlite3", ":memory:")
+	db.DB().SetMaxOpenConns(1) // every connection opens a new in-memory database
+	defer db.Close()
+	db.CreateTable(&inbox.Message{})
+
+	// Messages rolled back aren't recorded
+	tx := db.Begin()
+	if err := inbox.Record(tx, "msg1"); err != nil {
+		t.Fatalf("Couldn't record message [Error]: %v", err)
+	}
+	tx.Rollback()
+
+	cases := []struct {
+		name string
+		id   string
+		err  error
+	}{
+		{"New", "msg1", nil},
+		{"Replayed", "msg1", inbox.ErrHandled},
+		{"Other", "msg2", nil},
+		{"No message", "", nil},
+		{"No message again", "", nil},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			tx := db.Begin()
+			err := inbox.Record(tx, tc.id)
+			if err != nil {
+				tx.Rollback()
+			} else {
+				tx.Commit()
+			}
+
+			if err != tc.err {
+				t.Errorf("Wrong error [Expected]: %v [Actual]: %v", tc.err, err)
+			}
+		})
+	}
+
+	var count int
+	db.Model(&inbox.Message{}).Count(&count)
+	if count != 2 {
+		t.Errorf("Wrong message count [Expected]: %d [Actual]: %d", 2, count)
+	}
+}
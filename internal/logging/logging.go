@@ -0,0 +1,109 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// RequestIDHeader carries the ID that correlates the logs of a request
+// across services.
+const RequestIDHeader = "X-Request-ID"
+
+// RequestIDField of log entries and AMQP message headers with the request ID.
+const RequestIDField = "request_id"
+
+// Formats of log output.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// validID of requests accepted from clients, so IDs can't break log lines.
+var validID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+type contextKey int
+
+const (
+	loggerKey contextKey = iota
+	requestIDKey
+)
+
+// Setup the standard logger with the given format and level, writing to
+// stdout.
+func Setup(format, level string) error {
+	switch format {
+	case FormatText:
+		log.SetFormatter(&log.TextFormatter{
+			DisableColors: true,
+			FullTimestamp: true,
+		})
+	case FormatJSON:
+		log.SetFormatter(&log.JSONFormatter{})
+	default:
+		return fmt.Errorf("Unknown log format [Format]: %s", format)
+	}
+
+	lvl, err := log.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	log.SetLevel(lvl)
+	log.SetOutput(os.Stdout)
+
+	return nil
+}
+
+// FromContext returns the logger of the request or message being handled, or
+// the standard logger if there's none.
+func FromContext(ctx context.Context) *log.Entry {
+	if l, ok := ctx.Value(loggerKey).(*log.Entry); ok {
+		return l
+	}
+	return log.NewEntry(log.StandardLogger())
+}
+
+// WithFields returns a context whose logger has the given fields.
+func WithFields(ctx context.Context, fields log.Fields) context.Context {
+	return context.WithValue(ctx, loggerKey, FromContext(ctx).WithFields(fields))
+}
+
+// WithRequestID returns a context carrying the request ID, whose logger adds
+// it to every entry.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	ctx = context.WithValue(ctx, requestIDKey, id)
+	return WithFields(ctx, log.Fields{RequestIDField: id})
+}
+
+// RequestID carried by the context, or an empty string if there's none.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// Middleware that accepts the client's request ID or generates one, returning
+// it in the response and keeping it in the request so it's forwarded to
+// other services. Handlers log through FromContext.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validID.MatchString(id) {
+			id = uuid.New().String()
+		}
+		r.Header.Set(RequestIDHeader, id)
+		rw.Header().Set(RequestIDHeader, id)
+
+		ctx := WithRequestID(r.Context(), id)
+		ctx = WithFields(ctx, log.Fields{
+			"uri":    r.URL.RequestURI(),
+			"method": r.Method,
+		})
+
+		next.ServeHTTP(rw, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,106 @@
+package logging_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/varrrro/pay-up/internal/logging"
+)
+
+func TestSetup(t *testing.T) {
+	defer logging.Setup(logging.FormatText, "info")
+
+	cases := []struct {
+		name   string
+		format string
+		level  string
+		fails  bool
+	}{
+		{"Text", logging.FormatText, "info", false},
+		{"JSON", logging.FormatJSON, "debug", false},
+		{"Unknown format", "xml", "info", true},
+		{"Unknown level", logging.FormatJSON, "loud", true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := logging.Setup(tc.format, tc.level)
+
+			if (err != nil) != tc.fails {
+				t.Errorf("Wrong setup result [Expected failure]: %t [Error]: %v", tc.fails, err)
+			}
+		})
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	cases := []struct {
+		name     string
+		id       string
+		accepted bool
+	}{
+		{"Client ID", "abc-123", true},
+		{"No ID", "", false},
+		{"Invalid ID", "bad id\n", false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var ctxID, fwdID string
+			h := logging.Middleware(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+				ctxID = logging.RequestID(r.Context())
+				fwdID = r.Header.Get(logging.RequestIDHeader)
+			}))
+
+			req := httptest.NewRequest("GET", "/groups", nil)
+			if tc.id != "" {
+				req.Header.Set(logging.RequestIDHeader, tc.id)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			resID := rec.Header().Get(logging.RequestIDHeader)
+			if resID == "" || resID != ctxID || resID != fwdID {
+				t.Errorf("Request ID isn't consistent [Response]: %s [Context]: %s [Forwarded]: %s", resID, ctxID, fwdID)
+			}
+			if (resID == tc.id) != tc.accepted {
+				t.Errorf("Wrong request ID [Sent]: %q [Actual]: %q", tc.id, resID)
+			}
+		})
+	}
+}
+
+func TestFromContext(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	log.SetFormatter(&log.JSONFormatter{})
+	defer logging.Setup(logging.FormatText, "info")
+
+	// Without a logger in the context, the standard one is used
+	logging.FromContext(context.Background()).Info("plain")
+
+	ctx := logging.WithRequestID(context.Background(), "abc-123")
+	ctx = logging.WithFields(ctx, log.Fields{"operation": "add-expense"})
+	logging.FromContext(ctx).Info("tagged")
+
+	dec := json.NewDecoder(&buf)
+	var plain, tagged map[string]interface{}
+	if err := dec.Decode(&plain); err != nil {
+		t.Fatalf("Can't decode log entry [Error]: %v", err)
+	}
+	if err := dec.Decode(&tagged); err != nil {
+		t.Fatalf("Can't decode log entry [Error]: %v", err)
+	}
+
+	if _, ok := plain[logging.RequestIDField]; ok {
+		t.Error("Plain entry has a request ID")
+	}
+	if tagged[logging.RequestIDField] != "abc-123" || tagged["operation"] != "add-expense" {
+		t.Errorf("Wrong entry fields [Actual]: %v", tagged)
+	}
+}
@@ -0,0 +1,159 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Namespace of every metric.
+const Namespace = "payup"
+
+// HTTP metrics.
+var (
+	HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests handled, by route and status.",
+	}, []string{"method", "route", "status"})
+
+	HTTPDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: Namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Time taken to handle HTTP requests, by route and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+)
+
+// AMQP metrics.
+var (
+	MessagesConsumed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "amqp_messages_consumed_total",
+		Help:      "AMQP messages received by the consumer, by operation.",
+	}, []string{"operation"})
+
+	MessagesAcked = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "amqp_messages_acked_total",
+		Help:      "AMQP messages handled and acked, by operation.",
+	}, []string{"operation"})
+
+	MessagesNacked = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "amqp_messages_nacked_total",
+		Help:      "AMQP messages nacked, by operation and whether they were requeued.",
+	}, []string{"operation", "requeued"})
+
+	MessagesPublished = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "amqp_messages_published_total",
+		Help:      "AMQP messages published, by operation and result.",
+	}, []string{"operation", "result"})
+
+	HandlerDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: Namespace,
+		Name:      "amqp_handler_duration_seconds",
+		Help:      "Time taken by the handlers of AMQP messages, by operation.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation"})
+
+	ConsumerLag = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: Namespace,
+		Name:      "amqp_consumer_lag_seconds",
+		Help:      "Time between publishing AMQP messages and handling them, by operation.",
+		Buckets:   []float64{.01, .05, .1, .5, 1, 5, 10, 30, 60, 300},
+	}, []string{"operation"})
+)
+
+// EmailsSent by the notifier, by template and result.
+var EmailsSent = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: Namespace,
+	Name:      "emails_sent_total",
+	Help:      "Emails sent by the notifier, by template and result.",
+}, []string{"template", "result"})
+
+// DBDuration of database queries.
+var DBDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: Namespace,
+	Name:      "db_query_duration_seconds",
+	Help:      "Time taken by database queries, by operation and table.",
+	Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+}, []string{"operation", "table"})
+
+// Handler serving the metrics of the process.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// Middleware that records the count and latency of HTTP requests, labelled
+// with the route's template so IDs don't create new series.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: rw, status: http.StatusOK}
+
+		next.ServeHTTP(rec, r)
+
+		route := "unmatched"
+		if cr := mux.CurrentRoute(r); cr != nil {
+			if tpl, err := cr.GetPathTemplate(); err == nil {
+				route = tpl
+			}
+		}
+
+		status := strconv.Itoa(rec.status)
+		HTTPRequests.WithLabelValues(r.Method, route, status).Inc()
+		HTTPDuration.WithLabelValues(r.Method, route, status).Observe(time.Since(start).Seconds())
+	})
+}
+
+// statusRecorder keeps the status code written to the response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	s.status = code
+	s.ResponseWriter.WriteHeader(code)
+}
+
+// Flush the response if the underlying writer supports it, for streams.
+func (s *statusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// InstrumentDB records the latency of the queries made through gorm.
+func InstrumentDB(db *gorm.DB) {
+	start := func(scope *gorm.Scope) {
+		scope.InstanceSet("metrics:start", time.Now())
+	}
+	observe := func(op string) func(*gorm.Scope) {
+		return func(scope *gorm.Scope) {
+			if v, ok := scope.InstanceGet("metrics:start"); ok {
+				DBDuration.WithLabelValues(op, scope.TableName()).Observe(time.Since(v.(time.Time)).Seconds())
+			}
+		}
+	}
+
+	cb := db.Callback()
+	cb.Create().Before("gorm:create").Register("metrics:before_create", start)
+	cb.Create().After("gorm:create").Register("metrics:after_create", observe("create"))
+	cb.Query().Before("gorm:query").Register("metrics:before_query", start)
+	cb.Query().After("gorm:query").Register("metrics:after_query", observe("query"))
+	cb.Update().Before("gorm:update").Register("metrics:before_update", start)
+	cb.Update().After("gorm:update").Register("metrics:after_update", observe("update"))
+	cb.Delete().Before("gorm:delete").Register("metrics:before_delete", start)
+	cb.Delete().After("gorm:delete").Register("metrics:after_delete", observe("delete"))
+	cb.RowQuery().Before("gorm:row_query").Register("metrics:before_row_query", start)
+	cb.RowQuery().After("gorm:row_query").Register("metrics:after_row_query", observe("row_query"))
+}
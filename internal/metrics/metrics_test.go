@@ -0,0 +1,86 @@
+package metrics_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/varrrro/pay-up/internal/metrics"
+)
+
+func TestMiddleware(t *testing.T) {
+	r := mux.NewRouter()
+	r.Use(metrics.Middleware)
+	r.HandleFunc("/things/{id}", func(rw http.ResponseWriter, r *http.Request) {
+		if mux.Vars(r)["id"] == "missing" {
+			rw.WriteHeader(http.StatusNotFound)
+		}
+	}).Methods("GET")
+
+	cases := []struct {
+		name   string
+		uri    string
+		status string
+	}{
+		{"Found", "/things/1", "200"},
+		{"Other ID", "/things/2", "200"},
+		{"Not found", "/things/missing", "404"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			counter := metrics.HTTPRequests.WithLabelValues("GET", "/things/{id}", tc.status)
+			before := testutil.ToFloat64(counter)
+
+			r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", tc.uri, nil))
+
+			if after := testutil.ToFloat64(counter); after != before+1 {
+				t.Errorf("Wrong request count [Expected]: %v [Actual]: %v", before+1, after)
+			}
+		})
+	}
+}
+
+func TestHandler(t *testing.T) {
+	metrics.HTTPRequests.WithLabelValues("GET", "/", "200").Inc()
+
+	rec := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("Wrong status code [Expected]: %d [Actual]: %d", http.StatusOK, rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), "payup_http_requests_total") {
+		t.Error("HTTP request count isn't exposed")
+	}
+}
+
+func TestInstrumentDB(t *testing.T) {
+	type thing struct {
+		ID   int
+		Name string
+	}
+
+	db, _ := gorm.Open("sqlite3", ":memory:")
+	defer db.Close()
+	metrics.InstrumentDB(db)
+	db.AutoMigrate(&thing{})
+
+	db.Create(&thing{ID: 1, Name: "one"})
+	db.Find(&[]thing{})
+
+	rec := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	for _, op := range []string{"create", "query"} {
+		series := `payup_db_query_duration_seconds_count{operation="` + op + `",table="things"}`
+		if !strings.Contains(rec.Body.String(), series) {
+			t.Errorf("Query latency not recorded [Operation]: %s", op)
+		}
+	}
+}
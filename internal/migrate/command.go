@@ -0,0 +1,58 @@
+package migrate
+
+import (
+	"errors"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Usage of the migrate subcommand.
+const Usage = "migrate [up | down [steps] | status]"
+
+// Run the migrate subcommand with the given arguments: up applies every
+// pending migration, down reverts the given number of migrations (one by
+// default) and status lists every known migration.
+func Run(m *Migrator, args []string) error {
+	cmd := "up"
+	if len(args) > 0 {
+		cmd = args[0]
+	}
+
+	switch cmd {
+	case "up":
+		done, err := m.Up()
+		for _, mig := range done {
+			log.WithFields(log.Fields{"version": mig.Version, "name": mig.Name}).Info("Migration applied")
+		}
+		return err
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				return errors.New("Steps must be a positive integer")
+			}
+			steps = n
+		}
+
+		done, err := m.Down(steps)
+		for _, mig := range done {
+			log.WithFields(log.Fields{"version": mig.Version, "name": mig.Name}).Info("Migration reverted")
+		}
+		return err
+	case "status":
+		st, err := m.Status()
+		for _, s := range st {
+			logger := log.WithFields(log.Fields{"version": s.Version, "name": s.Name})
+			if s.Applied {
+				logger.WithField("applied_at", s.AppliedAt).Info("Migration applied")
+			} else {
+				logger.Info("Migration pending")
+			}
+		}
+		return err
+	default:
+		return errors.New("Unknown command, usage: " + Usage)
+	}
+}
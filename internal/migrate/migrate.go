@@ -0,0 +1,246 @@
+package migrate
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/varrrro/pay-up/internal/dberr"
+)
+
+// Migration that changes the schema from the previous version to Version.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+}
+
+// Record of an applied migration, kept in the schema version table.
+type Record struct {
+	Version   int       `gorm:"primary_key;auto_increment:false"`
+	Name      string    `gorm:"not null"`
+	AppliedAt time.Time `gorm:"not null"`
+}
+
+// Status of a known migration.
+type Status struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Migrator that applies migrations in order, keeping track of the applied
+// ones in a schema version table.
+type Migrator struct {
+	db         *gorm.DB
+	table      string
+	migrations []Migration
+}
+
+// New Migrator instance for the given migrations, which must have unique
+// positive versions and up functions.
+func New(db *gorm.DB, table string, migrations []Migration) (*Migrator, error) {
+	sorted := make([]Migration, len(migrations))
+	copy(sorted, migrations)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+
+	for i, mig := range sorted {
+		if mig.Version <= 0 {
+			return nil, &Error{"Migration version isn't positive", mig.Version, nil}
+		}
+		if i > 0 && sorted[i-1].Version == mig.Version {
+			return nil, &Error{"Migration version is duplicated", mig.Version, nil}
+		}
+		if mig.Up == nil {
+			return nil, &Error{"Migration has no up function", mig.Version, nil}
+		}
+	}
+
+	return &Migrator{db, table, sorted}, nil
+}
+
+// Version of the schema, which is 0 before any migration is applied.
+func (m *Migrator) Version() (int, error) {
+	var version int
+	err := m.locked(func(tx *gorm.DB, applied map[int]Record) error {
+		version = latest(applied)
+		return nil
+	})
+
+	return version, err
+}
+
+// Status of every known migration, in order.
+func (m *Migrator) Status() ([]Status, error) {
+	var res []Status
+	err := m.locked(func(tx *gorm.DB, applied map[int]Record) error {
+		for _, mig := range m.migrations {
+			rec, ok := applied[mig.Version]
+			res = append(res, Status{mig, ok, rec.AppliedAt})
+		}
+		return nil
+	})
+
+	return res, err
+}
+
+// Up applies every pending migration, returning the ones applied.
+func (m *Migrator) Up() ([]Migration, error) {
+	var done []Migration
+	err := m.locked(func(tx *gorm.DB, applied map[int]Record) error {
+		if v := latest(applied); v > m.latest() {
+			return &Error{"Schema is newer than the known migrations", v, nil}
+		}
+
+		for _, mig := range m.migrations {
+			if _, ok := applied[mig.Version]; ok {
+				continue
+			}
+
+			if err := mig.Up(tx); err != nil {
+				return &Error{"Migration failed", mig.Version, err}
+			}
+
+			rec := Record{mig.Version, mig.Name, time.Now().UTC()}
+			if err := tx.Table(m.table).Create(&rec).Error; err != nil {
+				return dberr.Wrap("record migration", err)
+			}
+
+			done = append(done, mig)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return done, nil
+}
+
+// Down reverts the given number of applied migrations, latest first,
+// returning the ones reverted.
+func (m *Migrator) Down(steps int) ([]Migration, error) {
+	var done []Migration
+	err := m.locked(func(tx *gorm.DB, applied map[int]Record) error {
+		for i := len(m.migrations) - 1; i >= 0 && len(done) < steps; i-- {
+			mig := m.migrations[i]
+			if _, ok := applied[mig.Version]; !ok {
+				continue
+			}
+
+			if mig.Down == nil {
+				return &Error{"Migration can't be reverted", mig.Version, nil}
+			}
+
+			if err := mig.Down(tx); err != nil {
+				return &Error{"Migration revert failed", mig.Version, err}
+			}
+
+			if err := tx.Table(m.table).Where("version = ?", mig.Version).Delete(&Record{}).Error; err != nil {
+				return dberr.Wrap("unrecord migration", err)
+			}
+
+			done = append(done, mig)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return done, nil
+}
+
+// locked runs the function in a transaction that holds the migration lock,
+// so only one replica migrates at a time, passing the applied migrations.
+// PostgreSQL uses an advisory lock, while SQLite already serializes writing
+// transactions.
+func (m *Migrator) locked(fn func(tx *gorm.DB, applied map[int]Record) error) error {
+	tx := m.db.Begin()
+	if err := tx.Error; err != nil {
+		return dberr.Wrap("begin transaction", err)
+	}
+
+	if tx.Dialect().GetName() == "postgres" {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", m.lockID()).Error; err != nil {
+			tx.Rollback()
+			return dberr.Wrap("lock migrations", err)
+		}
+	}
+
+	// Create schema version table on first run
+	if !tx.HasTable(m.table) {
+		if err := tx.Table(m.table).CreateTable(&Record{}).Error; err != nil {
+			tx.Rollback()
+			return dberr.Wrap("create schema version table", err)
+		}
+	}
+
+	var recs []Record
+	if err := tx.Table(m.table).Find(&recs).Error; err != nil {
+		tx.Rollback()
+		return dberr.Wrap("fetch applied migrations", err)
+	}
+
+	applied := make(map[int]Record, len(recs))
+	for _, rec := range recs {
+		applied[rec.Version] = rec
+	}
+
+	if err := fn(tx, applied); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return dberr.Wrap("commit transaction", tx.Commit().Error)
+}
+
+// lockID of the advisory lock, derived from the table name so services
+// sharing a database don't block each other.
+func (m *Migrator) lockID() int64 {
+	h := fnv.New64a()
+	h.Write([]byte(m.table))
+	return int64(h.Sum64())
+}
+
+func (m *Migrator) latest() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+func latest(applied map[int]Record) int {
+	var v int
+	for version := range applied {
+		if version > v {
+			v = version
+		}
+	}
+
+	return v
+}
+
+// Error returned when a migration can't be applied or reverted.
+type Error struct {
+	msg     string
+	version int
+	err     error
+}
+
+func (e *Error) Error() string {
+	if e.err == nil {
+		return fmt.Sprintf("%s [Version]: %d", e.msg, e.version)
+	}
+
+	return fmt.Sprintf("%s [Version]: %d [Error]: %s", e.msg, e.version, e.err.Error())
+}
+
+// Unwrap returns the error returned by the migration.
+func (e *Error) Unwrap() error {
+	return e.err
+}
@@ -0,0 +1,250 @@
+package migrate_test
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/postgres"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"github.com/varrrro/pay-up/internal/migrate"
+)
+
+type thingV1 struct {
+	ID   int `gorm:"primary_key;auto_increment:false"`
+	Name string
+}
+
+type thingV2 struct {
+	ID    int `gorm:"primary_key;auto_increment:false"`
+	Name  string
+	Color string `gorm:"not null;default:'red'"`
+}
+
+var migrations = []migrate.Migration{
+	{
+		Version: 2,
+		Name:    "add_thing_colors",
+		Up:      func(tx *gorm.DB) error { return migrate.AddColumns(tx, "things", &thingV2{}) },
+		Down:    func(tx *gorm.DB) error { return migrate.DropColumns(tx, "things", &thingV1{}, "color") },
+	},
+	{
+		Version: 1,
+		Name:    "create_things",
+		Up:      func(tx *gorm.DB) error { return migrate.CreateTable(tx, "things", &thingV1{}) },
+		Down:    func(tx *gorm.DB) error { return migrate.DropTable(tx, "things") },
+	},
+}
+
+// databases to run the tests against, which include PostgreSQL when
+// TEST_POSTGRES_CONN is set.
+func databases(t *testing.T) map[string]*gorm.DB {
+	dbs := make(map[string]*gorm.DB)
+
+	db, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Can't open SQLite database [Error]: %v", err)
+	}
+	db.DB().SetMaxOpenConns(1) // every connection opens a new in-memory database
+	dbs["sqlite3"] = db
+
+	if conn := os.Getenv("TEST_POSTGRES_CONN"); conn != "" {
+		db, err := gorm.Open("postgres", conn)
+		if err != nil {
+			t.Fatalf("Can't open PostgreSQL database [Error]: %v", err)
+		}
+		dbs["postgres"] = db
+	}
+
+	return dbs
+}
+
+func cleanup(db *gorm.DB) {
+	db.DropTableIfExists("things", "migrations")
+	db.Close()
+}
+
+func TestNew(t *testing.T) {
+	up := func(tx *gorm.DB) error { return nil }
+
+	cases := []struct {
+		name       string
+		migrations []migrate.Migration
+		fail       bool
+	}{
+		{"Valid", migrations, false},
+		{"Empty", nil, false},
+		{"Zero version", []migrate.Migration{{Version: 0, Up: up}}, true},
+		{"Duplicated version", []migrate.Migration{{Version: 1, Up: up}, {Version: 1, Up: up}}, true},
+		{"No up function", []migrate.Migration{{Version: 1}}, true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := migrate.New(nil, "migrations", tc.migrations)
+
+			if tc.fail && err == nil {
+				t.Error("Invalid migrations didn't return an error")
+			} else if !tc.fail && err != nil {
+				t.Errorf("Valid migrations returned an error [Error]: %v", err)
+			}
+		})
+	}
+}
+
+func TestUpDown(t *testing.T) {
+	for name, db := range databases(t) {
+		t.Run(name, func(t *testing.T) {
+			defer cleanup(db)
+			m, _ := migrate.New(db, "migrations", migrations)
+
+			// Apply migrations in order
+			done, err := m.Up()
+			if err != nil {
+				t.Fatalf("Couldn't apply migrations [Error]: %v", err)
+			}
+			if len(done) != 2 || done[0].Version != 1 || done[1].Version != 2 {
+				t.Errorf("Wrong migrations applied [Expected]: %v [Actual]: %v", []int{1, 2}, done)
+			}
+			if v, _ := m.Version(); v != 2 {
+				t.Errorf("Wrong version [Expected]: %d [Actual]: %d", 2, v)
+			}
+
+			// Applying again is a no-op
+			if done, err := m.Up(); err != nil || len(done) != 0 {
+				t.Errorf("Applying migrations twice wasn't a no-op [Applied]: %v [Error]: %v", done, err)
+			}
+
+			// Reverting keeps the rows
+			db.Exec("INSERT INTO things (id, name) VALUES (1, 'test')")
+			if done, err := m.Down(1); err != nil || len(done) != 1 || done[0].Version != 2 {
+				t.Fatalf("Couldn't revert last migration [Reverted]: %v [Error]: %v", done, err)
+			}
+			if db.Dialect().HasColumn("things", "color") {
+				t.Error("Reverted column wasn't dropped")
+			}
+			var count int
+			db.Table("things").Count(&count)
+			if count != 1 {
+				t.Errorf("Wrong row count [Expected]: %d [Actual]: %d", 1, count)
+			}
+
+			st, err := m.Status()
+			if err != nil || len(st) != 2 || !st[0].Applied || st[1].Applied {
+				t.Errorf("Wrong status [Status]: %v [Error]: %v", st, err)
+			}
+
+			// Re-applying adds the column with its default
+			if _, err := m.Up(); err != nil {
+				t.Fatalf("Couldn't reapply migrations [Error]: %v", err)
+			}
+			var thing thingV2
+			if err := db.Table("things").First(&thing).Error; err != nil || thing.Color != "red" {
+				t.Errorf("Wrong column default [Expected]: %s [Actual]: %s", "red", thing.Color)
+			}
+
+			// Reverting everything drops the table
+			if done, err := m.Down(10); err != nil || len(done) != 2 {
+				t.Errorf("Couldn't revert every migration [Reverted]: %v [Error]: %v", done, err)
+			}
+			if db.HasTable("things") {
+				t.Error("Reverted table wasn't dropped")
+			}
+			if v, _ := m.Version(); v != 0 {
+				t.Errorf("Wrong version [Expected]: %d [Actual]: %d", 0, v)
+			}
+		})
+	}
+}
+
+func TestUpFailure(t *testing.T) {
+	failed := errors.New("failed")
+	broken := append([]migrate.Migration{{
+		Version: 3,
+		Name:    "broken",
+		Up:      func(tx *gorm.DB) error { return failed },
+	}}, migrations...)
+
+	for name, db := range databases(t) {
+		t.Run(name, func(t *testing.T) {
+			defer cleanup(db)
+			m, _ := migrate.New(db, "migrations", broken)
+
+			var merr *migrate.Error
+			if _, err := m.Up(); !errors.As(err, &merr) || !errors.Is(err, failed) {
+				t.Errorf("Wrong error [Expected]: %v [Actual]: %v", failed, err)
+			}
+
+			// Every migration of the run is rolled back
+			if v, _ := m.Version(); v != 0 {
+				t.Errorf("Wrong version [Expected]: %d [Actual]: %d", 0, v)
+			}
+			if db.HasTable("things") {
+				t.Error("Failed run wasn't rolled back")
+			}
+		})
+	}
+}
+
+func TestUnknownVersion(t *testing.T) {
+	for name, db := range databases(t) {
+		t.Run(name, func(t *testing.T) {
+			defer cleanup(db)
+			m, _ := migrate.New(db, "migrations", migrations)
+			m.Up()
+
+			// Older binaries refuse to migrate a newer schema
+			old, _ := migrate.New(db, "migrations", migrations[1:])
+			if _, err := old.Up(); err == nil {
+				t.Error("Migrating newer schema didn't return an error")
+			}
+
+			// Migrations without a down function can't be reverted
+			m, _ = migrate.New(db, "migrations", []migrate.Migration{
+				{Version: 1, Up: migrations[1].Up},
+				{Version: 2, Up: migrations[0].Up},
+			})
+			if _, err := m.Down(1); err == nil {
+				t.Error("Reverting irreversible migration didn't return an error")
+			}
+		})
+	}
+}
+
+func TestRun(t *testing.T) {
+	db := databases(t)["sqlite3"]
+	defer cleanup(db)
+	m, _ := migrate.New(db, "migrations", migrations)
+
+	cases := []struct {
+		args    []string
+		version int
+		fail    bool
+	}{
+		{nil, 2, false},
+		{[]string{"down"}, 1, false},
+		{[]string{"up"}, 2, false},
+		{[]string{"down", "2"}, 0, false},
+		{[]string{"down", "test"}, 0, true},
+		{[]string{"down", "0"}, 0, true},
+		{[]string{"status"}, 0, false},
+		{[]string{"test"}, 0, true},
+	}
+
+	for _, tc := range cases {
+		t.Run(fmt.Sprint(tc.args), func(t *testing.T) {
+			err := migrate.Run(m, tc.args)
+
+			if tc.fail && err == nil {
+				t.Error("Wrong arguments didn't return an error")
+			} else if !tc.fail && err != nil {
+				t.Errorf("Command failed [Error]: %v", err)
+			}
+			if v, _ := m.Version(); v != tc.version {
+				t.Errorf("Wrong version [Expected]: %d [Actual]: %d", tc.version, v)
+			}
+		})
+	}
+}
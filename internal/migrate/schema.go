@@ -0,0 +1,86 @@
+package migrate
+
+import (
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+// CreateTable with the columns of the model, unless it already exists. Models
+// passed to migrations are snapshots of the schema at that version, so later
+// changes to the application's models don't rewrite history.
+func CreateTable(tx *gorm.DB, table string, model interface{}) error {
+	if tx.HasTable(table) {
+		return tx.Error
+	}
+
+	return tx.Table(table).CreateTable(model).Error
+}
+
+// DropTable if it exists.
+func DropTable(tx *gorm.DB, table string) error {
+	return tx.DropTableIfExists(table).Error
+}
+
+// AddColumns of the model that are missing from the table.
+func AddColumns(tx *gorm.DB, table string, model interface{}) error {
+	if sqlite(tx) {
+		return rebuild(tx, table, model)
+	}
+
+	return tx.Table(table).AutoMigrate(model).Error
+}
+
+// DropColumns from the table, where the model has the remaining columns.
+func DropColumns(tx *gorm.DB, table string, model interface{}, columns ...string) error {
+	if sqlite(tx) {
+		return rebuild(tx, table, model)
+	}
+
+	for _, c := range columns {
+		if !tx.Dialect().HasColumn(table, c) {
+			continue
+		}
+		if err := tx.Table(table).DropColumn(c).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sqlite reports whether the transaction runs on SQLite, which can't add
+// columns with non-constant defaults nor drop columns.
+func sqlite(tx *gorm.DB) bool {
+	return tx.Dialect().GetName() == "sqlite3"
+}
+
+// rebuild the table with the columns of the model, copying the rows' values
+// for the columns in both, as recommended for SQLite schema changes.
+func rebuild(tx *gorm.DB, table string, model interface{}) error {
+	tmp := table + "_migrate"
+	if err := tx.Table(tmp).CreateTable(model).Error; err != nil {
+		return err
+	}
+
+	var common []string
+	for _, f := range tx.NewScope(model).GetModelStruct().StructFields {
+		if f.IsNormal && !f.IsIgnored && tx.Dialect().HasColumn(table, f.DBName) {
+			common = append(common, tx.Dialect().Quote(f.DBName))
+		}
+	}
+
+	cols := strings.Join(common, ", ")
+	stmts := []string{
+		"INSERT INTO " + tx.Dialect().Quote(tmp) + " (" + cols + ") SELECT " + cols + " FROM " + tx.Dialect().Quote(table),
+		"DROP TABLE " + tx.Dialect().Quote(table),
+		"ALTER TABLE " + tx.Dialect().Quote(tmp) + " RENAME TO " + tx.Dialect().Quote(table),
+	}
+	for _, s := range stmts {
+		if err := tx.Exec(s).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,127 @@
+package notifier
+
+import (
+	"context"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/varrrro/pay-up/internal/notifier/recipient"
+)
+
+// Settings of weekly digests, overridden by the digester's fields.
+var (
+	DigestInterval   = time.Minute
+	DigestRetryDelay = time.Hour
+)
+
+// Digester sends the weekly digests that are due periodically. Members
+// sharing an email address get a single digest with all of their groups.
+type Digester struct {
+	Manager    Manager
+	Mailer     *Mailer
+	Interval   time.Duration
+	Period     time.Duration
+	RetryDelay time.Duration
+
+	done chan struct{}
+}
+
+// NewDigester with the given manager and mailer and the default settings.
+func NewDigester(m Manager, ml *Mailer) *Digester {
+	return &Digester{
+		Manager:    m,
+		Mailer:     ml,
+		Interval:   DigestInterval,
+		Period:     DigestPeriod,
+		RetryDelay: DigestRetryDelay,
+		done:       make(chan struct{}),
+	}
+}
+
+// Start sending digests until the context is cancelled.
+func (d *Digester) Start(ctx context.Context) {
+	go func() {
+		defer close(d.done)
+
+		ticker := time.NewTicker(d.Interval)
+		defer ticker.Stop()
+
+		for {
+			if _, err := d.Digest(ctx); err != nil {
+				log.WithError(err).Warn("Can't send digests")
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Done is closed once the digester stopped after its context was cancelled.
+func (d *Digester) Done() <-chan struct{} {
+	return d.done
+}
+
+// Digest sends the digests that are due, returning how many were sent.
+func (d *Digester) Digest(ctx context.Context) (int, error) {
+	now := time.Now().UTC()
+
+	due, err := d.Manager.DueDigests(now)
+	if err != nil {
+		return 0, err
+	}
+
+	// Claim the digests of each address, which are next to each other
+	n := 0
+	for i := 0; i < len(due); {
+		if ctx.Err() != nil {
+			break
+		}
+
+		email := due[i].Email
+		var claimed []recipient.Recipient
+		for ; i < len(due) && due[i].Email == email; i++ {
+			ok, err := d.Manager.ClaimDigest(due[i].MemberID, now, now.Add(d.Period))
+			if err != nil {
+				return n, err
+			}
+			if ok {
+				claimed = append(claimed, due[i])
+			}
+		}
+		if len(claimed) == 0 {
+			continue // sent by another instance
+		}
+
+		if err := d.send(ctx, email, claimed, now); err != nil {
+			return n, err
+		}
+		n++
+	}
+
+	return n, nil
+}
+
+// send the digest of the claimed recipients, retrying it later if it fails.
+func (d *Digester) send(ctx context.Context, email string, claimed []recipient.Recipient, now time.Time) error {
+	data := DigestData{Email: email, Groups: make([]DigestGroup, 0, len(claimed))}
+	for _, r := range claimed {
+		data.Groups = append(data.Groups, DigestGroup{GroupName: r.GroupName, Name: r.Name, Balance: r.Balance})
+	}
+
+	if err := d.Mailer.Send(ctx, DigestTemplate, email, &data); err == nil {
+		return nil
+	}
+
+	// Give the claims back, so the digest is retried
+	retry := now.Add(d.RetryDelay)
+	for _, r := range claimed {
+		if _, err := d.Manager.ClaimDigest(r.MemberID, now.Add(d.Period), retry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
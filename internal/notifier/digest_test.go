@@ -0,0 +1,74 @@
+package notifier_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/varrrro/pay-up/internal/gmicro/member"
+	"github.com/varrrro/pay-up/internal/notifier"
+	"github.com/varrrro/pay-up/internal/notifier/recipient"
+)
+
+func TestDigester(t *testing.T) {
+	g1, g2 := uuid.New(), uuid.New()
+	m1, m2, m3, m4 := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	h(context.Background(), "balance-changed", balanceChange(g1, nil, map[uuid.UUID]float32{m1: 12.5, m3: -12.5}))
+	h(context.Background(), "balance-changed", balanceChange(g2, nil, map[uuid.UUID]float32{m2: -4, m4: 4}))
+
+	// The same person in two groups, another one who didn't ask for digests
+	setPreferences(t, member.Preferences{MemberID: m1, GroupID: g1, Email: "test@example.com", WeeklyDigest: true})
+	setPreferences(t, member.Preferences{MemberID: m2, GroupID: g2, Email: "test@example.com", WeeklyDigest: true})
+	setPreferences(t, member.Preferences{MemberID: m3, GroupID: g1, Email: "other@example.com"})
+
+	d := notifier.NewDigester(nm, ml)
+
+	// The first digest is a period away
+	if n, err := d.Digest(context.Background()); err != nil || n != 0 {
+		t.Fatalf("Wrong digest result [Expected]: %d [Actual]: %d [Error]: %v", 0, n, err)
+	}
+
+	// Make the digests due
+	db.Model(&recipient.Recipient{}).Where("weekly_digest = ?", true).Update("next_digest_at", time.Now().UTC().Add(-time.Second))
+
+	// Sending fails, so the digest is retried later
+	failing = true
+	if n, err := d.Digest(context.Background()); err != nil || n != 1 {
+		t.Fatalf("Wrong digest result [Expected]: %d [Actual]: %d [Error]: %v", 1, n, err)
+	}
+	failing = false
+	var r recipient.Recipient
+	db.First(&r, "member_id = ?", m1)
+	if r.NextDigestAt == nil || r.NextDigestAt.After(time.Now().Add(2*time.Hour)) {
+		t.Errorf("Failed digest isn't retried [Next digest]: %v", r.NextDigestAt)
+	}
+
+	// A single digest has both groups
+	db.Model(&recipient.Recipient{}).Where("weekly_digest = ?", true).Update("next_digest_at", time.Now().UTC().Add(-time.Second))
+	if n, err := d.Digest(context.Background()); err != nil || n != 1 {
+		t.Fatalf("Wrong digest result [Expected]: %d [Actual]: %d [Error]: %v", 1, n, err)
+	}
+	msgs := takeSent()
+	if len(msgs) != 1 || msgs[0].To != "test@example.com" {
+		t.Fatalf("Wrong emails sent [Actual]: %+v", msgs)
+	}
+	for _, s := range []string{"you're owed 12.50", "you owe 4.00"} {
+		if !strings.Contains(msgs[0].Body, s) {
+			t.Errorf("Digest doesn't contain %q [Body]: %s", s, msgs[0].Body)
+		}
+	}
+
+	// Nothing else is due until next week
+	if n, _ := d.Digest(context.Background()); n != 0 {
+		t.Errorf("Wrong digest result [Expected]: %d [Actual]: %d", 0, n)
+	}
+	var r2 recipient.Recipient
+	db.First(&r2, "member_id = ?", m2)
+	if r2.NextDigestAt == nil || r2.NextDigestAt.Before(time.Now().Add(6*24*time.Hour)) {
+		t.Errorf("Next digest isn't a week away [Next digest]: %v", r2.NextDigestAt)
+	}
+
+	clearDB()
+}
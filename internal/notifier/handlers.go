@@ -0,0 +1,174 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"strings"
+
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"github.com/varrrro/pay-up/internal/gmicro/group"
+	"github.com/varrrro/pay-up/internal/gmicro/member"
+	"github.com/varrrro/pay-up/internal/logging"
+	"github.com/varrrro/pay-up/internal/notifier/recipient"
+)
+
+// MessageHandler for the domain events published by the groups
+// microservice. Events meant for other subscribers are ignored.
+func MessageHandler(m Manager, ml *Mailer) func(context.Context, string, []byte) error {
+	return func(ctx context.Context, op string, body []byte) error {
+		logging.FromContext(ctx).WithField("operation", op).Info("AMQP message received")
+		m := m.WithContext(ctx)
+
+		switch op {
+		case "balance-changed":
+			return balanceChangedHandler(ctx, body, m, ml)
+		case "preferences-changed":
+			return preferencesChangedHandler(ctx, body, m)
+		case "group-deleted":
+			return groupDeletedHandler(ctx, body, m)
+		default:
+			logging.FromContext(ctx).WithField("operation", op).Debug("Ignoring event")
+			return nil
+		}
+	}
+}
+
+func balanceChangedHandler(ctx context.Context, body []byte, m Manager, ml *Mailer) error {
+	logger := logging.FromContext(ctx).WithField("operation", "balance-changed")
+
+	// Decode JSON
+	var ev group.BalanceChange
+	if err := json.Unmarshal(body, &ev); err != nil {
+		logger.WithError(err).Error("Can't decode body")
+		return err
+	}
+
+	// Update balances
+	recipients, err := m.UpdateBalances(&ev)
+	if err != nil {
+		logger.WithError(err).Error("Can't update balances")
+		return err
+	}
+
+	// Emails are best effort from here on, since failing the message would
+	// send the ones already sent again
+	if ev.Expense != nil {
+		expenseAdded(ctx, ml, &ev, recipients)
+	}
+	for i := range recipients {
+		owed(ctx, m, ml, &recipients[i])
+	}
+
+	return nil
+}
+
+func preferencesChangedHandler(ctx context.Context, body []byte, m Manager) error {
+	logger := logging.FromContext(ctx).WithField("operation", "preferences-changed")
+
+	// Decode JSON
+	var p member.Preferences
+	if err := json.Unmarshal(body, &p); err != nil {
+		logger.WithError(err).Error("Can't decode body")
+		return err
+	}
+
+	// Update preferences
+	if err := m.UpdatePreferences(&p); err != nil {
+		logger.WithError(err).Error("Can't update preferences")
+		return err
+	}
+
+	return nil
+}
+
+func groupDeletedHandler(ctx context.Context, body []byte, m Manager) error {
+	logger := logging.FromContext(ctx).WithField("operation", "group-deleted")
+
+	// Decode JSON
+	var d group.Deletion
+	if err := json.Unmarshal(body, &d); err != nil {
+		logger.WithError(err).Error("Can't decode body")
+		return err
+	}
+
+	// Forget the group's members
+	if err := m.DeleteGroup(d.GroupID); err != nil {
+		logger.WithError(err).Error("Can't delete group")
+		return err
+	}
+
+	return nil
+}
+
+// expenseAdded emails the recipients of an expense who asked for it. The
+// payer isn't told, since they're usually who added it.
+func expenseAdded(ctx context.Context, ml *Mailer, ev *group.BalanceChange, recipients []recipient.Recipient) {
+	e := ev.Expense
+	rids := strings.Split(e.Recipients, ";")
+
+	// Shares are rounded down to cents, like balances
+	share := e.Amount / float32(len(rids))
+	share = float32(math.Floor(float64(share*100))) / 100
+
+	involved := make(map[uuid.UUID]bool, len(rids))
+	for _, rid := range rids {
+		if id, err := uuid.Parse(rid); err == nil && id != e.Payer {
+			involved[id] = true
+		}
+	}
+
+	payer := ""
+	for _, b := range ev.Balances {
+		if b.MemberID == e.Payer {
+			payer = b.Name
+		}
+	}
+
+	for _, r := range recipients {
+		if !involved[r.MemberID] || !r.ExpenseAdded || r.Email == "" {
+			continue
+		}
+
+		ml.Send(ctx, ExpenseTemplate, r.Email, &ExpenseData{
+			Name:        r.Name,
+			GroupName:   ev.GroupName,
+			Payer:       payer,
+			Description: e.Description,
+			Amount:      e.Amount,
+			Share:       share,
+			Date:        e.Date,
+			Balance:     r.Balance,
+		})
+	}
+}
+
+// owed emails the recipient once they're owed more than their threshold,
+// and again only after their balance went back under it.
+func owed(ctx context.Context, m Manager, ml *Mailer, r *recipient.Recipient) {
+	logger := logging.FromContext(ctx).WithFields(log.Fields{
+		"group_id":  r.GroupID,
+		"member_id": r.MemberID,
+	})
+
+	switch {
+	case r.Owed() && !r.OwedNotified:
+		err := ml.Send(ctx, OwedTemplate, r.Email, &OwedData{
+			Name:      r.Name,
+			GroupName: r.GroupName,
+			Balance:   r.Balance,
+			Threshold: r.OwedThreshold,
+		})
+		if err != nil {
+			return // tried again on the next balance change
+		}
+		if err := m.SetOwedNotified(r.MemberID, true); err != nil {
+			logger.WithError(err).Warn("Can't record owed notification")
+		}
+	case !r.Owed() && r.OwedNotified:
+		if err := m.SetOwedNotified(r.MemberID, false); err != nil {
+			logger.WithError(err).Warn("Can't reset owed notification")
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package notifier_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/varrrro/pay-up/internal/gmicro/group"
+	"github.com/varrrro/pay-up/internal/gmicro/member"
+	"github.com/varrrro/pay-up/internal/inbox"
+	"github.com/varrrro/pay-up/internal/notifier/recipient"
+	"github.com/varrrro/pay-up/internal/tmicro/expense"
+)
+
+// balanceChange encodes the event with the given balances of the members.
+func balanceChange(gid uuid.UUID, e *expense.Expense, balances map[uuid.UUID]float32) []byte {
+	ev := group.BalanceChange{GroupID: gid, GroupName: "Trip", Operation: "add-expense", Expense: e, At: time.Now()}
+	i := 0
+	for mid, b := range balances {
+		i++
+		ev.Balances = append(ev.Balances, group.MemberBalance{MemberID: mid, Name: fmt.Sprintf("Test%d", i), Balance: b})
+	}
+
+	body, _ := json.Marshal(&ev)
+	return body
+}
+
+// setPreferences of a member through the handler.
+func setPreferences(t *testing.T, p member.Preferences) {
+	body, _ := json.Marshal(&p)
+	if err := h(context.Background(), "preferences-changed", body); err != nil {
+		t.Fatalf("Can't set preferences [Error]: %v", err)
+	}
+}
+
+func TestMessageHandler(t *testing.T) {
+	gid := uuid.New()
+	mid := uuid.New()
+	body := balanceChange(gid, nil, map[uuid.UUID]float32{mid: 0})
+	pbody, _ := json.Marshal(&member.Preferences{MemberID: mid, GroupID: gid, Email: "test@example.com"})
+	dbody, _ := json.Marshal(&group.Deletion{GroupID: gid})
+
+	cases := []struct {
+		op   string
+		body []byte
+		fail bool
+	}{
+		{"balance-changed", body, false},
+		{"balance-changed", []byte("test"), true},
+		{"preferences-changed", pbody, false},
+		{"preferences-changed", []byte("test"), true},
+		{"group-deleted", dbody, false},
+		{"group-deleted", []byte("test"), true},
+		{"webhook-queued", nil, false},
+	}
+
+	for _, tc := range cases {
+		t.Run(fmt.Sprintf("%s %t", tc.op, tc.fail), func(t *testing.T) {
+			if err := h(context.Background(), tc.op, tc.body); (err != nil) != tc.fail {
+				t.Errorf("Wrong handler result [Expected failure]: %t [Error]: %v", tc.fail, err)
+			}
+		})
+	}
+
+	// The deleted group's members are forgotten
+	var count int
+	db.Model(&recipient.Recipient{}).Where("group_id = ?", gid).Count(&count)
+	if count != 0 {
+		t.Errorf("Wrong recipient count [Expected]: %d [Actual]: %d", 0, count)
+	}
+
+	clearDB()
+}
+
+func TestMessageHandlerReplayed(t *testing.T) {
+	gid := uuid.New()
+	mid := uuid.New()
+	ctx := inbox.WithMessageID(context.Background(), "msg1")
+
+	if err := h(ctx, "balance-changed", balanceChange(gid, nil, map[uuid.UUID]float32{mid: 5})); err != nil {
+		t.Fatalf("Can't handle balance change [Error]: %v", err)
+	}
+	h(context.Background(), "balance-changed", balanceChange(gid, nil, map[uuid.UUID]float32{mid: 8}))
+
+	// Replaying the first event can't bring back its balance
+	if err := h(ctx, "balance-changed", balanceChange(gid, nil, map[uuid.UUID]float32{mid: 5})); err != inbox.ErrHandled {
+		t.Errorf("Wrong error [Expected]: %v [Actual]: %v", inbox.ErrHandled, err)
+	}
+
+	var r recipient.Recipient
+	db.First(&r, "member_id = ?", mid)
+	if r.Balance != 8 {
+		t.Errorf("Wrong balance [Expected]: %f [Actual]: %f", 8.0, r.Balance)
+	}
+
+	clearDB()
+}
+
+func TestExpenseEmails(t *testing.T) {
+	gid := uuid.New()
+	payer, r1, r2, r3 := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	balances := map[uuid.UUID]float32{payer: 0, r1: 0, r2: 0, r3: 0}
+	h(context.Background(), "balance-changed", balanceChange(gid, nil, balances))
+
+	// Payer and r1 ask for emails, r2 doesn't, r3 isn't involved
+	setPreferences(t, member.Preferences{MemberID: payer, GroupID: gid, Email: "payer@example.com", ExpenseAdded: true})
+	setPreferences(t, member.Preferences{MemberID: r1, GroupID: gid, Email: "r1@example.com", ExpenseAdded: true})
+	setPreferences(t, member.Preferences{MemberID: r2, GroupID: gid, Email: "r2@example.com"})
+	setPreferences(t, member.Preferences{MemberID: r3, GroupID: gid, Email: "r3@example.com", ExpenseAdded: true})
+
+	e := expense.Expense{ID: uuid.New(), GroupID: gid, Amount: 30, Description: "Dinner", Payer: payer, Recipients: strings.Join([]string{payer.String(), r1.String(), r2.String()}, ";")}
+	balances = map[uuid.UUID]float32{payer: 20, r1: -10, r2: -10, r3: 0}
+	if err := h(context.Background(), "balance-changed", balanceChange(gid, &e, balances)); err != nil {
+		t.Fatalf("Operation can't finish correctly [Error]: %v", err)
+	}
+
+	msgs := takeSent()
+	if len(msgs) != 1 || msgs[0].To != "r1@example.com" {
+		t.Fatalf("Wrong emails sent [Expected]: %s [Actual]: %+v", "r1@example.com", msgs)
+	}
+	for _, s := range []string{"Dinner", "30.00", "Your share: 10.00", "-10.00"} {
+		if !strings.Contains(msgs[0].Body, s) {
+			t.Errorf("Email body doesn't contain %q [Body]: %s", s, msgs[0].Body)
+		}
+	}
+	if msgs[0].Subject != "New expense in Trip" {
+		t.Errorf("Wrong subject [Expected]: %s [Actual]: %s", "New expense in Trip", msgs[0].Subject)
+	}
+
+	// Failing to send doesn't fail the message
+	failing = true
+	defer func() { failing = false }()
+	if err := h(context.Background(), "balance-changed", balanceChange(gid, &e, balances)); err != nil {
+		t.Errorf("Failed email failed the message [Error]: %v", err)
+	}
+
+	clearDB()
+}
+
+func TestOwedEmails(t *testing.T) {
+	gid := uuid.New()
+	mid, other := uuid.New(), uuid.New()
+	setPreferences(t, member.Preferences{MemberID: mid, GroupID: gid, Email: "test@example.com", OwedThreshold: 50})
+
+	cases := []struct {
+		balance float32
+		emails  int
+	}{
+		{40, 0},  // under the threshold
+		{60, 1},  // over it
+		{70, 0},  // already told
+		{30, 0},  // back under it
+		{55, 1},  // over it again
+		{-10, 0}, // owing money
+	}
+
+	for _, tc := range cases {
+		h(context.Background(), "balance-changed", balanceChange(gid, nil, map[uuid.UUID]float32{mid: tc.balance, other: -tc.balance}))
+
+		if msgs := takeSent(); len(msgs) != tc.emails {
+			t.Errorf("Wrong emails sent [Balance]: %v [Expected]: %d [Actual]: %d", tc.balance, tc.emails, len(msgs))
+		}
+	}
+
+	clearDB()
+}
@@ -0,0 +1,149 @@
+package notifier
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"github.com/varrrro/pay-up/internal/logging"
+)
+
+// Message sent by email, with a plain text body.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Transport that sends emails.
+type Transport interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// SMTPTransport sends emails through an SMTP server, upgrading the
+// connection with STARTTLS when the server supports it. Auth is optional.
+type SMTPTransport struct {
+	Addr string
+	From string
+	Auth smtp.Auth
+}
+
+// NewSMTPTransport for the server at addr, logging in with PLAIN
+// authentication if a user is given.
+func NewSMTPTransport(addr, from, user, password string) (*SMTPTransport, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid SMTP address [Address]: %s [Error]: %s", addr, err.Error())
+	}
+
+	t := &SMTPTransport{Addr: addr, From: from}
+	if user != "" {
+		t.Auth = smtp.PlainAuth("", user, password, host)
+	}
+
+	return t, nil
+}
+
+// Send the message, giving up when the context is done.
+func (t *SMTPTransport) Send(ctx context.Context, msg Message) error {
+	host, _, err := net.SplitHostPort(t.Addr)
+	if err != nil {
+		return err
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", t.Addr)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if t.Auth != nil {
+		if err := c.Auth(t.Auth); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Mail(t.From); err != nil {
+		return err
+	}
+	if err := c.Rcpt(msg.To); err != nil {
+		return err
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(t.format(msg)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
+
+// format the message with its headers. Line breaks are normalized by the
+// SMTP client.
+func (t *SMTPTransport) format(msg Message) []byte {
+	var b strings.Builder
+
+	// Line breaks in headers would let templates inject other headers
+	subject := strings.NewReplacer("\r", " ", "\n", " ").Replace(msg.Subject)
+
+	fmt.Fprintf(&b, "From: %s\n", t.From)
+	fmt.Fprintf(&b, "To: %s\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&b, "Date: %s\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "Message-ID: <%s@payup>\n", uuid.New())
+	b.WriteString("MIME-Version: 1.0\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\n\n")
+	b.WriteString(msg.Body)
+
+	return []byte(b.String())
+}
+
+// LogTransport logs emails instead of sending them, for development setups
+// without an SMTP server.
+type LogTransport struct{}
+
+// Send logs the message.
+func (LogTransport) Send(ctx context.Context, msg Message) error {
+	logging.FromContext(ctx).WithFields(log.Fields{
+		"to":      msg.To,
+		"subject": msg.Subject,
+	}).Info("Email not sent, no SMTP server configured\n" + msg.Body)
+	return nil
+}
+
+// MockTransport used in tests.
+type MockTransport func(msg Message) error
+
+// Send calls the mock function.
+func (t MockTransport) Send(ctx context.Context, msg Message) error {
+	return t(msg)
+}
@@ -0,0 +1,130 @@
+package notifier_test
+
+import (
+	"bufio"
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/varrrro/pay-up/internal/notifier"
+)
+
+// smtpServer accepting a single message on a local port, sending what it
+// received to the returned channel.
+func smtpServer(t *testing.T) (string, <-chan string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Can't listen [Error]: %v", err)
+	}
+
+	received := make(chan string, 1)
+	go func() {
+		defer l.Close()
+
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+		var session strings.Builder
+		r := bufio.NewReader(conn)
+		reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+
+		reply("220 localhost ESMTP test")
+		data := false
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				break
+			}
+			session.WriteString(line)
+
+			switch {
+			case data && line == ".\r\n":
+				data = false
+				reply("250 OK")
+			case data:
+			case strings.HasPrefix(line, "EHLO"):
+				reply("250-localhost")
+				reply("250 8BITMIME")
+			case strings.HasPrefix(line, "DATA"):
+				data = true
+				reply("354 Go ahead")
+			case strings.HasPrefix(line, "QUIT"):
+				reply("221 Bye")
+				received <- session.String()
+				return
+			default:
+				reply("250 OK")
+			}
+		}
+		close(received)
+	}()
+
+	return l.Addr().String(), received
+}
+
+func TestSMTPTransport(t *testing.T) {
+	addr, received := smtpServer(t)
+
+	tr, err := notifier.NewSMTPTransport(addr, "payup@example.com", "", "")
+	if err != nil {
+		t.Fatalf("Can't create transport [Error]: %v", err)
+	}
+
+	msg := notifier.Message{To: "test@example.com", Subject: "Hi\r\nBcc: evil@example.com", Body: "Hello\nWorld\n"}
+	if err := tr.Send(context.Background(), msg); err != nil {
+		t.Fatalf("Can't send email [Error]: %v", err)
+	}
+
+	session := <-received
+	for _, s := range []string{
+		"MAIL FROM:<payup@example.com>",
+		"RCPT TO:<test@example.com>",
+		"Subject: Hi  Bcc: evil@example.com\r\n",
+		"Content-Type: text/plain; charset=utf-8\r\n",
+		"\r\n\r\nHello\r\nWorld\r\n.\r\n",
+	} {
+		if !strings.Contains(session, s) {
+			t.Errorf("SMTP session doesn't contain %q [Session]: %s", s, session)
+		}
+	}
+
+	// Unreachable servers fail
+	if err := tr.Send(context.Background(), msg); err == nil {
+		t.Error("Sending to a closed server didn't fail")
+	}
+}
+
+func TestLoadTemplates(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "templates")
+	defer os.RemoveAll(dir)
+
+	// Files override the defaults
+	ioutil.WriteFile(filepath.Join(dir, "owed.tmpl"), []byte(`{{define "subject"}}Owed {{.Balance}}{{end}}Pay {{.Name}}`), 0644)
+	ts, err := notifier.LoadTemplates(dir)
+	if err != nil {
+		t.Fatalf("Can't load templates [Error]: %v", err)
+	}
+
+	subject, body, err := ts.Render(notifier.OwedTemplate, &notifier.OwedData{Name: "Test", Balance: 10})
+	if err != nil || subject != "Owed 10" || body != "Pay Test" {
+		t.Errorf("Wrong rendered email [Subject]: %s [Body]: %s [Error]: %v", subject, body, err)
+	}
+	if _, _, err := ts.Render(notifier.DigestTemplate, &notifier.DigestData{}); err != nil {
+		t.Errorf("Can't render default template [Error]: %v", err)
+	}
+
+	// Templates must have a subject
+	ioutil.WriteFile(filepath.Join(dir, "digest.tmpl"), []byte(`No subject`), 0644)
+	if _, err := notifier.LoadTemplates(dir); err == nil {
+		t.Error("Template without subject was loaded")
+	}
+}
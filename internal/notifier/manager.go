@@ -0,0 +1,200 @@
+package notifier
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+	"github.com/varrrro/pay-up/internal/dberr"
+	"github.com/varrrro/pay-up/internal/gmicro/group"
+	"github.com/varrrro/pay-up/internal/gmicro/member"
+	"github.com/varrrro/pay-up/internal/inbox"
+	"github.com/varrrro/pay-up/internal/notifier/recipient"
+	"github.com/varrrro/pay-up/internal/tracing"
+)
+
+// DigestPeriod between the digests sent to a member.
+var DigestPeriod = 7 * 24 * time.Hour
+
+// Manager interface for the notifier.
+type Manager interface {
+	UpdateBalances(ev *group.BalanceChange) ([]recipient.Recipient, error)
+	UpdatePreferences(p *member.Preferences) error
+	SetOwedNotified(mid uuid.UUID, notified bool) error
+	DeleteGroup(gid uuid.UUID) error
+	DueDigests(now time.Time) ([]recipient.Recipient, error)
+	ClaimDigest(mid uuid.UUID, now, next time.Time) (bool, error)
+	WithContext(ctx context.Context) Manager
+}
+
+// RecipientsManager keeping the notifier's view of the members of each group,
+// built from the events it consumes.
+type RecipientsManager struct {
+	DB      *gorm.DB
+	message string // ID of the AMQP message being handled, if any
+}
+
+// NewManager with the given database connection.
+func NewManager(db *gorm.DB) *RecipientsManager {
+	return &RecipientsManager{DB: db}
+}
+
+// WithContext returns a manager whose queries are traced as part of the
+// message being handled in ctx. Balances and preferences updated by the
+// message record it as handled, and return inbox.ErrHandled if it already
+// was, so a replayed event can't bring back old values.
+func (rm *RecipientsManager) WithContext(ctx context.Context) Manager {
+	return &RecipientsManager{DB: tracing.WithContext(ctx, rm.DB), message: inbox.MessageID(ctx)}
+}
+
+// UpdateBalances of the members of a group, forgetting the members that
+// aren't in it anymore, and returning the group's recipients afterwards.
+func (rm *RecipientsManager) UpdateBalances(ev *group.BalanceChange) ([]recipient.Recipient, error) {
+	recipients := []recipient.Recipient{}
+
+	tx := rm.DB.Begin()
+	if err := tx.Error; err != nil {
+		return recipients, dberr.Wrap("begin transaction", err)
+	}
+
+	if err := inbox.Record(tx, rm.message); err != nil {
+		tx.Rollback()
+		return recipients, err
+	}
+
+	now := time.Now().UTC()
+	mids := make([]uuid.UUID, 0, len(ev.Balances))
+	for _, b := range ev.Balances {
+		mids = append(mids, b.MemberID)
+
+		changes := map[string]interface{}{
+			"group_id":   ev.GroupID,
+			"group_name": ev.GroupName,
+			"name":       b.Name,
+			"balance":    b.Balance,
+			"updated_at": now,
+		}
+		if err := upsert(tx, "update balances", b.MemberID, changes); err != nil {
+			tx.Rollback()
+			return recipients, err
+		}
+	}
+
+	del := tx.Where("group_id = ?", ev.GroupID)
+	if len(mids) > 0 {
+		del = del.Where("member_id NOT IN (?)", mids)
+	}
+	if err := del.Delete(&recipient.Recipient{}).Error; err != nil {
+		tx.Rollback()
+		return recipients, dberr.Wrap("update balances", err)
+	}
+
+	if err := tx.Where("group_id = ?", ev.GroupID).Find(&recipients).Error; err != nil {
+		tx.Rollback()
+		return recipients, dberr.Wrap("update balances", err)
+	}
+
+	return recipients, dberr.Wrap("commit transaction", tx.Commit().Error)
+}
+
+// UpdatePreferences of a member. The first digest is sent a period after
+// the member asks for them.
+func (rm *RecipientsManager) UpdatePreferences(p *member.Preferences) error {
+	var r recipient.Recipient
+
+	tx := rm.DB.Begin()
+	if err := tx.Error; err != nil {
+		return dberr.Wrap("begin transaction", err)
+	}
+
+	if err := inbox.Record(tx, rm.message); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err := tx.First(&r, "member_id = ?", p.MemberID).Error
+	if err != nil && !gorm.IsRecordNotFoundError(err) {
+		tx.Rollback()
+		return dberr.Wrap("update preferences", err)
+	}
+
+	changes := map[string]interface{}{
+		"group_id":       p.GroupID,
+		"email":          p.Email,
+		"expense_added":  p.ExpenseAdded,
+		"owed_threshold": p.OwedThreshold,
+		"weekly_digest":  p.WeeklyDigest,
+		"updated_at":     time.Now().UTC(),
+	}
+	if !p.WeeklyDigest {
+		changes["next_digest_at"] = nil
+	} else if r.NextDigestAt == nil {
+		changes["next_digest_at"] = time.Now().UTC().Add(DigestPeriod)
+	}
+
+	if err := upsert(tx, "update preferences", p.MemberID, changes); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return dberr.Wrap("commit transaction", tx.Commit().Error)
+}
+
+// SetOwedNotified records whether the member was told they're owed more than
+// their threshold.
+func (rm *RecipientsManager) SetOwedNotified(mid uuid.UUID, notified bool) error {
+	err := rm.DB.Model(&recipient.Recipient{}).
+		Where("member_id = ?", mid).
+		UpdateColumn("owed_notified", notified).Error
+
+	return dberr.Wrap("set owed notified", err)
+}
+
+// DeleteGroup forgetting its members.
+func (rm *RecipientsManager) DeleteGroup(gid uuid.UUID) error {
+	return dberr.Wrap("delete group", rm.DB.Where("group_id = ?", gid).Delete(&recipient.Recipient{}).Error)
+}
+
+// DueDigests returns the recipients whose digest is due, sorted by email so
+// the ones sharing an address are next to each other.
+func (rm *RecipientsManager) DueDigests(now time.Time) ([]recipient.Recipient, error) {
+	due := []recipient.Recipient{}
+
+	err := rm.DB.Where("weekly_digest = ? AND email <> '' AND next_digest_at <= ?", true, now).
+		Order("email, group_name").
+		Find(&due).Error
+
+	return due, dberr.Wrap("find due digests", err)
+}
+
+// ClaimDigest of a member that's due at the given time by moving it to the
+// next one, unless another instance claimed it first.
+func (rm *RecipientsManager) ClaimDigest(mid uuid.UUID, now, next time.Time) (bool, error) {
+	res := rm.DB.Model(&recipient.Recipient{}).
+		Where("member_id = ? AND next_digest_at <= ?", mid, now).
+		UpdateColumn("next_digest_at", next)
+	if res.Error != nil {
+		return false, dberr.Wrap("claim digest", res.Error)
+	}
+
+	return res.RowsAffected == 1, nil
+}
+
+// upsert the columns of a member's recipient, creating it if there's none.
+func upsert(db *gorm.DB, op string, mid uuid.UUID, changes map[string]interface{}) error {
+	res := db.Model(&recipient.Recipient{}).Where("member_id = ?", mid).UpdateColumns(changes)
+	if res.Error != nil {
+		return dberr.Wrap(op, res.Error)
+	}
+	if res.RowsAffected > 0 {
+		return nil
+	}
+
+	r := recipient.Recipient{MemberID: mid}
+	if err := db.Create(&r).Error; err != nil {
+		return dberr.Wrap(op, err)
+	}
+
+	return dberr.Wrap(op, db.Model(&recipient.Recipient{}).Where("member_id = ?", mid).UpdateColumns(changes).Error)
+}
@@ -0,0 +1,59 @@
+package notifier
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+	"github.com/varrrro/pay-up/internal/migrate"
+)
+
+// MigrationsTable keeping the applied migrations of the notifier's schema.
+const MigrationsTable = "notifier_migrations"
+
+// Migrations of the notifier's schema, in order. Applied migrations must
+// never change, since deployed databases already went through them.
+var Migrations = []migrate.Migration{
+	{
+		Version: 1,
+		Name:    "create_recipients",
+		Up: func(tx *gorm.DB) error {
+			return migrate.CreateTable(tx, "recipients", &recipientV1{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return migrate.DropTable(tx, "recipients")
+		},
+	},
+	{
+		Version: 2,
+		Name:    "create_handled_messages",
+		Up: func(tx *gorm.DB) error {
+			return migrate.CreateTable(tx, "handled_messages", &handledMessageV2{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return migrate.DropTable(tx, "handled_messages")
+		},
+	},
+}
+
+// Snapshots of the schema at each version.
+
+type recipientV1 struct {
+	MemberID      uuid.UUID `gorm:"type:uuid;primary_key"`
+	GroupID       uuid.UUID `gorm:"type:uuid;index"`
+	GroupName     string
+	Name          string
+	Balance       float32
+	Email         string
+	ExpenseAdded  bool
+	OwedThreshold float32
+	WeeklyDigest  bool
+	OwedNotified  bool
+	NextDigestAt  *time.Time `gorm:"index"`
+	UpdatedAt     time.Time  `gorm:"not null;default:CURRENT_TIMESTAMP"`
+}
+
+type handledMessageV2 struct {
+	ID        string `gorm:"primary_key"`
+	HandledAt time.Time
+}
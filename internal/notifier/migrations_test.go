@@ -0,0 +1,56 @@
+package notifier_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/postgres"
+	"github.com/varrrro/pay-up/internal/inbox"
+	"github.com/varrrro/pay-up/internal/migrate"
+	"github.com/varrrro/pay-up/internal/notifier"
+	"github.com/varrrro/pay-up/internal/notifier/recipient"
+)
+
+func TestMigrations(t *testing.T) {
+	dbs := make(map[string]*gorm.DB)
+	mdb, _ := gorm.Open("sqlite3", ":memory:")
+	mdb.DB().SetMaxOpenConns(1) // every connection opens a new in-memory database
+	dbs["sqlite3"] = mdb
+
+	// PostgreSQL is only tested when a database is available
+	if conn := os.Getenv("TEST_POSTGRES_CONN"); conn != "" {
+		pdb, err := gorm.Open("postgres", conn)
+		if err != nil {
+			t.Fatalf("Can't open PostgreSQL database [Error]: %v", err)
+		}
+		dbs["postgres"] = pdb
+	}
+
+	for name, mdb := range dbs {
+		t.Run(name, func(t *testing.T) {
+			defer mdb.Close()
+			defer mdb.DropTableIfExists(notifier.MigrationsTable)
+			mig, _ := migrate.New(mdb, notifier.MigrationsTable, notifier.Migrations)
+
+			if _, err := mig.Up(); err != nil {
+				t.Fatalf("Couldn't apply migrations [Error]: %v", err)
+			}
+			for _, model := range []interface{}{&recipient.Recipient{}, &inbox.Message{}} {
+				scope := mdb.NewScope(model)
+				for _, f := range scope.GetModelStruct().StructFields {
+					if f.IsNormal && !f.IsIgnored && !mdb.Dialect().HasColumn(scope.TableName(), f.DBName) {
+						t.Errorf("Missing column [Table]: %s [Column]: %s", scope.TableName(), f.DBName)
+					}
+				}
+			}
+
+			if _, err := mig.Down(len(notifier.Migrations)); err != nil {
+				t.Errorf("Couldn't revert every migration [Error]: %v", err)
+			}
+			if mdb.HasTable("recipients") || mdb.HasTable("handled_messages") {
+				t.Error("Tables weren't dropped")
+			}
+		})
+	}
+}
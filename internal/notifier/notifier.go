@@ -0,0 +1,53 @@
+package notifier
+
+import (
+	"context"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/varrrro/pay-up/internal/logging"
+	"github.com/varrrro/pay-up/internal/metrics"
+)
+
+// SendTimeout of each email.
+var SendTimeout = 30 * time.Second
+
+// Mailer renders emails from templates and sends them through a transport.
+type Mailer struct {
+	Transport Transport
+	Templates *Templates
+	Timeout   time.Duration
+}
+
+// NewMailer with the given transport and templates.
+func NewMailer(t Transport, ts *Templates) *Mailer {
+	return &Mailer{Transport: t, Templates: ts, Timeout: SendTimeout}
+}
+
+// Send the email rendered from the template to the given address.
+func (ml *Mailer) Send(ctx context.Context, name, to string, data interface{}) error {
+	logger := logging.FromContext(ctx).WithFields(log.Fields{
+		"template": name,
+		"to":       to,
+	})
+
+	subject, body, err := ml.Templates.Render(name, data)
+	if err != nil {
+		logger.WithError(err).Error("Can't render email")
+		metrics.EmailsSent.WithLabelValues(name, "error").Inc()
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, ml.Timeout)
+	defer cancel()
+
+	if err := ml.Transport.Send(ctx, Message{To: to, Subject: subject, Body: body}); err != nil {
+		logger.WithError(err).Warn("Can't send email")
+		metrics.EmailsSent.WithLabelValues(name, "error").Inc()
+		return err
+	}
+
+	logger.Info("Email sent")
+	metrics.EmailsSent.WithLabelValues(name, "ok").Inc()
+	return nil
+}
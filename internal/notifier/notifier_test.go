@@ -0,0 +1,83 @@
+package notifier_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"github.com/varrrro/pay-up/internal/inbox"
+	"github.com/varrrro/pay-up/internal/migrate"
+	"github.com/varrrro/pay-up/internal/notifier"
+	"github.com/varrrro/pay-up/internal/notifier/recipient"
+)
+
+var db *gorm.DB
+var nm *notifier.RecipientsManager
+var ml *notifier.Mailer
+var h func(context.Context, string, []byte) error
+
+// sent keeps the emails sent through the mock transport, which fails while
+// failing is set.
+var (
+	mu      sync.Mutex
+	sent    []notifier.Message
+	failing bool
+)
+
+var transport = notifier.MockTransport(func(msg notifier.Message) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if failing {
+		return errors.New("Sending failed")
+	}
+	sent = append(sent, msg)
+	return nil
+})
+
+func TestMain(m *testing.M) {
+	// Open connection to test DB
+	db, _ = gorm.Open("sqlite3", ":memory:")
+	db.DB().SetMaxOpenConns(1) // every connection opens a new in-memory database
+	defer db.Close()
+
+	// Create tables
+	mig, _ := migrate.New(db, notifier.MigrationsTable, notifier.Migrations)
+	if _, err := mig.Up(); err != nil {
+		panic(err)
+	}
+
+	// Create manager and mailer with the test DB and transport
+	nm = notifier.NewManager(db)
+	ts, err := notifier.LoadTemplates("")
+	if err != nil {
+		panic(err)
+	}
+	ml = notifier.NewMailer(transport, ts)
+
+	// Create AMQP message handler
+	h = notifier.MessageHandler(nm, ml)
+
+	// Run tests
+	os.Exit(m.Run())
+}
+
+// takeSent returns the emails sent so far, forgetting them.
+func takeSent() []notifier.Message {
+	mu.Lock()
+	defer mu.Unlock()
+
+	msgs := sent
+	sent = nil
+	return msgs
+}
+
+func clearDB() {
+	db.Delete(&inbox.Message{})
+	db.Delete(&recipient.Recipient{})
+	takeSent()
+}
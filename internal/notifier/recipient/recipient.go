@@ -0,0 +1,31 @@
+package recipient
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Recipient of notifications, as the notifier knows a member of a group from
+// the balance changes and preferences it was sent. OwedNotified is set once
+// the member was told they're owed more than their threshold, until the
+// balance drops back under it.
+type Recipient struct {
+	MemberID      uuid.UUID  `json:"member_id" gorm:"type:uuid;primary_key"`
+	GroupID       uuid.UUID  `json:"group_id" gorm:"type:uuid"`
+	GroupName     string     `json:"group_name"`
+	Name          string     `json:"name"`
+	Balance       float32    `json:"balance"`
+	Email         string     `json:"email"`
+	ExpenseAdded  bool       `json:"expense_added"`
+	OwedThreshold float32    `json:"owed_threshold"`
+	WeeklyDigest  bool       `json:"weekly_digest"`
+	OwedNotified  bool       `json:"owed_notified"`
+	NextDigestAt  *time.Time `json:"next_digest_at"`
+	UpdatedAt     time.Time  `json:"updated_at" gorm:"not null;default:CURRENT_TIMESTAMP"`
+}
+
+// Owed reports whether the member is owed more than their threshold.
+func (r *Recipient) Owed() bool {
+	return r.Email != "" && r.OwedThreshold > 0 && r.Balance > r.OwedThreshold
+}
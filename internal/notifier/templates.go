@@ -0,0 +1,138 @@
+package notifier
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Names of the email templates, which are also the names of the files that
+// override them.
+const (
+	ExpenseTemplate = "expense-added"
+	OwedTemplate    = "owed"
+	DigestTemplate  = "digest"
+)
+
+// ExpenseData of the email sent to members involved in a new expense.
+type ExpenseData struct {
+	Name        string
+	GroupName   string
+	Payer       string
+	Description string
+	Amount      float32
+	Share       float32
+	Date        time.Time
+	Balance     float32
+}
+
+// OwedData of the email sent to members owed more than their threshold.
+type OwedData struct {
+	Name      string
+	GroupName string
+	Balance   float32
+	Threshold float32
+}
+
+// DigestData of the weekly email with the balances of a member in each of
+// their groups.
+type DigestData struct {
+	Email  string
+	Groups []DigestGroup
+}
+
+// DigestGroup with the balance of the member in a group.
+type DigestGroup struct {
+	GroupName string
+	Name      string
+	Balance   float32
+}
+
+// defaults of the templates. Each one defines a "subject" template, and the
+// rest is the body.
+var defaults = map[string]string{
+	ExpenseTemplate: `{{define "subject"}}New expense in {{.GroupName}}{{end -}}
+Hi {{.Name}},
+
+{{.Payer}} added an expense in {{.GroupName}} that involves you:
+
+  {{if .Description}}{{.Description}}{{else}}Expense{{end}} on {{.Date.Format "2 Jan 2006"}}
+  Total: {{printf "%.2f" .Amount}}
+  Your share: {{printf "%.2f" .Share}}
+
+Your balance in the group is now {{printf "%.2f" .Balance}}.
+`,
+	OwedTemplate: `{{define "subject"}}You're owed {{printf "%.2f" .Balance}} in {{.GroupName}}{{end -}}
+Hi {{.Name}},
+
+The other members of {{.GroupName}} owe you {{printf "%.2f" .Balance}}, more
+than the {{printf "%.2f" .Threshold}} you asked to be told about. It may be a
+good time to settle up.
+`,
+	DigestTemplate: `{{define "subject"}}Your weekly balances{{end -}}
+Here are your balances this week:
+{{range .Groups}}
+  {{.GroupName}} ({{.Name}}): {{if gt .Balance 0.0}}you're owed {{printf "%.2f" .Balance}}{{else if lt .Balance 0.0}}you owe {{printf "%.2f" (neg .Balance)}}{{else}}settled{{end}}
+{{- end}}
+`,
+}
+
+var funcs = template.FuncMap{
+	"neg": func(f float32) float32 { return -f },
+}
+
+// Templates of the emails.
+type Templates struct {
+	t map[string]*template.Template
+}
+
+// LoadTemplates using the defaults, overridden by the files named after each
+// template with the .tmpl extension in dir, if given.
+func LoadTemplates(dir string) (*Templates, error) {
+	ts := &Templates{t: make(map[string]*template.Template, len(defaults))}
+
+	for name, text := range defaults {
+		if dir != "" {
+			path := filepath.Join(dir, name+".tmpl")
+			b, err := ioutil.ReadFile(path)
+			if err == nil {
+				text = string(b)
+			} else if !os.IsNotExist(err) {
+				return nil, fmt.Errorf("Can't read template [File]: %s [Error]: %s", path, err.Error())
+			}
+		}
+
+		t, err := template.New(name).Funcs(funcs).Option("missingkey=error").Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("Can't parse template [Name]: %s [Error]: %s", name, err.Error())
+		}
+		if t.Lookup("subject") == nil {
+			return nil, fmt.Errorf("Template doesn't define a subject [Name]: %s", name)
+		}
+		ts.t[name] = t
+	}
+
+	return ts, nil
+}
+
+// Render the subject and body of the template with the given data.
+func (ts *Templates) Render(name string, data interface{}) (string, string, error) {
+	t, ok := ts.t[name]
+	if !ok {
+		return "", "", fmt.Errorf("Unknown template [Name]: %s", name)
+	}
+
+	var subject, body strings.Builder
+	if err := t.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return "", "", err
+	}
+	if err := t.Execute(&body, data); err != nil {
+		return "", "", err
+	}
+
+	return strings.TrimSpace(subject.String()), body.String(), nil
+}
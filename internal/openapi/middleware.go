@@ -0,0 +1,119 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/varrrro/pay-up/internal/logging"
+	"github.com/varrrro/pay-up/internal/problem"
+)
+
+// Handler that serves the document as JSON.
+func Handler(d *Document) func(http.ResponseWriter, *http.Request) {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(d)
+	}
+}
+
+// ValidationMiddleware that checks path parameters, query parameters and
+// request bodies against the operation matched by the router.
+func ValidationMiddleware(d *Document) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			route := mux.CurrentRoute(r)
+			if route == nil {
+				next.ServeHTTP(rw, r)
+				return
+			}
+
+			tmpl, err := route.GetPathTemplate()
+			if err != nil {
+				next.ServeHTTP(rw, r)
+				return
+			}
+
+			op := d.Operation(tmpl, r.Method)
+			if op == nil {
+				next.ServeHTTP(rw, r)
+				return
+			}
+
+			errs := d.validateRequest(op, r)
+			if len(errs) > 0 {
+				logging.FromContext(r.Context()).WithField("errors", errs).Warn("Request doesn't match API specification")
+
+				problem.Write(rw, r, problem.New(http.StatusBadRequest, "validation_failed", "Request doesn't match API specification").With("fields", errs))
+				return
+			}
+
+			next.ServeHTTP(rw, r)
+		})
+	}
+}
+
+func (d *Document) validateRequest(op *Operation, r *http.Request) []FieldError {
+	var errs []FieldError
+
+	// Check parameters
+	vars := mux.Vars(r)
+	query := r.URL.Query()
+	for _, p := range op.Parameters {
+		var raw string
+		var present bool
+		switch p.In {
+		case "path":
+			raw, present = vars[p.Name]
+		case "query":
+			raw, present = query.Get(p.Name), query.Get(p.Name) != ""
+		case "header":
+			raw, present = r.Header.Get(p.Name), r.Header.Get(p.Name) != ""
+		default:
+			continue
+		}
+
+		if !present {
+			if p.Required {
+				errs = append(errs, FieldError{p.Name, "is required"})
+			}
+			continue
+		}
+
+		errs = append(errs, d.ValidateParam(p.Schema, raw, p.Name)...)
+	}
+
+	// Check body
+	if op.RequestBody == nil {
+		return errs
+	}
+
+	var body []byte
+	if r.Body != nil {
+		body, _ = ioutil.ReadAll(r.Body)
+		r.Body.Close()
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	if len(bytes.TrimSpace(body)) == 0 {
+		if op.RequestBody.Required {
+			errs = append(errs, FieldError{"body", "is required"})
+		}
+		return errs
+	}
+
+	mt, ok := op.RequestBody.Content["application/json"]
+	if !ok {
+		return errs
+	}
+
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		return append(errs, FieldError{"body", "must be valid JSON"})
+	}
+
+	return append(errs, d.Validate(mt.Schema, v, "")...)
+}
@@ -0,0 +1,106 @@
+package openapi
+
+import "strings"
+
+// Document following the OpenAPI 3.0 specification. Only the parts used to
+// describe and validate this project's APIs are modelled.
+type Document struct {
+	OpenAPI    string              `json:"openapi"`
+	Info       Info                `json:"info"`
+	Paths      map[string]PathItem `json:"paths"`
+	Components Components          `json:"components"`
+}
+
+// Info about the API.
+type Info struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+// PathItem with the operations available for a path, by lowercase method.
+type PathItem map[string]*Operation
+
+// Operation on a path.
+type Operation struct {
+	OperationID string               `json:"operationId"`
+	Summary     string               `json:"summary,omitempty"`
+	Parameters  []Parameter          `json:"parameters,omitempty"`
+	RequestBody *RequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*Response `json:"responses"`
+}
+
+// Parameter of an operation, in the path, query or a header.
+type Parameter struct {
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required,omitempty"`
+	Schema   *Schema `json:"schema"`
+}
+
+// RequestBody of an operation, by media type.
+type RequestBody struct {
+	Required bool                  `json:"required,omitempty"`
+	Content  map[string]*MediaType `json:"content"`
+}
+
+// Response of an operation.
+type Response struct {
+	Description string                `json:"description"`
+	Headers     map[string]*Header    `json:"headers,omitempty"`
+	Content     map[string]*MediaType `json:"content,omitempty"`
+}
+
+// Header sent with a response.
+type Header struct {
+	Description string  `json:"description,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+// MediaType with the schema of its content.
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Components that can be referenced from elsewhere in the document.
+type Components struct {
+	Schemas map[string]*Schema `json:"schemas"`
+}
+
+// Schema of a value, a subset of JSON Schema as used by OpenAPI 3.0.
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	ReadOnly             bool               `json:"readOnly,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	ExclusiveMinimum     bool               `json:"exclusiveMinimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *bool              `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+}
+
+// Operation for the given path template and method, if present.
+func (d *Document) Operation(path, method string) *Operation {
+	item, ok := d.Paths[path]
+	if !ok {
+		return nil
+	}
+
+	return item[strings.ToLower(method)]
+}
+
+// Resolve a schema reference to the component it points to.
+func (d *Document) Resolve(s *Schema) *Schema {
+	for s != nil && s.Ref != "" {
+		s = d.Components.Schemas[s.Ref[len("#/components/schemas/"):]]
+	}
+
+	return s
+}
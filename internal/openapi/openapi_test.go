@@ -0,0 +1,126 @@
+package openapi_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/varrrro/pay-up/internal/openapi"
+)
+
+func TestHandler(t *testing.T) {
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/openapi.json", nil)
+	openapi.Handler(openapi.GatewaySpec())(rec, req)
+	res := rec.Result()
+	defer res.Body.Close()
+
+	var doc openapi.Document
+	if err := json.NewDecoder(res.Body).Decode(&doc); err != nil {
+		t.Fatalf("Can't decode document [Error]: %v", err)
+	}
+
+	if doc.Operation("/groups/{groupid}/expenses", "POST") == nil {
+		t.Error("Gateway document doesn't describe expenses")
+	} else if openapi.GroupsSpec().Operation("/groups/{groupid}/expenses", "POST") != nil {
+		t.Error("Groups document describes expenses")
+	}
+}
+
+func TestValidationMiddleware(t *testing.T) {
+	ok := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	})
+
+	r := mux.NewRouter()
+	r.Use(openapi.ValidationMiddleware(openapi.GatewaySpec()))
+	r.Handle("/groups", ok).Methods("POST")
+	r.Handle("/groups/{groupid}", ok).Methods("GET", "PUT")
+	r.Handle("/groups/{groupid}/expenses", ok).Methods("POST")
+
+	gid := uuid.New().String()
+	expense := `{"group_id":"` + gid + `","amount":%s,"payer":"` + uuid.New().String() + `","recipients":"` + uuid.New().String() + `"}`
+
+	cases := []struct {
+		method     string
+		path       string
+		body       string
+		statusCode int
+		field      string
+	}{
+		{"POST", "/groups", `{"name":"Test"}`, http.StatusOK, ""},
+		{"POST", "/groups", `{"name":""}`, http.StatusBadRequest, "name"},
+		{"POST", "/groups", `{}`, http.StatusBadRequest, "name"},
+		{"POST", "/groups", `{"name":"Test","currency":"EUR"}`, http.StatusBadRequest, "currency"},
+		{"POST", "/groups", `{"name":"Test","created_at":"2020-01-01T00:00:00Z"}`, http.StatusBadRequest, "created_at"},
+		{"POST", "/groups", `{"name":"Test","members":null}`, http.StatusBadRequest, "members"},
+		{"POST", "/groups", ``, http.StatusBadRequest, "body"},
+		{"POST", "/groups", `{"name":`, http.StatusBadRequest, "body"},
+		{"GET", "/groups/" + gid, ``, http.StatusOK, ""},
+		{"GET", "/groups/test", ``, http.StatusBadRequest, "groupid"},
+		{"POST", "/groups/" + gid + "/expenses", fmt.Sprintf(expense, "12.5"), http.StatusOK, ""},
+		{"POST", "/groups/" + gid + "/expenses", fmt.Sprintf(expense, "-3"), http.StatusBadRequest, "amount"},
+		{"POST", "/groups/" + gid + "/expenses", fmt.Sprintf(expense, "0"), http.StatusBadRequest, "amount"},
+		{"POST", "/groups/" + gid + "/expenses", fmt.Sprintf(expense, `"12"`), http.StatusBadRequest, "amount"},
+	}
+
+	for _, tc := range cases {
+		t.Run(fmt.Sprintf("%s %s %d", tc.method, tc.path, tc.statusCode), func(t *testing.T) {
+			// Create request
+			req, err := http.NewRequest(tc.method, tc.path, bytes.NewBufferString(tc.body))
+			if err != nil {
+				t.Errorf("Can't create request [Error]: %v", err)
+			}
+
+			// Serve test request
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+			res := rec.Result() // get response
+			defer res.Body.Close()
+
+			// Check response status code
+			if res.StatusCode != tc.statusCode {
+				t.Fatalf("Wrong status code [Expected]: %d [Actual]: %d", tc.statusCode, res.StatusCode)
+			}
+
+			if tc.statusCode != http.StatusBadRequest {
+				return
+			}
+
+			// Check the offending field is reported
+			var p struct {
+				Code    string `json:"code"`
+				Details struct {
+					Fields []openapi.FieldError `json:"fields"`
+				} `json:"details"`
+			}
+			if err := json.NewDecoder(res.Body).Decode(&p); err != nil {
+				t.Fatalf("Can't decode response body [Error]: %v", err)
+			}
+			if p.Code != "validation_failed" {
+				t.Errorf("Wrong error code [Expected]: %s [Actual]: %s", "validation_failed", p.Code)
+			} else if len(p.Details.Fields) == 0 || p.Details.Fields[0].Field != tc.field {
+				t.Errorf("Wrong field reported [Expected]: %s [Actual]: %v", tc.field, p.Details.Fields)
+			}
+		})
+	}
+}
+
+func TestValidateResource(t *testing.T) {
+	spec := openapi.GroupsSpec()
+	schema := &openapi.Schema{Ref: "#/components/schemas/Member"}
+	m := map[string]interface{}{"name": "Test", "balance": 1e6, "group_id": uuid.New().String()}
+
+	// Read-only fields can't be sent, but are part of the resource
+	if errs := spec.Validate(schema, m, ""); len(errs) != 2 || errs[0].Field != "balance" || errs[1].Field != "group_id" {
+		t.Errorf("Wrong request errors [Expected]: %s [Actual]: %v", "balance, group_id", errs)
+	}
+	if errs := spec.ValidateResource(schema, m, ""); len(errs) != 0 {
+		t.Errorf("Wrong resource errors [Expected]: %v [Actual]: %v", nil, errs)
+	}
+}
@@ -0,0 +1,621 @@
+package openapi
+
+// GroupsSpec describes the routes served by the groups microservice.
+func GroupsSpec() *Document {
+	return &Document{
+		OpenAPI:    "3.0.3",
+		Info:       Info{Title: "PayUp groups", Version: "1.0.0"},
+		Paths:      groupsPaths(),
+		Components: Components{Schemas: schemas()},
+	}
+}
+
+// GatewaySpec describes the public API served by the gateway.
+func GatewaySpec() *Document {
+	paths := groupsPaths()
+
+	paths["/groups/{groupid}/expenses"] = PathItem{
+		"post": {
+			OperationID: "addExpense",
+			Summary:     "Add an expense to the group",
+			Parameters:  []Parameter{pathUUID("groupid")},
+			RequestBody: jsonBody("Expense"),
+			Responses:   responses("202", "400", "401", "403", "404"),
+		},
+		"delete": {
+			OperationID: "deleteLastExpense",
+			Summary:     "Delete the last expense of the group",
+			Parameters:  []Parameter{pathUUID("groupid")},
+			Responses:   responses("202", "400", "401", "403", "404"),
+		},
+	}
+	paths["/groups/{groupid}/payments"] = PathItem{
+		"post": {
+			OperationID: "addPayment",
+			Summary:     "Add a payment to the group",
+			Parameters:  []Parameter{pathUUID("groupid")},
+			RequestBody: jsonBody("Payment"),
+			Responses:   responses("202", "400", "401", "403", "404"),
+		},
+		"delete": {
+			OperationID: "deleteLastPayment",
+			Summary:     "Delete the last payment of the group",
+			Parameters:  []Parameter{pathUUID("groupid")},
+			Responses:   responses("202", "400", "401", "403", "404"),
+		},
+	}
+	paths["/groups/{groupid}/events"] = PathItem{
+		"get": {
+			OperationID: "streamGroupEvents",
+			Summary:     "Stream the group's balance changes as Server-Sent Events",
+			Parameters:  []Parameter{pathUUID("groupid"), header("Last-Event-ID")},
+			Responses:   streamed(responses("200", "400", "401", "403", "404", "503"), "BalanceChange"),
+		},
+	}
+
+	return &Document{
+		OpenAPI:    "3.0.3",
+		Info:       Info{Title: "PayUp", Version: "1.0.0"},
+		Paths:      paths,
+		Components: Components{Schemas: schemas()},
+	}
+}
+
+func groupsPaths() map[string]PathItem {
+
+	return map[string]PathItem{
+		"/": {
+			"get": {
+				OperationID: "getStatus",
+				Summary:     "Check that the service is running",
+				Responses:   responses("200"),
+			},
+		},
+		"/livez": {
+			"get": {
+				OperationID: "getLiveness",
+				Summary:     "Check that the process is alive, for liveness probes",
+				Responses:   responses("200"),
+			},
+		},
+		"/readyz": {
+			"get": {
+				OperationID: "getReadiness",
+				Summary:     "Check the service's dependencies, for readiness probes",
+				Responses:   withSchema(withSchema(responses("200", "503"), "200", "HealthReport"), "503", "HealthReport"),
+			},
+		},
+		"/metrics": {
+			"get": {
+				OperationID: "getMetrics",
+				Summary:     "Get the service's metrics in the Prometheus text format",
+				Responses:   responses("200"),
+			},
+		},
+		"/groups": {
+			"get": {
+				OperationID: "listGroups",
+				Summary:     "List the groups of the user",
+				Parameters: []Parameter{
+					query("q", &Schema{Type: "string", MaxLength: intPtr(100)}),
+					query("sort", &Schema{Type: "string", Enum: []string{"name", "-name", "created_at", "-created_at", "active_at", "-active_at"}}),
+					query("limit", &Schema{Type: "integer", Minimum: floatPtr(1), Maximum: floatPtr(100)}),
+					query("cursor", &Schema{Type: "string"}),
+					query("archived", &Schema{Type: "boolean"}),
+				},
+				Responses: withSchema(responses("200", "400", "401"), "200", "GroupPage"),
+			},
+			"post": {
+				OperationID: "createGroup",
+				Summary:     "Create a group owned by the user",
+				Parameters:  []Parameter{idempotencyKey()},
+				RequestBody: jsonBody("Group"),
+				Responses:   created(responses("201", "400", "401", "409", "422"), "Group"),
+			},
+		},
+		"/groups/{groupid}": {
+			"get": {
+				OperationID: "getGroup",
+				Summary:     "Fetch a group with its members",
+				Parameters:  []Parameter{pathUUID("groupid"), header("If-None-Match")},
+				Responses:   tagged(withSchema(responses("200", "304", "400", "401", "403", "404"), "200", "Group"), "200"),
+			},
+			"put": {
+				OperationID: "updateGroup",
+				Summary:     "Update a group's name",
+				Parameters:  []Parameter{pathUUID("groupid"), header("If-Match")},
+				RequestBody: jsonBody("Group"),
+				Responses:   tagged(responses("200", "400", "401", "403", "404", "409", "412", "428"), "200"),
+			},
+			"patch": {
+				OperationID: "patchGroup",
+				Summary:     "Change some fields of a group",
+				Parameters:  []Parameter{pathUUID("groupid"), header("If-Match")},
+				RequestBody: patchBody(),
+				Responses:   tagged(withSchema(responses("200", "400", "401", "403", "404", "409", "412", "415", "422"), "200", "Group"), "200"),
+			},
+			"delete": {
+				OperationID: "deleteGroup",
+				Summary:     "Delete a group with its members and transactions",
+				Parameters:  []Parameter{pathUUID("groupid"), header("If-Match"), query("force", &Schema{Type: "boolean"})},
+				Responses:   responses("202", "400", "401", "403", "404", "409", "412", "500"),
+			},
+		},
+		"/groups/{groupid}/archive": {
+			"post": {
+				OperationID: "archiveGroup",
+				Summary:     "Archive a group, keeping its members and history",
+				Parameters:  []Parameter{pathUUID("groupid")},
+				Responses:   tagged(withSchema(responses("200", "400", "401", "403", "404"), "200", "Group"), "200"),
+			},
+		},
+		"/groups/{groupid}/restore": {
+			"post": {
+				OperationID: "restoreGroup",
+				Summary:     "Restore an archived group",
+				Parameters:  []Parameter{pathUUID("groupid")},
+				Responses:   tagged(withSchema(responses("200", "400", "401", "403", "404", "409"), "200", "Group"), "200"),
+			},
+		},
+		"/groups/{groupid}/members": {
+			"post": {
+				OperationID: "addMember",
+				Summary:     "Add a member to the group",
+				Parameters:  []Parameter{pathUUID("groupid"), idempotencyKey()},
+				RequestBody: jsonBody("NewMember"),
+				Responses:   created(responses("201", "400", "401", "403", "404", "409", "422"), "Member"),
+			},
+		},
+		"/groups/{groupid}/members/{memberid}": {
+			"get": {
+				OperationID: "getMember",
+				Summary:     "Fetch a member of the group",
+				Parameters:  []Parameter{pathUUID("groupid"), pathUUID("memberid"), header("If-None-Match")},
+				Responses:   tagged(withSchema(responses("200", "304", "400", "401", "403", "404"), "200", "Member"), "200"),
+			},
+			"put": {
+				OperationID: "updateMember",
+				Summary:     "Update a member's name",
+				Parameters:  []Parameter{pathUUID("groupid"), pathUUID("memberid"), header("If-Match")},
+				RequestBody: jsonBody("Member"),
+				Responses:   tagged(responses("200", "400", "401", "403", "404", "409", "412"), "200"),
+			},
+			"patch": {
+				OperationID: "patchMember",
+				Summary:     "Change some fields of a member",
+				Parameters:  []Parameter{pathUUID("groupid"), pathUUID("memberid"), header("If-Match")},
+				RequestBody: patchBody(),
+				Responses:   tagged(withSchema(responses("200", "400", "401", "403", "404", "409", "412", "415", "422"), "200", "Member"), "200"),
+			},
+			"delete": {
+				OperationID: "deleteMember",
+				Summary:     "Delete a member with no balance",
+				Parameters:  []Parameter{pathUUID("groupid"), pathUUID("memberid"), header("If-Match")},
+				Responses:   responses("204", "400", "401", "403", "404", "409", "412"),
+			},
+		},
+		"/groups/{groupid}/members/{memberid}/preferences": {
+			"get": {
+				OperationID: "getPreferences",
+				Summary:     "Fetch the notification preferences of the user's member",
+				Parameters:  []Parameter{pathUUID("groupid"), pathUUID("memberid")},
+				Responses:   withSchema(responses("200", "400", "401", "403", "404"), "200", "Preferences"),
+			},
+			"put": {
+				OperationID: "updatePreferences",
+				Summary:     "Replace the notification preferences of the user's member",
+				Parameters:  []Parameter{pathUUID("groupid"), pathUUID("memberid")},
+				RequestBody: jsonBody("Preferences"),
+				Responses:   withSchema(responses("200", "400", "401", "403", "404", "422"), "200", "Preferences"),
+			},
+		},
+		"/groups/{groupid}/invites": {
+			"post": {
+				OperationID: "createInvite",
+				Summary:     "Create an invite to join the group",
+				Parameters:  []Parameter{pathUUID("groupid"), idempotencyKey()},
+				RequestBody: jsonBody("Invite"),
+				Responses:   created(responses("201", "400", "401", "403", "404", "409", "422"), "Invite"),
+			},
+		},
+		"/groups/{groupid}/invites/{inviteid}": {
+			"delete": {
+				OperationID: "revokeInvite",
+				Summary:     "Revoke an invite",
+				Parameters:  []Parameter{pathUUID("groupid"), pathUUID("inviteid")},
+				Responses:   responses("204", "400", "401", "403", "404"),
+			},
+		},
+		"/invites/{token}": {
+			"post": {
+				OperationID: "redeemInvite",
+				Summary:     "Join a group with an invite token",
+				Parameters:  []Parameter{{Name: "token", In: "path", Required: true, Schema: &Schema{Type: "string", MinLength: intPtr(1)}}},
+				Responses:   withSchema(responses("200", "401", "404", "409", "410"), "200", "Member"),
+			},
+		},
+		"/groups/{groupid}/webhooks": {
+			"get": {
+				OperationID: "listWebhooks",
+				Summary:     "List the webhooks of the group, without their secrets",
+				Parameters:  []Parameter{pathUUID("groupid")},
+				Responses:   withArray(responses("200", "400", "401", "403", "404"), "200", "Webhook"),
+			},
+			"post": {
+				OperationID: "createWebhook",
+				Summary:     "Create a webhook receiving the group's events",
+				Parameters:  []Parameter{pathUUID("groupid"), idempotencyKey()},
+				RequestBody: jsonBody("Webhook"),
+				Responses:   created(responses("201", "400", "401", "403", "404", "409", "422"), "Webhook"),
+			},
+		},
+		"/groups/{groupid}/webhooks/{webhookid}": {
+			"delete": {
+				OperationID: "deleteWebhook",
+				Summary:     "Delete a webhook with its delivery log",
+				Parameters:  []Parameter{pathUUID("groupid"), pathUUID("webhookid")},
+				Responses:   responses("204", "400", "401", "403", "404"),
+			},
+		},
+		"/groups/{groupid}/webhooks/{webhookid}/deliveries": {
+			"get": {
+				OperationID: "listDeliveries",
+				Summary:     "List the latest deliveries of a webhook",
+				Parameters:  []Parameter{pathUUID("groupid"), pathUUID("webhookid")},
+				Responses:   withArray(responses("200", "400", "401", "403", "404"), "200", "Delivery"),
+			},
+		},
+		"/groups/{groupid}/webhooks/{webhookid}/deliveries/{deliveryid}/redeliver": {
+			"post": {
+				OperationID: "redeliver",
+				Summary:     "Deliver the event of a delivery again",
+				Parameters:  []Parameter{pathUUID("groupid"), pathUUID("webhookid"), pathUUID("deliveryid")},
+				Responses:   withSchema(responses("202", "400", "401", "403", "404"), "202", "Delivery"),
+			},
+		},
+	}
+}
+
+func schemas() map[string]*Schema {
+	roles := []string{"viewer", "member", "admin", "owner"}
+
+	return map[string]*Schema{
+		"Group": {
+			Type:     "object",
+			Required: []string{"name"},
+			Properties: map[string]*Schema{
+				"id":          {Type: "string", Format: "uuid"},
+				"name":        {Type: "string", MinLength: intPtr(1), MaxLength: intPtr(100)},
+				"description": {Type: "string", MaxLength: intPtr(500)},
+				"members":     {Type: "array", Nullable: true, ReadOnly: true, Items: &Schema{Ref: "#/components/schemas/Member"}},
+				"version":     {Type: "integer", Minimum: floatPtr(0)},
+				"created_at":  {Type: "string", Format: "date-time", ReadOnly: true},
+				"updated_at":  {Type: "string", Format: "date-time", ReadOnly: true},
+				"active_at":   {Type: "string", Format: "date-time", ReadOnly: true},
+				"archived_at": {Type: "string", Format: "date-time", Nullable: true, ReadOnly: true},
+				"deleting":    {Type: "boolean", ReadOnly: true},
+			},
+			AdditionalProperties: boolPtr(false),
+		},
+		"GroupSummary": {
+			Type: "object",
+			Properties: map[string]*Schema{
+				"id":          {Type: "string", Format: "uuid"},
+				"name":        {Type: "string"},
+				"description": {Type: "string"},
+				"members":     {Type: "integer"},
+				"version":     {Type: "integer"},
+				"created_at":  {Type: "string", Format: "date-time"},
+				"active_at":   {Type: "string", Format: "date-time"},
+				"archived_at": {Type: "string", Format: "date-time", Nullable: true},
+			},
+		},
+		"GroupPage": {
+			Type:     "object",
+			Required: []string{"groups"},
+			Properties: map[string]*Schema{
+				"groups":      {Type: "array", Items: &Schema{Ref: "#/components/schemas/GroupSummary"}},
+				"next_cursor": {Type: "string"},
+			},
+		},
+		"HealthReport": {
+			Type:     "object",
+			Required: []string{"status", "checks"},
+			Properties: map[string]*Schema{
+				"status": {Type: "string", Enum: []string{"ok", "unavailable"}},
+				"checks": {Type: "object"},
+			},
+		},
+		"Member": {
+			Type:     "object",
+			Required: []string{"name"},
+			Properties: map[string]*Schema{
+				"id":         {Type: "string", Format: "uuid"},
+				"name":       {Type: "string", MinLength: intPtr(1), MaxLength: intPtr(100)},
+				"balance":    {Type: "number", ReadOnly: true},
+				"group_id":   {Type: "string", Format: "uuid", ReadOnly: true},
+				"user_id":    {Type: "string", ReadOnly: true},
+				"role":       {Type: "string", Enum: roles, ReadOnly: true},
+				"version":    {Type: "integer", Minimum: floatPtr(0)},
+				"created_at": {Type: "string", Format: "date-time", ReadOnly: true},
+				"updated_at": {Type: "string", Format: "date-time", ReadOnly: true},
+			},
+			AdditionalProperties: boolPtr(false),
+		},
+		"NewMember": {
+			Type:     "object",
+			Required: []string{"name"},
+			Properties: map[string]*Schema{
+				"id":      {Type: "string", Format: "uuid"},
+				"name":    {Type: "string", MinLength: intPtr(1), MaxLength: intPtr(100)},
+				"user_id": {Type: "string"},
+				"role":    {Type: "string", Enum: roles},
+				"version": {Type: "integer", Minimum: floatPtr(0)},
+			},
+			AdditionalProperties: boolPtr(false),
+		},
+		"Preferences": {
+			Type: "object",
+			Properties: map[string]*Schema{
+				"member_id":      {Type: "string", Format: "uuid", ReadOnly: true},
+				"group_id":       {Type: "string", Format: "uuid", ReadOnly: true},
+				"email":          {Type: "string", MaxLength: intPtr(254)},
+				"expense_added":  {Type: "boolean"},
+				"owed_threshold": {Type: "number", Minimum: floatPtr(0)},
+				"weekly_digest":  {Type: "boolean"},
+				"updated_at":     {Type: "string", Format: "date-time", ReadOnly: true},
+			},
+			AdditionalProperties: boolPtr(false),
+		},
+		"Invite": {
+			Type: "object",
+			Properties: map[string]*Schema{
+				"id":         {Type: "string", Format: "uuid", ReadOnly: true},
+				"group_id":   {Type: "string", Format: "uuid", ReadOnly: true},
+				"member_id":  {Type: "string", Format: "uuid"},
+				"role":       {Type: "string", Enum: roles},
+				"created_by": {Type: "string", ReadOnly: true},
+				"expires_at": {Type: "string", Format: "date-time"},
+				"max_uses":   {Type: "integer", Minimum: floatPtr(0)},
+				"uses":       {Type: "integer", ReadOnly: true},
+				"revoked":    {Type: "boolean", ReadOnly: true},
+				"token":      {Type: "string", ReadOnly: true},
+			},
+			AdditionalProperties: boolPtr(false),
+		},
+		"Webhook": {
+			Type:     "object",
+			Required: []string{"url"},
+			Properties: map[string]*Schema{
+				"id":         {Type: "string", Format: "uuid", ReadOnly: true},
+				"group_id":   {Type: "string", Format: "uuid", ReadOnly: true},
+				"url":        {Type: "string", MinLength: intPtr(1), MaxLength: intPtr(2000)},
+				"secret":     {Type: "string", MinLength: intPtr(16), MaxLength: intPtr(255)},
+				"events":     {Type: "string", MaxLength: intPtr(500)},
+				"created_by": {Type: "string", ReadOnly: true},
+				"created_at": {Type: "string", Format: "date-time", ReadOnly: true},
+			},
+			AdditionalProperties: boolPtr(false),
+		},
+		"Delivery": {
+			Type: "object",
+			Properties: map[string]*Schema{
+				"id":              {Type: "string", Format: "uuid"},
+				"webhook_id":      {Type: "string", Format: "uuid"},
+				"group_id":        {Type: "string", Format: "uuid"},
+				"event_id":        {Type: "string", Format: "uuid"},
+				"event":           {Type: "string", Enum: []string{"expense.added", "expense.removed", "payment.recorded", "member.joined", "balance.settled"}},
+				"payload":         {Type: "string"},
+				"state":           {Type: "string", Enum: []string{"pending", "delivered", "failed"}},
+				"attempts":        {Type: "integer"},
+				"status_code":     {Type: "integer"},
+				"last_error":      {Type: "string"},
+				"next_attempt_at": {Type: "string", Format: "date-time"},
+				"created_at":      {Type: "string", Format: "date-time"},
+				"delivered_at":    {Type: "string", Format: "date-time", Nullable: true},
+			},
+		},
+		"Expense": {
+			Type:     "object",
+			Required: []string{"group_id", "amount", "payer", "recipients"},
+			Properties: map[string]*Schema{
+				"id":          {Type: "string", Format: "uuid"},
+				"group_id":    {Type: "string", Format: "uuid"},
+				"date":        {Type: "string", Format: "date-time"},
+				"amount":      {Type: "number", Minimum: floatPtr(0), ExclusiveMinimum: true},
+				"description": {Type: "string", MaxLength: intPtr(200)},
+				"payer":       {Type: "string", Format: "uuid"},
+				"recipients":  {Type: "string", MinLength: intPtr(1)},
+			},
+			AdditionalProperties: boolPtr(false),
+		},
+		"Payment": {
+			Type:     "object",
+			Required: []string{"group_id", "amount", "payer", "recipient"},
+			Properties: map[string]*Schema{
+				"id":        {Type: "string", Format: "uuid"},
+				"group_id":  {Type: "string", Format: "uuid"},
+				"date":      {Type: "string", Format: "date-time"},
+				"amount":    {Type: "number", Minimum: floatPtr(0), ExclusiveMinimum: true},
+				"payer":     {Type: "string", Format: "uuid"},
+				"recipient": {Type: "string", Format: "uuid"},
+			},
+			AdditionalProperties: boolPtr(false),
+		},
+		"PatchOperation": {
+			Type:     "object",
+			Required: []string{"op", "path"},
+			Properties: map[string]*Schema{
+				"op":    {Type: "string", Enum: []string{"add", "remove", "replace", "move", "copy", "test"}},
+				"path":  {Type: "string"},
+				"from":  {Type: "string"},
+				"value": {},
+			},
+			AdditionalProperties: boolPtr(false),
+		},
+		"BalanceChange": {
+			Type:     "object",
+			Required: []string{"group_id", "operation", "balances", "at"},
+			Properties: map[string]*Schema{
+				"group_id":   {Type: "string", Format: "uuid"},
+				"group_name": {Type: "string"},
+				"operation":  {Type: "string", Enum: []string{"add-expense", "delete-expense", "add-payment", "delete-payment"}},
+				"balances": {
+					Type: "array",
+					Items: &Schema{
+						Type:     "object",
+						Required: []string{"member_id", "name", "balance"},
+						Properties: map[string]*Schema{
+							"member_id": {Type: "string", Format: "uuid"},
+							"name":      {Type: "string"},
+							"balance":   {Type: "number"},
+						},
+					},
+				},
+				"expense": {Ref: "#/components/schemas/Expense"},
+				"at":      {Type: "string", Format: "date-time"},
+			},
+		},
+		"Problem": {
+			Type:     "object",
+			Required: []string{"type", "title", "status", "code"},
+			Properties: map[string]*Schema{
+				"type":     {Type: "string"},
+				"title":    {Type: "string"},
+				"status":   {Type: "integer"},
+				"code":     {Type: "string"},
+				"detail":   {Type: "string"},
+				"instance": {Type: "string"},
+				"details":  {Type: "object"},
+			},
+		},
+	}
+}
+
+func pathUUID(name string) Parameter {
+	return Parameter{Name: name, In: "path", Required: true, Schema: &Schema{Type: "string", Format: "uuid"}}
+}
+
+func query(name string, schema *Schema) Parameter {
+	return Parameter{Name: name, In: "query", Schema: schema}
+}
+
+func header(name string) Parameter {
+	return Parameter{Name: name, In: "header", Schema: &Schema{Type: "string", MinLength: intPtr(1)}}
+}
+
+func idempotencyKey() Parameter {
+	return Parameter{Name: "Idempotency-Key", In: "header", Schema: &Schema{Type: "string", MinLength: intPtr(1), MaxLength: intPtr(255)}}
+}
+
+func jsonBody(schema string) *RequestBody {
+	return &RequestBody{
+		Required: true,
+		Content: map[string]*MediaType{
+			"application/json": {Schema: &Schema{Ref: "#/components/schemas/" + schema}},
+		},
+	}
+}
+
+// patchBody accepts a JSON Merge Patch or a JSON Patch. The patched resource
+// is validated by the handler, as the patch itself doesn't follow its schema.
+func patchBody() *RequestBody {
+	return &RequestBody{
+		Required: true,
+		Content: map[string]*MediaType{
+			"application/merge-patch+json": {Schema: &Schema{Type: "object"}},
+			"application/json-patch+json":  {Schema: &Schema{Type: "array", Items: &Schema{Ref: "#/components/schemas/PatchOperation"}}},
+		},
+	}
+}
+
+var descriptions = map[string]string{
+	"200": "OK",
+	"201": "Created",
+	"202": "Accepted for asynchronous processing",
+	"204": "No content",
+	"304": "Not modified since the given ETag",
+	"400": "Invalid request",
+	"401": "No authenticated user",
+	"403": "User lacks the required role",
+	"404": "Not found",
+	"409": "Conflict with the current state",
+	"410": "Invite revoked, expired or used up",
+	"412": "Modified since the given ETag",
+	"415": "Unsupported patch format",
+	"422": "Idempotency key already used for another request, or patched resource isn't valid",
+	"428": "No If-Match header or version given",
+	"500": "Request couldn't be queued",
+	"503": "Dependencies are unavailable",
+}
+
+func responses(codes ...string) map[string]*Response {
+	res := make(map[string]*Response, len(codes))
+	for _, c := range codes {
+		res[c] = &Response{Description: descriptions[c]}
+	}
+
+	for c, r := range res {
+		if c >= "400" {
+			r.Content = map[string]*MediaType{
+				"application/problem+json": {Schema: &Schema{Ref: "#/components/schemas/Problem"}},
+			}
+		}
+	}
+
+	return res
+}
+
+func withSchema(res map[string]*Response, code, schema string) map[string]*Response {
+	res[code].Content = map[string]*MediaType{
+		"application/json": {Schema: &Schema{Ref: "#/components/schemas/" + schema}},
+	}
+
+	return res
+}
+
+// streamed responses are Server-Sent Events whose data follows the schema.
+func streamed(res map[string]*Response, schema string) map[string]*Response {
+	res["200"].Content = map[string]*MediaType{
+		"text/event-stream": {Schema: &Schema{Ref: "#/components/schemas/" + schema}},
+	}
+
+	return res
+}
+
+func withArray(res map[string]*Response, code, schema string) map[string]*Response {
+	res[code].Content = map[string]*MediaType{
+		"application/json": {Schema: &Schema{Type: "array", Items: &Schema{Ref: "#/components/schemas/" + schema}}},
+	}
+
+	return res
+}
+
+func created(res map[string]*Response, schema string) map[string]*Response {
+	res["201"].Headers = map[string]*Header{
+		"Location": {Description: "URL of the created resource", Schema: &Schema{Type: "string"}},
+	}
+
+	return withSchema(res, "201", schema)
+}
+
+func tagged(res map[string]*Response, code string) map[string]*Response {
+	if res[code].Headers == nil {
+		res[code].Headers = make(map[string]*Header)
+	}
+	res[code].Headers["ETag"] = &Header{Description: "Version of the resource", Schema: &Schema{Type: "string"}}
+
+	return res
+}
+
+func intPtr(n int) *int {
+	return &n
+}
+
+func floatPtr(n float64) *float64 {
+	return &n
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
@@ -0,0 +1,215 @@
+package openapi
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+// FieldError describes why a value doesn't match its schema.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Validate a decoded JSON request body against a schema, returning every
+// mismatch found. The field of each error is the path to the value, starting
+// at name. Read-only properties are owned by the server, so requests can't
+// set them.
+func (d *Document) Validate(s *Schema, v interface{}, name string) []FieldError {
+	return d.validate(s, v, name, true)
+}
+
+// ValidateResource checks a whole resource against its schema like Validate,
+// but allowing its read-only properties.
+func (d *Document) ValidateResource(s *Schema, v interface{}, name string) []FieldError {
+	return d.validate(s, v, name, false)
+}
+
+func (d *Document) validate(s *Schema, v interface{}, name string, request bool) []FieldError {
+	s = d.Resolve(s)
+	if s == nil {
+		return nil
+	}
+
+	if v == nil {
+		if s.Nullable {
+			return nil
+		}
+		return []FieldError{{name, "must not be null"}}
+	}
+
+	switch s.Type {
+	case "object":
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return []FieldError{{name, "must be an object"}}
+		}
+		return d.validateObject(s, obj, name, request)
+	case "array":
+		arr, ok := v.([]interface{})
+		if !ok {
+			return []FieldError{{name, "must be an array"}}
+		}
+		var errs []FieldError
+		for i, item := range arr {
+			errs = append(errs, d.validate(s.Items, item, fmt.Sprintf("%s[%d]", name, i), request)...)
+		}
+		return errs
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			return []FieldError{{name, "must be a string"}}
+		}
+		return validateString(s, str, name)
+	case "number", "integer":
+		num, ok := v.(float64)
+		if !ok {
+			return []FieldError{{name, "must be a number"}}
+		}
+		return validateNumber(s, num, name)
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return []FieldError{{name, "must be a boolean"}}
+		}
+	}
+
+	return nil
+}
+
+// ValidateParam checks a raw path or query parameter against its schema.
+func (d *Document) ValidateParam(s *Schema, raw, name string) []FieldError {
+	s = d.Resolve(s)
+	if s == nil {
+		return nil
+	}
+
+	switch s.Type {
+	case "number", "integer":
+		num, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return []FieldError{{name, "must be a number"}}
+		}
+		return validateNumber(s, num, name)
+	case "boolean":
+		if _, err := strconv.ParseBool(raw); err != nil {
+			return []FieldError{{name, "must be a boolean"}}
+		}
+		return nil
+	default:
+		return validateString(s, raw, name)
+	}
+}
+
+func (d *Document) validateObject(s *Schema, obj map[string]interface{}, name string, request bool) []FieldError {
+	var errs []FieldError
+
+	for _, req := range s.Required {
+		if _, ok := obj[req]; !ok {
+			errs = append(errs, FieldError{join(name, req), "is required"})
+		}
+	}
+
+	// Check properties in a stable order
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		prop, ok := s.Properties[k]
+		if !ok {
+			if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+				errs = append(errs, FieldError{join(name, k), "is not allowed"})
+			}
+			continue
+		}
+
+		if request && d.Resolve(prop).ReadOnly {
+			errs = append(errs, FieldError{join(name, k), "is read-only"})
+			continue
+		}
+
+		errs = append(errs, d.validate(prop, obj[k], join(name, k), request)...)
+	}
+
+	return errs
+}
+
+func validateString(s *Schema, str, name string) []FieldError {
+	var errs []FieldError
+
+	if len(s.Enum) > 0 {
+		found := false
+		for _, e := range s.Enum {
+			if e == str {
+				found = true
+			}
+		}
+		if !found {
+			errs = append(errs, FieldError{name, fmt.Sprintf("must be one of %v", s.Enum)})
+		}
+	}
+
+	length := utf8.RuneCountInString(str)
+	if s.MinLength != nil && length < *s.MinLength {
+		errs = append(errs, FieldError{name, fmt.Sprintf("must have at least %d characters", *s.MinLength)})
+	}
+	if s.MaxLength != nil && length > *s.MaxLength {
+		errs = append(errs, FieldError{name, fmt.Sprintf("must have at most %d characters", *s.MaxLength)})
+	}
+
+	if s.Pattern != "" {
+		if ok, _ := regexp.MatchString(s.Pattern, str); !ok {
+			errs = append(errs, FieldError{name, fmt.Sprintf("must match %s", s.Pattern)})
+		}
+	}
+
+	switch s.Format {
+	case "uuid":
+		if _, err := uuid.Parse(str); err != nil {
+			errs = append(errs, FieldError{name, "must be a UUID"})
+		}
+	case "date-time":
+		if _, err := time.Parse(time.RFC3339, str); err != nil {
+			errs = append(errs, FieldError{name, "must be an RFC 3339 date-time"})
+		}
+	}
+
+	return errs
+}
+
+func validateNumber(s *Schema, num float64, name string) []FieldError {
+	var errs []FieldError
+
+	if s.Type == "integer" && num != float64(int64(num)) {
+		errs = append(errs, FieldError{name, "must be an integer"})
+	}
+
+	if s.Minimum != nil {
+		if s.ExclusiveMinimum && num <= *s.Minimum {
+			errs = append(errs, FieldError{name, fmt.Sprintf("must be greater than %v", *s.Minimum)})
+		} else if num < *s.Minimum {
+			errs = append(errs, FieldError{name, fmt.Sprintf("must be at least %v", *s.Minimum)})
+		}
+	}
+	if s.Maximum != nil && num > *s.Maximum {
+		errs = append(errs, FieldError{name, fmt.Sprintf("must be at most %v", *s.Maximum)})
+	}
+
+	return errs
+}
+
+func join(name, field string) string {
+	if name == "" {
+		return field
+	}
+
+	return name + "." + field
+}
@@ -0,0 +1,55 @@
+package patch
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/varrrro/pay-up/internal/problem"
+)
+
+// Error used when a patch document is malformed or can't be applied.
+type Error struct {
+	msg  string
+	path string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s [Path]: %s", e.msg, e.path)
+}
+
+// Problem describing the error.
+func (e *Error) Problem() *problem.Problem {
+	return problem.New(http.StatusBadRequest, "invalid_patch", e.msg).With("path", e.path)
+}
+
+// TestError used when a test operation doesn't match the document.
+type TestError struct {
+	msg  string
+	path string
+}
+
+func (e *TestError) Error() string {
+	return fmt.Sprintf("%s [Path]: %s", e.msg, e.path)
+}
+
+// Problem describing the error.
+func (e *TestError) Problem() *problem.Problem {
+	return problem.New(http.StatusConflict, "patch_test_failed", e.msg).With("path", e.path)
+}
+
+// UnsupportedError used when a patch has an unknown content type.
+type UnsupportedError struct {
+	msg         string
+	contentType string
+}
+
+func (e *UnsupportedError) Error() string {
+	return fmt.Sprintf("%s [ContentType]: %s", e.msg, e.contentType)
+}
+
+// Problem describing the error.
+func (e *UnsupportedError) Problem() *problem.Problem {
+	return problem.New(http.StatusUnsupportedMediaType, "unsupported_patch", e.msg).
+		With("content_type", e.contentType).
+		With("supported", []string{MergeContentType, JSONContentType})
+}
@@ -0,0 +1,366 @@
+package patch
+
+import (
+	"encoding/json"
+	"errors"
+	"mime"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Content types of the supported patch formats.
+const (
+	MergeContentType = "application/merge-patch+json" // RFC 7396
+	JSONContentType  = "application/json-patch+json"  // RFC 6902
+)
+
+// Operation of a JSON Patch document.
+type Operation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// Patch a JSON document with the format given by the content type, returning
+// the patched document.
+func Patch(contentType string, doc, patch []byte) ([]byte, error) {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mt = contentType
+	}
+
+	var v interface{}
+	if err := json.Unmarshal(doc, &v); err != nil {
+		return nil, err
+	}
+
+	switch mt {
+	case MergeContentType:
+		v, err = Merge(v, patch)
+	case JSONContentType:
+		v, err = Apply(v, patch)
+	default:
+		return nil, &UnsupportedError{"Unsupported patch format", mt}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(v)
+}
+
+// Merge the patch into the decoded document, following RFC 7396.
+func Merge(doc interface{}, patch []byte) (interface{}, error) {
+	var p interface{}
+	if err := json.Unmarshal(patch, &p); err != nil {
+		return nil, &Error{"Patch isn't valid JSON", ""}
+	}
+
+	return merge(doc, p), nil
+}
+
+func merge(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = make(map[string]interface{})
+	}
+
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+		} else {
+			t[k] = merge(t[k], v)
+		}
+	}
+
+	return t
+}
+
+// Apply the operations in the patch to the decoded document, following
+// RFC 6902. The document is left unchanged if any operation fails.
+func Apply(doc interface{}, patch []byte) (interface{}, error) {
+	var ops []Operation
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		return nil, &Error{"Patch isn't a valid list of operations", ""}
+	}
+
+	doc = deepCopy(doc)
+	for _, op := range ops {
+		var err error
+		if doc, err = apply(doc, op); err != nil {
+			return nil, err
+		}
+	}
+
+	return doc, nil
+}
+
+func apply(doc interface{}, op Operation) (interface{}, error) {
+	path, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Decode the operation's value
+	var value interface{}
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return nil, &Error{"Operation has no value", op.Path}
+		}
+		if err := json.Unmarshal(op.Value, &value); err != nil {
+			return nil, &Error{"Operation value isn't valid JSON", op.Path}
+		}
+	case "move", "copy":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+		if op.Op == "move" && strings.HasPrefix(op.Path, op.From+"/") {
+			return nil, &Error{"Can't move a value into itself", op.Path}
+		}
+		if value, err = get(doc, from); err != nil {
+			return nil, err
+		}
+		value = deepCopy(value)
+		if op.Op == "move" {
+			if doc, err = remove(doc, from, op.From); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	switch op.Op {
+	case "add", "move", "copy":
+		return add(doc, path, value, op.Path)
+	case "remove":
+		return remove(doc, path, op.Path)
+	case "replace":
+		if _, err := get(doc, path); err != nil {
+			return nil, err
+		}
+		if len(path) == 0 {
+			return value, nil
+		}
+		if doc, err = remove(doc, path, op.Path); err != nil {
+			return nil, err
+		}
+		return add(doc, path, value, op.Path)
+	case "test":
+		cur, err := get(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !reflect.DeepEqual(cur, value) {
+			return nil, &TestError{"Value doesn't match", op.Path}
+		}
+		return doc, nil
+	default:
+		return nil, &Error{"Unknown operation " + strconv.Quote(op.Op), op.Path}
+	}
+}
+
+// parsePointer splits a JSON Pointer (RFC 6901) into its unescaped tokens.
+func parsePointer(p string) ([]string, error) {
+	if p == "" {
+		return nil, nil
+	}
+	if p[0] != '/' {
+		return nil, &Error{"Path must start with /", p}
+	}
+
+	tokens := strings.Split(p[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.Replace(strings.Replace(t, "~1", "/", -1), "~0", "~", -1)
+	}
+
+	return tokens, nil
+}
+
+func get(doc interface{}, path []string) (interface{}, error) {
+	for i, t := range path {
+		switch c := doc.(type) {
+		case map[string]interface{}:
+			v, ok := c[t]
+			if !ok {
+				return nil, &Error{"Path doesn't exist", pointer(path[:i+1])}
+			}
+			doc = v
+		case []interface{}:
+			n, err := index(t, len(c)-1)
+			if err != nil {
+				return nil, &Error{err.Error(), pointer(path[:i+1])}
+			}
+			doc = c[n]
+		default:
+			return nil, &Error{"Path doesn't exist", pointer(path[:i+1])}
+		}
+	}
+
+	return doc, nil
+}
+
+func add(doc interface{}, path []string, value interface{}, p string) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	return update(doc, path, p, func(parent interface{}, key string) (interface{}, error) {
+		switch c := parent.(type) {
+		case map[string]interface{}:
+			c[key] = value
+			return c, nil
+		case []interface{}:
+			n := len(c)
+			if key != "-" {
+				var err error
+				if n, err = index(key, len(c)); err != nil {
+					return nil, &Error{err.Error(), p}
+				}
+			}
+			c = append(c, nil)
+			copy(c[n+1:], c[n:])
+			c[n] = value
+			return c, nil
+		default:
+			return nil, &Error{"Path doesn't exist", p}
+		}
+	})
+}
+
+func remove(doc interface{}, path []string, p string) (interface{}, error) {
+	if len(path) == 0 {
+		return nil, &Error{"Can't remove the whole document", p}
+	}
+
+	return update(doc, path, p, func(parent interface{}, key string) (interface{}, error) {
+		switch c := parent.(type) {
+		case map[string]interface{}:
+			if _, ok := c[key]; !ok {
+				return nil, &Error{"Path doesn't exist", p}
+			}
+			delete(c, key)
+			return c, nil
+		case []interface{}:
+			n, err := index(key, len(c)-1)
+			if err != nil {
+				return nil, &Error{err.Error(), p}
+			}
+			return append(c[:n], c[n+1:]...), nil
+		default:
+			return nil, &Error{"Path doesn't exist", p}
+		}
+	})
+}
+
+// update walks to the parent of the value in the path and replaces it with
+// the result of the function, as appending to arrays creates new slices.
+func update(doc interface{}, path []string, p string, fn func(interface{}, string) (interface{}, error)) (interface{}, error) {
+	if len(path) == 1 {
+		return fn(doc, path[0])
+	}
+
+	switch c := doc.(type) {
+	case map[string]interface{}:
+		child, ok := c[path[0]]
+		if !ok {
+			return nil, &Error{"Path doesn't exist", p}
+		}
+		v, err := update(child, path[1:], p, fn)
+		if err != nil {
+			return nil, err
+		}
+		c[path[0]] = v
+		return c, nil
+	case []interface{}:
+		n, err := index(path[0], len(c)-1)
+		if err != nil {
+			return nil, &Error{err.Error(), p}
+		}
+		v, err := update(c[n], path[1:], p, fn)
+		if err != nil {
+			return nil, err
+		}
+		c[n] = v
+		return c, nil
+	default:
+		return nil, &Error{"Path doesn't exist", p}
+	}
+}
+
+// index parses an array index, which can't be above max.
+func index(t string, max int) (int, error) {
+	n, err := strconv.Atoi(t)
+	if err != nil || n < 0 || (len(t) > 1 && t[0] == '0') {
+		return 0, errors.New("Invalid array index")
+	}
+	if n > max {
+		return 0, errors.New("Array index out of bounds")
+	}
+
+	return n, nil
+}
+
+func pointer(path []string) string {
+	var b strings.Builder
+	for _, t := range path {
+		b.WriteString("/")
+		b.WriteString(strings.Replace(strings.Replace(t, "~", "~0", -1), "/", "~1", -1))
+	}
+
+	return b.String()
+}
+
+func deepCopy(v interface{}) interface{} {
+	switch c := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(c))
+		for k, v := range c {
+			m[k] = deepCopy(v)
+		}
+		return m
+	case []interface{}:
+		a := make([]interface{}, len(c))
+		for i, v := range c {
+			a[i] = deepCopy(v)
+		}
+		return a
+	default:
+		return v
+	}
+}
+
+// Changed returns the sorted names of the top-level fields that differ
+// between two JSON objects.
+func Changed(before, after []byte) ([]string, error) {
+	var b, a map[string]interface{}
+	if err := json.Unmarshal(before, &b); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(after, &a); err != nil {
+		return nil, err
+	}
+
+	var fields []string
+	for k, v := range a {
+		if !reflect.DeepEqual(b[k], v) {
+			fields = append(fields, k)
+		}
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			fields = append(fields, k)
+		}
+	}
+	sort.Strings(fields)
+
+	return fields, nil
+}
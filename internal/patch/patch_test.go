@@ -0,0 +1,134 @@
+package patch_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/varrrro/pay-up/internal/patch"
+	"github.com/varrrro/pay-up/internal/problem"
+)
+
+const doc = `{"name":"Test","tags":["a","b"],"nested":{"x":1}}`
+
+func TestMerge(t *testing.T) {
+	cases := []struct {
+		patch    string
+		expected string
+	}{
+		{`{"name":"Updated"}`, `{"name":"Updated","tags":["a","b"],"nested":{"x":1}}`},
+		{`{"tags":null}`, `{"name":"Test","nested":{"x":1}}`},
+		{`{"tags":["c"]}`, `{"name":"Test","tags":["c"],"nested":{"x":1}}`},
+		{`{"nested":{"x":null,"y":2}}`, `{"name":"Test","tags":["a","b"],"nested":{"y":2}}`},
+		{`{}`, doc},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.patch, func(t *testing.T) {
+			res, err := patch.Patch(patch.MergeContentType, []byte(doc), []byte(tc.patch))
+			if err != nil {
+				t.Fatalf("Can't apply patch [Error]: %v", err)
+			}
+			checkJSON(t, tc.expected, res)
+		})
+	}
+}
+
+func TestApply(t *testing.T) {
+	cases := []struct {
+		patch    string
+		expected string
+	}{
+		{`[{"op":"replace","path":"/name","value":"Updated"}]`, `{"name":"Updated","tags":["a","b"],"nested":{"x":1}}`},
+		{`[{"op":"add","path":"/tags/1","value":"c"}]`, `{"name":"Test","tags":["a","c","b"],"nested":{"x":1}}`},
+		{`[{"op":"add","path":"/tags/-","value":"c"}]`, `{"name":"Test","tags":["a","b","c"],"nested":{"x":1}}`},
+		{`[{"op":"remove","path":"/tags/0"}]`, `{"name":"Test","tags":["b"],"nested":{"x":1}}`},
+		{`[{"op":"move","from":"/nested/x","path":"/x"}]`, `{"name":"Test","tags":["a","b"],"nested":{},"x":1}`},
+		{`[{"op":"copy","from":"/tags","path":"/copy"}]`, `{"name":"Test","tags":["a","b"],"nested":{"x":1},"copy":["a","b"]}`},
+		{`[{"op":"test","path":"/name","value":"Test"},{"op":"remove","path":"/nested"}]`, `{"name":"Test","tags":["a","b"]}`},
+		{`[{"op":"add","path":"/a~1b","value":true}]`, `{"name":"Test","tags":["a","b"],"nested":{"x":1},"a/b":true}`},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.patch, func(t *testing.T) {
+			res, err := patch.Patch(patch.JSONContentType+"; charset=utf-8", []byte(doc), []byte(tc.patch))
+			if err != nil {
+				t.Fatalf("Can't apply patch [Error]: %v", err)
+			}
+			checkJSON(t, tc.expected, res)
+		})
+	}
+}
+
+func TestPatchErrors(t *testing.T) {
+	cases := []struct {
+		contentType string
+		patch       string
+		status      int
+		code        string
+	}{
+		{"application/json", `{"name":"Updated"}`, http.StatusUnsupportedMediaType, "unsupported_patch"},
+		{patch.MergeContentType, `{`, http.StatusBadRequest, "invalid_patch"},
+		{patch.JSONContentType, `{"op":"add"}`, http.StatusBadRequest, "invalid_patch"},
+		{patch.JSONContentType, `[{"op":"rename","path":"/name"}]`, http.StatusBadRequest, "invalid_patch"},
+		{patch.JSONContentType, `[{"op":"add","path":"name","value":1}]`, http.StatusBadRequest, "invalid_patch"},
+		{patch.JSONContentType, `[{"op":"add","path":"/name"}]`, http.StatusBadRequest, "invalid_patch"},
+		{patch.JSONContentType, `[{"op":"remove","path":"/missing"}]`, http.StatusBadRequest, "invalid_patch"},
+		{patch.JSONContentType, `[{"op":"replace","path":"/tags/2","value":"c"}]`, http.StatusBadRequest, "invalid_patch"},
+		{patch.JSONContentType, `[{"op":"add","path":"/tags/01","value":"c"}]`, http.StatusBadRequest, "invalid_patch"},
+		{patch.JSONContentType, `[{"op":"move","from":"/nested","path":"/nested/y"}]`, http.StatusBadRequest, "invalid_patch"},
+		{patch.JSONContentType, `[{"op":"test","path":"/name","value":"Other"}]`, http.StatusConflict, "patch_test_failed"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.patch, func(t *testing.T) {
+			_, err := patch.Patch(tc.contentType, []byte(doc), []byte(tc.patch))
+
+			var perr problem.Error
+			if !errors.As(err, &perr) {
+				t.Fatalf("Error doesn't describe a problem [Error]: %v", err)
+			}
+			if p := perr.Problem(); p.Status != tc.status || p.Code != tc.code {
+				t.Errorf("Wrong problem [Expected]: %d %s [Actual]: %d %s", tc.status, tc.code, p.Status, p.Code)
+			}
+		})
+	}
+}
+
+func TestApplyAtomic(t *testing.T) {
+	var v interface{}
+	json.Unmarshal([]byte(doc), &v)
+
+	_, err := patch.Apply(v, []byte(`[{"op":"remove","path":"/tags/0"},{"op":"test","path":"/name","value":"Other"}]`))
+	if err == nil {
+		t.Fatal("Failed test didn't return an error")
+	}
+
+	res, _ := json.Marshal(v)
+	checkJSON(t, doc, res)
+}
+
+func TestChanged(t *testing.T) {
+	changed, err := patch.Changed([]byte(doc), []byte(`{"name":"Updated","tags":["a","b"],"extra":1}`))
+	if err != nil {
+		t.Fatalf("Can't compare documents [Error]: %v", err)
+	}
+
+	expected := []string{"extra", "name", "nested"}
+	if !reflect.DeepEqual(changed, expected) {
+		t.Errorf("Wrong fields [Expected]: %v [Actual]: %v", expected, changed)
+	}
+}
+
+func checkJSON(t *testing.T, expected string, actual []byte) {
+	t.Helper()
+
+	var e, a interface{}
+	json.Unmarshal([]byte(expected), &e)
+	json.Unmarshal(actual, &a)
+	if !reflect.DeepEqual(e, a) {
+		t.Errorf("Wrong document [Expected]: %s [Actual]: %s", expected, actual)
+	}
+}
@@ -0,0 +1,69 @@
+package problem
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// ContentType of problem responses.
+const ContentType = "application/problem+json"
+
+// Problem details of an error response, as defined in RFC 7807. Code is a
+// stable identifier clients can rely on, while Detail is meant for humans.
+type Problem struct {
+	Type     string                 `json:"type"`
+	Title    string                 `json:"title"`
+	Status   int                    `json:"status"`
+	Code     string                 `json:"code"`
+	Detail   string                 `json:"detail,omitempty"`
+	Instance string                 `json:"instance,omitempty"`
+	Details  map[string]interface{} `json:"details,omitempty"`
+}
+
+// Error that can describe itself as a problem.
+type Error interface {
+	error
+	Problem() *Problem
+}
+
+// New problem with the given status code, error code and detail message.
+func New(status int, code, detail string) *Problem {
+	return &Problem{
+		Type:   "/problems/" + code,
+		Title:  http.StatusText(status),
+		Status: status,
+		Code:   code,
+		Detail: detail,
+	}
+}
+
+// With adds a detail to the problem.
+func (p *Problem) With(key string, val interface{}) *Problem {
+	if p.Details == nil {
+		p.Details = make(map[string]interface{})
+	}
+	p.Details[key] = val
+
+	return p
+}
+
+// FromError returns the problem described by the error, or a generic
+// internal error that doesn't leak its message.
+func FromError(err error) *Problem {
+	var e Error
+	if errors.As(err, &e) {
+		return e.Problem()
+	}
+
+	return New(http.StatusInternalServerError, "internal_error", "The request couldn't be completed")
+}
+
+// Write the problem as the response to a request.
+func Write(rw http.ResponseWriter, r *http.Request, p *Problem) {
+	p.Instance = r.URL.Path
+
+	rw.Header().Set("Content-Type", ContentType)
+	rw.WriteHeader(p.Status)
+	json.NewEncoder(rw).Encode(p)
+}
@@ -1,19 +1,34 @@
 package publisher
 
 import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
 	"github.com/streadway/amqp"
+	"github.com/varrrro/pay-up/internal/logging"
+	"github.com/varrrro/pay-up/internal/metrics"
+	"github.com/varrrro/pay-up/internal/signature"
+	"github.com/varrrro/pay-up/internal/tracing"
+	"go.opentelemetry.io/otel/api/kv"
+	"go.opentelemetry.io/otel/api/trace"
 )
 
+// ErrClosed is returned when publishing after the publisher was closed.
+var ErrClosed = errors.New("Publisher is closed")
+
 // Publisher of AMQP messages.
 type Publisher interface {
-	Publish(op string, body []byte) error
+	Publish(ctx context.Context, op string, body []byte) error
 }
 
 // MockPublisher used in tests.
 type MockPublisher func(op string, body []byte) error
 
 // Publish function that calls the mock function.
-func (p MockPublisher) Publish(op string, body []byte) error {
+func (p MockPublisher) Publish(ctx context.Context, op string, body []byte) error {
 	return p(op, body)
 }
 
@@ -22,10 +37,15 @@ type AMQPPublisher struct {
 	conn     *amqp.Connection
 	exchange string
 	key      string
+	secret   []byte
+
+	mu     sync.Mutex
+	closed bool
+	wg     sync.WaitGroup // messages being published
 }
 
-// New AMQPPublisher instance.
-func New(conn *amqp.Connection, exchange, key string) (*AMQPPublisher, error) {
+// New AMQPPublisher instance that signs messages with the shared secret.
+func New(conn *amqp.Connection, exchange, key string, secret []byte) (*AMQPPublisher, error) {
 	ch, err := conn.Channel()
 	if err != nil {
 		return nil, err
@@ -48,20 +68,55 @@ func New(conn *amqp.Connection, exchange, key string) (*AMQPPublisher, error) {
 		conn:     conn,
 		exchange: exchange,
 		key:      key,
+		secret:   secret,
 	}, nil
 }
 
-// Publish a message to the publisher's exchange with the given routing key.
-func (p *AMQPPublisher) Publish(op string, body []byte) error {
+// Publish a message to the publisher's exchange with the given routing key,
+// carrying the trace context and request ID of ctx in its headers.
+func (p *AMQPPublisher) Publish(ctx context.Context, op string, body []byte) (err error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return ErrClosed
+	}
+	p.wg.Add(1)
+	p.mu.Unlock()
+	defer p.wg.Done()
+
+	ctx, span := tracing.Tracer().Start(ctx, "publish "+op,
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			kv.String("messaging.system", "rabbitmq"),
+			kv.String("messaging.destination", p.key),
+			kv.String("messaging.operation", op),
+		),
+	)
+	defer func() { tracing.End(ctx, span, err) }()
+
 	ch, err := p.conn.Channel()
 	if err != nil {
+		metrics.MessagesPublished.WithLabelValues(op, "error").Inc()
 		return err
 	}
+	defer ch.Close()
+
+	id := uuid.New().String()
+	ts := time.Now()
+
+	headers := amqp.Table{
+		"operation": op,
+		"signature": signature.SignMessage(p.secret, op, id, ts, body),
+	}
+	if id := logging.RequestID(ctx); id != "" {
+		headers[logging.RequestIDField] = id
+	}
+	tracing.InjectAMQP(ctx, headers)
 
 	msg := amqp.Publishing{
-		Headers: amqp.Table{
-			"operation": op,
-		},
+		Headers:      headers,
+		MessageId:    id,
+		Timestamp:    ts,
 		ContentType:  "application/json",
 		DeliveryMode: 2,
 		Priority:     1,
@@ -75,8 +130,31 @@ func (p *AMQPPublisher) Publish(op string, body []byte) error {
 		false,      // immediate
 		msg,        // message
 	); err != nil {
+		metrics.MessagesPublished.WithLabelValues(op, "error").Inc()
 		return err
 	}
 
+	metrics.MessagesPublished.WithLabelValues(op, "ok").Inc()
 	return nil
 }
+
+// Close the publisher, refusing new messages and waiting until the ones being
+// published are handed to the server or the context is done.
+func (p *AMQPPublisher) Close(ctx context.Context) error {
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,67 @@
+package shutdown
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Step of a service's shutdown.
+type Step struct {
+	Name string
+	Stop func(ctx context.Context) error
+}
+
+// Wait until the process is asked to terminate, returning the signal.
+func Wait() os.Signal {
+	sch := make(chan os.Signal, 1)
+	signal.Notify(sch, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sch)
+
+	return <-sch
+}
+
+// Run the steps in order, all of them sharing the given deadline. Failed
+// steps don't stop the rest, so resources are released even if draining
+// takes too long. Returns the number of failed steps.
+func Run(timeout time.Duration, steps ...Step) int {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var failed int
+	for _, s := range steps {
+		logger := log.WithField("step", s.Name)
+
+		if err := s.Stop(ctx); err != nil {
+			logger.WithError(err).Warn("Shutdown step failed")
+			failed++
+		} else {
+			logger.Info("Shutdown step finished")
+		}
+	}
+
+	return failed
+}
+
+// Done returns a step function that waits for the channel to be closed.
+func Done(ch <-chan struct{}) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		select {
+		case <-ch:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Close returns a step function that calls the close function.
+func Close(close func() error) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		return close()
+	}
+}
@@ -0,0 +1,50 @@
+package shutdown_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/varrrro/pay-up/internal/shutdown"
+)
+
+func TestRun(t *testing.T) {
+	var order []string
+	step := func(name string, err error) shutdown.Step {
+		return shutdown.Step{Name: name, Stop: func(ctx context.Context) error {
+			order = append(order, name)
+			return err
+		}}
+	}
+
+	// Failed steps don't stop the rest
+	failed := shutdown.Run(time.Second, step("http", nil), step("consumer", errors.New("failed")), step("database", nil))
+
+	if failed != 1 {
+		t.Errorf("Wrong failed steps [Expected]: %d [Actual]: %d", 1, failed)
+	}
+	if len(order) != 3 || order[0] != "http" || order[1] != "consumer" || order[2] != "database" {
+		t.Errorf("Wrong step order [Expected]: %v [Actual]: %v", []string{"http", "consumer", "database"}, order)
+	}
+}
+
+func TestRunDeadline(t *testing.T) {
+	never := make(chan struct{})
+	closed := make(chan struct{})
+	close(closed)
+
+	start := time.Now()
+	failed := shutdown.Run(50*time.Millisecond,
+		shutdown.Step{Name: "closed", Stop: shutdown.Done(closed)},
+		shutdown.Step{Name: "never", Stop: shutdown.Done(never)},
+		shutdown.Step{Name: "close", Stop: shutdown.Close(func() error { return nil })},
+	)
+
+	if failed != 1 {
+		t.Errorf("Wrong failed steps [Expected]: %d [Actual]: %d", 1, failed)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Deadline wasn't respected [Elapsed]: %v", elapsed)
+	}
+}
@@ -0,0 +1,70 @@
+package signature_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/varrrro/pay-up/internal/signature"
+)
+
+func TestVerifier(t *testing.T) {
+	now := time.Now()
+	body := []byte(`{"group_id":"test"}`)
+	sig := signature.SignMessage(secret, "add-payment", "msg1", now, body)
+	v := signature.NewVerifier(secret, time.Minute)
+
+	cases := []struct {
+		name string
+		sig  string
+		op   string
+		id   string
+		ts   time.Time
+		body []byte
+		fail bool
+	}{
+		{"Valid", sig, "add-payment", "msg1", now, body, false},
+		{"Unsigned", "", "add-payment", "msg1", now, body, true},
+		{"Tampered operation", sig, "delete-payment", "msg1", now, body, true},
+		{"Tampered body", sig, "add-payment", "msg1", now, []byte(`{}`), true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := v.Verify(tc.sig, tc.op, tc.id, tc.ts, tc.body)
+
+			if tc.fail && err == nil {
+				t.Error("Verifying invalid message didn't return an error")
+			} else if !tc.fail && err != nil {
+				t.Errorf("Can't verify valid message [Error]: %v", err)
+			}
+		})
+	}
+}
+
+func TestVerifierFresh(t *testing.T) {
+	now := time.Now()
+	v := signature.NewVerifier(secret, time.Minute)
+
+	cases := []struct {
+		name string
+		ts   time.Time
+		fail bool
+	}{
+		{"Current", now, false},
+		{"Within skew", now.Add(-30 * time.Second), false},
+		{"Stale", now.Add(-time.Hour), true},
+		{"Future", now.Add(time.Hour), true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := v.Fresh(tc.ts, now)
+
+			if tc.fail && err == nil {
+				t.Error("Checking stale message didn't return an error")
+			} else if !tc.fail && err != nil {
+				t.Errorf("Can't check fresh message [Error]: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package signature
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Headers used to carry the signature of a request.
+const (
+	TimestampHeader = "X-Signature-Timestamp"
+	SignatureHeader = "X-Signature"
+	UserHeader      = "X-User-ID"
+	UserNameHeader  = "X-User-Name"
+)
+
+// Sign a request with a shared secret, covering its method, URI, user and
+// their name, timestamp and body.
+func Sign(r *http.Request, secret []byte, now time.Time) error {
+	body, err := readBody(r)
+	if err != nil {
+		return err
+	}
+
+	ts := strconv.FormatInt(now.Unix(), 10)
+	r.Header.Set(TimestampHeader, ts)
+	r.Header.Set(SignatureHeader, compute(r, ts, body, secret))
+
+	return nil
+}
+
+// Verify the signature of a request, rejecting it if it's missing, doesn't
+// match or its timestamp is further than skew from now.
+func Verify(r *http.Request, secret []byte, skew time.Duration, now time.Time) error {
+	sig := r.Header.Get(SignatureHeader)
+	ts := r.Header.Get(TimestampHeader)
+	if sig == "" || ts == "" {
+		return errors.New("Request isn't signed")
+	}
+
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return errors.New("Malformed signature timestamp")
+	}
+
+	if d := now.Sub(time.Unix(sec, 0)); d > skew || d < -skew {
+		return errors.New("Signature timestamp out of range")
+	}
+
+	body, err := readBody(r)
+	if err != nil {
+		return err
+	}
+
+	if !hmac.Equal([]byte(sig), []byte(compute(r, ts, body, secret))) {
+		return errors.New("Signature doesn't match")
+	}
+
+	return nil
+}
+
+func compute(r *http.Request, ts string, body []byte, secret []byte) string {
+	hash := sha256.Sum256(body)
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(r.Method + "\n"))
+	mac.Write([]byte(r.URL.RequestURI() + "\n"))
+	mac.Write([]byte(r.Header.Get(UserHeader) + "\n"))
+	mac.Write([]byte(r.Header.Get(UserNameHeader) + "\n"))
+	mac.Write([]byte(ts + "\n"))
+	mac.Write([]byte(hex.EncodeToString(hash[:])))
+
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// readBody of a request, leaving it in place for the next reader.
+func readBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil {
+		return nil, nil
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	r.Body.Close()
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	return body, nil
+}
@@ -0,0 +1,65 @@
+package signature_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/varrrro/pay-up/internal/signature"
+)
+
+var secret = []byte("secret")
+
+func TestSignAndVerify(t *testing.T) {
+	now := time.Now()
+
+	// Create and sign request
+	req, _ := http.NewRequest("POST", "http://gmicro/groups", bytes.NewBufferString(`{"name":"Test"}`))
+	req.Header.Set("X-User-ID", "user")
+	if err := signature.Sign(req, secret, now); err != nil {
+		t.Fatalf("Can't sign request [Error]: %v", err)
+	}
+
+	// Body must still be readable
+	if body, _ := ioutil.ReadAll(req.Body); string(body) != `{"name":"Test"}` {
+		t.Errorf("Body changed after signing [Actual]: %s", body)
+	}
+	req.Body = ioutil.NopCloser(bytes.NewBufferString(`{"name":"Test"}`))
+
+	if err := signature.Verify(req, secret, time.Minute, now); err != nil {
+		t.Errorf("Can't verify signed request [Error]: %v", err)
+	}
+}
+
+func TestVerifyFailures(t *testing.T) {
+	now := time.Now()
+
+	cases := []struct {
+		name   string
+		modify func(r *http.Request)
+		at     time.Time
+	}{
+		{"Tampered user", func(r *http.Request) { r.Header.Set("X-User-ID", "admin") }, now},
+		{"Tampered user name", func(r *http.Request) { r.Header.Set("X-User-Name", "Admin") }, now},
+		{"Tampered body", func(r *http.Request) { r.Body = ioutil.NopCloser(bytes.NewBufferString(`{}`)) }, now},
+		{"Tampered path", func(r *http.Request) { r.URL.Path = "/groups/other" }, now},
+		{"Missing signature", func(r *http.Request) { r.Header.Del(signature.SignatureHeader) }, now},
+		{"Stale", func(r *http.Request) {}, now.Add(time.Hour)},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req, _ := http.NewRequest("PUT", "http://gmicro/groups/test", bytes.NewBufferString(`{"name":"Test"}`))
+			req.Header.Set("X-User-ID", "user")
+			signature.Sign(req, secret, now)
+
+			tc.modify(req)
+
+			if err := signature.Verify(req, secret, time.Minute, tc.at); err == nil {
+				t.Error("Verifying invalid request didn't return an error")
+			}
+		})
+	}
+}
@@ -2,8 +2,10 @@ package tmicro
 
 import (
 	"fmt"
+	"net/http"
 
 	"github.com/google/uuid"
+	"github.com/varrrro/pay-up/internal/problem"
 )
 
 // NotFoundError used when an item isn't found.
@@ -16,6 +18,11 @@ func (e *NotFoundError) Error() string {
 	return fmt.Sprintf("%s [Group ID]: %v", e.msg, e.id)
 }
 
+// Problem describing the error.
+func (e *NotFoundError) Problem() *problem.Problem {
+	return problem.New(http.StatusNotFound, "not_found", e.msg).With("group_id", e.id)
+}
+
 // UUIDParseError used when a string can't be parsed as a UUID.
 type UUIDParseError struct {
 	msg string
@@ -26,3 +33,49 @@ type UUIDParseError struct {
 func (e *UUIDParseError) Error() string {
 	return fmt.Sprintf("%s [Value]: %s [Error]: %s", e.msg, e.val, e.err.Error())
 }
+
+// Problem describing the error.
+func (e *UUIDParseError) Problem() *problem.Problem {
+	return problem.New(http.StatusBadRequest, "invalid_uuid", e.msg).With("value", e.val)
+}
+
+// ForbiddenError used when a member can't remove another member's transaction.
+type ForbiddenError struct {
+	msg      string
+	id       uuid.UUID
+	memberid uuid.UUID
+}
+
+func (e *ForbiddenError) Error() string {
+	return fmt.Sprintf("%s [ID]: %v [MemberID]: %v", e.msg, e.id, e.memberid)
+}
+
+// Problem describing the error.
+func (e *ForbiddenError) Problem() *problem.Problem {
+	return problem.New(http.StatusForbidden, "forbidden", e.msg).
+		With("id", e.id).
+		With("member_id", e.memberid)
+}
+
+// PublishError used when a reply can't be published after the message was
+// applied. It's temporary, so the message is handled again, which is only
+// safe for messages whose changes are idempotent.
+type PublishError struct {
+	msg string
+	op  string
+	err error
+}
+
+func (e *PublishError) Error() string {
+	return fmt.Sprintf("%s [Operation]: %s [Error]: %s", e.msg, e.op, e.err.Error())
+}
+
+// Unwrap returns the error returned by the publisher.
+func (e *PublishError) Unwrap() error {
+	return e.err
+}
+
+// Temporary reports that the reply may be published if tried again.
+func (e *PublishError) Temporary() bool {
+	return true
+}
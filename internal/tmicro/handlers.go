@@ -1,41 +1,46 @@
 package tmicro
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 
 	log "github.com/sirupsen/logrus"
 
 	"github.com/google/uuid"
+	"github.com/varrrro/pay-up/internal/logging"
 	"github.com/varrrro/pay-up/internal/publisher"
 	"github.com/varrrro/pay-up/internal/tmicro/expense"
 	"github.com/varrrro/pay-up/internal/tmicro/payment"
 )
 
 // MessageHandler using a data manager and message publisher.
-func MessageHandler(m Manager, p publisher.Publisher) func(string, []byte) error {
-	return func(op string, body []byte) error {
-		log.WithField("operation", op).Info("AMQP message received")
+func MessageHandler(m Manager, p publisher.Publisher) func(context.Context, string, []byte) error {
+	return func(ctx context.Context, op string, body []byte) error {
+		logging.FromContext(ctx).WithField("operation", op).Info("AMQP message received")
+		m := m.WithContext(ctx)
 
 		switch op {
 		case "add-expense":
-			return addExpenseHandler(body, m, p)
+			return addExpenseHandler(ctx, body, m, p)
 		case "delete-expense":
-			return deleteExpenseHandler(body, m, p)
+			return deleteExpenseHandler(ctx, body, m, p)
 		case "add-payment":
-			return addPaymentHandler(body, m, p)
+			return addPaymentHandler(ctx, body, m, p)
 		case "delete-payment":
-			return deletePaymentHandler(body, m, p)
+			return deletePaymentHandler(ctx, body, m, p)
+		case "delete-group":
+			return deleteGroupHandler(ctx, body, m, p)
 		default:
 			err := errors.New("Wrong operation type")
-			log.WithError(err).Warn("Can't handle message")
+			logging.FromContext(ctx).WithError(err).Warn("Can't handle message")
 			return err
 		}
 	}
 }
 
-func addExpenseHandler(body []byte, m Manager, pub publisher.Publisher) error {
-	logger := log.WithField("operation", "add-expense")
+func addExpenseHandler(ctx context.Context, body []byte, m Manager, pub publisher.Publisher) error {
+	logger := logging.FromContext(ctx).WithField("operation", "add-expense")
 
 	// Decode JSON
 	var e expense.Expense
@@ -51,7 +56,7 @@ func addExpenseHandler(body []byte, m Manager, pub publisher.Publisher) error {
 	}
 
 	// Publish AMQP message
-	if err := pub.Publish("add-expense", body); err != nil {
+	if err := pub.Publish(ctx, "add-expense", body); err != nil {
 		logger.WithError(err).Warn("Can't publish AMQP message")
 		return err
 	}
@@ -59,8 +64,8 @@ func addExpenseHandler(body []byte, m Manager, pub publisher.Publisher) error {
 	return nil
 }
 
-func deleteExpenseHandler(body []byte, m Manager, pub publisher.Publisher) error {
-	logger := log.WithField("operation", "delete-expense")
+func deleteExpenseHandler(ctx context.Context, body []byte, m Manager, pub publisher.Publisher) error {
+	logger := logging.FromContext(ctx).WithField("operation", "delete-expense")
 
 	// Decode JSON
 	var data map[string]interface{}
@@ -83,8 +88,15 @@ func deleteExpenseHandler(body []byte, m Manager, pub publisher.Publisher) error
 		return err
 	}
 
+	// Check if removal is restricted to a member's own expenses
+	memberid, err := parseMemberID(data)
+	if err != nil {
+		logger.WithError(err).Warn("Member ID isn't valid UUID")
+		return err
+	}
+
 	// Remove last expense
-	exp, err := m.RemoveLastExpense(groupid)
+	exp, err := m.RemoveLastExpense(groupid, memberid)
 	if err != nil {
 		logger.WithFields(log.Fields{
 			"group_id": groupidstr,
@@ -101,7 +113,7 @@ func deleteExpenseHandler(body []byte, m Manager, pub publisher.Publisher) error
 	}
 
 	// Publish AMQP message
-	if err := pub.Publish("delete-expense", newBody); err != nil {
+	if err := pub.Publish(ctx, "delete-expense", newBody); err != nil {
 		logger.WithError(err).Warn("Can't publish AMQP message")
 		return err
 	}
@@ -109,8 +121,8 @@ func deleteExpenseHandler(body []byte, m Manager, pub publisher.Publisher) error
 	return nil
 }
 
-func addPaymentHandler(body []byte, m Manager, pub publisher.Publisher) error {
-	logger := log.WithField("operation", "add-payment")
+func addPaymentHandler(ctx context.Context, body []byte, m Manager, pub publisher.Publisher) error {
+	logger := logging.FromContext(ctx).WithField("operation", "add-payment")
 
 	// Decode JSON
 	var p payment.Payment
@@ -126,7 +138,7 @@ func addPaymentHandler(body []byte, m Manager, pub publisher.Publisher) error {
 	}
 
 	// Publish AMQP message
-	if err := pub.Publish("add-payment", body); err != nil {
+	if err := pub.Publish(ctx, "add-payment", body); err != nil {
 		logger.WithError(err).Warn("Can't publish AMQP message")
 		return err
 	}
@@ -134,8 +146,8 @@ func addPaymentHandler(body []byte, m Manager, pub publisher.Publisher) error {
 	return nil
 }
 
-func deletePaymentHandler(body []byte, m Manager, pub publisher.Publisher) error {
-	logger := log.WithField("operation", "delete-payment")
+func deletePaymentHandler(ctx context.Context, body []byte, m Manager, pub publisher.Publisher) error {
+	logger := logging.FromContext(ctx).WithField("operation", "delete-payment")
 
 	// Decode JSON
 	var data map[string]interface{}
@@ -158,8 +170,15 @@ func deletePaymentHandler(body []byte, m Manager, pub publisher.Publisher) error
 		return err
 	}
 
+	// Check if removal is restricted to a member's own payments
+	memberid, err := parseMemberID(data)
+	if err != nil {
+		logger.WithError(err).Error("Member ID isn't valid UUID")
+		return err
+	}
+
 	// Remove last payment
-	payment, err := m.RemoveLastPayment(groupid)
+	payment, err := m.RemoveLastPayment(groupid, memberid)
 	if err != nil {
 		logger.WithField("group_id", groupidstr).WithError(err).Error("Can't delete payment")
 		return err
@@ -173,10 +192,62 @@ func deletePaymentHandler(body []byte, m Manager, pub publisher.Publisher) error
 	}
 
 	// Publish AMQP message
-	if err := pub.Publish("delete-payment", newBody); err != nil {
+	if err := pub.Publish(ctx, "delete-payment", newBody); err != nil {
 		logger.WithError(err).Warn("Can't publish AMQP message")
 		return err
 	}
 
 	return nil
 }
+
+func deleteGroupHandler(ctx context.Context, body []byte, m Manager, pub publisher.Publisher) error {
+	logger := logging.FromContext(ctx).WithField("operation", "delete-group")
+
+	// Decode JSON
+	var data map[string]interface{}
+	if err := json.Unmarshal(body, &data); err != nil {
+		logger.WithError(err).Error("Can't parse message body")
+		return err
+	}
+
+	// Check if group ID is present
+	groupidstr, ok := data["group_id"].(string)
+	if !ok {
+		logger.Error("Group ID not present in message body")
+		return errors.New("No group ID in message body")
+	}
+
+	// Check if group ID is valid UUID
+	groupid, err := uuid.Parse(groupidstr)
+	if err != nil {
+		logger.WithField("id", groupidstr).Error("Group ID isn't valid UUID")
+		return err
+	}
+
+	// Purge group's transactions
+	if err := m.PurgeGroup(groupid); err != nil {
+		logger.WithField("group_id", groupidstr).WithError(err).Error("Can't purge group")
+		return err
+	}
+
+	// Publish AMQP message so the group itself is deleted. Purging is
+	// idempotent, so the message is requeued until the reply goes out rather
+	// than leaving the group being deleted forever.
+	if err := pub.Publish(ctx, "delete-group", body); err != nil {
+		logger.WithError(err).Warn("Can't publish AMQP message")
+		return &PublishError{"Can't publish group deletion", "delete-group", err}
+	}
+
+	return nil
+}
+
+// parseMemberID returns the optional member ID in a message body, or the nil
+// UUID if there's none.
+func parseMemberID(data map[string]interface{}) (uuid.UUID, error) {
+	memberidstr, ok := data["member_id"].(string)
+	if !ok {
+		return uuid.Nil, nil
+	}
+
+	return uuid.Parse(memberidstr)
+}
@@ -1,11 +1,15 @@
 package tmicro_test
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"testing"
 
 	"github.com/google/uuid"
+	"github.com/varrrro/pay-up/internal/publisher"
+	"github.com/varrrro/pay-up/internal/tmicro"
 	"github.com/varrrro/pay-up/internal/tmicro/expense"
 	"github.com/varrrro/pay-up/internal/tmicro/payment"
 )
@@ -42,6 +46,7 @@ func TestMessageHandler(t *testing.T) {
 		{"delete-expense", []byte(``), true},
 		{"delete-expense", []byte(`{}`), true},
 		{"delete-expense", []byte(`{"group_id":"test"}`), true},
+		{"delete-expense", []byte(`{"group_id":"` + e.GroupID.String() + `","member_id":"test"}`), true},
 		{"delete-expense", []byte(`{"group_id":"` + uuid.New().String() + `"}`), true},
 
 		{"add-payment", pbody, false},
@@ -51,15 +56,21 @@ func TestMessageHandler(t *testing.T) {
 		{"delete-payment", []byte(``), true},
 		{"delete-payment", []byte(`{}`), true},
 		{"delete-payment", []byte(`{"group_id":"test"}`), true},
+		{"delete-payment", []byte(`{"group_id":"` + p.GroupID.String() + `","member_id":"test"}`), true},
 		{"delete-payment", []byte(`{"group_id":"` + uuid.New().String() + `"}`), true},
 
+		{"delete-group", []byte(`{"group_id":"` + e.GroupID.String() + `","force":true}`), false},
+		{"delete-group", []byte(``), true},
+		{"delete-group", []byte(`{}`), true},
+		{"delete-group", []byte(`{"group_id":"test"}`), true},
+
 		{"test", nil, true},
 	}
 
 	for _, tc := range cases {
 		if !tc.fail {
 			t.Run(fmt.Sprintf("Correct %s", tc.op), func(t *testing.T) {
-				err := h(tc.op, tc.body)
+				err := h(context.Background(), tc.op, tc.body)
 
 				if err != nil {
 					t.Errorf("Operation can't finish correctly [Error]: %v", err)
@@ -67,7 +78,7 @@ func TestMessageHandler(t *testing.T) {
 			})
 		} else {
 			t.Run(fmt.Sprintf("Error %s", tc.op), func(t *testing.T) {
-				err := h(tc.op, tc.body)
+				err := h(context.Background(), tc.op, tc.body)
 
 				if err == nil {
 					t.Error("Using wrong values didn't return an error")
@@ -76,3 +87,36 @@ func TestMessageHandler(t *testing.T) {
 		}
 	}
 }
+
+func TestDeleteGroupPublishFails(t *testing.T) {
+	var published int
+	failing := true
+	h := tmicro.MessageHandler(tm, publisher.MockPublisher(func(op string, body []byte) error {
+		if failing {
+			return errors.New("Publishing failed")
+		}
+		published++
+		return nil
+	}))
+
+	e := expense.Expense{ID: uuid.New(), GroupID: uuid.New(), Amount: 10, Payer: uuid.New(), Recipients: uuid.New().String()}
+	tm.CreateExpense(&e)
+	body := []byte(`{"group_id":"` + e.GroupID.String() + `"}`)
+
+	// The message is requeued until the reply is published
+	err := h(context.Background(), "delete-group", body)
+	var terr interface{ Temporary() bool }
+	if !errors.As(err, &terr) || !terr.Temporary() {
+		t.Errorf("Failed reply didn't return a temporary error [Error]: %v", err)
+	}
+
+	failing = false
+	if err := h(context.Background(), "delete-group", body); err != nil {
+		t.Errorf("Handling requeued message returned an error [Error]: %v", err)
+	}
+	if published != 1 {
+		t.Errorf("Wrong published count [Expected]: %d [Actual]: %d", 1, published)
+	}
+
+	clearDB()
+}
@@ -1,24 +1,32 @@
 package tmicro
 
 import (
+	"context"
+	"strings"
+
 	"github.com/google/uuid"
 	"github.com/jinzhu/gorm"
+	"github.com/varrrro/pay-up/internal/dberr"
+	"github.com/varrrro/pay-up/internal/inbox"
 	"github.com/varrrro/pay-up/internal/tmicro/expense"
 	"github.com/varrrro/pay-up/internal/tmicro/payment"
-	"strings"
+	"github.com/varrrro/pay-up/internal/tracing"
 )
 
 // Manager interface for the transactions microservice.
 type Manager interface {
 	CreateExpense(e *expense.Expense) error
-	RemoveLastExpense(gid uuid.UUID) (*expense.Expense, error)
+	RemoveLastExpense(gid, mid uuid.UUID) (*expense.Expense, error)
 	CreatePayment(p *payment.Payment) error
-	RemoveLastPayment(gid uuid.UUID) (*payment.Payment, error)
+	RemoveLastPayment(gid, mid uuid.UUID) (*payment.Payment, error)
+	PurgeGroup(gid uuid.UUID) error
+	WithContext(ctx context.Context) Manager
 }
 
 // TransactionsManager that works as single source of truth.
 type TransactionsManager struct {
-	DB *gorm.DB
+	DB      *gorm.DB
+	message string // ID of the AMQP message being handled, if any
 }
 
 // NewManager with the given database connection.
@@ -26,6 +34,14 @@ func NewManager(db *gorm.DB) *TransactionsManager {
 	return &TransactionsManager{DB: db}
 }
 
+// WithContext returns a manager whose queries are traced as part of the
+// request or message being handled in ctx. Transactions created or removed by
+// a message record it as handled, and return inbox.ErrHandled if it already
+// was.
+func (tm *TransactionsManager) WithContext(ctx context.Context) Manager {
+	return &TransactionsManager{DB: tracing.WithContext(ctx, tm.DB), message: inbox.MessageID(ctx)}
+}
+
 // CreateExpense in the given group.
 func (tm *TransactionsManager) CreateExpense(e *expense.Expense) error {
 	rec := strings.Split(e.Recipients, ";")
@@ -35,44 +51,134 @@ func (tm *TransactionsManager) CreateExpense(e *expense.Expense) error {
 		}
 	}
 
-	tm.DB.Create(e)
+	tx := tm.DB.Begin()
+	if err := tx.Error; err != nil {
+		return dberr.Wrap("begin transaction", err)
+	}
+
+	if err := inbox.Record(tx, tm.message); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Create(e).Error; err != nil {
+		tx.Rollback()
+		return dberr.Wrap("create expense", err)
+	}
 
-	return nil
+	return dberr.Wrap("commit transaction", tx.Commit().Error)
 }
 
-// RemoveLastExpense from the given group.
-func (tm *TransactionsManager) RemoveLastExpense(gid uuid.UUID) (*expense.Expense, error) {
+// RemoveLastExpense from the given group. If a member ID is given, the
+// expense must have been paid by that member.
+func (tm *TransactionsManager) RemoveLastExpense(gid, mid uuid.UUID) (*expense.Expense, error) {
 	var e expense.Expense
 
-	tm.DB.Where("group_id = ?", gid).Order("date DESC").First(&e)
+	tx := tm.DB.Begin()
+	if err := tx.Error; err != nil {
+		return nil, dberr.Wrap("begin transaction", err)
+	}
 
-	if e.GroupID != gid {
+	if err := inbox.Record(tx, tm.message); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	err := tx.Where("group_id = ?", gid).Order("date DESC").First(&e).Error
+	if gorm.IsRecordNotFoundError(err) {
+		tx.Rollback()
 		return nil, &NotFoundError{"No expense found", gid}
+	} else if err != nil {
+		tx.Rollback()
+		return nil, dberr.Wrap("remove last expense", err)
 	}
 
-	tm.DB.Delete(&e)
+	if mid != uuid.Nil && e.Payer != mid {
+		tx.Rollback()
+		return nil, &ForbiddenError{"Expense was paid by another member", e.ID, mid}
+	}
 
-	return &e, nil
+	if err := tx.Delete(&e).Error; err != nil {
+		tx.Rollback()
+		return nil, dberr.Wrap("remove last expense", err)
+	}
+
+	return &e, dberr.Wrap("commit transaction", tx.Commit().Error)
 }
 
 // CreatePayment in the given group.
 func (tm *TransactionsManager) CreatePayment(p *payment.Payment) error {
-	tm.DB.Create(p)
+	tx := tm.DB.Begin()
+	if err := tx.Error; err != nil {
+		return dberr.Wrap("begin transaction", err)
+	}
 
-	return nil
+	if err := inbox.Record(tx, tm.message); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Create(p).Error; err != nil {
+		tx.Rollback()
+		return dberr.Wrap("create payment", err)
+	}
+
+	return dberr.Wrap("commit transaction", tx.Commit().Error)
 }
 
-// RemoveLastPayment from the given group.
-func (tm *TransactionsManager) RemoveLastPayment(gid uuid.UUID) (*payment.Payment, error) {
+// RemoveLastPayment from the given group. If a member ID is given, the
+// payment must have been made by that member.
+func (tm *TransactionsManager) RemoveLastPayment(gid, mid uuid.UUID) (*payment.Payment, error) {
 	var p payment.Payment
 
-	tm.DB.Where("group_id = ?", gid).Order("date DESC").First(&p)
+	tx := tm.DB.Begin()
+	if err := tx.Error; err != nil {
+		return nil, dberr.Wrap("begin transaction", err)
+	}
 
-	if p.GroupID != gid {
+	if err := inbox.Record(tx, tm.message); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	err := tx.Where("group_id = ?", gid).Order("date DESC").First(&p).Error
+	if gorm.IsRecordNotFoundError(err) {
+		tx.Rollback()
 		return nil, &NotFoundError{"No payment found", gid}
+	} else if err != nil {
+		tx.Rollback()
+		return nil, dberr.Wrap("remove last payment", err)
+	}
+
+	if mid != uuid.Nil && p.Payer != mid {
+		tx.Rollback()
+		return nil, &ForbiddenError{"Payment was made by another member", p.ID, mid}
 	}
 
-	tm.DB.Delete(&p)
+	if err := tx.Delete(&p).Error; err != nil {
+		tx.Rollback()
+		return nil, dberr.Wrap("remove last payment", err)
+	}
+
+	return &p, dberr.Wrap("commit transaction", tx.Commit().Error)
+}
+
+// PurgeGroup removing every expense and payment of the given group.
+func (tm *TransactionsManager) PurgeGroup(gid uuid.UUID) error {
+	tx := tm.DB.Begin()
+	if err := tx.Error; err != nil {
+		return dberr.Wrap("begin transaction", err)
+	}
+
+	if err := tx.Where("group_id = ?", gid).Delete(&expense.Expense{}).Error; err != nil {
+		tx.Rollback()
+		return dberr.Wrap("purge group", err)
+	}
+
+	if err := tx.Where("group_id = ?", gid).Delete(&payment.Payment{}).Error; err != nil {
+		tx.Rollback()
+		return dberr.Wrap("purge group", err)
+	}
 
-	return &p, nil
+	return dberr.Wrap("commit transaction", tx.Commit().Error)
 }
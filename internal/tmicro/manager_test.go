@@ -1,11 +1,16 @@
 package tmicro_test
 
 import (
+	"context"
 	"testing"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"github.com/varrrro/pay-up/internal/dberr"
+	"github.com/varrrro/pay-up/internal/inbox"
+	"github.com/varrrro/pay-up/internal/tmicro"
 	"github.com/varrrro/pay-up/internal/tmicro/expense"
 	"github.com/varrrro/pay-up/internal/tmicro/payment"
 )
@@ -46,6 +51,58 @@ func TestCreateExpenseParseError(t *testing.T) {
 	clearDB()
 }
 
+func TestCreateExpenseDuplicate(t *testing.T) {
+	e := expense.Expense{
+		ID:         uuid.New(),
+		GroupID:    uuid.New(),
+		Date:       time.Now(),
+		Amount:     25.3,
+		Payer:      uuid.New(),
+		Recipients: uuid.New().String(),
+	}
+
+	if err := tm.CreateExpense(&e); err != nil {
+		t.Errorf("Couldn't create expense. Error: %s", err.Error())
+	}
+
+	if err := tm.CreateExpense(&e); !dberr.Is(err, dberr.Conflict) {
+		t.Errorf("Creating duplicate expense didn't return a conflict. Error: %v", err)
+	}
+
+	clearDB()
+}
+
+func TestManagerDatabaseUnavailable(t *testing.T) {
+	bad, _ := gorm.Open("sqlite3", ":memory:")
+	bad.Close()
+	btm := tmicro.NewManager(bad)
+
+	cases := []struct {
+		name string
+		call func() error
+	}{
+		{"CreateExpense", func() error {
+			return btm.CreateExpense(&expense.Expense{ID: uuid.New(), Recipients: uuid.New().String()})
+		}},
+		{"RemoveLastExpense", func() error { _, err := btm.RemoveLastExpense(uuid.New(), uuid.Nil); return err }},
+		{"CreatePayment", func() error { return btm.CreatePayment(&payment.Payment{ID: uuid.New()}) }},
+		{"RemoveLastPayment", func() error { _, err := btm.RemoveLastPayment(uuid.New(), uuid.Nil); return err }},
+		{"PurgeGroup", func() error { return btm.PurgeGroup(uuid.New()) }},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.call()
+
+			if !dberr.Is(err, dberr.Unavailable) {
+				t.Errorf("Wrong error [Expected]: %v [Actual]: %v", dberr.Unavailable, err)
+			} else if !dberr.Temporary(err) {
+				t.Error("Unavailable database isn't worth retrying")
+			}
+		})
+	}
+}
+
 func TestRemoveLastExpense(t *testing.T) {
 	e := expense.Expense{
 		ID:          uuid.New(),
@@ -61,7 +118,7 @@ func TestRemoveLastExpense(t *testing.T) {
 		t.Errorf("Couldn't create expense. Error: %s", err.Error())
 	}
 
-	if e2, err := tm.RemoveLastExpense(e.GroupID); err != nil {
+	if e2, err := tm.RemoveLastExpense(e.GroupID, uuid.Nil); err != nil {
 		t.Errorf("Couldn't remove last expense. Error: %s", err.Error())
 	} else if e2.ID != e.ID {
 		t.Error("Returned expense doesn't match original.")
@@ -71,13 +128,64 @@ func TestRemoveLastExpense(t *testing.T) {
 }
 
 func TestRemoveLastExpenseNotFound(t *testing.T) {
-	if _, err := tm.RemoveLastExpense(uuid.New()); err == nil {
+	if _, err := tm.RemoveLastExpense(uuid.New(), uuid.Nil); err == nil {
 		t.Error("Removing expense from non-existant group didn't return an error.")
 	}
 
 	clearDB()
 }
 
+func TestRemoveLastExpenseForbidden(t *testing.T) {
+	e := expense.Expense{
+		ID:          uuid.New(),
+		GroupID:     uuid.New(),
+		Date:        time.Now(),
+		Amount:      25.3,
+		Description: "test",
+		Payer:       uuid.New(),
+		Recipients:  uuid.New().String() + ";" + uuid.New().String(),
+	}
+
+	if err := tm.CreateExpense(&e); err != nil {
+		t.Errorf("Couldn't create expense. Error: %s", err.Error())
+	}
+
+	if _, err := tm.RemoveLastExpense(e.GroupID, uuid.New()); err == nil {
+		t.Error("Removing another member's expense didn't return an error.")
+	}
+
+	if _, err := tm.RemoveLastExpense(e.GroupID, e.Payer); err != nil {
+		t.Errorf("Couldn't remove own expense. Error: %s", err.Error())
+	}
+
+	clearDB()
+}
+
+func TestRemoveLastExpenseReplayed(t *testing.T) {
+	gid := uuid.New()
+	for i := 0; i < 2; i++ {
+		e := expense.Expense{ID: uuid.New(), GroupID: gid, Date: time.Now(), Amount: 10, Payer: uuid.New(), Recipients: uuid.New().String()}
+		tm.CreateExpense(&e)
+	}
+
+	// Replaying the removal must not remove the expense before the last one
+	ctx := inbox.WithMessageID(context.Background(), "msg1")
+	if _, err := tm.WithContext(ctx).RemoveLastExpense(gid, uuid.Nil); err != nil {
+		t.Fatalf("Couldn't remove last expense [Error]: %v", err)
+	}
+	if _, err := tm.WithContext(ctx).RemoveLastExpense(gid, uuid.Nil); err != inbox.ErrHandled {
+		t.Errorf("Wrong error [Expected]: %v [Actual]: %v", inbox.ErrHandled, err)
+	}
+
+	var count int
+	db.Model(&expense.Expense{}).Where("group_id = ?", gid).Count(&count)
+	if count != 1 {
+		t.Errorf("Wrong expense count [Expected]: %d [Actual]: %d", 1, count)
+	}
+
+	clearDB()
+}
+
 func TestCreatePayment(t *testing.T) {
 	p := payment.Payment{
 		ID:        uuid.New(),
@@ -109,7 +217,7 @@ func TestRemoveLastPayment(t *testing.T) {
 		t.Errorf("Couldn't create payment. Error: %s", err.Error())
 	}
 
-	if p2, err := tm.RemoveLastPayment(p.GroupID); err != nil {
+	if p2, err := tm.RemoveLastPayment(p.GroupID, uuid.Nil); err != nil {
 		t.Errorf("Couldn't remove last payment. Error: %s", err.Error())
 	} else if p2.ID != p.ID {
 		t.Error("Returned payment doesn't match original.")
@@ -119,9 +227,70 @@ func TestRemoveLastPayment(t *testing.T) {
 }
 
 func TestRemoveLastPaymentNotFound(t *testing.T) {
-	if _, err := tm.RemoveLastPayment(uuid.New()); err == nil {
+	if _, err := tm.RemoveLastPayment(uuid.New(), uuid.Nil); err == nil {
 		t.Error("Removing payment from non-existant group didn't return an error.")
 	}
 
 	clearDB()
 }
+
+func TestRemoveLastPaymentForbidden(t *testing.T) {
+	p := payment.Payment{
+		ID:        uuid.New(),
+		GroupID:   uuid.New(),
+		Date:      time.Now(),
+		Amount:    27.3,
+		Payer:     uuid.New(),
+		Recipient: uuid.New(),
+	}
+
+	if err := tm.CreatePayment(&p); err != nil {
+		t.Errorf("Couldn't create payment. Error: %s", err.Error())
+	}
+
+	if _, err := tm.RemoveLastPayment(p.GroupID, uuid.New()); err == nil {
+		t.Error("Removing another member's payment didn't return an error.")
+	}
+
+	if _, err := tm.RemoveLastPayment(p.GroupID, p.Payer); err != nil {
+		t.Errorf("Couldn't remove own payment. Error: %s", err.Error())
+	}
+
+	clearDB()
+}
+
+func TestPurgeGroup(t *testing.T) {
+	gid := uuid.New()
+	e := expense.Expense{
+		ID:         uuid.New(),
+		GroupID:    gid,
+		Date:       time.Now(),
+		Amount:     12.5,
+		Payer:      uuid.New(),
+		Recipients: uuid.New().String(),
+	}
+	tm.CreateExpense(&e)
+
+	p := payment.Payment{
+		ID:        uuid.New(),
+		GroupID:   gid,
+		Date:      time.Now(),
+		Amount:    27.3,
+		Payer:     uuid.New(),
+		Recipient: uuid.New(),
+	}
+	tm.CreatePayment(&p)
+
+	if err := tm.PurgeGroup(gid); err != nil {
+		t.Errorf("Couldn't purge group. Error: %s", err.Error())
+	}
+
+	if _, err := tm.RemoveLastExpense(gid, uuid.Nil); err == nil {
+		t.Error("Expense of purged group wasn't removed.")
+	}
+	if _, err := tm.RemoveLastPayment(gid, uuid.Nil); err == nil {
+		t.Error("Payment of purged group wasn't removed.")
+	}
+
+	clearDB()
+}
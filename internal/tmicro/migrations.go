@@ -0,0 +1,69 @@
+package tmicro
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+	"github.com/varrrro/pay-up/internal/migrate"
+)
+
+// MigrationsTable keeping the applied migrations of the transactions' schema.
+const MigrationsTable = "tmicro_migrations"
+
+// Migrations of the transactions' schema, in order. Applied migrations must
+// never change, since deployed databases already went through them.
+var Migrations = []migrate.Migration{
+	{
+		Version: 1,
+		Name:    "create_expenses_and_payments",
+		Up: func(tx *gorm.DB) error {
+			if err := migrate.CreateTable(tx, "expenses", &expenseV1{}); err != nil {
+				return err
+			}
+			return migrate.CreateTable(tx, "payments", &paymentV1{})
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := migrate.DropTable(tx, "payments"); err != nil {
+				return err
+			}
+			return migrate.DropTable(tx, "expenses")
+		},
+	},
+	{
+		Version: 2,
+		Name:    "create_handled_messages",
+		Up: func(tx *gorm.DB) error {
+			return migrate.CreateTable(tx, "handled_messages", &handledMessageV2{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return migrate.DropTable(tx, "handled_messages")
+		},
+	},
+}
+
+// Snapshots of the schema at each version.
+
+type expenseV1 struct {
+	ID          uuid.UUID `gorm:"type:uuid;primary_key"`
+	GroupID     uuid.UUID `gorm:"type:uuid"`
+	Date        time.Time
+	Amount      float32
+	Description string
+	Payer       uuid.UUID `gorm:"type:uuid"`
+	Recipients  string
+}
+
+type paymentV1 struct {
+	ID        uuid.UUID `gorm:"type:uuid;primary_key"`
+	GroupID   uuid.UUID `gorm:"type:uuid"`
+	Date      time.Time
+	Amount    float32
+	Payer     uuid.UUID `gorm:"type:uuid"`
+	Recipient uuid.UUID `gorm:"type:uuid"`
+}
+
+type handledMessageV2 struct {
+	ID        string `gorm:"primary_key"`
+	HandledAt time.Time
+}
@@ -1,10 +1,13 @@
 package tmicro_test
 
 import (
+	"context"
 	"os"
 	"testing"
 
 	"github.com/jinzhu/gorm"
+	"github.com/varrrro/pay-up/internal/inbox"
+	"github.com/varrrro/pay-up/internal/migrate"
 	"github.com/varrrro/pay-up/internal/publisher"
 	"github.com/varrrro/pay-up/internal/tmicro"
 	"github.com/varrrro/pay-up/internal/tmicro/expense"
@@ -13,7 +16,7 @@ import (
 
 var db *gorm.DB
 var tm *tmicro.TransactionsManager
-var h func(string, []byte) error
+var h func(context.Context, string, []byte) error
 
 func TestMain(m *testing.M) {
 	// Open connection to test DB
@@ -21,7 +24,10 @@ func TestMain(m *testing.M) {
 	defer db.Close()
 
 	// Create tables
-	db.CreateTable(&expense.Expense{}, &payment.Payment{})
+	mig, _ := migrate.New(db, tmicro.MigrationsTable, tmicro.Migrations)
+	if _, err := mig.Up(); err != nil {
+		panic(err)
+	}
 
 	// Create manager with test DB connection
 	tm = tmicro.NewManager(db)
@@ -36,6 +42,7 @@ func TestMain(m *testing.M) {
 }
 
 func clearDB() {
+	db.Delete(&inbox.Message{})
 	db.Delete(&expense.Expense{})
 	db.Delete(&payment.Payment{})
 }
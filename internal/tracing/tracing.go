@@ -0,0 +1,230 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+	"github.com/streadway/amqp"
+	"go.opentelemetry.io/otel/api/global"
+	"go.opentelemetry.io/otel/api/kv"
+	"go.opentelemetry.io/otel/api/propagation"
+	"go.opentelemetry.io/otel/api/standard"
+	"go.opentelemetry.io/otel/api/trace"
+	"go.opentelemetry.io/otel/exporters/otlp"
+	"go.opentelemetry.io/otel/exporters/trace/stdout"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// Name of the tracer used by every service.
+const Name = "github.com/varrrro/pay-up"
+
+// Exporters of spans.
+const (
+	ExporterNone   = "none"
+	ExporterStdout = "stdout"
+	ExporterOTLP   = "otlp"
+)
+
+// Init the global tracer of the service, exporting spans with the given
+// exporter. Returns a function that flushes pending spans and stops the
+// exporter, to be called on shutdown.
+func Init(service, exporter, endpoint string) (func(ctx context.Context) error, error) {
+	res := resource.New(standard.ServiceNameKey.String(service))
+
+	var tp *sdktrace.Provider
+	var stop func() error
+
+	switch exporter {
+	case ExporterNone:
+		return func(ctx context.Context) error { return nil }, nil
+	case ExporterStdout:
+		exp, err := stdout.NewExporter(stdout.Options{})
+		if err != nil {
+			return nil, err
+		}
+		if tp, err = sdktrace.NewProvider(sdktrace.WithSyncer(exp), sdktrace.WithResource(res)); err != nil {
+			return nil, err
+		}
+		stop = func() error { return nil }
+	case ExporterOTLP:
+		exp, err := otlp.NewExporter(otlp.WithInsecure(), otlp.WithAddress(endpoint))
+		if err != nil {
+			return nil, err
+		}
+		bsp, err := sdktrace.NewBatchSpanProcessor(exp)
+		if err != nil {
+			return nil, err
+		}
+		if tp, err = sdktrace.NewProvider(sdktrace.WithResource(res)); err != nil {
+			return nil, err
+		}
+		tp.RegisterSpanProcessor(bsp)
+		stop = func() error {
+			tp.UnregisterSpanProcessor(bsp)
+			return exp.Stop()
+		}
+	default:
+		return nil, fmt.Errorf("Unknown trace exporter [Exporter]: %s", exporter)
+	}
+
+	global.SetTraceProvider(tp)
+
+	return func(ctx context.Context) error {
+		errc := make(chan error, 1)
+		go func() { errc <- stop() }()
+
+		select {
+		case err := <-errc:
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}, nil
+}
+
+// Tracer of the service.
+func Tracer() trace.Tracer {
+	return global.Tracer(Name)
+}
+
+// End the span, recording the error if there's one.
+func End(ctx context.Context, span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(ctx, err)
+	}
+	span.End()
+}
+
+// Middleware that continues the trace of HTTP requests, or starts a new one,
+// with a span named after the route's template.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		ctx := propagation.ExtractHTTP(r.Context(), global.Propagators(), r.Header)
+
+		route := "unmatched"
+		if cr := mux.CurrentRoute(r); cr != nil {
+			if tpl, err := cr.GetPathTemplate(); err == nil {
+				route = tpl
+			}
+		}
+
+		ctx, span := Tracer().Start(ctx, r.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(standard.HTTPServerAttributesFromHTTPRequest("", route, r)...),
+		)
+		defer span.End()
+
+		rec := &statusRecorder{ResponseWriter: rw, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		span.SetAttributes(standard.HTTPAttributesFromHTTPStatusCode(rec.status)...)
+		span.SetStatus(standard.SpanStatusFromHTTPStatusCode(rec.status))
+	})
+}
+
+// statusRecorder keeps the status code written to the response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	s.status = code
+	s.ResponseWriter.WriteHeader(code)
+}
+
+// Flush the response if the underlying writer supports it, for streams.
+func (s *statusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// InjectHTTP adds the trace context to the headers of an outgoing request.
+func InjectHTTP(ctx context.Context, h http.Header) {
+	propagation.InjectHTTP(ctx, global.Propagators(), h)
+}
+
+// amqpHeaders lets the propagators read and write AMQP message headers.
+type amqpHeaders amqp.Table
+
+func (h amqpHeaders) Get(key string) string {
+	v, _ := h[key].(string)
+	return v
+}
+
+func (h amqpHeaders) Set(key, value string) {
+	h[key] = value
+}
+
+// InjectAMQP adds the trace context to the headers of an AMQP message.
+func InjectAMQP(ctx context.Context, h amqp.Table) {
+	propagation.InjectHTTP(ctx, global.Propagators(), amqpHeaders(h))
+}
+
+// ExtractAMQP returns a context with the trace context in the headers of an
+// AMQP message.
+func ExtractAMQP(ctx context.Context, h amqp.Table) context.Context {
+	return propagation.ExtractHTTP(ctx, global.Propagators(), amqpHeaders(h))
+}
+
+// contextKey of the gorm setting that keeps the context of queries.
+const contextKey = "tracing:context"
+
+// WithContext returns a database connection whose queries are traced as
+// children of the span in the context.
+func WithContext(ctx context.Context, db *gorm.DB) *gorm.DB {
+	return db.Set(contextKey, ctx)
+}
+
+// InstrumentDB adds spans to the queries made through connections returned
+// by WithContext. Queries made without a context aren't traced.
+func InstrumentDB(db *gorm.DB) {
+	start := func(op string) func(*gorm.Scope) {
+		return func(scope *gorm.Scope) {
+			v, ok := scope.Get(contextKey)
+			if !ok {
+				return
+			}
+
+			_, span := Tracer().Start(v.(context.Context), op+" "+scope.TableName(),
+				trace.WithSpanKind(trace.SpanKindClient),
+				trace.WithAttributes(
+					standard.DBSystemKey.String(scope.Dialect().GetName()),
+					kv.String("db.operation", op),
+					kv.String("db.table", scope.TableName()),
+				),
+			)
+			scope.InstanceSet("tracing:span", span)
+		}
+	}
+	end := func(scope *gorm.Scope) {
+		v, ok := scope.InstanceGet("tracing:span")
+		if !ok {
+			return
+		}
+
+		span := v.(trace.Span)
+		span.SetAttributes(standard.DBStatementKey.String(scope.SQL))
+		if err := scope.DB().Error; err != nil && !gorm.IsRecordNotFoundError(err) {
+			span.RecordError(context.Background(), err)
+		}
+		span.End()
+	}
+
+	cb := db.Callback()
+	cb.Create().Before("gorm:create").Register("tracing:before_create", start("create"))
+	cb.Create().After("gorm:create").Register("tracing:after_create", end)
+	cb.Query().Before("gorm:query").Register("tracing:before_query", start("query"))
+	cb.Query().After("gorm:query").Register("tracing:after_query", end)
+	cb.Update().Before("gorm:update").Register("tracing:before_update", start("update"))
+	cb.Update().After("gorm:update").Register("tracing:after_update", end)
+	cb.Delete().Before("gorm:delete").Register("tracing:before_delete", start("delete"))
+	cb.Delete().After("gorm:delete").Register("tracing:after_delete", end)
+	cb.RowQuery().Before("gorm:row_query").Register("tracing:before_row_query", start("row_query"))
+	cb.RowQuery().After("gorm:row_query").Register("tracing:after_row_query", end)
+}
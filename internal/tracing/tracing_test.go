@@ -0,0 +1,174 @@
+package tracing_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"github.com/streadway/amqp"
+	"github.com/varrrro/pay-up/internal/tracing"
+	"go.opentelemetry.io/otel/api/global"
+	"go.opentelemetry.io/otel/api/trace"
+	export "go.opentelemetry.io/otel/sdk/export/trace"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// recorder keeps the spans exported, standing in for a collector.
+type recorder struct {
+	mu    sync.Mutex
+	spans []*export.SpanData
+}
+
+func (r *recorder) ExportSpan(ctx context.Context, sd *export.SpanData) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, sd)
+}
+
+// take the spans exported so far, forgetting them.
+func (r *recorder) take() []*export.SpanData {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	spans := r.spans
+	r.spans = nil
+	return spans
+}
+
+var rec = &recorder{}
+
+func TestMain(m *testing.M) {
+	tp, err := sdktrace.NewProvider(sdktrace.WithSyncer(rec))
+	if err != nil {
+		panic(err)
+	}
+	global.SetTraceProvider(tp)
+
+	os.Exit(m.Run())
+}
+
+func TestInit(t *testing.T) {
+	cases := []struct {
+		name     string
+		exporter string
+		fails    bool
+	}{
+		{"None", tracing.ExporterNone, false},
+		{"Unknown", "zipkin", true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			stop, err := tracing.Init("test", tc.exporter, "")
+
+			if (err != nil) != tc.fails {
+				t.Fatalf("Wrong init result [Expected failure]: %t [Error]: %v", tc.fails, err)
+			}
+			if err == nil {
+				if err := stop(context.Background()); err != nil {
+					t.Errorf("Couldn't stop tracing [Error]: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	r := mux.NewRouter()
+	r.Use(tracing.Middleware)
+	r.HandleFunc("/things/{id}", func(rw http.ResponseWriter, r *http.Request) {
+		// Continue the trace in an outgoing request
+		out := http.Header{}
+		tracing.InjectHTTP(r.Context(), out)
+		rw.Header().Set("traceparent", out.Get("traceparent"))
+	}).Methods("GET")
+
+	// Start a trace as an upstream service would
+	ctx, parent := tracing.Tracer().Start(context.Background(), "upstream")
+	req := httptest.NewRequest("GET", "/things/1", nil)
+	tracing.InjectHTTP(ctx, req.Header)
+	parent.End()
+	rec.take()
+
+	res := httptest.NewRecorder()
+	r.ServeHTTP(res, req)
+
+	spans := rec.take()
+	if len(spans) != 1 {
+		t.Fatalf("Wrong span count [Expected]: %d [Actual]: %d", 1, len(spans))
+	}
+	span := spans[0]
+	if span.Name != "GET /things/{id}" {
+		t.Errorf("Wrong span name [Expected]: %s [Actual]: %s", "GET /things/{id}", span.Name)
+	}
+	if span.SpanKind != trace.SpanKindServer {
+		t.Errorf("Wrong span kind [Expected]: %v [Actual]: %v", trace.SpanKindServer, span.SpanKind)
+	}
+	if span.SpanContext.TraceID != parent.SpanContext().TraceID || span.ParentSpanID != parent.SpanContext().SpanID {
+		t.Error("Span doesn't continue the upstream trace")
+	}
+	if res.Header().Get("traceparent") == "" {
+		t.Error("Trace context isn't injected in outgoing requests")
+	}
+}
+
+func TestAMQP(t *testing.T) {
+	ctx, span := tracing.Tracer().Start(context.Background(), "publish")
+	defer span.End()
+
+	headers := amqp.Table{"operation": "add-expense"}
+	tracing.InjectAMQP(ctx, headers)
+
+	if _, ok := headers["traceparent"].(string); !ok {
+		t.Fatal("Trace context isn't injected in message headers")
+	}
+
+	got := trace.RemoteSpanContextFromContext(tracing.ExtractAMQP(context.Background(), headers))
+	if got.TraceID != span.SpanContext().TraceID || got.SpanID != span.SpanContext().SpanID {
+		t.Errorf("Wrong extracted span [Expected]: %v [Actual]: %v", span.SpanContext(), got)
+	}
+}
+
+func TestInstrumentDB(t *testing.T) {
+	type thing struct {
+		ID   int
+		Name string
+	}
+
+	db, _ := gorm.Open("sqlite3", ":memory:")
+	defer db.Close()
+	tracing.InstrumentDB(db)
+	db.AutoMigrate(&thing{})
+	rec.take()
+
+	// Queries without a context aren't traced
+	db.Create(&thing{ID: 1, Name: "one"})
+	if spans := rec.take(); len(spans) != 0 {
+		t.Errorf("Wrong span count [Expected]: %d [Actual]: %d", 0, len(spans))
+	}
+
+	ctx, parent := tracing.Tracer().Start(context.Background(), "handler")
+	tdb := tracing.WithContext(ctx, db)
+	tdb.Create(&thing{ID: 2, Name: "two"})
+	tdb.Find(&[]thing{})
+	parent.End()
+
+	spans := rec.take()
+	names := []string{"create things", "query things", "handler"}
+	if len(spans) != len(names) {
+		t.Fatalf("Wrong span count [Expected]: %d [Actual]: %d", len(names), len(spans))
+	}
+	for i, name := range names[:2] {
+		if spans[i].Name != name {
+			t.Errorf("Wrong span name [Expected]: %s [Actual]: %s", name, spans[i].Name)
+		}
+		if spans[i].ParentSpanID != parent.SpanContext().SpanID {
+			t.Errorf("Query span isn't a child of the handler [Span]: %s", spans[i].Name)
+		}
+	}
+}